@@ -0,0 +1,78 @@
+package limiter
+
+import (
+	"context"
+)
+
+// AnyLimiter implements the Strategy interface by allowing a request if any rule allows it,
+// the OR counterpart of MultiLimiter, e.g. "either you have per-minute budget left or you
+// hold a one-time token".
+//
+// Rules are checked in order and the first one that allows the request wins: only that rule
+// is charged, and the rules after it aren't checked at all. Put the rule that should be spent
+// first (e.g. the renewable per-minute budget before the one-time tokens) first. The rules
+// before it denied the request, which consumes nothing.
+type AnyLimiter struct {
+	rules []Rule
+}
+
+// NewAnyLimiter creates a new AnyLimiter allowing requests that any of the given rules allows.
+func NewAnyLimiter(rules ...Rule) *AnyLimiter {
+	return &AnyLimiter{
+		rules: rules,
+	}
+}
+
+// Allow checks the request against the rules in turn until one allows it.
+// The limit argument is ignored, each rule applies its own limit.
+// The result's Source is set to the name of the rule that decided it.
+func (a *AnyLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	res, _, err := a.AllowWithKey(ctx, key, limit)
+	return res, err
+}
+
+// AllowWithKey is like Allow but also returns the namespaced key of the rule that decided
+// the result, e.g. "one_time:client-1".
+//
+// When every rule denies the request, the least restrictive denial decides: the one that
+// can be retried soonest, or the one with the most remaining on a tie. An error from any
+// rule is returned as is, without checking the remaining rules.
+func (a *AnyLimiter) AllowWithKey(ctx context.Context, key string, limit Limit) (*Result, string, error) {
+	var (
+		denied    *Result
+		deniedKey = key
+	)
+	for _, rule := range a.rules {
+		k := key
+		if rule.Name != "" {
+			k = rule.Name + ":" + key
+		}
+
+		res, err := rule.Strategy.Allow(ctx, k, rule.Limit)
+		if err != nil {
+			ReleaseResult(denied)
+			return nil, k, err
+		}
+		if rule.Name != "" {
+			res.Source = rule.Name
+		}
+		if res.Allowed {
+			ReleaseResult(denied)
+			return res, k, nil
+		}
+
+		if denied == nil || res.ResetAfter < denied.ResetAfter ||
+			(res.ResetAfter == denied.ResetAfter && res.Remaining > denied.Remaining) {
+			ReleaseResult(denied)
+			denied, deniedKey = res, k
+		} else {
+			ReleaseResult(res)
+		}
+	}
+
+	if denied == nil {
+		// Without rules there is nothing to deny the request
+		return &Result{Allowed: true}, key, nil
+	}
+	return denied, deniedKey, nil
+}
@@ -0,0 +1,109 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAnyLimiterChargesFirstAllowingRule(t *testing.T) {
+	ctx := context.Background()
+	tb := NewTokenBucket(WithClock(newFakeClock()))
+	perMinute := Limit{Rate: 2, Period: time.Minute, Burst: 2}
+	oneTime := Limit{Rate: 1, Period: 24 * time.Hour, Burst: 1}
+	a := NewAnyLimiter(
+		Rule{Name: "per_minute", Strategy: tb, Limit: perMinute},
+		Rule{Name: "one_time", Strategy: tb, Limit: oneTime},
+	)
+
+	for i, want := range []string{"per_minute", "per_minute", "one_time"} {
+		res, key, err := a.AllowWithKey(ctx, "client", Limit{})
+		if err != nil || !res.Allowed {
+			t.Fatalf("request %d = %+v, %v, want allowed", i, res, err)
+		}
+		if res.Source != want || key != want+":client" {
+			t.Errorf("request %d allowed by %q on %q, want %s", i, res.Source, key, want)
+		}
+		// The one-time token is only spent once the per-minute budget is
+		if q, _ := tb.Quota(ctx, "one_time:client", oneTime); (q.Remaining == 0) != (want == "one_time") {
+			t.Errorf("request %d: one-time tokens left = %d", i, q.Remaining)
+		}
+	}
+
+	if res := must(a.Allow(ctx, "client", Limit{})); res.Allowed {
+		t.Error("request with every rule spent allowed")
+	}
+}
+
+func TestAnyLimiterLeastRestrictiveDenial(t *testing.T) {
+	ctx := context.Background()
+	deny := func(resetAfter time.Duration, remaining int) Strategy {
+		return strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+			return &Result{Reason: ReasonRateExceeded, ResetAfter: resetAfter, Remaining: remaining}, nil
+		})
+	}
+
+	a := NewAnyLimiter(
+		Rule{Name: "slow", Strategy: deny(time.Minute, 0)},
+		Rule{Name: "soon", Strategy: deny(time.Second, 0)},
+		Rule{Name: "later", Strategy: deny(time.Hour, 0)},
+	)
+	res, key, err := a.AllowWithKey(ctx, "k", Limit{})
+	if err != nil || res.Allowed || res.Source != "soon" || key != "soon:k" || res.ResetAfter != time.Second {
+		t.Errorf("denial = %+v on %q, %v, want the one retrying soonest", res, key, err)
+	}
+
+	// On a tie, the one with the most remaining
+	a = NewAnyLimiter(
+		Rule{Name: "a", Strategy: deny(time.Second, 0)},
+		Rule{Name: "b", Strategy: deny(time.Second, 1)},
+	)
+	if res := must(a.Allow(ctx, "k", Limit{})); res.Source != "b" {
+		t.Errorf("tied denial from %q, want b with more remaining", res.Source)
+	}
+}
+
+func TestAnyLimiterStopsAtErrorsAndAllows(t *testing.T) {
+	ctx := context.Background()
+	var checked []string
+	rule := func(name string, res *Result, err error) Rule {
+		return Rule{Name: name, Strategy: strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+			checked = append(checked, key)
+			return res, err
+		})}
+	}
+
+	a := NewAnyLimiter(
+		rule("down", nil, errBackend),
+		rule("fine", &Result{Allowed: true}, nil),
+	)
+	if _, key, err := a.AllowWithKey(ctx, "k", Limit{}); !errors.Is(err, errBackend) || key != "down:k" {
+		t.Errorf("error = %v on %q, want the backend error of down:k", err, key)
+	}
+	if len(checked) != 1 {
+		t.Errorf("checked %v, want to stop at the error", checked)
+	}
+
+	// Rules after the allowing one aren't checked, and unnamed rules use the key as is
+	checked = nil
+	a = NewAnyLimiter(
+		Rule{Strategy: strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+			checked = append(checked, key)
+			return &Result{Allowed: true, Source: "inner"}, nil
+		})},
+		rule("never", &Result{Allowed: true}, nil),
+	)
+	res, key, err := a.AllowWithKey(ctx, "k", Limit{})
+	if err != nil || !res.Allowed || key != "k" || res.Source != "inner" {
+		t.Errorf("unnamed rule = %+v on %q, %v, want allowed on k with the strategy's source", res, key, err)
+	}
+	if len(checked) != 1 {
+		t.Errorf("checked %v, want only the first rule", checked)
+	}
+
+	// Without rules, nothing denies
+	if res := must(NewAnyLimiter().Allow(ctx, "k", Limit{})); !res.Allowed {
+		t.Error("AnyLimiter without rules denied")
+	}
+}
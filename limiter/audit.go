@@ -0,0 +1,91 @@
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// AuditLimiter implements the Strategy interface by reporting every decision of the
+// inner strategy, allowed or denied, to a callback (e.g. to stream them to Kafka or ELK).
+//
+// The callback runs on a separate goroutine fed by a buffered channel, so a slow
+// consumer never blocks Allow. When the buffer is full, decisions are dropped and
+// counted in Dropped.
+type AuditLimiter struct {
+	inner    Strategy
+	callback func(key string, res *Result, ts time.Time)
+	events   chan auditEvent
+	dropped  atomic.Uint64
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+type auditEvent struct {
+	key string
+	res Result
+	ts  time.Time
+}
+
+// NewAuditLimiter creates a new AuditLimiter wrapping inner, buffering up to bufferSize decisions.
+// Call Close to stop the delivery goroutine.
+func NewAuditLimiter(inner Strategy, callback func(key string, res *Result, ts time.Time), bufferSize int) *AuditLimiter {
+	a := &AuditLimiter{
+		inner:    inner,
+		callback: callback,
+		events:   make(chan auditEvent, bufferSize),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go a.deliver()
+	return a
+}
+
+// Allow checks the request against the inner strategy and queues the decision for the callback.
+func (a *AuditLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	res, err := a.inner.Allow(ctx, key, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// The result is copied since the caller may release it before the callback runs
+	select {
+	case a.events <- auditEvent{key: key, res: *res, ts: time.Now()}:
+	default:
+		a.dropped.Add(1)
+	}
+
+	return res, nil
+}
+
+// Dropped returns how many decisions were dropped because the buffer was full.
+func (a *AuditLimiter) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Close delivers the decisions already buffered and stops the delivery goroutine.
+// Decisions made after Close are dropped once the buffer fills up.
+func (a *AuditLimiter) Close() {
+	close(a.quit)
+	<-a.done
+}
+
+func (a *AuditLimiter) deliver() {
+	defer close(a.done)
+
+	for {
+		select {
+		case ev := <-a.events:
+			a.callback(ev.key, &ev.res, ev.ts)
+		case <-a.quit:
+			for {
+				select {
+				case ev := <-a.events:
+					a.callback(ev.key, &ev.res, ev.ts)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
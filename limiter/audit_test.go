@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditLimiterReportsBothOutcomes(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu      sync.Mutex
+		allowed []bool
+	)
+	a := NewAuditLimiter(NewTokenBucket(), func(key string, res *Result, ts time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if key != "k" || ts.IsZero() {
+			t.Errorf("callback got key %q at %s", key, ts)
+		}
+		allowed = append(allowed, res.Allowed)
+	}, 10)
+
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	for i := 0; i < 2; i++ {
+		res, err := a.Allow(ctx, "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The caller may recycle its result right away
+		ReleaseResult(res)
+	}
+	a.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(allowed) != 2 || !allowed[0] || allowed[1] {
+		t.Fatalf("callback saw %v, want [true false]", allowed)
+	}
+}
+
+func TestAuditLimiterDropsWhenFull(t *testing.T) {
+	ctx := context.Background()
+	block := make(chan struct{})
+	a := NewAuditLimiter(NewTokenBucket(), func(key string, res *Result, ts time.Time) {
+		<-block
+	}, 1)
+
+	limit := Limit{Rate: 100, Period: time.Second, Burst: 100}
+	for i := 0; i < 10; i++ {
+		// Allow never waits on the blocked callback
+		if _, err := a.Allow(ctx, "k", limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// At most one decision is being delivered and one buffered
+	if dropped := a.Dropped(); dropped < 8 {
+		t.Errorf("Dropped = %d, want at least 8", dropped)
+	}
+	close(block)
+	a.Close()
+}
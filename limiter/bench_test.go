@@ -0,0 +1,100 @@
+package limiter
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func BenchmarkTokenBucketAllow(b *testing.B) {
+	ctx := context.Background()
+	tb := NewTokenBucket()
+	limit := Limit{Rate: 1e9, Period: time.Second, Burst: 1e9}
+
+	b.Run("unreleased", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tb.Allow(ctx, "k", limit)
+		}
+	})
+	b.Run("released", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			res, _ := tb.Allow(ctx, "k", limit)
+			ReleaseResult(res)
+		}
+	})
+}
+
+// BenchmarkAllow compares the in-memory strategies, e.g.
+//
+//	go test -bench 'Allow/sliding_window/keys=10000' -cpu 1,4,16
+//
+// Keys are picked round-robin, and results are released as a well-behaved caller would.
+func BenchmarkAllow(b *testing.B) {
+	ctx := context.Background()
+	// High enough that nothing is ever denied, so every strategy takes its allow path
+	limit := Limit{Rate: 1e9, Period: time.Second, Burst: 1e9}
+
+	strategies := []struct {
+		name string
+		new  func() Strategy
+	}{
+		{"token_bucket", func() Strategy { return NewTokenBucket() }},
+		{"sharded_token_bucket", func() Strategy { return NewShardedTokenBucketAuto() }},
+		{"sliding_window", func() Strategy { return NewSlidingWindow() }},
+		{"sliding_window_ring", func() Strategy { return NewSlidingWindowRing(10) }},
+		{"fixed_window", func() Strategy { return NewFixedWindow() }},
+		{"leaky_bucket", func() Strategy { return NewLeakyBucket() }},
+	}
+
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			for _, n := range []int{1, 10000} {
+				keys := make([]string, n)
+				for i := range keys {
+					keys[i] = "key:" + strconv.Itoa(i)
+				}
+
+				// Creating the keys' state is left out, so the timings show steady-state requests
+				warm := func() Strategy {
+					strategy := s.new()
+					for _, key := range keys {
+						res, _ := strategy.Allow(ctx, key, limit)
+						ReleaseResult(res)
+					}
+					return strategy
+				}
+
+				b.Run("keys="+strconv.Itoa(n), func(b *testing.B) {
+					b.Run("serial", func(b *testing.B) {
+						strategy := warm()
+						b.ReportAllocs()
+						b.ResetTimer()
+						for i := 0; i < b.N; i++ {
+							res, _ := strategy.Allow(ctx, keys[i%n], limit)
+							ReleaseResult(res)
+						}
+					})
+					b.Run("parallel", func(b *testing.B) {
+						strategy := warm()
+						var worker atomic.Int64
+						b.ReportAllocs()
+						b.ResetTimer()
+						b.RunParallel(func(pb *testing.PB) {
+							// Workers start at different keys, so they don't all contend on one
+							i := int(worker.Add(1)) * 7919
+							for pb.Next() {
+								res, _ := strategy.Allow(ctx, keys[i%n], limit)
+								ReleaseResult(res)
+								i++
+							}
+						})
+					})
+				})
+			}
+		})
+	}
+}
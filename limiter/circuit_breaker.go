@@ -0,0 +1,219 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a CircuitBreakerLimiter.
+type BreakerState int
+
+const (
+	// BreakerClosed means requests go to the inner strategy.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen means the inner strategy is failing and requests get the fallback decision.
+	BreakerOpen
+	// BreakerHalfOpen means the cooldown has passed and a probe request is testing recovery.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state, for logs and metrics labels.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig tunes when a CircuitBreakerLimiter opens and how it recovers.
+type BreakerConfig struct {
+	// ErrorThreshold is the fraction of failed requests within a Window that opens the
+	// breaker. Default: 0.5.
+	ErrorThreshold float64
+	// MinRequests is how many requests a Window must see before its error rate counts, so
+	// a single failure in a quiet period doesn't open the breaker. Default: 10.
+	MinRequests int
+	// Window is how long errors are counted before the counts start over. Default: 10s.
+	Window time.Duration
+	// Cooldown is how long the breaker stays open before letting a probe through. Default: 5s.
+	Cooldown time.Duration
+	// FailOpen allows requests while the breaker is open. By default they are denied with
+	// ReasonBackendError, which protects the backend the limiter guards at the cost of
+	// availability.
+	FailOpen bool
+}
+
+// CircuitBreakerLimiter implements the Strategy interface by guarding a remote strategy,
+// such as RedisTokenBucket, with a circuit breaker. Once the share of requests failing
+// within a window reaches the threshold, the breaker opens: requests get the fallback
+// decision without calling the inner strategy, so a failing Redis isn't buried under
+// retries. After the cooldown it half-opens and lets a single probe through; the breaker
+// closes if the probe succeeds and opens again otherwise.
+//
+// Only backend errors count as failures; ErrExceedsBurst and ErrInvalidCost are passed
+// through without affecting the breaker, and so are requests whose context was cancelled.
+type CircuitBreakerLimiter struct {
+	inner Strategy
+	cfg   BreakerConfig
+	clock Clock
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+// NewCircuitBreakerLimiter creates a new CircuitBreakerLimiter wrapping inner, starting closed.
+// It accepts WithClock, which times the windows and the cooldown.
+func NewCircuitBreakerLimiter(inner Strategy, cfg BreakerConfig, opts ...Option) *CircuitBreakerLimiter {
+	if cfg.ErrorThreshold <= 0 || cfg.ErrorThreshold > 1 {
+		cfg.ErrorThreshold = 0.5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 5 * time.Second
+	}
+	return &CircuitBreakerLimiter{
+		inner: inner,
+		cfg:   cfg,
+		clock: applyOptions(opts).clock,
+	}
+}
+
+// Allow checks the request against the inner strategy, or returns the fallback decision
+// while the breaker is open.
+func (c *CircuitBreakerLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return c.inner.Allow(ctx, key, limit)
+	}
+
+	probe, wait, ok := c.admit(c.clock.Now())
+	if !ok {
+		return c.fallback(wait), nil
+	}
+
+	res, err := c.inner.Allow(ctx, key, limit)
+	if err != nil && (ctx.Err() != nil || errors.Is(err, ErrExceedsBurst) || errors.Is(err, ErrInvalidCost)) {
+		// The caller gave up or made an invalid request, which says nothing about the
+		// backend either way
+		c.release(probe)
+		return res, err
+	}
+	c.record(probe, err != nil, c.clock.Now())
+	return res, err
+}
+
+// State returns the current state of the breaker.
+func (c *CircuitBreakerLimiter) State() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == BreakerOpen && c.clock.Now().Sub(c.openedAt) >= c.cfg.Cooldown {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+// admit reports whether a request may go to the inner strategy and whether it is the probe.
+// Otherwise it returns how long until the next probe.
+func (c *CircuitBreakerLimiter) admit(now time.Time) (probe bool, wait time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case BreakerClosed:
+		return false, 0, true
+	case BreakerOpen:
+		if elapsed := now.Sub(c.openedAt); elapsed < c.cfg.Cooldown {
+			return false, c.cfg.Cooldown - elapsed, false
+		}
+		c.state = BreakerHalfOpen
+	}
+
+	// Half-open: a single probe at a time, the rest keep getting the fallback
+	if c.probing {
+		return false, 0, false
+	}
+	c.probing = true
+	return true, 0, true
+}
+
+// record updates the breaker with the outcome of a request that reached the inner strategy.
+func (c *CircuitBreakerLimiter) record(probe, failed bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if probe {
+		c.probing = false
+		if failed {
+			c.open(now)
+		} else {
+			c.state = BreakerClosed
+			c.windowStart = now
+			c.requests, c.failures = 0, 0
+		}
+		return
+	}
+	if c.state != BreakerClosed {
+		return
+	}
+
+	if now.Sub(c.windowStart) >= c.cfg.Window {
+		c.windowStart = now
+		c.requests, c.failures = 0, 0
+	}
+	c.requests++
+	if failed {
+		c.failures++
+	}
+	if c.requests >= c.cfg.MinRequests && float64(c.failures) >= c.cfg.ErrorThreshold*float64(c.requests) {
+		c.open(now)
+	}
+}
+
+// release frees the probe slot of a request whose outcome isn't recorded, so the next
+// request probes instead. The breaker stays half-open.
+func (c *CircuitBreakerLimiter) release(probe bool) {
+	if !probe {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probing = false
+}
+
+func (c *CircuitBreakerLimiter) open(now time.Time) {
+	c.state = BreakerOpen
+	c.openedAt = now
+	c.requests, c.failures = 0, 0
+}
+
+// fallback returns the decision for a request that skipped the inner strategy.
+func (c *CircuitBreakerLimiter) fallback(wait time.Duration) *Result {
+	result := newResult("circuit_breaker")
+	if c.cfg.FailOpen {
+		result.Allowed = true
+		return result
+	}
+	result.Reason = ReasonBackendError
+	result.ResetAfter = computeResetAfter(wait)
+	return result
+}
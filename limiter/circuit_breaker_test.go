@@ -0,0 +1,219 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyBackend is a Strategy that fails while down is set, counting the calls that reach it.
+type flakyBackend struct {
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+func (f *flakyBackend) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	f.calls.Add(1)
+	if f.down.Load() {
+		return nil, errBackend
+	}
+	return &Result{Allowed: true, Source: "backend"}, nil
+}
+
+func TestCircuitBreakerLifecycle(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	backend := &flakyBackend{}
+	clock := newFakeClock()
+	cb := NewCircuitBreakerLimiter(backend, BreakerConfig{MinRequests: 4, Cooldown: 20 * time.Millisecond}, WithClock(clock))
+
+	// Closed: successes and failures below MinRequests keep it closed
+	if res, err := cb.Allow(ctx, "k", limit); err != nil || !res.Allowed {
+		t.Fatalf("request to a healthy backend = %+v, %v", res, err)
+	}
+	backend.down.Store(true)
+	for i := 0; i < 2; i++ {
+		if _, err := cb.Allow(ctx, "k", limit); !errors.Is(err, errBackend) {
+			t.Fatalf("failure %d: error = %v, want the backend error", i, err)
+		}
+	}
+	if s := cb.State(); s != BreakerClosed {
+		t.Fatalf("state after 2 of 3 failing = %s, want closed", s)
+	}
+
+	// Open: the 4th request makes 3 of 4 fail, and later ones skip the backend
+	cb.Allow(ctx, "k", limit)
+	if s := cb.State(); s != BreakerOpen {
+		t.Fatalf("state after 3 of 4 failing = %s, want open", s)
+	}
+	calls := backend.calls.Load()
+	res, err := cb.Allow(ctx, "k", limit)
+	if err != nil || res.Allowed || res.Reason != ReasonBackendError || res.ResetAfter <= 0 {
+		t.Fatalf("request while open = %+v, %v, want denied for a backend error until the cooldown ends", res, err)
+	}
+	if backend.calls.Load() != calls {
+		t.Fatal("request while open reached the backend")
+	}
+
+	// Half-open, failed probe: open again
+	clock.Advance(20 * time.Millisecond)
+	if s := cb.State(); s != BreakerHalfOpen {
+		t.Fatalf("state after the cooldown = %s, want half_open", s)
+	}
+	if _, err := cb.Allow(ctx, "k", limit); !errors.Is(err, errBackend) {
+		t.Fatalf("probe error = %v, want the backend error", err)
+	}
+	if s := cb.State(); s != BreakerOpen {
+		t.Fatalf("state after a failed probe = %s, want open", s)
+	}
+
+	// Half-open, successful probe: closed
+	backend.down.Store(false)
+	clock.Advance(20 * time.Millisecond)
+	if res, err := cb.Allow(ctx, "k", limit); err != nil || !res.Allowed {
+		t.Fatalf("probe = %+v, %v, want allowed by the backend", res, err)
+	}
+	if s := cb.State(); s != BreakerClosed {
+		t.Fatalf("state after a successful probe = %s, want closed", s)
+	}
+	if res, err := cb.Allow(ctx, "k", limit); err != nil || res.Source != "backend" {
+		t.Fatalf("request after closing = %+v, %v, want it to reach the backend", res, err)
+	}
+}
+
+func TestCircuitBreakerFailOpen(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	backend := &flakyBackend{}
+	backend.down.Store(true)
+	cb := NewCircuitBreakerLimiter(backend, BreakerConfig{MinRequests: 1, Cooldown: time.Hour, FailOpen: true})
+
+	cb.Allow(ctx, "k", limit)
+	res, err := cb.Allow(ctx, "k", limit)
+	if err != nil || !res.Allowed || res.Source != "circuit_breaker" {
+		t.Fatalf("request while open = %+v, %v, want allowed by the breaker", res, err)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	clock := newFakeClock()
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	var fail atomic.Bool
+	fail.Store(true)
+	cb := NewCircuitBreakerLimiter(strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		if fail.Load() {
+			return nil, errBackend
+		}
+		entered <- struct{}{}
+		<-unblock
+		return &Result{Allowed: true}, nil
+	}), BreakerConfig{MinRequests: 1, Cooldown: 20 * time.Millisecond}, WithClock(clock))
+
+	cb.Allow(ctx, "k", limit)
+	fail.Store(false)
+	clock.Advance(20 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cb.Allow(ctx, "k", limit)
+	}()
+	<-entered
+
+	// While the probe is in flight, the rest get the fallback
+	if res, err := cb.Allow(ctx, "k", limit); err != nil || res.Allowed || res.Source != "circuit_breaker" {
+		t.Errorf("request during the probe = %+v, %v, want the fallback", res, err)
+	}
+	close(unblock)
+	<-done
+	if s := cb.State(); s != BreakerClosed {
+		t.Errorf("state after the probe = %s, want closed", s)
+	}
+}
+
+func TestCircuitBreakerCancelledProbeReleasesSlot(t *testing.T) {
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	backend := &flakyBackend{}
+	backend.down.Store(true)
+	clock := newFakeClock()
+	cb := NewCircuitBreakerLimiter(strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return backend.Allow(ctx, key, limit)
+	}), BreakerConfig{MinRequests: 1, Cooldown: 20 * time.Millisecond}, WithClock(clock))
+
+	cb.Allow(context.Background(), "k", limit)
+	clock.Advance(20 * time.Millisecond)
+
+	// The probe's caller gives up: the outcome is neither a success nor a failure
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cb.Allow(cancelled, "k", limit); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled probe error = %v, want context.Canceled", err)
+	}
+	if s := cb.State(); s != BreakerHalfOpen {
+		t.Fatalf("state after a cancelled probe = %s, want still half_open", s)
+	}
+
+	// The next request is the probe, rather than being refused while the slot stays taken
+	calls := backend.calls.Load()
+	if _, err := cb.Allow(context.Background(), "k", limit); !errors.Is(err, errBackend) {
+		t.Fatalf("next probe error = %v, want the backend error", err)
+	}
+	if backend.calls.Load() != calls+1 {
+		t.Fatal("request after a cancelled probe didn't reach the backend")
+	}
+	if s := cb.State(); s != BreakerOpen {
+		t.Errorf("state after the failed probe = %s, want open", s)
+	}
+}
+
+func TestCircuitBreakerIgnoresCallerErrors(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	cb := NewCircuitBreakerLimiter(strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		return nil, ErrInvalidCost
+	}), BreakerConfig{MinRequests: 1})
+
+	for i := 0; i < 5; i++ {
+		if _, err := cb.Allow(ctx, "k", limit); !errors.Is(err, ErrInvalidCost) {
+			t.Fatalf("error = %v, want ErrInvalidCost passed through", err)
+		}
+	}
+	if s := cb.State(); s != BreakerClosed {
+		t.Errorf("state after invalid requests = %s, want closed", s)
+	}
+}
+
+func TestCircuitBreakerInvalidProbeReleasesSlot(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	backend := &flakyBackend{}
+	backend.down.Store(true)
+	clock := newFakeClock()
+	cb := NewCircuitBreakerLimiter(strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		if key == "invalid" {
+			return nil, ErrInvalidCost
+		}
+		return backend.Allow(ctx, key, limit)
+	}), BreakerConfig{MinRequests: 1, Cooldown: 20 * time.Millisecond}, WithClock(clock))
+
+	cb.Allow(ctx, "k", limit)
+	clock.Advance(20 * time.Millisecond)
+
+	// An invalid request is no sign of recovery, so it doesn't close the breaker
+	if _, err := cb.Allow(ctx, "invalid", limit); !errors.Is(err, ErrInvalidCost) {
+		t.Fatalf("invalid probe error = %v, want ErrInvalidCost", err)
+	}
+	if s := cb.State(); s != BreakerHalfOpen {
+		t.Fatalf("state after an invalid probe = %s, want still half_open", s)
+	}
+	if _, err := cb.Allow(ctx, "k", limit); !errors.Is(err, errBackend) {
+		t.Fatalf("next probe error = %v, want the backend error", err)
+	}
+}
@@ -0,0 +1,261 @@
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// PeerPath is the path under which PeerHandler is expected to be mounted on every node.
+const PeerPath = "/_ratelimit/allow"
+
+// HashRing assigns keys to peers by consistent hashing, so adding or removing a peer only
+// moves the keys of its neighbours on the ring. Every node must build the ring from the same
+// peers and replica count, or nodes will disagree on who owns a key.
+type HashRing struct {
+	hashes []uint32
+	owners map[uint32]string
+}
+
+// NewHashRing creates a HashRing placing each peer at replicas points on the ring.
+// More replicas spread keys more evenly; 100 is a reasonable default.
+func NewHashRing(replicas int, peers ...string) *HashRing {
+	r := &HashRing{
+		owners: make(map[uint32]string),
+	}
+	for _, peer := range peers {
+		for i := 0; i < replicas; i++ {
+			h := hashKey(peer + "#" + strconv.Itoa(i))
+			r.hashes = append(r.hashes, h)
+			r.owners[h] = peer
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Owner returns the peer owning key, or "" if the ring is empty.
+func (r *HashRing) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// Transport forwards an Allow call to the peer owning the key.
+type Transport interface {
+	Allow(ctx context.Context, peer, key string, limit Limit) (*Result, error)
+}
+
+// DistributedMemoryLimiter implements the Strategy interface by spreading keys over a cluster
+// of nodes without a central store. Each key is owned by one node, chosen by the HashRing, which
+// keeps its state in a local token bucket; other nodes forward Allow calls for the key to the
+// owner through the Transport. Every node must serve PeerHandler so it can answer for its keys.
+//
+// There is no replication. When a node is lost, Allow fails with the transport's error for
+// the keys it owned until the ring is rebuilt without it; the keys then move to other nodes
+// and start over with a full budget. Nodes that briefly disagree on the ring may each enforce
+// the limit for the same key, letting it through up to twice as often.
+type DistributedMemoryLimiter struct {
+	peers Transport
+	self  string
+	ring  *HashRing
+	local *TokenBucket
+}
+
+// defaultPeerCleanup is how long the local bucket of a DistributedMemoryLimiter keeps idle
+// keys unless WithCleanup says otherwise, since PeerHandler lets any peer add keys to it.
+const defaultPeerCleanup = 10 * time.Minute
+
+// NewDistributedMemoryLimiter creates a new DistributedMemoryLimiter for the node named self,
+// which must be one of the peers in ring. peers carries calls to the other nodes.
+// opts configure the local token bucket, as for NewTokenBucket; its idle keys are dropped
+// after 10 minutes unless WithCleanup is given.
+func NewDistributedMemoryLimiter(peers Transport, self string, ring *HashRing, opts ...Option) *DistributedMemoryLimiter {
+	return &DistributedMemoryLimiter{
+		peers: peers,
+		self:  self,
+		ring:  ring,
+		local: NewTokenBucket(append([]Option{WithCleanup(defaultPeerCleanup)}, opts...)...),
+	}
+}
+
+// Allow checks the request locally if this node owns key, and forwards it to the owner otherwise.
+func (d *DistributedMemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	owner := d.ring.Owner(key)
+	if limit.IsUnlimited() || owner == "" || owner == d.self {
+		return d.local.Allow(ctx, key, limit)
+	}
+	return d.peers.Allow(ctx, owner, key, limit)
+}
+
+// peerRequest and peerResponse are the JSON messages exchanged by HTTPTransport and PeerHandler.
+type peerRequest struct {
+	Key    string        `json:"key"`
+	Rate   int           `json:"rate"`
+	Period time.Duration `json:"period"`
+	Burst  int           `json:"burst"`
+}
+
+type peerResponse struct {
+	Allowed    bool          `json:"allowed"`
+	Remaining  int           `json:"remaining"`
+	ResetAfter time.Duration `json:"reset_after"`
+	Source     string        `json:"source,omitempty"`
+	Reason     Reason        `json:"reason"`
+}
+
+// peerError is the JSON body PeerHandler answers with when the check fails. Code names the
+// error so HTTPTransport can return the same sentinel error as a local check would.
+type peerError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// peerErrorCodes maps the codes of peerError to the errors they stand for.
+var peerErrorCodes = map[string]error{
+	"exceeds_burst": ErrExceedsBurst,
+	"invalid_cost":  ErrInvalidCost,
+}
+
+// writePeerError answers a failed check: 422 with the code of a known error, which would fail
+// the same way on any node, and 500 for anything else.
+func writePeerError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	body := peerError{Code: "internal", Message: err.Error()}
+	for code, target := range peerErrorCodes {
+		if errors.Is(err, target) {
+			status = http.StatusUnprocessableEntity
+			body.Code = code
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// PeerHandler answers Allow calls forwarded by other nodes, checking them against this
+// node's local state. Mount it at PeerPath, and keep it off the public internet: any caller
+// can consume or probe the budget of any key.
+func (d *DistributedMemoryLimiter) PeerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req peerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		// Answer locally even if this node doesn't own the key by its own ring,
+		// so nodes disagreeing on the ring can't forward calls in circles
+		limit := Limit{Rate: req.Rate, Period: req.Period, Burst: req.Burst}
+		res, err := d.local.Allow(r.Context(), req.Key, limit)
+		if err != nil {
+			writePeerError(w, err)
+			return
+		}
+		defer ReleaseResult(res)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(peerResponse{
+			Allowed:    res.Allowed,
+			Remaining:  res.Remaining,
+			ResetAfter: res.ResetAfter,
+			Source:     res.Source,
+			Reason:     res.Reason,
+		})
+	})
+}
+
+// HTTPTransport is a Transport calling PeerHandler over HTTP. Peers are named by their base
+// URL, e.g. "http://10.0.0.2:8080", and PeerPath is appended to it.
+//
+// Errors the owner reports with a known code, such as ErrExceedsBurst, are returned wrapped
+// so errors.Is matches them. A 429 from the peer, e.g. from a rate limiter in front of
+// PeerHandler, is returned as a denied result honouring its Retry-After rather than as an error.
+type HTTPTransport struct {
+	// Client sends the requests. Default: http.DefaultClient. Set a timeout on it so a
+	// hung peer can't stall requests.
+	Client *http.Client
+}
+
+// Allow forwards the check to peer.
+func (t *HTTPTransport) Allow(ctx context.Context, peer, key string, limit Limit) (*Result, error) {
+	body, err := json.Marshal(peerRequest{Key: key, Rate: limit.Rate, Period: limit.Period, Burst: limit.Burst})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+PeerPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		res := newResult("")
+		res.Reason = ReasonRateExceeded
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			res.ResetAfter = time.Duration(seconds) * time.Second
+		}
+		return res, nil
+	default:
+		var pe peerError
+		if err := json.NewDecoder(resp.Body).Decode(&pe); err == nil {
+			if target, ok := peerErrorCodes[pe.Code]; ok {
+				return nil, fmt.Errorf("limiter: peer %s: %w", peer, target)
+			}
+			if pe.Message != "" {
+				return nil, fmt.Errorf("limiter: peer %s returned %s: %s", peer, resp.Status, pe.Message)
+			}
+		}
+		return nil, fmt.Errorf("limiter: peer %s returned %s", peer, resp.Status)
+	}
+	var pr peerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return nil, err
+	}
+
+	res := newResult(pr.Source)
+	res.Allowed = pr.Allowed
+	res.Remaining = pr.Remaining
+	res.ResetAfter = pr.ResetAfter
+	res.Reason = pr.Reason
+	return res, nil
+}
@@ -0,0 +1,213 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// mockTransport routes forwarded calls straight to the limiter of the owning node.
+type mockTransport struct {
+	nodes map[string]*DistributedMemoryLimiter
+	down  map[string]bool
+	calls int
+}
+
+var errPeerDown = errors.New("peer down")
+
+func (m *mockTransport) Allow(ctx context.Context, peer, key string, limit Limit) (*Result, error) {
+	m.calls++
+	if m.down[peer] {
+		return nil, errPeerDown
+	}
+	return m.nodes[peer].local.Allow(ctx, key, limit)
+}
+
+func newCluster(peers ...string) (*mockTransport, *HashRing) {
+	ring := NewHashRing(100, peers...)
+	transport := &mockTransport{nodes: make(map[string]*DistributedMemoryLimiter), down: make(map[string]bool)}
+	for _, peer := range peers {
+		transport.nodes[peer] = NewDistributedMemoryLimiter(transport, peer, ring)
+	}
+	return transport, ring
+}
+
+func TestHashRingIsStable(t *testing.T) {
+	ring := NewHashRing(100, "a", "b", "c")
+	reordered := NewHashRing(100, "c", "a", "b")
+	owned := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner := ring.Owner(key)
+		if owner != reordered.Owner(key) {
+			t.Fatalf("%s: owner depends on the order of the peers", key)
+		}
+		owned[owner]++
+	}
+	for _, peer := range []string{"a", "b", "c"} {
+		if owned[peer] < 200 {
+			t.Errorf("%s owns %d of 1000 keys, want a fair share", peer, owned[peer])
+		}
+	}
+
+	// Removing a peer only moves its own keys
+	smaller := NewHashRing(100, "a", "b")
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if owner := ring.Owner(key); owner != "c" && smaller.Owner(key) != owner {
+			t.Fatalf("%s moved from %s when c left", key, owner)
+		}
+	}
+
+	if owner := NewHashRing(100).Owner("k"); owner != "" {
+		t.Errorf("empty ring owner = %q, want none", owner)
+	}
+}
+
+func TestDistributedMemoryLimiterSharesBudgetAcrossNodes(t *testing.T) {
+	ctx := context.Background()
+	transport, _ := newCluster("a", "b", "c")
+	limit := Limit{Rate: 3, Period: time.Minute, Burst: 3}
+
+	// Requests for one key spread over every node draw from the owner's single budget
+	allowed := 0
+	for i := 0; i < 9; i++ {
+		node := transport.nodes[string(rune('a'+i%3))]
+		if must(node.Allow(ctx, "k", limit)).Allowed {
+			allowed++
+		}
+	}
+	if allowed != limit.Burst {
+		t.Errorf("%d requests allowed across the cluster, want %d", allowed, limit.Burst)
+	}
+	if transport.calls != 6 {
+		t.Errorf("%d forwarded calls, want 6 from the two nodes not owning the key", transport.calls)
+	}
+}
+
+func TestDistributedMemoryLimiterOwnerDown(t *testing.T) {
+	ctx := context.Background()
+	transport, ring := newCluster("a", "b")
+	limit := Limit{Rate: 3, Period: time.Minute, Burst: 3}
+	owner := ring.Owner("k")
+	other := "a"
+	if owner == "a" {
+		other = "b"
+	}
+
+	transport.down[owner] = true
+	if _, err := transport.nodes[other].Allow(ctx, "k", limit); !errors.Is(err, errPeerDown) {
+		t.Errorf("Allow with the owner down = %v, want the transport error", err)
+	}
+	// Unlimited requests never leave the node
+	if res, err := transport.nodes[other].Allow(ctx, "k", Unlimited); err != nil || !res.Allowed {
+		t.Errorf("unlimited Allow with the owner down = %+v, %v, want allowed", res, err)
+	}
+}
+
+func TestDistributedMemoryLimiterDropsIdleKeys(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 1}
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		idle time.Duration
+	}{
+		{"default", nil, defaultPeerCleanup},
+		{"WithCleanup", []Option{WithCleanup(time.Minute)}, time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newFakeClock()
+			d := NewDistributedMemoryLimiter(nil, "self", NewHashRing(100, "self"), append(tc.opts, WithClock(clock))...)
+			for _, key := range []string{"a", "b", "c"} {
+				must(d.Allow(ctx, key, limit))
+			}
+
+			// Requests keep arriving for another key only; the idle ones are swept
+			clock.Advance(tc.idle + time.Second)
+			must(d.Allow(ctx, "d", limit))
+			clock.Advance(tc.idle)
+			must(d.Allow(ctx, "d", limit))
+			if n := len(d.local.buckets); n != 1 {
+				t.Errorf("%d buckets kept, want only the active key's", n)
+			}
+		})
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	ctx := context.Background()
+	owner := NewDistributedMemoryLimiter(nil, "owner", NewHashRing(100, "owner"))
+	mux := http.NewServeMux()
+	mux.Handle(PeerPath, owner.PeerHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	transport := &HTTPTransport{Client: srv.Client()}
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+
+	if res := must(transport.Allow(ctx, srv.URL, "k", limit)); !res.Allowed || res.Source != "token_bucket" {
+		t.Fatalf("first call = %+v, want allowed by the owner's token bucket", res)
+	}
+	res := must(transport.Allow(ctx, srv.URL, "k", limit))
+	if res.Allowed || res.Reason != ReasonRateExceeded || res.ResetAfter <= 0 {
+		t.Fatalf("second call = %+v, want denied with a ResetAfter", res)
+	}
+}
+
+func TestHTTPTransportMapsPeerErrors(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		want    error
+	}{
+		{"exceeds burst", func(w http.ResponseWriter, r *http.Request) {
+			writePeerError(w, fmt.Errorf("checking: %w", ErrExceedsBurst))
+		}, ErrExceedsBurst},
+		{"invalid cost", func(w http.ResponseWriter, r *http.Request) {
+			writePeerError(w, ErrInvalidCost)
+		}, ErrInvalidCost},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+
+			_, err := (&HTTPTransport{}).Allow(ctx, srv.URL, "k", limit)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("error = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	// Other failures are backend errors
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writePeerError(w, errors.New("disk on fire"))
+	}))
+	defer srv.Close()
+	_, err := (&HTTPTransport{}).Allow(ctx, srv.URL, "k", limit)
+	if err == nil || ReasonFor(err) != ReasonBackendError {
+		t.Errorf("error = %v, want a backend error", err)
+	}
+
+	// A peer throttling the call denies the request instead of failing it
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	res, err := (&HTTPTransport{}).Allow(ctx, srv.URL, "k", limit)
+	if err != nil {
+		t.Fatalf("429 from the peer: error %v, want a denial", err)
+	}
+	if res.Allowed || res.Reason != ReasonRateExceeded || res.ResetAfter != 3*time.Second {
+		t.Errorf("429 from the peer = %+v, want denied for 3s", res)
+	}
+}
@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Event describes a single decision, as published by EventLimiter.
+type Event struct {
+	Key       string
+	Allowed   bool
+	Remaining int
+	Time      time.Time
+}
+
+// EventLimiter implements the Strategy interface by publishing every decision of the inner
+// strategy to a buffered channel, e.g. to feed a live view of who is being throttled.
+//
+// Publishing never blocks: when the buffer is full because nobody reads Events fast enough,
+// the event is dropped and counted in Dropped, so a stalled consumer costs the hot path
+// nothing. Consumers that need every decision should use AuditLimiter instead. Failed
+// requests are not published.
+type EventLimiter struct {
+	inner   Strategy
+	events  chan Event
+	dropped atomic.Uint64
+}
+
+// NewEventLimiter creates a new EventLimiter wrapping inner, buffering up to bufferSize events.
+func NewEventLimiter(inner Strategy, bufferSize int) *EventLimiter {
+	return &EventLimiter{
+		inner:  inner,
+		events: make(chan Event, bufferSize),
+	}
+}
+
+// Allow checks the request against the inner strategy and publishes the decision.
+func (e *EventLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	res, err := e.inner.Allow(ctx, key, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case e.events <- Event{Key: key, Allowed: res.Allowed, Remaining: res.Remaining, Time: time.Now()}:
+	default:
+		e.dropped.Add(1)
+	}
+
+	return res, nil
+}
+
+// Events returns the channel decisions are published to. It is shared by all readers,
+// so each event is received by only one of them. It is never closed.
+func (e *EventLimiter) Events() <-chan Event {
+	return e.events
+}
+
+// Dropped returns how many events were dropped because the buffer was full.
+func (e *EventLimiter) Dropped() uint64 {
+	return e.dropped.Load()
+}
@@ -0,0 +1,95 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventLimiterPublishesDecisions(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Period: time.Minute, Burst: 2}
+	e := NewEventLimiter(NewTokenBucket(), 10)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := e.Allow(ctx, "k", limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, want := range []Event{
+		{Key: "k", Allowed: true, Remaining: 1},
+		{Key: "k", Allowed: true, Remaining: 0},
+		{Key: "k", Allowed: false, Remaining: 0},
+	} {
+		select {
+		case got := <-e.Events():
+			if got.Key != want.Key || got.Allowed != want.Allowed || got.Remaining != want.Remaining {
+				t.Errorf("event %d = %+v, want %+v", i, got, want)
+			}
+			if got.Time.Before(start) || got.Time.After(time.Now()) {
+				t.Errorf("event %d time %s, want the time of the decision", i, got.Time)
+			}
+		default:
+			t.Fatalf("event %d missing", i)
+		}
+	}
+	if e.Dropped() != 0 {
+		t.Errorf("dropped %d events with room in the buffer", e.Dropped())
+	}
+}
+
+func TestEventLimiterDropsWhenFull(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 100, Period: time.Minute, Burst: 100}
+	e := NewEventLimiter(NewTokenBucket(), 2)
+
+	// Nobody reads: the requests still go through, and the overflow is dropped
+	for i := 0; i < 5; i++ {
+		res, err := e.Allow(ctx, "k", limit)
+		if err != nil || !res.Allowed {
+			t.Fatalf("request %d = %+v, %v, want allowed despite the full buffer", i, res, err)
+		}
+	}
+	if e.Dropped() != 3 {
+		t.Fatalf("dropped %d events, want 3", e.Dropped())
+	}
+
+	// The oldest events were kept
+	for _, want := range []int{99, 98} {
+		if got := <-e.Events(); got.Remaining != want {
+			t.Errorf("kept event with %d remaining, want %d", got.Remaining, want)
+		}
+	}
+
+	// Draining makes room again
+	e.Allow(ctx, "k", limit)
+	select {
+	case got := <-e.Events():
+		if got.Remaining != 94 {
+			t.Errorf("event after draining has %d remaining, want 94", got.Remaining)
+		}
+	default:
+		t.Error("no event published after draining")
+	}
+	if e.Dropped() != 3 {
+		t.Errorf("dropped %d events, want still 3", e.Dropped())
+	}
+}
+
+func TestEventLimiterSkipsFailures(t *testing.T) {
+	e := NewEventLimiter(strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		return nil, errBackend
+	}), 1)
+
+	if _, err := e.Allow(context.Background(), "k", Limit{Rate: 1, Period: time.Second}); !errors.Is(err, errBackend) {
+		t.Fatalf("error = %v, want the backend error", err)
+	}
+	select {
+	case ev := <-e.Events():
+		t.Errorf("failed request published %+v", ev)
+	default:
+	}
+}
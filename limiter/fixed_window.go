@@ -0,0 +1,203 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FixedWindow implements the Strategy interface using the fixed window counter algorithm.
+// Windows are aligned to multiples of the period since the zero time, so a 24h period
+// resets at midnight UTC. It allows up to Rate requests per window and ignores Burst.
+type FixedWindow struct {
+	mu      sync.Mutex
+	windows map[string]*fixedState
+	clock   Clock
+	janitor janitor
+}
+
+type fixedState struct {
+	windowStart time.Time
+	count       int
+}
+
+// NewFixedWindow creates a new instance of FixedWindow strategy.
+// It accepts WithCleanup and WithClock.
+func NewFixedWindow(opts ...Option) *FixedWindow {
+	o := applyOptions(opts)
+	return &FixedWindow{
+		windows: make(map[string]*fixedState),
+		clock:   o.clock,
+		janitor: janitor{idle: o.cleanupIdle},
+	}
+}
+
+// Allow checks if the request is allowed based on the fixed window algorithm.
+func (fw *FixedWindow) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("fixed_window"), nil
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return fw.allow(key, limit, fw.clock.Now()), nil
+}
+
+// AllowMulti checks each key independently under a single lock, see the package-level AllowMulti.
+func (fw *FixedWindow) AllowMulti(ctx context.Context, reqs []KeyLimit) ([]*Result, error) {
+	results := make([]*Result, len(reqs))
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	now := fw.clock.Now()
+	for i, req := range reqs {
+		if req.Limit.IsUnlimited() {
+			results[i] = unlimitedResult("fixed_window")
+			continue
+		}
+		results[i] = fw.allow(req.Key, req.Limit, now)
+	}
+	return results, nil
+}
+
+// allow checks and counts a request for key. Must be called with the lock held.
+func (fw *FixedWindow) allow(key string, limit Limit, now time.Time) *Result {
+	if fw.janitor.due(now) {
+		for k, w := range fw.windows {
+			if now.Sub(w.windowStart) >= fw.janitor.idle {
+				delete(fw.windows, k)
+			}
+		}
+	}
+
+	w, exists := fw.windows[key]
+	if !exists {
+		w = &fixedState{}
+		fw.windows[key] = w
+	}
+	return admitFixed(w, limit, now)
+}
+
+// Peek reports whether a request for key would be allowed, without counting it.
+func (fw *FixedWindow) Peek(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("fixed_window"), nil
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	var peek fixedState
+	if w, exists := fw.windows[key]; exists {
+		peek = *w
+	}
+	return peekResult(admitFixed(&peek, limit, fw.clock.Now())), nil
+}
+
+// Quota reports the count of key in the current window, which resets when the window ends.
+func (fw *FixedWindow) Quota(ctx context.Context, key string, limit Limit) (*Quota, error) {
+	now := fw.clock.Now()
+	if limit.IsUnlimited() {
+		return unlimitedQuota(now), nil
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	start := now.Truncate(limit.Period)
+	count := 0
+	if w, exists := fw.windows[key]; exists && !start.After(w.windowStart) {
+		count = w.count
+	}
+	resetAt := now
+	if count > 0 {
+		resetAt = start.Add(limit.Period)
+	}
+	return newQuota(limit.Rate, limit.Rate-count, resetAt), nil
+}
+
+// admitFixed moves w to the window containing now and counts a request in it if there is room.
+func admitFixed(w *fixedState, limit Limit, now time.Time) *Result {
+	start := now.Truncate(limit.Period)
+
+	// Only move forward, so a clock stepping backwards doesn't reset the count
+	if start.After(w.windowStart) {
+		w.windowStart = start
+		w.count = 0
+	}
+
+	result := newResult("fixed_window")
+	if w.count < limit.Rate {
+		w.count++
+		result.Allowed = true
+		result.Remaining = limit.Rate - w.count
+	} else {
+		result.Allowed = false
+		result.Reason = ReasonRateExceeded
+		result.Remaining = 0
+		result.ResetAfter = computeResetAfter(start.Add(limit.Period).Sub(now))
+	}
+
+	return result
+}
+
+// Refund removes n requests from the current window count for key.
+func (fw *FixedWindow) Refund(ctx context.Context, key string, limit Limit, n int) error {
+	if limit.IsUnlimited() {
+		return nil
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	w, exists := fw.windows[key]
+	if !exists {
+		return nil
+	}
+
+	w.count -= n
+	if w.count < 0 {
+		w.count = 0
+	}
+	return nil
+}
+
+// ActiveKeys returns the keys that used up limit in the current window.
+func (fw *FixedWindow) ActiveKeys(ctx context.Context, limit Limit) ([]string, error) {
+	if limit.IsUnlimited() {
+		return nil, nil
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	start := fw.clock.Now().Truncate(limit.Period)
+	var keys []string
+	for key, w := range fw.windows {
+		if w.windowStart.Equal(start) && w.count >= limit.Rate {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Reset clears the state of key, restoring its full budget.
+func (fw *FixedWindow) Reset(ctx context.Context, key string) (bool, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	_, exists := fw.windows[key]
+	delete(fw.windows, key)
+	return exists, nil
+}
+
+// Flush clears the state of every key, restoring their full budgets.
+func (fw *FixedWindow) Flush(ctx context.Context) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.windows = make(map[string]*fixedState)
+	return nil
+}
@@ -0,0 +1,55 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+)
+
+// GlobalLimiter implements the Strategy interface with a single token bucket shared by every key.
+// Use it to cap total throughput to a backend regardless of which client sends the request.
+type GlobalLimiter struct {
+	mu    sync.Mutex
+	b     *bucket
+	tb    *TokenBucket // Creates the bucket, honouring the options
+	clock Clock
+}
+
+// NewGlobalLimiter creates a new instance of GlobalLimiter.
+// It accepts WithInitialTokens and WithClock.
+func NewGlobalLimiter(opts ...Option) *GlobalLimiter {
+	o := applyOptions(opts)
+	return &GlobalLimiter{
+		tb:    NewTokenBucket(opts...),
+		clock: o.clock,
+	}
+}
+
+// Allow checks if the request is allowed. The key is ignored.
+func (g *GlobalLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return g.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN checks if a request consuming n tokens is allowed. The key is ignored.
+func (g *GlobalLimiter) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	if n <= 0 {
+		return nil, ErrInvalidCost
+	}
+	if limit.IsUnlimited() {
+		return unlimitedResult("global"), nil
+	}
+	if exceedsBurst(float64(n), limit) {
+		return nil, ErrExceedsBurst
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	if g.b == nil {
+		g.b = g.tb.create(limit, now)
+	}
+
+	res := g.b.take(limit, float64(n), now)
+	res.Source = "global"
+	return res, nil
+}
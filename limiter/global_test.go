@@ -0,0 +1,28 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGlobalLimiterSharesOneBudget(t *testing.T) {
+	ctx := context.Background()
+	g := NewGlobalLimiter(WithClock(newFakeClock()))
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 5}
+
+	for i := 0; i < 5; i++ {
+		res, err := g.Allow(ctx, fmt.Sprintf("client-%d", i), limit)
+		if err != nil || !res.Allowed {
+			t.Fatalf("client-%d = %+v, %v, want allowed", i, res, err)
+		}
+	}
+	res, err := g.Allow(ctx, "someone-else", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Fatal("a new key got its own budget, want the shared one exhausted")
+	}
+}
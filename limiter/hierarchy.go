@@ -0,0 +1,96 @@
+package limiter
+
+import (
+	"context"
+)
+
+// Level is one tier of a HierarchicalLimiter, e.g. the users, teams or orgs of a tenancy.
+type Level struct {
+	Name     string // Prefixed to the level's keys, so levels can share one strategy
+	Strategy Strategy
+	Limit    Limit
+	// Key maps the request key to the key of this level, e.g. a user ID to its org ID.
+	// Nil keeps the request key, as for the bottom level.
+	Key func(key string) string
+}
+
+// HierarchicalLimiter implements the Strategy interface for hierarchical tenancy, where a
+// request of a user also draws down the budgets of its team and its org. The request is
+// allowed only if every level allows it, and then consumes one unit from each of them; a
+// denial names the exhausted level in the result's Source, whether it is the user's own
+// limit or, say, the org cap shared by all its users.
+//
+// Levels are given child first. Consumption is atomic when every level uses the same
+// strategy and that strategy implements MultiAllower, as RedisMultiBucket does: all levels
+// are checked and charged in a single script, so concurrent requests can never overdraw a
+// parent. Otherwise levels are checked one by one like MultiLimiter does, and the levels
+// already charged are refunded on denial if their strategy implements Refunder; in between,
+// a concurrent request may see the parent's budget lowered by a request that ends up denied.
+type HierarchicalLimiter struct {
+	levels []Level
+	shared Strategy // The strategy of every level, nil if they differ
+}
+
+// NewHierarchicalLimiter creates a new HierarchicalLimiter over levels, child first.
+func NewHierarchicalLimiter(levels ...Level) *HierarchicalLimiter {
+	h := &HierarchicalLimiter{
+		levels: levels,
+	}
+	if len(levels) > 0 {
+		h.shared = levels[0].Strategy
+		for _, level := range levels[1:] {
+			if level.Strategy != h.shared {
+				h.shared = nil
+				break
+			}
+		}
+	}
+	return h
+}
+
+// Allow checks the request against every level.
+// The limit argument is ignored, each level applies its own limit.
+// The result's Source is set to the name of the level that decided it.
+func (h *HierarchicalLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	res, _, err := h.AllowWithKey(ctx, key, limit)
+	return res, err
+}
+
+// AllowWithKey is like Allow but also returns the namespaced key of the level that decided
+// the result, e.g. "org:acme".
+func (h *HierarchicalLimiter) AllowWithKey(ctx context.Context, key string, limit Limit) (*Result, string, error) {
+	checks := make([]check, len(h.levels))
+	for i, level := range h.levels {
+		k := key
+		if level.Key != nil {
+			k = level.Key(key)
+		}
+		if level.Name != "" {
+			k = level.Name + ":" + k
+		}
+		checks[i] = check{name: level.Name, strategy: level.Strategy, key: k, limit: level.Limit}
+	}
+
+	var (
+		res *Result
+		i   int
+		err error
+	)
+	if m, ok := h.shared.(MultiAllower); ok {
+		reqs := make([]KeyLimit, len(checks))
+		for j, c := range checks {
+			reqs[j] = KeyLimit{Key: c.key, Limit: c.limit}
+		}
+		res, i, err = m.AllowAll(ctx, reqs)
+		if res != nil && i >= 0 && checks[i].name != "" {
+			res.Source = checks[i].name
+		}
+	} else {
+		res, i, err = allowAll(ctx, checks)
+	}
+
+	if i < 0 || i >= len(checks) {
+		return res, key, err
+	}
+	return res, checks[i].key, err
+}
@@ -0,0 +1,126 @@
+package limiter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// orgOf maps user keys like "acme/alice" to their org, "acme".
+func orgOf(user string) string {
+	org, _, _ := strings.Cut(user, "/")
+	return org
+}
+
+func TestHierarchicalLimiterOrgCapBindsFirst(t *testing.T) {
+	ctx := context.Background()
+	userLimit := Limit{Rate: 5, Period: time.Hour, Burst: 5}
+	orgLimit := Limit{Rate: 3, Period: time.Hour, Burst: 3}
+	_, client := newTestRedis(t)
+
+	for name, newStrategy := range map[string]func() Strategy{
+		"token_bucket": func() Strategy { return NewTokenBucket(WithClock(newFakeClock())) },
+		// Checked atomically in one script
+		"redis_multi": func() Strategy { return NewRedisMultiBucket(client) },
+	} {
+		s := newStrategy()
+		h := NewHierarchicalLimiter(
+			Level{Name: "user", Strategy: s, Limit: userLimit},
+			Level{Name: "org", Strategy: s, Limit: orgLimit, Key: orgOf},
+		)
+
+		// The org's 3 requests are shared by its users, each well under their own cap
+		for i, user := range []string{"acme/alice", "acme/alice", "acme/bob"} {
+			if res := must(h.Allow(ctx, user, Limit{})); !res.Allowed {
+				t.Fatalf("%s: request %d of %s denied", name, i, user)
+			}
+		}
+		res, key, err := h.AllowWithKey(ctx, "acme/bob", Limit{})
+		if err != nil || res.Allowed || res.Source != "org" || key != "org:acme" {
+			t.Errorf("%s: 4th request = %+v on %q, %v, want denied by org:acme", name, res, key, err)
+		}
+
+		// The denial charged no level: bob still has 4 of his 5
+		q, err := s.(QuotaReporter).Quota(ctx, "user:acme/bob", userLimit)
+		if err != nil || q.Remaining != 4 {
+			t.Errorf("%s: bob's quota = %+v, %v, want 4 remaining", name, q, err)
+		}
+
+		// Other orgs have their own budget
+		if res := must(h.Allow(ctx, "globex/carol", Limit{})); !res.Allowed {
+			t.Errorf("%s: user of another org denied", name)
+		}
+	}
+}
+
+func TestHierarchicalLimiterUserCapBinds(t *testing.T) {
+	ctx := context.Background()
+	tb := NewTokenBucket(WithClock(newFakeClock()))
+	orgLimit := Limit{Rate: 10, Period: time.Hour, Burst: 10}
+	h := NewHierarchicalLimiter(
+		Level{Name: "user", Strategy: tb, Limit: Limit{Rate: 1, Period: time.Hour, Burst: 1}},
+		Level{Name: "org", Strategy: tb, Limit: orgLimit, Key: orgOf},
+	)
+
+	must(h.Allow(ctx, "acme/alice", Limit{}))
+	res, key, err := h.AllowWithKey(ctx, "acme/alice", Limit{})
+	if err != nil || res.Allowed || res.Source != "user" || key != "user:acme/alice" {
+		t.Errorf("2nd request = %+v on %q, %v, want denied by user:acme/alice", res, key, err)
+	}
+	if q, _ := tb.Quota(ctx, "org:acme", orgLimit); q.Remaining != 9 {
+		t.Errorf("org remaining = %d, want 9: only the allowed request charged", q.Remaining)
+	}
+}
+
+func TestHierarchicalLimiterRefundsAcrossStrategies(t *testing.T) {
+	ctx := context.Background()
+	users, orgs := NewTokenBucket(WithClock(newFakeClock())), NewTokenBucket(WithClock(newFakeClock()))
+	userLimit := Limit{Rate: 5, Period: time.Hour, Burst: 5}
+	h := NewHierarchicalLimiter(
+		Level{Name: "user", Strategy: users, Limit: userLimit},
+		Level{Name: "org", Strategy: orgs, Limit: Limit{Rate: 1, Period: time.Hour, Burst: 1}, Key: orgOf},
+	)
+
+	must(h.Allow(ctx, "acme/alice", Limit{}))
+	if res := must(h.Allow(ctx, "acme/alice", Limit{})); res.Allowed || res.Source != "org" {
+		t.Fatalf("2nd request = %+v, want denied by org", res)
+	}
+	// The user level was charged before the org denied, then refunded
+	if q, _ := users.Quota(ctx, "user:acme/alice", userLimit); q.Remaining != 4 {
+		t.Errorf("alice's remaining = %d, want 4 after the refund", q.Remaining)
+	}
+}
+
+func TestHierarchicalLimiterAtomicUnderConcurrency(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	r := NewRedisMultiBucket(client)
+	h := NewHierarchicalLimiter(
+		Level{Name: "user", Strategy: r, Limit: Limit{Rate: 100, Period: time.Hour, Burst: 100}},
+		Level{Name: "org", Strategy: r, Limit: Limit{Rate: 10, Period: time.Hour, Burst: 10}, Key: orgOf},
+	)
+
+	var admitted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			res, err := h.Allow(ctx, user, Limit{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Allowed {
+				admitted.Add(1)
+			}
+		}("acme/" + string(rune('a'+i%5)))
+	}
+	wg.Wait()
+	if n := admitted.Load(); n != 10 {
+		t.Errorf("admitted %d concurrent requests, want exactly the org cap of 10", n)
+	}
+}
@@ -0,0 +1,225 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// LeakyBucket implements the Strategy interface using the leaky bucket (as a meter) algorithm.
+// Every request adds to the bucket's level, which leaks at Rate per Period, and a request is
+// admitted only if it fits under the capacity (Burst).
+type LeakyBucket struct {
+	mu      sync.Mutex
+	buckets map[string]*leakyState
+	clock   Clock
+	janitor janitor
+}
+
+type leakyState struct {
+	level    float64
+	lastLeak time.Time
+}
+
+// NewLeakyBucket creates a new instance of LeakyBucket strategy.
+// It accepts WithCleanup and WithClock.
+func NewLeakyBucket(opts ...Option) *LeakyBucket {
+	o := applyOptions(opts)
+	return &LeakyBucket{
+		buckets: make(map[string]*leakyState),
+		clock:   o.clock,
+		janitor: janitor{idle: o.cleanupIdle},
+	}
+}
+
+// Allow checks if the request is allowed based on the leaky bucket algorithm.
+func (lb *LeakyBucket) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return lb.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN checks if a request adding n to the bucket is allowed.
+// It returns ErrExceedsBurst without touching the bucket if n can never fit in it.
+func (lb *LeakyBucket) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	if n <= 0 {
+		return nil, ErrInvalidCost
+	}
+	if limit.IsUnlimited() {
+		return unlimitedResult("leaky_bucket"), nil
+	}
+	if exceedsBurst(float64(n), limit) {
+		return nil, ErrExceedsBurst
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	return lb.allow(key, limit, float64(n), lb.clock.Now()), nil
+}
+
+// AllowMulti checks each key independently under a single lock, see the package-level AllowMulti.
+func (lb *LeakyBucket) AllowMulti(ctx context.Context, reqs []KeyLimit) ([]*Result, error) {
+	results := make([]*Result, len(reqs))
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := lb.clock.Now()
+	for i, req := range reqs {
+		if req.Limit.IsUnlimited() {
+			results[i] = unlimitedResult("leaky_bucket")
+			continue
+		}
+		results[i] = lb.allow(req.Key, req.Limit, 1, now)
+	}
+	return results, nil
+}
+
+// allow adds amount to the bucket for key if it fits. Must be called with the lock held.
+func (lb *LeakyBucket) allow(key string, limit Limit, amount float64, now time.Time) *Result {
+	if lb.janitor.due(now) {
+		for k, b := range lb.buckets {
+			if now.Sub(b.lastLeak) >= lb.janitor.idle {
+				delete(lb.buckets, k)
+			}
+		}
+	}
+
+	b, exists := lb.buckets[key]
+	if !exists {
+		b = &leakyState{lastLeak: now}
+		lb.buckets[key] = b
+	}
+	return admitLeaky(b, limit, amount, now)
+}
+
+// Peek reports whether a request for key would be allowed, without adding it to the bucket.
+func (lb *LeakyBucket) Peek(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("leaky_bucket"), nil
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := lb.clock.Now()
+	peek := leakyState{lastLeak: now}
+	if b, exists := lb.buckets[key]; exists {
+		peek = *b
+	}
+	return peekResult(admitLeaky(&peek, limit, 1, now)), nil
+}
+
+// Quota reports the room left in the bucket of key and when it will have drained.
+func (lb *LeakyBucket) Quota(ctx context.Context, key string, limit Limit) (*Quota, error) {
+	now := lb.clock.Now()
+	if limit.IsUnlimited() {
+		return unlimitedQuota(now), nil
+	}
+
+	if limit.Rate == 0 {
+		return newQuota(0, 0, now), nil
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	peek := leakyState{lastLeak: now}
+	if b, exists := lb.buckets[key]; exists {
+		peek = *b
+	}
+	leakPerSec := peek.leak(limit, now)
+	drain := secondsToDuration(peek.level / leakPerSec)
+	return newQuota(limit.Burst, wholeUnits(float64(limit.Burst)-peek.level), now.Add(drain)), nil
+}
+
+// admitLeaky drains b up to now and adds amount to it if it fits.
+func admitLeaky(b *leakyState, limit Limit, amount float64, now time.Time) *Result {
+	// Drain what leaked since the last request
+	leakPerSec := b.leak(limit, now)
+
+	capacity := float64(limit.Burst)
+	result := newResult("leaky_bucket")
+
+	if exceedsBurst(amount, limit) {
+		// Only Peek and AllowMulti get here, the others fail with ErrExceedsBurst.
+		// Waiting never helps, so there is no ResetAfter.
+		result.Reason = ReasonBurstExceeded
+	} else if b.level+amount <= capacity {
+		b.level += amount
+		result.Allowed = true
+		result.Remaining = wholeUnits(capacity - b.level)
+	} else {
+		result.Allowed = false
+		result.Reason = ReasonRateExceeded
+		result.Remaining = wholeUnits(capacity - b.level)
+		// Time to leak enough for the request to fit
+		waitSec := (b.level + amount - capacity) / leakPerSec
+		result.ResetAfter = computeResetAfter(time.Duration(waitSec * float64(time.Second)))
+	}
+
+	return result
+}
+
+// leak drains what leaked since the last update and returns the leak rate.
+func (b *leakyState) leak(limit Limit, now time.Time) float64 {
+	leakPerSec := float64(limit.Rate) / limit.Period.Seconds()
+	elapsed := now.Sub(b.lastLeak).Seconds()
+	if elapsed < 0 {
+		// The clock stepped backwards. Leak nothing and keep lastLeak.
+		return leakPerSec
+	}
+	if leakPerSec <= 0 {
+		// A zero Rate never leaks
+		b.lastLeak = now
+		return leakPerSec
+	}
+	// Never leak more than the bucket holds, so long idle times or clock jumps
+	// can't overflow the product or lose precision
+	if drain := b.level / leakPerSec; elapsed > drain {
+		elapsed = drain
+	}
+	b.level = math.Max(0, b.level-elapsed*leakPerSec)
+	b.lastLeak = now
+	return leakPerSec
+}
+
+// ActiveKeys returns the keys whose bucket has no room left for one more request under limit.
+func (lb *LeakyBucket) ActiveKeys(ctx context.Context, limit Limit) ([]string, error) {
+	if limit.IsUnlimited() {
+		return nil, nil
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := lb.clock.Now()
+	var keys []string
+	for key, b := range lb.buckets {
+		peek := *b
+		peek.leak(limit, now)
+		if peek.level+1 > float64(limit.Burst) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Reset clears the state of key, restoring its full budget.
+func (lb *LeakyBucket) Reset(ctx context.Context, key string) (bool, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	_, exists := lb.buckets[key]
+	delete(lb.buckets, key)
+	return exists, nil
+}
+
+// Flush clears the state of every key, restoring their full budgets.
+func (lb *LeakyBucket) Flush(ctx context.Context) error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.buckets = make(map[string]*leakyState)
+	return nil
+}
@@ -0,0 +1,25 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLeakyBucketLongIdleDrainsToEmpty(t *testing.T) {
+	clock := newFakeClock()
+	lb := NewLeakyBucket(WithClock(clock))
+	// A rate high enough that days of leaking would overflow any sensible level
+	limit := Limit{Rate: math.MaxInt32, Period: time.Nanosecond, Burst: 10}
+
+	exhaust(t, lb, "k", limit)
+	clock.Advance(30 * 24 * time.Hour)
+
+	if n := exhaust(t, lb, "k", limit); n != limit.Burst {
+		t.Fatalf("admitted %d after a month idle, want the full burst of %d", n, limit.Burst)
+	}
+	if res := must(lb.Allow(context.Background(), "k", limit)); res.Allowed {
+		t.Fatal("request over the burst allowed")
+	}
+}
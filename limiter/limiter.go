@@ -2,14 +2,97 @@ package limiter
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"time"
 )
 
+// ErrExceedsBurst is returned when a request asks for more tokens than the limit's burst,
+// including any request under a zero Burst, and by the bucket strategies for any request
+// under a zero Rate, which never refills. Such a request can never succeed, so callers
+// should reject it permanently instead of retrying.
+var ErrExceedsBurst = errors.New("limiter: request exceeds burst size")
+
+// exceedsBurst reports whether a request of cost can never fit in the burst of limit, or
+// is under a zero Rate that never refills it, and should fail with ErrExceedsBurst instead
+// of being denied with a wait that never ends.
+func exceedsBurst(cost float64, limit Limit) bool {
+	return cost > float64(limit.Burst) || limit.Rate == 0
+}
+
+// burstExceededResult is the denial of a request that can never fit in the burst, for the
+// calls that decide several keys at once and report it per key instead of failing with
+// ErrExceedsBurst. Waiting never helps, so it has no ResetAfter.
+func burstExceededResult(source string) *Result {
+	res := newResult(source)
+	res.Reason = ReasonBurstExceeded
+	return res
+}
+
+// ErrInvalidCost is returned when a request asks for a non-positive amount of capacity,
+// e.g. AllowN with n <= 0.
+var ErrInvalidCost = errors.New("limiter: cost must be positive")
+
 // Result represents the result of a rate limit check
 type Result struct {
 	Allowed    bool
 	Remaining  int
 	ResetAfter time.Duration
+	// Source names the strategy (or multi-limiter rule) that produced the result.
+	// It is optional and may be empty.
+	Source string
+	// Reason tells why the request was allowed or denied. It is ReasonOK for allowed requests.
+	Reason Reason
+}
+
+// Reason classifies a rate limit decision, for client error messages and metrics.
+type Reason int
+
+const (
+	// ReasonOK means the request was allowed.
+	ReasonOK Reason = iota
+	// ReasonRateExceeded means the key has used up its budget for now; retrying later may succeed.
+	ReasonRateExceeded
+	// ReasonBurstExceeded means the request is larger than the burst, or under a zero Rate,
+	// and can never succeed.
+	ReasonBurstExceeded
+	// ReasonBackendError means the decision could not be made, e.g. because Redis is down.
+	ReasonBackendError
+	// ReasonOverloaded means the request was shed to protect an overloaded service, whatever its key.
+	ReasonOverloaded
+)
+
+// String returns the snake_case name of r, e.g. "rate_exceeded".
+func (r Reason) String() string {
+	switch r {
+	case ReasonOK:
+		return "ok"
+	case ReasonRateExceeded:
+		return "rate_exceeded"
+	case ReasonBurstExceeded:
+		return "burst_exceeded"
+	case ReasonBackendError:
+		return "backend_error"
+	case ReasonOverloaded:
+		return "overloaded"
+	default:
+		return "unknown"
+	}
+}
+
+// ReasonFor returns the Reason for an error returned by a strategy:
+// ReasonBurstExceeded for ErrExceedsBurst, ReasonBackendError for any other error
+// and ReasonOK for nil.
+func ReasonFor(err error) Reason {
+	switch {
+	case err == nil:
+		return ReasonOK
+	case errors.Is(err, ErrExceedsBurst):
+		return ReasonBurstExceeded
+	default:
+		return ReasonBackendError
+	}
 }
 
 // Strategy defines the interface for different rate limiting algorithms
@@ -18,9 +101,224 @@ type Strategy interface {
 	Allow(ctx context.Context, key string, limit Limit) (*Result, error)
 }
 
+// StrategyN is implemented by strategies that can consume more than one unit per request.
+//
+// The cost n must be positive: AllowN rejects zero and negative costs with ErrInvalidCost,
+// whatever the limit, without touching the key. A free check would otherwise pass as an
+// allowed request and a negative one would hand out capacity; use Peek to check a key
+// without consuming anything, and Refund to give capacity back.
+type StrategyN interface {
+	Strategy
+	// AllowN checks if a request costing n units is allowed
+	AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error)
+}
+
+// Refunder is implemented by strategies that can give back capacity consumed by an earlier Allow.
+type Refunder interface {
+	// Refund returns n units to key, never exceeding the limit's capacity
+	Refund(ctx context.Context, key string, limit Limit, n int) error
+}
+
+// Seeder is implemented by strategies whose per-key state can be restored, e.g. after a deploy.
+type Seeder interface {
+	// Seed sets key to hold tokens as of time at. Tokens are not checked against the burst
+	// when seeding, they are capped on the next refill.
+	Seed(ctx context.Context, key string, tokens float64, at time.Time) error
+}
+
+// Resettable is implemented by strategies that can clear the state of a key on demand.
+type Resettable interface {
+	// Reset clears key, restoring its full budget. It reports whether the key had any state.
+	Reset(ctx context.Context, key string) (bool, error)
+}
+
+// Flusher is implemented by strategies that can clear the state of every key at once,
+// e.g. for test teardown or an admin "reset everything" action.
+type Flusher interface {
+	// Flush clears every key, restoring their full budgets.
+	Flush(ctx context.Context) error
+}
+
+// Preloader is implemented by strategies running Lua scripts on Redis.
+type Preloader interface {
+	// Preload loads the scripts into the Redis script cache with SCRIPT LOAD, so the first
+	// requests don't pay for a NOSCRIPT round trip. Call it at startup; scripts evicted by
+	// SCRIPT FLUSH or a Redis restart are still reloaded on demand.
+	Preload(ctx context.Context) error
+}
+
+// Peekable is implemented by strategies that can report a key's quota without consuming it.
+type Peekable interface {
+	// Peek reports whether a single-unit request for key would be allowed right now, and how
+	// many such requests remain, without changing any state.
+	Peek(ctx context.Context, key string, limit Limit) (*Result, error)
+}
+
+// peekResult turns the result of a request run against a copy of a key's state into the
+// result of Peek, by giving back the unit the request consumed.
+func peekResult(res *Result) *Result {
+	if res.Allowed {
+		res.Remaining++
+	}
+	return res
+}
+
+// KeyLister is implemented by strategies that can enumerate the keys currently being throttled.
+type KeyLister interface {
+	// ActiveKeys returns the keys whose next single-unit request under limit would be denied.
+	// The answer is approximate: state keeps changing while and after it is computed.
+	ActiveKeys(ctx context.Context, limit Limit) ([]string, error)
+}
+
+// KeyReporter is implemented by strategies that store state under a key derived from the
+// one they are given, e.g. by prefixing it.
+type KeyReporter interface {
+	// AllowWithKey behaves like Allow and also returns the key the decision was made under
+	AllowWithKey(ctx context.Context, key string, limit Limit) (*Result, string, error)
+}
+
+// AllowWithKey checks the request against s like s.Allow, and also returns the effective key
+// the decision was made under, for debugging and metrics. It is key itself unless s
+// implements KeyReporter.
+func AllowWithKey(ctx context.Context, s Strategy, key string, limit Limit) (*Result, string, error) {
+	if kr, ok := s.(KeyReporter); ok {
+		return kr.AllowWithKey(ctx, key, limit)
+	}
+	res, err := s.Allow(ctx, key, limit)
+	return res, key, err
+}
+
+// RateLimitError is returned by Check when a request is denied. It carries the Result of
+// the denial, which is never recycled, so it may be kept.
+type RateLimitError struct {
+	Result *Result
+}
+
+// Error describes the denial, e.g. "limiter: rate_exceeded, retry after 1.5s".
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("limiter: %s, retry after %s", e.Result.Reason, e.Result.ResetAfter)
+}
+
+// RetryAfter returns how long to wait before the request may be allowed.
+func (e *RateLimitError) RetryAfter() time.Duration {
+	return e.Result.ResetAfter
+}
+
+// Check checks the request against s like s.Allow, for callers that prefer error-based
+// control flow: it returns nil when the request is allowed, a *RateLimitError when it is
+// denied, and the strategy's error as is when the check failed. Use errors.As to tell a
+// denial from a failure:
+//
+//	if err := limiter.Check(ctx, s, key, limit); err != nil {
+//		var denied *limiter.RateLimitError
+//		if errors.As(err, &denied) {
+//			time.Sleep(denied.RetryAfter())
+//		}
+//		return err
+//	}
+func Check(ctx context.Context, s Strategy, key string, limit Limit) error {
+	res, err := s.Allow(ctx, key, limit)
+	if err != nil {
+		return err
+	}
+	if !res.Allowed {
+		return &RateLimitError{Result: res}
+	}
+	ReleaseResult(res)
+	return nil
+}
+
 // Limit defines the rate limiting rules
+//
+// Burst is honoured by the bucket strategies (token bucket, leaky bucket and their Redis
+// versions), which let a client exceed the steady Rate by up to Burst requests after being
+// idle. The window strategies (SlidingWindow, FixedWindow) count requests per period and
+// ignore it, so the same Limit with Burst > Rate admits a larger spike from a token bucket
+// than from a sliding window. Set Burst equal to Rate to get comparable behaviour.
 type Limit struct {
 	Rate   int           // How many requests
 	Period time.Duration // Time window (e.g., Per Second, Per Minute)
 	Burst  int           // Maximum burst size (e.g. for Token Bucket)
 }
+
+// Per returns a limit of n requests per period d, with the given burst.
+func Per(n int, d time.Duration, burst int) Limit {
+	return Limit{Rate: n, Period: d, Burst: burst}
+}
+
+// PerSecond returns a limit of rps requests per second, with the given burst. Fractional
+// rates are turned into a whole number of requests over a whole number of seconds, e.g.
+// 2.5 becomes 5 per 2s and 1/3 becomes 1 per 3s. Rates that need a longer period are
+// approximated by the closest fraction over at most 1000 seconds (or 1/rps seconds for
+// rates below one request in 1000 seconds), to keep windows short. Periods are capped at
+// the longest whole number of seconds a Duration holds, about 292 years, so slower rates
+// round down to a zero Rate, and rates beyond math.MaxInt per second are capped at it. A
+// non-positive or NaN rps yields a limit that denies every request, whatever the burst,
+// and an infinite one yields Unlimited.
+func PerSecond(rps float64, burst int) Limit {
+	if rps <= 0 || math.IsNaN(rps) {
+		return Limit{Rate: 0, Period: time.Second, Burst: 0}
+	}
+	if math.IsInf(rps, 1) {
+		return Unlimited
+	}
+	if rps >= math.MaxInt {
+		return Limit{Rate: math.MaxInt, Period: time.Second, Burst: burst}
+	}
+
+	maxSeconds := math.Min(math.Max(1000, math.Ceil(1/rps)), float64(maxPeriodSeconds))
+	n, seconds := approximate(rps, int64(maxSeconds))
+	return Limit{
+		Rate:   int(n),
+		Period: time.Duration(seconds) * time.Second,
+		Burst:  burst,
+	}
+}
+
+// maxPeriodSeconds is the longest whole number of seconds a Duration holds.
+const maxPeriodSeconds = math.MaxInt64 / int64(time.Second)
+
+// approximate returns the continued fraction convergent n/d closest to x with d <= maxDen.
+func approximate(x float64, maxDen int64) (n, d int64) {
+	// Convergents h/k, starting from h(-1)/k(-1) = 1/0 and h(-2)/k(-2) = 0/1
+	h, hPrev := int64(1), int64(0)
+	k, kPrev := int64(0), int64(1)
+	f := x
+	for {
+		a := int64(math.Floor(f))
+		hNext, kNext := a*h+hPrev, a*k+kPrev
+		if kNext > maxDen {
+			break
+		}
+		h, hPrev = hNext, h
+		k, kPrev = kNext, k
+
+		frac := f - float64(a)
+		if frac == 0 || math.Abs(float64(h)/float64(k)-x) < 1e-12*x {
+			break
+		}
+		f = 1 / frac
+		if f > float64(maxDen) {
+			// The next term alone would take the denominator past maxDen
+			break
+		}
+	}
+	return h, k
+}
+
+// Unlimited is a sentinel Limit that every strategy allows immediately without touching its state.
+// Return it from a LimitFunc for admin or internal requests. Any negative Rate is treated the same way.
+var Unlimited = Limit{Rate: -1}
+
+// IsUnlimited reports whether the limit is the Unlimited sentinel.
+func (l Limit) IsUnlimited() bool {
+	return l.Rate < 0
+}
+
+// unlimitedResult is the result strategies return for an Unlimited limit.
+func unlimitedResult(source string) *Result {
+	res := newResult(source)
+	res.Allowed = true
+	res.Remaining = math.MaxInt
+	return res
+}
@@ -0,0 +1,266 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// strategyFunc adapts a function to the Strategy interface, e.g. to stand for a failing backend.
+type strategyFunc func(ctx context.Context, key string, limit Limit) (*Result, error)
+
+func (f strategyFunc) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return f(ctx, key, limit)
+}
+
+var errBackend = errors.New("backend down")
+
+func TestDenialReasons(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+
+	for _, name := range []string{"token_bucket", "sliding_window", "fixed_window", "leaky_bucket", "min_interval"} {
+		s, err := NewStrategy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.Allow(ctx, "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Reason != ReasonOK {
+			t.Errorf("%s: allowed Reason = %s, want ok", name, res.Reason)
+		}
+		if res, _ = s.Allow(ctx, "k", limit); res.Allowed || res.Reason != ReasonRateExceeded {
+			t.Errorf("%s: denial = %+v, want rate_exceeded", name, res)
+		}
+	}
+
+	_, err := NewTokenBucket().AllowN(ctx, "k", limit, 2)
+	if got := ReasonFor(err); got != ReasonBurstExceeded {
+		t.Errorf("ReasonFor(AllowN over burst) = %s, want burst_exceeded", got)
+	}
+	if got := ReasonFor(fmt.Errorf("wrapped: %w", errBackend)); got != ReasonBackendError {
+		t.Errorf("ReasonFor(backend error) = %s, want backend_error", got)
+	}
+
+	if res, _ := NewLoadShedder(1, time.Second).Allow(ctx, "k", limit); res.Reason != ReasonOverloaded {
+		t.Errorf("shed request Reason = %s, want overloaded", res.Reason)
+	}
+
+	failing := strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		return nil, errBackend
+	})
+	cb := NewCircuitBreakerLimiter(failing, BreakerConfig{MinRequests: 1})
+	if _, err := cb.Allow(ctx, "k", limit); !errors.Is(err, errBackend) {
+		t.Fatalf("first request error = %v, want the backend error", err)
+	}
+	if res, err := cb.Allow(ctx, "k", limit); err != nil || res.Allowed || res.Reason != ReasonBackendError {
+		t.Errorf("request with the breaker open = %+v, %v, want denied with backend_error", res, err)
+	}
+}
+
+func TestAllowWithKeyReportsEffectiveKey(t *testing.T) {
+	ctx := context.Background()
+	roomy := Limit{Rate: 10, Period: time.Minute, Burst: 10}
+	tight := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	orgOf := func(user string) string { return "acme" }
+
+	for _, tc := range []struct {
+		name     string
+		strategy Strategy
+		// Effective keys of the first and the second request for "alice"
+		first, second string
+	}{
+		{"plain strategy", NewTokenBucket(), "alice", "alice"},
+		{"multi limiter", NewMultiLimiter(
+			Rule{Name: "minute", Strategy: NewTokenBucket(), Limit: roomy},
+			Rule{Name: "daily", Strategy: NewTokenBucket(), Limit: tight},
+		), "daily:alice", "daily:alice"},
+		{"hierarchy", NewHierarchicalLimiter(
+			Level{Name: "user", Strategy: NewTokenBucket(), Limit: roomy},
+			Level{Name: "org", Strategy: NewTokenBucket(), Limit: tight, Key: orgOf},
+		), "org:acme", "org:acme"},
+		// The first rule pays for the first request, then the second rule takes over
+		{"any limiter", NewAnyLimiter(
+			Rule{Name: "minute", Strategy: NewTokenBucket(), Limit: tight},
+			Rule{Name: "one_time", Strategy: NewTokenBucket(), Limit: roomy},
+		), "minute:alice", "one_time:alice"},
+	} {
+		_, first, err := AllowWithKey(ctx, tc.strategy, "alice", tight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, second, err := AllowWithKey(ctx, tc.strategy, "alice", tight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != tc.first || second != tc.second {
+			t.Errorf("%s: effective keys %q and %q, want %q and %q", tc.name, first, second, tc.first, tc.second)
+		}
+	}
+}
+
+func TestPerSecond(t *testing.T) {
+	for _, tc := range []struct {
+		rps  float64
+		want Limit
+	}{
+		{1, Limit{Rate: 1, Period: time.Second, Burst: 5}},
+		{100, Limit{Rate: 100, Period: time.Second, Burst: 5}},
+		{2.5, Limit{Rate: 5, Period: 2 * time.Second, Burst: 5}},
+		{0.5, Limit{Rate: 1, Period: 2 * time.Second, Burst: 5}},
+		{1.0 / 3, Limit{Rate: 1, Period: 3 * time.Second, Burst: 5}},
+		{0.75, Limit{Rate: 3, Period: 4 * time.Second, Burst: 5}},
+		// Needs a period above 1000s, so it is approximated
+		{math.Pi, Limit{Rate: 355, Period: 113 * time.Second, Burst: 5}},
+		// Below one request in 1000s, the period stretches to 1/rps
+		{0.0001, Limit{Rate: 1, Period: 10000 * time.Second, Burst: 5}},
+		// Denies everything, even a burst
+		{0, Limit{Rate: 0, Period: time.Second, Burst: 0}},
+		{-2, Limit{Rate: 0, Period: time.Second, Burst: 0}},
+		{math.NaN(), Limit{Rate: 0, Period: time.Second, Burst: 0}},
+		{math.Inf(-1), Limit{Rate: 0, Period: time.Second, Burst: 0}},
+		{math.Inf(1), Unlimited},
+		// The period stops at the longest a Duration holds, and slower rates round to zero
+		{1.5e-10, Limit{Rate: 1, Period: 6666666667 * time.Second, Burst: 5}},
+		{1e-11, Limit{Rate: 0, Period: time.Second, Burst: 5}},
+		{math.SmallestNonzeroFloat64, Limit{Rate: 0, Period: time.Second, Burst: 5}},
+		// Rates too large for an int are capped
+		{1e19, Limit{Rate: math.MaxInt, Period: time.Second, Burst: 5}},
+		{math.MaxFloat64, Limit{Rate: math.MaxInt, Period: time.Second, Burst: 5}},
+	} {
+		if got := PerSecond(tc.rps, 5); got != tc.want {
+			t.Errorf("PerSecond(%g, 5) = %+v, want %+v", tc.rps, got, tc.want)
+		}
+	}
+}
+
+func TestPerSecondNeverOverflows(t *testing.T) {
+	for exp := -320; exp <= 300; exp++ {
+		for _, mantissa := range []float64{1, 1.5, 3.7, 9.9} {
+			rps := mantissa * math.Pow(10, float64(exp))
+			if l := PerSecond(rps, 1); l.Rate < 0 || l.Period <= 0 {
+				t.Fatalf("PerSecond(%g, 1) = %+v, want a non-negative rate over a positive period", rps, l)
+			}
+		}
+	}
+}
+
+func TestPerSecondZeroDeniesEveryRequest(t *testing.T) {
+	limit := PerSecond(0, 5)
+	for _, name := range []string{"token_bucket", "leaky_bucket", "sliding_window", "fixed_window"} {
+		s, err := NewStrategy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The bucket strategies fail outright, since no request fits in the zero burst
+		res, err := s.Allow(context.Background(), "k", limit)
+		if err != nil && !errors.Is(err, ErrExceedsBurst) {
+			t.Fatalf("%s: error = %v, want a denial or ErrExceedsBurst", name, err)
+		}
+		if err == nil && res.Allowed {
+			t.Errorf("%s: request allowed under PerSecond(0, 5)", name)
+		}
+	}
+}
+
+func TestPerSecondFractionalRateAdmission(t *testing.T) {
+	clock := newFakeClock()
+	tb := NewTokenBucket(WithClock(clock))
+	limit := PerSecond(2.5, 1)
+
+	// 2.5 requests per second, one at a time: 25 over 10 seconds
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if must(tb.Allow(context.Background(), "k", limit)).Allowed {
+			allowed++
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+	if allowed != 25 {
+		t.Errorf("%d requests allowed over 10s, want 25", allowed)
+	}
+}
+
+func TestPer(t *testing.T) {
+	if got, want := Per(10, time.Minute, 20), (Limit{Rate: 10, Period: time.Minute, Burst: 20}); got != want {
+		t.Errorf("Per(10, time.Minute, 20) = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	tb := NewTokenBucket(WithClock(newFakeClock()))
+	limit := Limit{Rate: 1, Period: 2 * time.Second, Burst: 1}
+
+	if err := Check(ctx, tb, "k", limit); err != nil {
+		t.Fatalf("allowed request: Check = %v, want nil", err)
+	}
+
+	err := Check(ctx, tb, "k", limit)
+	var denied *RateLimitError
+	if !errors.As(err, &denied) {
+		t.Fatalf("denied request: Check = %v, want a *RateLimitError", err)
+	}
+	if denied.Result.Allowed || denied.Result.Reason != ReasonRateExceeded {
+		t.Errorf("denial result = %+v, want denied for exceeding the rate", denied.Result)
+	}
+	if got := denied.RetryAfter(); got != 2*time.Second {
+		t.Errorf("RetryAfter = %v, want 2s", got)
+	}
+	if got, want := err.Error(), "limiter: rate_exceeded, retry after 2s"; got != want {
+		t.Errorf("Error = %q, want %q", got, want)
+	}
+
+	// The denial's result isn't recycled, so later checks can't change it
+	for i := 0; i < 10; i++ {
+		Check(ctx, tb, "other", limit)
+	}
+	if denied.Result.Reason != ReasonRateExceeded || denied.RetryAfter() != 2*time.Second {
+		t.Errorf("kept denial result changed to %+v", denied.Result)
+	}
+}
+
+func TestCheckFailure(t *testing.T) {
+	failing := strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		return nil, errBackend
+	})
+	err := Check(context.Background(), failing, "k", Limit{Rate: 1, Period: time.Second, Burst: 1})
+	if !errors.Is(err, errBackend) {
+		t.Errorf("Check = %v, want the backend error", err)
+	}
+	var denied *RateLimitError
+	if errors.As(err, &denied) {
+		t.Error("failed check reported as a denial")
+	}
+}
@@ -0,0 +1,286 @@
+// Package limitertest provides helpers for testing code built on the limiter package
+// without running external services.
+package limitertest
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+	"github.com/redis/go-redis/v9"
+)
+
+// ScriptFunc emulates a Lua script in Go. It runs atomically against the fake's keyspace.
+type ScriptFunc func(s *Store, keys []string, args []interface{}) (interface{}, error)
+
+// FakeScripter is an in-memory redis.Scripter for unit tests.
+//
+// It does not run Lua. Each script is emulated by a ScriptFunc registered under the script's
+// SHA1, and calls to unknown scripts fail with NOSCRIPT. The keyspace only supports what the
+// limiter scripts rely on: hashes with HGET/HSET semantics and PEXPIRE, with expiry checked
+// lazily against the wall clock, and sorted sets as used by RedisConcurrencyLimiter. NewFakeScripter registers the limiter package's scripts.
+type FakeScripter struct {
+	mu       sync.Mutex
+	handlers map[string]ScriptFunc
+	store    Store
+}
+
+// Store is the keyspace a ScriptFunc operates on.
+type Store struct {
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]float64
+	expires map[string]time.Time
+}
+
+var _ redis.Scripter = (*FakeScripter)(nil)
+
+// NewFakeScripter creates a FakeScripter that understands the Redis strategies' scripts.
+func NewFakeScripter() *FakeScripter {
+	f := &FakeScripter{
+		handlers: make(map[string]ScriptFunc),
+		store: Store{
+			hashes:  make(map[string]map[string]string),
+			zsets:   make(map[string]map[string]float64),
+			expires: make(map[string]time.Time),
+		},
+	}
+	f.Handle(limiter.TokenBucketScriptHash(), TokenBucketScript)
+	f.Handle(limiter.SeedScriptHash(), SeedScript)
+	f.Handle(limiter.PeekScriptHash(), PeekScript)
+	f.Handle(limiter.LeakyBucketScriptHash(), LeakyBucketScript)
+	f.Handle(limiter.DeleteScriptHash(), DeleteScript)
+	f.Handle(limiter.MultiBucketScriptHash(), MultiBucketScript)
+	f.Handle(limiter.AcquireLeaseScriptHash(), AcquireLeaseScript)
+	f.Handle(limiter.ReleaseLeaseScriptHash(), ReleaseLeaseScript)
+	f.Handle(limiter.ReapLeasesScriptHash(), ReapLeasesScript)
+	return f
+}
+
+// Handle registers fn as the emulation of the script with the given SHA1.
+func (f *FakeScripter) Handle(sha1 string, fn ScriptFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.handlers[sha1] = fn
+}
+
+// HGetAll returns a copy of the hash stored at key, or nil if it doesn't exist.
+func (f *FakeScripter) HGetAll(key string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	h := f.store.hash(key)
+	if h == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(h))
+	for k, v := range h {
+		copied[k] = v
+	}
+	return copied
+}
+
+// TTL returns the remaining time to live of key, or 0 if it has none or doesn't exist.
+func (f *FakeScripter) TTL(key string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.store.exists(key) {
+		return 0
+	}
+	if exp, ok := f.store.expires[key]; ok {
+		return time.Until(exp)
+	}
+	return 0
+}
+
+func (f *FakeScripter) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.run(ctx, scriptHash(script), keys, args)
+}
+
+func (f *FakeScripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.run(ctx, sha1, keys, args)
+}
+
+func (f *FakeScripter) EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.run(ctx, scriptHash(script), keys, args)
+}
+
+func (f *FakeScripter) EvalShaRO(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.run(ctx, sha1, keys, args)
+}
+
+func (f *FakeScripter) ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	exists := make([]bool, len(hashes))
+	for i, h := range hashes {
+		_, exists[i] = f.handlers[h]
+	}
+	cmd := redis.NewBoolSliceCmd(ctx)
+	cmd.SetVal(exists)
+	return cmd
+}
+
+func (f *FakeScripter) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cmd := redis.NewStringCmd(ctx)
+	sha := scriptHash(script)
+	if _, ok := f.handlers[sha]; !ok {
+		cmd.SetErr(fmt.Errorf("limitertest: no emulation registered for script %s", sha))
+		return cmd
+	}
+	cmd.SetVal(sha)
+	return cmd
+}
+
+func (f *FakeScripter) run(ctx context.Context, sha1 string, keys []string, args []interface{}) *redis.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cmd := redis.NewCmd(ctx)
+	fn, ok := f.handlers[sha1]
+	if !ok {
+		cmd.SetErr(errors.New("NOSCRIPT No matching script. Please use EVAL."))
+		return cmd
+	}
+
+	val, err := fn(&f.store, keys, args)
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal(val)
+	return cmd
+}
+
+// HGet returns a field of the hash stored at key.
+func (s *Store) HGet(key, field string) (string, bool) {
+	v, ok := s.hash(key)[field]
+	return v, ok
+}
+
+// HSet sets fields of the hash stored at key from alternating field/value pairs.
+func (s *Store) HSet(key string, fieldValues ...string) {
+	h := s.hash(key)
+	if h == nil {
+		h = make(map[string]string)
+		s.hashes[key] = h
+	}
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		h[fieldValues[i]] = fieldValues[i+1]
+	}
+}
+
+// ZAdd sets the score of member in the sorted set stored at key.
+func (s *Store) ZAdd(key string, score float64, member string) {
+	z := s.zset(key)
+	if z == nil {
+		z = make(map[string]float64)
+		s.zsets[key] = z
+	}
+	z[member] = score
+}
+
+// ZRem removes member from the sorted set stored at key and reports whether it was there.
+func (s *Store) ZRem(key, member string) bool {
+	z := s.zset(key)
+	_, ok := z[member]
+	delete(z, member)
+	if z != nil && len(z) == 0 {
+		s.Del(key)
+	}
+	return ok
+}
+
+// ZRemRangeByScore removes the members of the sorted set stored at key scored at most max.
+func (s *Store) ZRemRangeByScore(key string, max float64) {
+	z := s.zset(key)
+	for member, score := range z {
+		if score <= max {
+			delete(z, member)
+		}
+	}
+	if z != nil && len(z) == 0 {
+		s.Del(key)
+	}
+}
+
+// ZCard returns the number of members of the sorted set stored at key.
+func (s *Store) ZCard(key string) int {
+	return len(s.zset(key))
+}
+
+// PExpire sets the time to live of key.
+func (s *Store) PExpire(key string, ttl time.Duration) {
+	if s.exists(key) {
+		s.expires[key] = time.Now().Add(ttl)
+	}
+}
+
+// Del deletes key and reports whether it existed.
+func (s *Store) Del(key string) bool {
+	exists := s.exists(key)
+	delete(s.hashes, key)
+	delete(s.zsets, key)
+	delete(s.expires, key)
+	return exists
+}
+
+// Persist removes the time to live of key.
+func (s *Store) Persist(key string) {
+	delete(s.expires, key)
+}
+
+// hash returns the live hash at key, evicting it first if it has expired.
+func (s *Store) hash(key string) map[string]string {
+	s.evictExpired(key)
+	return s.hashes[key]
+}
+
+// zset returns the live sorted set at key, evicting it first if it has expired.
+func (s *Store) zset(key string) map[string]float64 {
+	s.evictExpired(key)
+	return s.zsets[key]
+}
+
+// exists reports whether key holds a live value of any type.
+func (s *Store) exists(key string) bool {
+	return s.hash(key) != nil || s.zset(key) != nil
+}
+
+func (s *Store) evictExpired(key string) {
+	if exp, ok := s.expires[key]; ok && !time.Now().Before(exp) {
+		delete(s.hashes, key)
+		delete(s.zsets, key)
+		delete(s.expires, key)
+	}
+}
+
+func scriptHash(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// Arg converts a script argument to a float64 the way Lua's tonumber would.
+func Arg(args []interface{}, i int) float64 {
+	if i >= len(args) {
+		return 0
+	}
+	f, _ := strconv.ParseFloat(fmt.Sprint(args[i]), 64)
+	return f
+}
+
+// FormatFloat renders a number without losing precision, as Redis does when a script stores it.
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
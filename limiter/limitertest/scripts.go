@@ -0,0 +1,245 @@
+package limitertest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// TokenBucketScript emulates the RedisTokenBucket Lua script.
+func TokenBucketScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	key := keys[0]
+	rate := Arg(args, 0)
+	capacity := Arg(args, 1)
+	now := Arg(args, 2)
+	requested := Arg(args, 3)
+	ttl := Arg(args, 4)
+	initial := Arg(args, 5)
+	trackDenials := Arg(args, 6)
+
+	lastTokens, okTokens := hgetFloat(s, key, "tokens")
+	lastUpdated, _ := hgetFloat(s, key, "last_updated")
+	if !okTokens {
+		lastTokens = initialTokens(capacity, initial)
+		lastUpdated = now
+	}
+
+	delta := math.Max(0, now-lastUpdated)
+	filled := math.Min(capacity, lastTokens+delta*rate)
+
+	var allowed int64
+	remaining := filled
+	resetAfter := 0.0
+
+	if filled >= requested {
+		allowed = 1
+		remaining = filled - requested
+		resetAfter = (capacity - remaining) / rate
+		s.HSet(key, "tokens", FormatFloat(remaining), "last_updated", FormatFloat(now))
+		if ttl > 0 {
+			s.PExpire(key, time.Duration(ttl)*time.Millisecond)
+		}
+	} else {
+		resetAfter = (requested - filled) / rate
+		if trackDenials == 1 {
+			s.HSet(key, "tokens", FormatFloat(filled), "last_updated", FormatFloat(now), "last_denied", FormatFloat(now))
+			if ttl > 0 {
+				s.PExpire(key, time.Duration(ttl)*time.Millisecond)
+			}
+		}
+	}
+
+	return []interface{}{allowed, FormatFloat(remaining), FormatFloat(resetAfter)}, nil
+}
+
+// initialTokens returns the tokens of a new bucket, full unless initial is non-negative.
+func initialTokens(capacity, initial float64) float64 {
+	if initial >= 0 {
+		return math.Min(capacity, initial)
+	}
+	return capacity
+}
+
+func hgetFloat(s *Store, key, field string) (float64, bool) {
+	v, ok := s.HGet(key, field)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return f, err == nil
+}
+
+// PeekScript emulates the RedisTokenBucket.Peek Lua script.
+func PeekScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	key := keys[0]
+	rate := Arg(args, 0)
+	capacity := Arg(args, 1)
+	now := Arg(args, 2)
+	initial := Arg(args, 3)
+	withDenied := Arg(args, 4)
+
+	lastTokens, ok := hgetFloat(s, key, "tokens")
+	lastUpdated, _ := hgetFloat(s, key, "last_updated")
+	if !ok {
+		lastTokens = initialTokens(capacity, initial)
+		lastUpdated = now
+	}
+
+	delta := math.Max(0, now-lastUpdated)
+	filled := math.Min(capacity, lastTokens+delta*rate)
+
+	reply := []interface{}{int64(0), FormatFloat(filled), FormatFloat((1 - filled) / rate)}
+	if filled >= 1 {
+		reply = []interface{}{int64(1), FormatFloat(filled), FormatFloat((capacity - filled) / rate)}
+	}
+	if withDenied == 1 {
+		lastDenied, ok := s.HGet(key, "last_denied")
+		if !ok {
+			lastDenied = "0"
+		}
+		reply = append(reply, lastDenied)
+	}
+	return reply, nil
+}
+
+// SeedScript emulates the RedisTokenBucket.Seed Lua script.
+func SeedScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	key := keys[0]
+	ttl := Arg(args, 2)
+
+	s.HSet(key, "tokens", FormatFloat(Arg(args, 0)), "last_updated", FormatFloat(Arg(args, 1)))
+	if ttl > 0 {
+		s.PExpire(key, time.Duration(ttl)*time.Millisecond)
+	} else {
+		s.Persist(key)
+	}
+
+	return int64(1), nil
+}
+
+// LeakyBucketScript emulates the RedisLeakyBucket Lua script.
+func LeakyBucketScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	key := keys[0]
+	rate := Arg(args, 0)
+	capacity := Arg(args, 1)
+	now := Arg(args, 2)
+	requested := Arg(args, 3)
+	ttl := Arg(args, 4)
+
+	level, ok := hgetFloat(s, key, "level")
+	lastLeak, _ := hgetFloat(s, key, "last_leak")
+	if !ok {
+		level = 0
+		lastLeak = now
+	}
+
+	delta := math.Max(0, now-lastLeak)
+	level = math.Max(0, level-delta*rate)
+
+	var allowed int64
+	resetAfter := 0.0
+
+	if level+requested <= capacity {
+		allowed = 1
+		level += requested
+		s.HSet(key, "level", FormatFloat(level), "last_leak", FormatFloat(now))
+		s.PExpire(key, time.Duration(ttl)*time.Millisecond)
+	} else {
+		resetAfter = (level + requested - capacity) / rate
+	}
+
+	return []interface{}{allowed, FormatFloat(capacity - level), FormatFloat(resetAfter)}, nil
+}
+
+// DeleteScript emulates the Lua script used to reset Redis buckets.
+func DeleteScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	if s.Del(keys[0]) {
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+// MultiBucketScript emulates the RedisMultiBucket Lua script.
+func MultiBucketScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	now := Arg(args, 0)
+	trackDenials := Arg(args, 1)
+	filled := make([]float64, len(keys))
+
+	for i, key := range keys {
+		base := 2 + i*5
+		rate := Arg(args, base)
+		capacity := Arg(args, base+1)
+		requested := Arg(args, base+2)
+
+		lastTokens, ok := hgetFloat(s, key, "tokens")
+		lastUpdated, _ := hgetFloat(s, key, "last_updated")
+		if !ok {
+			lastTokens = initialTokens(capacity, Arg(args, base+4))
+			lastUpdated = now
+		}
+
+		delta := math.Max(0, now-lastUpdated)
+		filled[i] = math.Min(capacity, lastTokens+delta*rate)
+
+		if filled[i] < requested {
+			if trackDenials == 1 {
+				s.HSet(key, "tokens", FormatFloat(filled[i]), "last_updated", FormatFloat(now), "last_denied", FormatFloat(now))
+				if ttl := Arg(args, base+3); ttl > 0 {
+					s.PExpire(key, time.Duration(ttl)*time.Millisecond)
+				}
+			}
+			return []interface{}{int64(0), int64(i), FormatFloat(filled[i]), FormatFloat((requested - filled[i]) / rate)}, nil
+		}
+	}
+
+	decided := 0
+	minRemaining := math.Inf(1)
+	resetAfter := 0.0
+	for i, key := range keys {
+		base := 2 + i*5
+		requested := Arg(args, base+2)
+		ttl := Arg(args, base+3)
+
+		remaining := filled[i] - requested
+		s.HSet(key, "tokens", FormatFloat(remaining), "last_updated", FormatFloat(now))
+		if ttl > 0 {
+			s.PExpire(key, time.Duration(ttl)*time.Millisecond)
+		}
+
+		if remaining < minRemaining {
+			minRemaining = remaining
+			decided = i
+			resetAfter = (Arg(args, base+1) - remaining) / Arg(args, base)
+		}
+	}
+
+	return []interface{}{int64(1), int64(decided), FormatFloat(minRemaining), FormatFloat(resetAfter)}, nil
+}
+
+// AcquireLeaseScript emulates the RedisConcurrencyLimiter.Acquire Lua script.
+func AcquireLeaseScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	key := keys[0]
+
+	s.ZRemRangeByScore(key, Arg(args, 0))
+	if float64(s.ZCard(key)) < Arg(args, 2) {
+		s.ZAdd(key, Arg(args, 1), fmt.Sprint(args[3]))
+		s.PExpire(key, time.Duration(Arg(args, 4))*time.Millisecond)
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+// ReleaseLeaseScript emulates the RedisConcurrencyLimiter.Release Lua script.
+func ReleaseLeaseScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	if s.ZRem(keys[0], fmt.Sprint(args[0])) {
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+// ReapLeasesScript emulates the Lua script run by the RedisConcurrencyLimiter reaper.
+func ReapLeasesScript(s *Store, keys []string, args []interface{}) (interface{}, error) {
+	s.ZRemRangeByScore(keys[0], Arg(args, 0))
+	return int64(s.ZCard(keys[0])), nil
+}
@@ -0,0 +1,254 @@
+package limitertest
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// call is a script call that ran on Redis, with its reply.
+type call struct {
+	sha1  string
+	keys  []string
+	args  []interface{}
+	reply interface{}
+	err   error
+}
+
+// recorder is a redis.Scripter recording the script calls that reached the scripts.
+type recorder struct {
+	redis.Scripter
+	calls []call
+}
+
+func (r *recorder) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	cmd := r.Scripter.EvalSha(ctx, sha1, keys, args...)
+	r.record(sha1, keys, args, cmd)
+	return cmd
+}
+
+func (r *recorder) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	cmd := r.Scripter.Eval(ctx, script, keys, args...)
+	sum := sha1.Sum([]byte(script))
+	r.record(hex.EncodeToString(sum[:]), keys, args, cmd)
+	return cmd
+}
+
+func (r *recorder) record(sha1 string, keys []string, args []interface{}, cmd *redis.Cmd) {
+	reply, err := cmd.Result()
+	if redis.HasErrorPrefix(err, "NOSCRIPT") {
+		// Nothing ran, the caller retries with EVAL
+		return
+	}
+	r.calls = append(r.calls, call{sha1: sha1, keys: keys, args: args, reply: reply, err: err})
+}
+
+// TestScriptsMatchRedis runs the limiter's Redis strategies against the real Lua scripts
+// in miniredis, then replays every script call on a FakeScripter, which must reply the same.
+func TestScriptsMatchRedis(t *testing.T) {
+	ctx := context.Background()
+	slow := limiter.Limit{Rate: 3, Period: time.Hour, Burst: 3}
+
+	for name, scenario := range map[string]func(t *testing.T, client redis.Scripter){
+		"token bucket": func(t *testing.T, client redis.Scripter) {
+			r := limiter.NewRedisTokenBucket(client, limiter.WithDenialTracking())
+			for i := 0; i < 4; i++ {
+				mustRun(t)(r.Allow(ctx, "tb", slow))
+			}
+			mustRun(t)(r.AllowN(ctx, "tb", slow, 2))
+			mustRun(t)(r.Peek(ctx, "tb", slow))
+			if _, err := r.Quota(ctx, "tb", slow); err != nil {
+				t.Fatal(err)
+			}
+			mustRun(t)(r.Peek(ctx, "fresh", slow))
+			if err := r.Seed(ctx, "seeded", 1.5, time.Now().Add(-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			mustRun(t)(r.AllowN(ctx, "seeded", slow, 2))
+			if _, err := r.Reset(ctx, "tb"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Reset(ctx, "missing"); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"initial tokens": func(t *testing.T, client redis.Scripter) {
+			r := limiter.NewRedisTokenBucket(client, limiter.WithInitialTokens(1), limiter.WithoutAutoExpire())
+			for i := 0; i < 3; i++ {
+				mustRun(t)(r.Allow(ctx, "k", slow))
+			}
+		},
+		"leaky bucket": func(t *testing.T, client redis.Scripter) {
+			r := limiter.NewRedisLeakyBucket(client)
+			for i := 0; i < 4; i++ {
+				mustRun(t)(r.Allow(ctx, "lb", slow))
+			}
+			mustRun(t)(r.AllowN(ctx, "other", slow, 3))
+		},
+		"multi bucket": func(t *testing.T, client redis.Scripter) {
+			r := limiter.NewRedisMultiBucket(client)
+			reqs := []limiter.KeyLimit{
+				{Key: "user", Limit: slow},
+				{Key: "org", Limit: limiter.Limit{Rate: 2, Period: time.Hour, Burst: 2}},
+			}
+			for i := 0; i < 4; i++ {
+				if _, _, err := r.AllowAll(ctx, reqs); err != nil {
+					t.Fatal(err)
+				}
+			}
+		},
+		"multi bucket denial tracking": func(t *testing.T, client redis.Scripter) {
+			r := limiter.NewRedisMultiBucket(client, limiter.WithDenialTracking())
+			reqs := []limiter.KeyLimit{
+				{Key: "user", Limit: slow},
+				{Key: "org", Limit: limiter.Limit{Rate: 1, Period: time.Hour, Burst: 1}},
+			}
+			for i := 0; i < 3; i++ {
+				if _, _, err := r.AllowAll(ctx, reqs); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := r.Quota(ctx, "org", reqs[1].Limit); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"concurrency": func(t *testing.T, client redis.Scripter) {
+			c := limiter.NewRedisConcurrencyLimiter(client, time.Minute)
+			defer c.Close()
+			if err := c.Preload(ctx); err != nil {
+				t.Fatal(err)
+			}
+			lease, _, err := c.Acquire(ctx, "conc", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := c.Acquire(ctx, "conc", 1); err != nil {
+				t.Fatal(err)
+			}
+			// The reaper runs once per lease TTL, call its script directly
+			now := time.Now().UnixMilli()
+			if err := client.EvalSha(ctx, limiter.ReapLeasesScriptHash(), []string{"conc"}, now).Err(); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Release(ctx, "conc", lease); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Release(ctx, "conc", lease); err != nil {
+				t.Fatal(err)
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			defer client.Close()
+
+			rec := &recorder{Scripter: client}
+			scenario(t, rec)
+			if len(rec.calls) == 0 {
+				t.Fatal("no script ran")
+			}
+
+			fake := NewFakeScripter()
+			for i, c := range rec.calls {
+				got, err := fake.EvalSha(ctx, c.sha1, c.keys, c.args...).Result()
+				if (err != nil) != (c.err != nil) {
+					t.Fatalf("call %d (%s %v): fake error %v, redis error %v", i, c.sha1, c.args, err, c.err)
+				}
+				if !sameReply(got, c.reply) {
+					t.Fatalf("call %d (%s %v): fake replied %#v, redis %#v", i, c.sha1, c.args, got, c.reply)
+				}
+			}
+		})
+	}
+}
+
+// mustRun returns a function failing t if a strategy call returned an error.
+func mustRun(t *testing.T) func(res *limiter.Result, err error) {
+	return func(res *limiter.Result, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// sameReply reports whether two script replies are equal, comparing numbers rendered as
+// strings numerically, since Lua and Go format floats with different precision.
+func sameReply(a, b interface{}) bool {
+	as, aok := a.([]interface{})
+	bs, bok := b.([]interface{})
+	if aok || bok {
+		if !aok || !bok || len(as) != len(bs) {
+			return false
+		}
+		for i := range as {
+			if !sameReply(as[i], bs[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	astr, aok := a.(string)
+	bstr, bok := b.(string)
+	if !aok || !bok {
+		return reflect.DeepEqual(a, b)
+	}
+	af, aerr := strconv.ParseFloat(astr, 64)
+	bf, berr := strconv.ParseFloat(bstr, 64)
+	if aerr == nil && berr == nil {
+		return math.Abs(af-bf) <= 1e-9*math.Max(1, math.Abs(bf))
+	}
+	return astr == bstr
+}
+
+func TestMalformedScriptRepliesFailRequests(t *testing.T) {
+	ctx := context.Background()
+	limit := limiter.Limit{Rate: 10, Period: time.Second, Burst: 10}
+
+	for name, reply := range map[string]interface{}{
+		// A denial must never be read as an allowed request with 0 defaults
+		"short reply":      []interface{}{int64(0)},
+		"string flag":      []interface{}{"0", "0", "1"},
+		"bad reset after":  []interface{}{int64(0), "0", "soon"},
+		"status not array": "OK",
+	} {
+		fake := NewFakeScripter()
+		fake.Handle(limiter.TokenBucketScriptHash(), func(s *Store, keys []string, args []interface{}) (interface{}, error) {
+			return reply, nil
+		})
+		fake.Handle(limiter.MultiBucketScriptHash(), func(s *Store, keys []string, args []interface{}) (interface{}, error) {
+			return reply, nil
+		})
+
+		res, err := limiter.NewRedisTokenBucket(fake).Allow(ctx, "k", limit)
+		if !errors.Is(err, limiter.ErrUnexpectedReply) || res != nil {
+			t.Errorf("%s: token bucket = %+v, %v, want ErrUnexpectedReply", name, res, err)
+		}
+		res, _, err = limiter.NewRedisMultiBucket(fake).AllowAll(ctx, []limiter.KeyLimit{{Key: "k", Limit: limit}})
+		if !errors.Is(err, limiter.ErrUnexpectedReply) || res != nil {
+			t.Errorf("%s: multi bucket = %+v, %v, want ErrUnexpectedReply", name, res, err)
+		}
+	}
+
+	// The multi bucket script also names the key that decided, which must be one of the keys
+	fake := NewFakeScripter()
+	fake.Handle(limiter.MultiBucketScriptHash(), func(s *Store, keys []string, args []interface{}) (interface{}, error) {
+		return []interface{}{int64(0), int64(len(keys)), "0", "1"}, nil
+	})
+	_, _, err := limiter.NewRedisMultiBucket(fake).AllowAll(ctx, []limiter.KeyLimit{{Key: "k", Limit: limit}})
+	if !errors.Is(err, limiter.ErrUnexpectedReply) {
+		t.Errorf("out of range key index: error = %v, want ErrUnexpectedReply", err)
+	}
+}
@@ -0,0 +1,77 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// LoadShedder implements the Strategy interface by denying a fraction of all requests at
+// random, whatever their key, to protect an overloaded service as a whole. It complements
+// per-key limits, which can't help when the overload comes from many well-behaved clients;
+// combine both with AllowAll.
+//
+// The probability is typically driven by a signal such as CPU usage, queue depth or latency,
+// by calling SetProbability as it changes. Denials carry the configured retry-after, with
+// ReasonOverloaded, and Remaining is always zero as there is no per-key budget to report.
+type LoadShedder struct {
+	probability atomic.Uint64 // math.Float64bits of the probability
+	retryAfter  time.Duration
+	rand        func() float64
+}
+
+// WithRand sets the source of the random numbers in [0, 1) LoadShedder draws to decide
+// which requests to shed, e.g. a seeded generator for reproducible tests. It must be safe
+// for concurrent use. Default: math/rand/v2's Float64.
+func WithRand(rand func() float64) Option {
+	return func(o *options) {
+		o.rand = rand
+	}
+}
+
+// NewLoadShedder creates a new LoadShedder denying requests with probability p, clamped to
+// [0, 1], and advising denied clients to retry after retryAfter. It accepts WithRand.
+func NewLoadShedder(p float64, retryAfter time.Duration, opts ...Option) *LoadShedder {
+	o := applyOptions(opts)
+	l := &LoadShedder{
+		retryAfter: retryAfter,
+		rand:       o.rand,
+	}
+	if l.rand == nil {
+		l.rand = rand.Float64
+	}
+	l.SetProbability(p)
+	return l
+}
+
+// SetProbability changes the probability of denying a request, clamped to [0, 1].
+// Zero lets every request through and one denies them all.
+func (l *LoadShedder) SetProbability(p float64) {
+	if math.IsNaN(p) {
+		p = 0
+	}
+	l.probability.Store(math.Float64bits(max(0, min(1, p))))
+}
+
+// Probability returns the current probability of denying a request.
+func (l *LoadShedder) Probability() float64 {
+	return math.Float64frombits(l.probability.Load())
+}
+
+// Allow denies the request with the current probability. The key is ignored.
+func (l *LoadShedder) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("load_shedder"), nil
+	}
+
+	result := newResult("load_shedder")
+	if p := l.Probability(); p > 0 && l.rand() < p {
+		result.Reason = ReasonOverloaded
+		result.ResetAfter = computeResetAfter(l.retryAfter)
+		return result, nil
+	}
+	result.Allowed = true
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestLoadShedderDenialRatio(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	rng := rand.New(rand.NewPCG(1, 2))
+	ls := NewLoadShedder(0.3, time.Second, WithRand(rng.Float64))
+
+	ratio := func() float64 {
+		const requests = 10000
+		denied := 0
+		for i := 0; i < requests; i++ {
+			// Every key shares the same odds
+			res := must(ls.Allow(ctx, string(rune('a'+i%26)), limit))
+			if !res.Allowed {
+				denied++
+			}
+			ReleaseResult(res)
+		}
+		return float64(denied) / requests
+	}
+
+	// The standard deviation over 10000 requests is below 0.005, so this is over 6 of them
+	if got := ratio(); math.Abs(got-0.3) > 0.03 {
+		t.Errorf("denied %.3f of requests, want about 0.3", got)
+	}
+	ls.SetProbability(0.8)
+	if got := ratio(); math.Abs(got-0.8) > 0.03 {
+		t.Errorf("denied %.3f of requests after SetProbability(0.8), want about 0.8", got)
+	}
+}
+
+func TestLoadShedderDecision(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	draw := 0.5
+	ls := NewLoadShedder(0.5, 3*time.Second, WithRand(func() float64 { return draw }))
+
+	// A request is shed when the draw falls below the probability
+	if res := must(ls.Allow(ctx, "k", limit)); !res.Allowed {
+		t.Errorf("draw of 0.5 at probability 0.5 denied, want allowed")
+	}
+	draw = 0.49
+	res := must(ls.Allow(ctx, "k", limit))
+	if res.Allowed || res.Reason != ReasonOverloaded || res.ResetAfter != 3*time.Second || res.Remaining != 0 {
+		t.Errorf("shed request = %+v, want denied as overloaded, retrying after 3s", res)
+	}
+
+	// Unlimited requests are never shed
+	ls.SetProbability(1)
+	if res := must(ls.Allow(ctx, "k", Unlimited)); !res.Allowed {
+		t.Error("unlimited request shed")
+	}
+}
+
+func TestLoadShedderProbabilityBounds(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	ls := NewLoadShedder(0, time.Second)
+
+	for _, tc := range []struct {
+		p, want float64
+	}{
+		{0, 0},
+		{-0.5, 0},
+		{math.NaN(), 0},
+		{0.25, 0.25},
+		{1, 1},
+		{7, 1},
+	} {
+		ls.SetProbability(tc.p)
+		if got := ls.Probability(); got != tc.want {
+			t.Errorf("SetProbability(%v): Probability = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+
+	// The extremes allow or deny everything, whatever the random draws
+	for _, tc := range []struct {
+		p       float64
+		allowed bool
+	}{{0, true}, {1, false}} {
+		ls.SetProbability(tc.p)
+		for i := 0; i < 1000; i++ {
+			if res := must(ls.Allow(ctx, "k", limit)); res.Allowed != tc.allowed {
+				t.Fatalf("probability %v: request %d allowed = %v, want %v", tc.p, i, res.Allowed, tc.allowed)
+			}
+		}
+	}
+	if got := NewLoadShedder(3, time.Second).Probability(); got != 1 {
+		t.Errorf("NewLoadShedder(3) probability = %v, want it clamped to 1", got)
+	}
+}
@@ -0,0 +1,95 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MinInterval implements the Strategy interface by enforcing a minimum spacing between the
+// requests of a key, for upstreams that reject calls made too close together. The interval
+// is Period/Rate, e.g. Limit{Rate: 1, Period: 200 * time.Millisecond} allows one request
+// every 200ms. Burst is ignored: requests can never bunch up, even after an idle spell.
+//
+// It compares timestamps rather than accumulating fractional tokens, so spacing is exact.
+type MinInterval struct {
+	mu      sync.Mutex
+	last    map[string]time.Time
+	clock   Clock
+	janitor janitor
+}
+
+// NewMinInterval creates a new instance of MinInterval strategy.
+// It accepts WithCleanup and WithClock.
+func NewMinInterval(opts ...Option) *MinInterval {
+	o := applyOptions(opts)
+	return &MinInterval{
+		last:    make(map[string]time.Time),
+		clock:   o.clock,
+		janitor: janitor{idle: o.cleanupIdle},
+	}
+}
+
+// Allow checks if at least the interval has passed since the last allowed request for key.
+func (mi *MinInterval) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("min_interval"), nil
+	}
+
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	now := mi.clock.Now()
+	result := newResult("min_interval")
+
+	if limit.Rate <= 0 {
+		result.Reason = ReasonRateExceeded
+		return result, nil
+	}
+	interval := limit.Period / time.Duration(limit.Rate)
+
+	if mi.janitor.due(now) {
+		for k, last := range mi.last {
+			if now.Sub(last) >= mi.janitor.idle {
+				delete(mi.last, k)
+			}
+		}
+	}
+
+	last, exists := mi.last[key]
+	since := now.Sub(last)
+	if exists && since < 0 {
+		// The clock stepped backwards. Restart the interval from now rather than
+		// make the key wait for the clock to catch up with the last request.
+		mi.last[key] = now
+		since = 0
+	}
+	if exists && since < interval {
+		result.Reason = ReasonRateExceeded
+		result.ResetAfter = computeResetAfter(interval - since)
+		return result, nil
+	}
+
+	mi.last[key] = now
+	result.Allowed = true
+	return result, nil
+}
+
+// Reset clears the state of key, so its next request is allowed immediately.
+func (mi *MinInterval) Reset(ctx context.Context, key string) (bool, error) {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	_, exists := mi.last[key]
+	delete(mi.last, key)
+	return exists, nil
+}
+
+// Flush clears the state of every key, restoring their full budgets.
+func (mi *MinInterval) Flush(ctx context.Context) error {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	mi.last = make(map[string]time.Time)
+	return nil
+}
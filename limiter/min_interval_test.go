@@ -0,0 +1,103 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMinIntervalExactSpacing(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	mi := NewMinInterval(WithClock(clock))
+	limit := Limit{Rate: 1, Period: 200 * time.Millisecond}
+
+	if res := must(mi.Allow(ctx, "k", limit)); !res.Allowed {
+		t.Fatal("first request denied")
+	}
+
+	clock.Advance(150 * time.Millisecond)
+	res := must(mi.Allow(ctx, "k", limit))
+	if res.Allowed {
+		t.Fatal("request 150ms after the last allowed, want denied")
+	}
+	if res.ResetAfter != 50*time.Millisecond {
+		t.Errorf("ResetAfter = %s, want the 50ms left of the interval", res.ResetAfter)
+	}
+
+	// A denied request doesn't restart the interval
+	clock.Advance(50*time.Millisecond - time.Nanosecond)
+	if res := must(mi.Allow(ctx, "k", limit)); res.Allowed || res.ResetAfter != time.Nanosecond {
+		t.Fatalf("request 1ns early = %+v, want denied with 1ns to wait", res)
+	}
+	clock.Advance(time.Nanosecond)
+	if res := must(mi.Allow(ctx, "k", limit)); !res.Allowed {
+		t.Fatal("request exactly one interval after the last allowed, want allowed")
+	}
+
+	// Spacing is measured from the last allowed request, with no drift over many intervals
+	for i := 0; i < 1000; i++ {
+		clock.Advance(200 * time.Millisecond)
+		if res := must(mi.Allow(ctx, "k", limit)); !res.Allowed {
+			t.Fatalf("request %d at exact spacing denied", i)
+		}
+		if res := must(mi.Allow(ctx, "k", limit)); res.Allowed {
+			t.Fatalf("request %d repeated without spacing allowed", i)
+		}
+	}
+}
+
+func TestMinIntervalDividesPeriodByRate(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	mi := NewMinInterval(WithClock(clock))
+	// 3 per second is one request every 333.333333ms
+	limit := Limit{Rate: 3, Period: time.Second, Burst: 10}
+
+	must(mi.Allow(ctx, "k", limit))
+	// Burst is ignored: a second request right away is denied
+	if res := must(mi.Allow(ctx, "k", limit)); res.Allowed {
+		t.Fatal("request without spacing allowed despite Burst")
+	}
+	clock.Advance(333333333 * time.Nanosecond)
+	if res := must(mi.Allow(ctx, "k", limit)); !res.Allowed {
+		t.Fatal("request one interval later denied")
+	}
+}
+
+func TestMinIntervalKeysAndReset(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	mi := NewMinInterval(WithClock(clock))
+	limit := Limit{Rate: 1, Period: time.Second}
+
+	must(mi.Allow(ctx, "a", limit))
+	if res := must(mi.Allow(ctx, "b", limit)); !res.Allowed {
+		t.Fatal("key b denied by the spacing of key a")
+	}
+
+	if existed, err := mi.Reset(ctx, "a"); err != nil || !existed {
+		t.Fatalf("Reset = %t, %v, want true", existed, err)
+	}
+	if res := must(mi.Allow(ctx, "a", limit)); !res.Allowed {
+		t.Fatal("request after Reset denied")
+	}
+}
+
+func TestMinIntervalClockSteppingBackwards(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	mi := NewMinInterval(WithClock(clock))
+	limit := Limit{Rate: 1, Period: time.Second}
+
+	must(mi.Allow(ctx, "k", limit))
+	clock.Advance(-time.Hour)
+	res := must(mi.Allow(ctx, "k", limit))
+	if res.Allowed || res.ResetAfter != time.Second {
+		t.Fatalf("request after stepping back = %+v, want denied for one interval, not an hour", res)
+	}
+	clock.Advance(time.Second)
+	if res := must(mi.Allow(ctx, "k", limit)); !res.Allowed {
+		t.Fatal("request one interval after stepping back denied")
+	}
+}
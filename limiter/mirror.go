@@ -0,0 +1,105 @@
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// MirrorLimiter implements the Strategy interface by returning the decisions of a primary
+// strategy while replaying every request against a secondary one in the background, e.g.
+// to compare a new Redis backend with the in-memory one before cutting over.
+//
+// Whenever the secondary decides differently (Allowed differs) or fails, onDivergence is
+// called with the key, both results and the secondary's error; the results must not be
+// retained after it returns. The secondary runs on a separate goroutine fed by a buffered
+// channel, so it never slows down or affects the primary's result; when the buffer is
+// full, requests are not mirrored and are counted in Dropped instead.
+type MirrorLimiter struct {
+	primary      Strategy
+	secondary    Strategy
+	onDivergence func(key string, primary, secondary *Result, err error)
+	requests     chan mirrorRequest
+	dropped      atomic.Uint64
+	quit         chan struct{}
+	done         chan struct{}
+}
+
+type mirrorRequest struct {
+	key     string
+	limit   Limit
+	primary Result
+}
+
+// NewMirrorLimiter creates a new MirrorLimiter, buffering up to bufferSize requests for the
+// secondary. Call Close to stop mirroring.
+func NewMirrorLimiter(primary, secondary Strategy, onDivergence func(key string, primary, secondary *Result, err error), bufferSize int) *MirrorLimiter {
+	m := &MirrorLimiter{
+		primary:      primary,
+		secondary:    secondary,
+		onDivergence: onDivergence,
+		requests:     make(chan mirrorRequest, bufferSize),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go m.mirror()
+	return m
+}
+
+// Allow returns the primary's decision and queues the request for the secondary.
+func (m *MirrorLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	res, err := m.primary.Allow(ctx, key, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// The result is copied since the caller may release it before the secondary runs
+	select {
+	case m.requests <- mirrorRequest{key: key, limit: limit, primary: *res}:
+	default:
+		m.dropped.Add(1)
+	}
+
+	return res, nil
+}
+
+// Dropped returns how many requests were not mirrored because the buffer was full.
+func (m *MirrorLimiter) Dropped() uint64 {
+	return m.dropped.Load()
+}
+
+// Close replays the requests already buffered and stops the mirroring goroutine.
+// Requests made after Close are not mirrored once the buffer fills up.
+func (m *MirrorLimiter) Close() {
+	close(m.quit)
+	<-m.done
+}
+
+func (m *MirrorLimiter) mirror() {
+	defer close(m.done)
+
+	for {
+		select {
+		case req := <-m.requests:
+			m.compare(req)
+		case <-m.quit:
+			for {
+				select {
+				case req := <-m.requests:
+					m.compare(req)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// compare replays req against the secondary and reports any divergence.
+func (m *MirrorLimiter) compare(req mirrorRequest) {
+	// Not the request's context: it is usually done by the time the secondary runs
+	res, err := m.secondary.Allow(context.Background(), req.key, req.limit)
+	if err != nil || res.Allowed != req.primary.Allowed {
+		m.onDivergence(req.key, &req.primary, res, err)
+	}
+	ReleaseResult(res)
+}
@@ -0,0 +1,132 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type divergence struct {
+	key                string
+	primary, secondary bool
+	err                error
+}
+
+// recordDivergences returns an onDivergence callback appending to a list, and a func
+// returning a copy of the list.
+func recordDivergences() (func(key string, primary, secondary *Result, err error), func() []divergence) {
+	var (
+		mu   sync.Mutex
+		seen []divergence
+	)
+	record := func(key string, primary, secondary *Result, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		d := divergence{key: key, primary: primary.Allowed, err: err}
+		if secondary != nil {
+			d.secondary = secondary.Allowed
+		}
+		seen = append(seen, d)
+	}
+	return record, func() []divergence {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]divergence(nil), seen...)
+	}
+}
+
+func TestMirrorLimiterReportsDivergences(t *testing.T) {
+	ctx := context.Background()
+	record, seen := recordDivergences()
+	// The secondary was configured with a tighter budget by mistake
+	tight := NewTokenBucket()
+	secondary := strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		return tight.Allow(ctx, key, Limit{Rate: 1, Period: time.Minute, Burst: 1})
+	})
+	m := NewMirrorLimiter(NewTokenBucket(), secondary, record, 16)
+	limit := Limit{Rate: 3, Period: time.Minute, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		if res := must(m.Allow(ctx, "k", limit)); !res.Allowed {
+			t.Fatalf("request %d = %+v, want the primary's decision", i, res)
+		}
+	}
+	m.Close()
+
+	got := seen()
+	want := []divergence{{key: "k", primary: true}, {key: "k", primary: true}}
+	if len(got) != len(want) {
+		t.Fatalf("divergences = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("divergence %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMirrorLimiterIsolatesSecondaryErrors(t *testing.T) {
+	ctx := context.Background()
+	errSecondary := errors.New("redis down")
+	record, seen := recordDivergences()
+	m := NewMirrorLimiter(NewTokenBucket(), strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		return nil, errSecondary
+	}), record, 16)
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+
+	res, err := m.Allow(ctx, "k", limit)
+	if err != nil || !res.Allowed {
+		t.Fatalf("Allow = %+v, %v, want the primary's allowed decision", res, err)
+	}
+	m.Close()
+
+	if got := seen(); len(got) != 1 || !errors.Is(got[0].err, errSecondary) || !got[0].primary {
+		t.Errorf("divergences = %+v, want one carrying the secondary's error", got)
+	}
+}
+
+func TestMirrorLimiterAgreementIsSilent(t *testing.T) {
+	ctx := context.Background()
+	record, seen := recordDivergences()
+	m := NewMirrorLimiter(NewTokenBucket(), NewTokenBucket(), record, 16)
+	limit := Limit{Rate: 2, Period: time.Minute, Burst: 2}
+
+	for i := 0; i < 4; i++ {
+		must(m.Allow(ctx, "k", limit))
+	}
+	m.Close()
+
+	if got := seen(); len(got) != 0 {
+		t.Errorf("divergences = %+v, want none from identical strategies", got)
+	}
+}
+
+func TestMirrorLimiterDropsWhenBufferFull(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	blocked := strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		<-release
+		return &Result{Allowed: true}, nil
+	})
+	record, _ := recordDivergences()
+	m := NewMirrorLimiter(NewTokenBucket(), blocked, record, 1)
+	limit := Limit{Rate: 100, Period: time.Minute, Burst: 100}
+
+	// One request is being replayed, one waits in the buffer, the rest are dropped
+	must(m.Allow(ctx, "k", limit))
+	deadline := time.Now().Add(time.Second)
+	for len(m.requests) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 5; i++ {
+		must(m.Allow(ctx, "k", limit))
+	}
+	close(release)
+	m.Close()
+
+	if got := m.Dropped(); got != 4 {
+		t.Errorf("Dropped = %d, want 4", got)
+	}
+}
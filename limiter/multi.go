@@ -0,0 +1,167 @@
+package limiter
+
+import (
+	"context"
+)
+
+// Rule pairs a strategy with the limit it enforces inside a MultiLimiter.
+type Rule struct {
+	Name     string // Prefixed to keys so several rules can share one strategy
+	Strategy Strategy
+	Limit    Limit
+}
+
+// KeyLimit pairs a key with the limit to apply to it.
+type KeyLimit struct {
+	Key   string
+	Limit Limit
+}
+
+// MultiLimiter implements the Strategy interface by requiring every rule to allow a request.
+// A request is only charged if all rules pass: rules consumed before a denial are refunded
+// when their strategy implements Refunder.
+type MultiLimiter struct {
+	rules []Rule
+}
+
+// NewMultiLimiter creates a new MultiLimiter enforcing all of the given rules.
+func NewMultiLimiter(rules ...Rule) *MultiLimiter {
+	return &MultiLimiter{
+		rules: rules,
+	}
+}
+
+// Allow checks the request against every rule.
+// The limit argument is ignored, each rule applies its own limit.
+// The result's Source is set to the name of the rule that decided it.
+func (m *MultiLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	res, _, err := m.AllowWithKey(ctx, key, limit)
+	return res, err
+}
+
+// AllowWithKey is like Allow but also returns the namespaced key of the rule that decided
+// the result, e.g. "daily_cap:client-1".
+func (m *MultiLimiter) AllowWithKey(ctx context.Context, key string, limit Limit) (*Result, string, error) {
+	checks := make([]check, len(m.rules))
+	for i, rule := range m.rules {
+		k := key
+		if rule.Name != "" {
+			k = rule.Name + ":" + key
+		}
+		checks[i] = check{name: rule.Name, strategy: rule.Strategy, key: k, limit: rule.Limit}
+	}
+
+	res, i, err := allowAll(ctx, checks)
+	if i < 0 {
+		return res, key, err
+	}
+	return res, checks[i].key, err
+}
+
+// MultiAllower is implemented by strategies that can check several keys in one atomic operation.
+type MultiAllower interface {
+	// AllowAll behaves like the package-level AllowAll
+	AllowAll(ctx context.Context, reqs []KeyLimit) (*Result, int, error)
+}
+
+// MultiKeyAllower is implemented by strategies that can check several independent keys in one call.
+type MultiKeyAllower interface {
+	// AllowMulti behaves like the package-level AllowMulti
+	AllowMulti(ctx context.Context, reqs []KeyLimit) ([]*Result, error)
+}
+
+// AllowMulti checks every key against s independently, as if Allow were called for each in
+// turn: a denied key doesn't affect the others, and results are in the same order as reqs.
+// It saves per-call overhead when s implements MultiKeyAllower (one lock for the in-memory
+// strategies, one pipeline for Redis) and falls back to calling Allow for each key otherwise.
+// On error no results are returned, though keys checked before the error stay charged.
+func AllowMulti(ctx context.Context, s Strategy, reqs []KeyLimit) ([]*Result, error) {
+	if m, ok := s.(MultiKeyAllower); ok {
+		return m.AllowMulti(ctx, reqs)
+	}
+
+	results := make([]*Result, len(reqs))
+	for i, req := range reqs {
+		res, err := s.Allow(ctx, req.Key, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// AllowAll checks every key against s, keeping the consumption only if all of them are allowed.
+// The returned index identifies the request that decided the outcome: the one that denied,
+// or the most restrictive one (fewest remaining) when all are allowed. It is -1 if reqs is empty.
+//
+// If s implements MultiAllower the check is delegated to it. Otherwise keys are checked one
+// by one and refunded on denial when s implements Refunder, which isn't atomic: a concurrent
+// request may briefly see the budget consumed.
+func AllowAll(ctx context.Context, s Strategy, reqs []KeyLimit) (*Result, int, error) {
+	if m, ok := s.(MultiAllower); ok {
+		return m.AllowAll(ctx, reqs)
+	}
+
+	checks := make([]check, len(reqs))
+	for i, req := range reqs {
+		checks[i] = check{strategy: s, key: req.Key, limit: req.Limit}
+	}
+	return allowAll(ctx, checks)
+}
+
+type check struct {
+	name     string
+	strategy Strategy
+	key      string
+	limit    Limit
+}
+
+func allowAll(ctx context.Context, checks []check) (*Result, int, error) {
+	result := &Result{Allowed: true}
+	decided := -1
+
+	for i, c := range checks {
+		res, err := c.strategy.Allow(ctx, c.key, c.limit)
+		if err != nil {
+			refundAll(ctx, checks[:i])
+			return nil, i, err
+		}
+		if c.name != "" {
+			res.Source = c.name
+		}
+		if !res.Allowed {
+			refundAll(ctx, checks[:i])
+			return res, i, nil
+		}
+
+		// Keep the most restrictive result so callers report the tightest budget
+		if decided == -1 || res.Remaining < result.Remaining ||
+			(res.Remaining == result.Remaining && res.ResetAfter > result.ResetAfter) {
+			result = res
+			decided = i
+		}
+	}
+
+	return result, decided, nil
+}
+
+// refundAll gives back one unit to each check. Errors are ignored since the refund is best effort.
+func refundAll(ctx context.Context, checks []check) {
+	for _, c := range checks {
+		if r, ok := c.strategy.(Refunder); ok {
+			_ = r.Refund(ctx, c.key, c.limit, 1)
+		}
+	}
+}
+
+// NewTieredLimiter creates a MultiLimiter combining a burst-friendly short-term limit,
+// enforced by a token bucket, with an absolute cap enforced by a fixed window
+// (e.g. Limit{Rate: 10000, Period: 24 * time.Hour} for a daily cap).
+// The result's Source is "short_term" or "daily_cap", naming the binding tier.
+func NewTieredLimiter(shortTerm Limit, dailyCap Limit) *MultiLimiter {
+	return NewMultiLimiter(
+		Rule{Name: "short_term", Strategy: NewTokenBucket(), Limit: shortTerm},
+		Rule{Name: "daily_cap", Strategy: NewFixedWindow(), Limit: dailyCap},
+	)
+}
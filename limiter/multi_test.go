@@ -0,0 +1,113 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMultiLimiterSourceNamesDenyingRule(t *testing.T) {
+	ctx := context.Background()
+	tb := NewTokenBucket()
+	m := NewMultiLimiter(
+		Rule{Name: "short_term", Strategy: tb, Limit: Limit{Rate: 10, Period: time.Second, Burst: 10}},
+		Rule{Name: "daily_cap", Strategy: tb, Limit: Limit{Rate: 1, Period: 24 * time.Hour, Burst: 1}},
+	)
+
+	res, err := m.Allow(ctx, "k", Limit{})
+	if err != nil || !res.Allowed {
+		t.Fatalf("first request = %+v, %v, want allowed", res, err)
+	}
+
+	res, key, err := m.AllowWithKey(ctx, "k", Limit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Fatal("second request allowed, want denied by the daily cap")
+	}
+	if res.Source != "daily_cap" || key != "daily_cap:k" {
+		t.Errorf("denied by %q on %q, want daily_cap on daily_cap:k", res.Source, key)
+	}
+}
+
+func TestTieredLimiterDailyCapBinds(t *testing.T) {
+	ctx := context.Background()
+	m := NewTieredLimiter(
+		Limit{Rate: 100, Period: time.Second, Burst: 100},
+		Limit{Rate: 3, Period: 24 * time.Hour},
+	)
+
+	for i := 0; i < 3; i++ {
+		res, err := m.Allow(ctx, "k", Limit{})
+		if err != nil || !res.Allowed {
+			t.Fatalf("request %d = %+v, %v, want allowed", i, res, err)
+		}
+	}
+	res, err := m.Allow(ctx, "k", Limit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.Source != "daily_cap" {
+		t.Fatalf("4th request = %+v, want denied by daily_cap with short-term tokens left", res)
+	}
+}
+
+func TestTieredLimiterShortTermBinds(t *testing.T) {
+	ctx := context.Background()
+	m := NewTieredLimiter(
+		Limit{Rate: 2, Period: time.Minute, Burst: 2},
+		Limit{Rate: 100, Period: 24 * time.Hour},
+	)
+
+	exhaust(t, m, "k", Limit{})
+	res, err := m.Allow(ctx, "k", Limit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.Source != "short_term" {
+		t.Fatalf("result %+v, want denied by short_term", res)
+	}
+}
+
+func TestAllowMultiKeepsInputOrder(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	one := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	reqs := []KeyLimit{
+		{Key: "five", Limit: Limit{Rate: 5, Period: time.Minute, Burst: 5}},
+		{Key: "spent", Limit: one},
+		{Key: "free", Limit: Unlimited},
+		{Key: "three", Limit: Limit{Rate: 3, Period: time.Minute, Burst: 3}},
+	}
+
+	for name, s := range map[string]Strategy{
+		"token_bucket":   NewTokenBucket(),
+		"sliding_window": NewSlidingWindow(),
+		"fixed_window":   NewFixedWindow(),
+		"leaky_bucket":   NewLeakyBucket(),
+		"redis":          NewRedisTokenBucket(client),
+		// Falls back to calling Allow for each key
+		"min_interval": NewMinInterval(),
+	} {
+		if _, err := s.Allow(ctx, "spent", one); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := AllowMulti(ctx, s, reqs)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(results) != len(reqs) {
+			t.Fatalf("%s: %d results for %d keys", name, len(results), len(reqs))
+		}
+		// A denied key doesn't stop the keys after it
+		if !results[0].Allowed || results[1].Allowed || !results[2].Allowed || !results[3].Allowed {
+			t.Errorf("%s: allowed = %t %t %t %t, want true false true true", name,
+				results[0].Allowed, results[1].Allowed, results[2].Allowed, results[3].Allowed)
+		}
+		if name != "min_interval" && (results[0].Remaining != 4 || results[3].Remaining != 2) {
+			t.Errorf("%s: remaining = %d and %d, want 4 and 2", name, results[0].Remaining, results[3].Remaining)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NegativeCacheLimiter implements the Strategy interface by remembering denials of the inner
+// strategy locally. After a key is denied, further requests for it are denied without calling
+// the inner strategy until the denial's ResetAfter has elapsed, which spares a remote backend
+// such as Redis from clients hammering it while throttled.
+//
+// The tradeoff is staleness: a refund, a reset or capacity freed by other instances is not
+// seen until the cached denial expires, and the cached result's Remaining is frozen at the
+// time of the denial, though its Reason is replayed as the inner strategy gave it. Denials
+// without a ResetAfter are not cached. Expired denials are swept once a minute, so keys that
+// stopped sending requests don't accumulate.
+type NegativeCacheLimiter struct {
+	inner Strategy
+	clock Clock
+
+	mu      sync.Mutex
+	denials map[string]cachedDenial
+	janitor janitor
+}
+
+type cachedDenial struct {
+	until     time.Time
+	remaining int
+	source    string
+	reason    Reason
+}
+
+// NewNegativeCacheLimiter creates a new NegativeCacheLimiter wrapping inner.
+// It accepts WithClock, which cached denials expire by.
+func NewNegativeCacheLimiter(inner Strategy, opts ...Option) *NegativeCacheLimiter {
+	n := &NegativeCacheLimiter{
+		inner:   inner,
+		clock:   applyOptions(opts).clock,
+		denials: make(map[string]cachedDenial),
+	}
+	n.janitor = janitor{idle: time.Minute, lastSweep: n.clock.Now()}
+	return n
+}
+
+// Allow denies the request from the cache if key was recently denied, and checks it against
+// the inner strategy otherwise.
+func (n *NegativeCacheLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return n.inner.Allow(ctx, key, limit)
+	}
+
+	now := n.clock.Now()
+
+	n.mu.Lock()
+	if n.janitor.due(now) {
+		for k, d := range n.denials {
+			if !now.Before(d.until) {
+				delete(n.denials, k)
+			}
+		}
+	}
+	d, cached := n.denials[key]
+	if cached && !now.Before(d.until) {
+		delete(n.denials, key)
+		cached = false
+	}
+	n.mu.Unlock()
+
+	if cached {
+		res := newResult(d.source)
+		res.Remaining = d.remaining
+		res.ResetAfter = computeResetAfter(d.until.Sub(now))
+		res.Reason = d.reason
+		return res, nil
+	}
+
+	res, err := n.inner.Allow(ctx, key, limit)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Allowed && res.ResetAfter > 0 {
+		n.mu.Lock()
+		n.denials[key] = cachedDenial{
+			until:     now.Add(res.ResetAfter),
+			remaining: res.Remaining,
+			source:    res.Source,
+			reason:    res.Reason,
+		}
+		n.mu.Unlock()
+	}
+
+	return res, nil
+}
@@ -0,0 +1,123 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingStrategy wraps a strategy, counting the calls that reach it.
+func countingStrategy(inner Strategy, calls *int) Strategy {
+	return strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		*calls++
+		return inner.Allow(ctx, key, limit)
+	})
+}
+
+func TestNegativeCacheSkipsBackendUntilReset(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	var calls int
+	n := NewNegativeCacheLimiter(countingStrategy(NewTokenBucket(WithClock(clock)), &calls), WithClock(clock))
+	// One token every 10s
+	limit := Limit{Rate: 6, Period: time.Minute, Burst: 1}
+
+	must(n.Allow(ctx, "k", limit))
+	if res := must(n.Allow(ctx, "k", limit)); res.Allowed || res.ResetAfter != 10*time.Second {
+		t.Fatalf("second request = %+v, want denied for 10s", res)
+	}
+	if calls != 2 {
+		t.Fatalf("%d backend calls, want 2", calls)
+	}
+
+	clock.Advance(4 * time.Second)
+	res := must(n.Allow(ctx, "k", limit))
+	if res.Allowed || res.Reason != ReasonRateExceeded || res.ResetAfter != 6*time.Second {
+		t.Errorf("cached denial = %+v, want denied for the remaining 6s", res)
+	}
+	if calls != 2 {
+		t.Errorf("%d backend calls while the denial is cached, want 2", calls)
+	}
+
+	// Other keys still reach the backend
+	must(n.Allow(ctx, "other", limit))
+	if calls != 3 {
+		t.Errorf("%d backend calls after a request for another key, want 3", calls)
+	}
+
+	clock.Advance(6 * time.Second)
+	if res := must(n.Allow(ctx, "k", limit)); !res.Allowed {
+		t.Errorf("request after the denial expired = %+v, want allowed", res)
+	}
+	if calls != 4 {
+		t.Errorf("%d backend calls after the denial expired, want 4", calls)
+	}
+}
+
+func TestNegativeCacheSkipsDenialsWithoutResetAfter(t *testing.T) {
+	ctx := context.Background()
+	var calls int
+	n := NewNegativeCacheLimiter(strategyFunc(func(ctx context.Context, key string, limit Limit) (*Result, error) {
+		calls++
+		return &Result{Reason: ReasonRateExceeded}, nil
+	}))
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+
+	for i := 0; i < 3; i++ {
+		must(n.Allow(ctx, "k", limit))
+	}
+	if calls != 3 {
+		t.Errorf("%d backend calls, want every denial without a ResetAfter to reach it", calls)
+	}
+}
+
+func TestNegativeCacheReplaysReason(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	backend := &flakyBackend{}
+	backend.down.Store(true)
+	cb := NewCircuitBreakerLimiter(backend, BreakerConfig{MinRequests: 1, Cooldown: time.Minute})
+	n := NewNegativeCacheLimiter(cb, WithClock(clock))
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 1}
+
+	// The failure opens the breaker, whose fail-closed fallback carries a ResetAfter
+	if _, err := n.Allow(ctx, "k", limit); !errors.Is(err, errBackend) {
+		t.Fatalf("error = %v, want the backend error", err)
+	}
+	res := must(n.Allow(ctx, "k", limit))
+	if res.Allowed || res.Reason != ReasonBackendError || res.ResetAfter <= 0 {
+		t.Fatalf("fallback = %+v, want a backend error denial with a ResetAfter", res)
+	}
+
+	clock.Advance(time.Second)
+	res = must(n.Allow(ctx, "k", limit))
+	if res.Allowed || res.Reason != ReasonBackendError || res.Source != "circuit_breaker" {
+		t.Errorf("cached denial = %+v, want the breaker's backend error replayed", res)
+	}
+	if got := backend.calls.Load(); got != 1 {
+		t.Errorf("%d backend calls, want 1", got)
+	}
+}
+
+func TestNegativeCacheSweepsExpiredDenials(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	n := NewNegativeCacheLimiter(NewTokenBucket(WithClock(clock)), WithClock(clock))
+	limit := Limit{Rate: 6, Period: time.Minute, Burst: 1}
+
+	for _, key := range []string{"a", "b", "c"} {
+		must(n.Allow(ctx, key, limit))
+		must(n.Allow(ctx, key, limit))
+	}
+	if len(n.denials) != 3 {
+		t.Fatalf("%d denials cached, want 3", len(n.denials))
+	}
+
+	// The keys went away; the next request for any key sweeps their expired denials
+	clock.Advance(time.Minute)
+	must(n.Allow(ctx, "d", limit))
+	if len(n.denials) != 0 {
+		t.Errorf("%d denials cached after the sweep, want 0", len(n.denials))
+	}
+}
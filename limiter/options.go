@@ -0,0 +1,141 @@
+package limiter
+
+import "time"
+
+// Option configures a strategy. Options that don't apply to a strategy are ignored by it,
+// so the same options can be given to NewStrategy whatever the name. Each constructor lists
+// the options it accepts:
+//
+//   - WithClock: every in-memory strategy, CircuitBreakerLimiter, PenaltyLimiter and
+//     NegativeCacheLimiter
+//   - WithCleanup: every in-memory strategy but GlobalLimiter
+//   - WithLRU: TokenBucket and ShardedTokenBucket
+//   - WithInitialTokens: the token buckets and GlobalLimiter
+//   - WithDenialTracking: TokenBucket, ShardedTokenBucket and the Redis token buckets
+//   - WithDecay: SlidingWindow
+//   - WithRand: LoadShedder
+//   - WithMaxShards: ShardedTokenBucket
+//   - WithKeyPrefix, WithKeyTTL: the Redis token buckets and RedisLeakyBucket
+//   - WithoutAutoExpire: the Redis token buckets
+//   - WithReapInterval: RedisConcurrencyLimiter
+//   - WithPenaltyCurve, WithMaxPenaltyLevel, WithPenaltyDecay, WithGoodBehaviorReset: PenaltyLimiter
+//
+// DistributedMemoryLimiter passes its options on to its local TokenBucket.
+type Option func(*options)
+
+type options struct {
+	clock         Clock
+	cleanupIdle   time.Duration
+	maxKeys       int
+	halfLife      time.Duration
+	initialTokens float64 // Negative for a full bucket
+	keyPrefix     string
+	keyTTL        time.Duration
+	noAutoExpire  bool
+	trackDenials  bool
+	rand          func() float64
+	maxShards     int
+	reapInterval  time.Duration
+
+	penaltyCurve      func(level int) float64
+	maxPenaltyLevel   int // Negative for the default
+	penaltyDecay      time.Duration
+	goodBehaviorReset int
+}
+
+// applyOptions returns the settings resulting from opts.
+func applyOptions(opts []Option) options {
+	o := options{
+		clock:           systemClock{},
+		initialTokens:   -1,
+		maxPenaltyLevel: -1,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithInitialTokens sets how many tokens the bucket of a key seen for the first time holds,
+// instead of a full bucket. It is capped at the limit's burst. Starting low keeps clients
+// from getting a fresh burst by churning through new keys, while the bucket still fills up
+// to the burst at the steady rate. It applies to TokenBucket and the Redis token buckets.
+func WithInitialTokens(tokens float64) Option {
+	return func(o *options) {
+		o.initialTokens = max(0, tokens)
+	}
+}
+
+// WithCleanup drops the state of keys left untouched for idle, so strategies don't keep
+// every key they ever saw. Idle keys are swept at most once per idle, by whichever request
+// comes next, so there is no goroutine to stop. A dropped key starts over with a full
+// budget, which is harmless once its state has fully recovered: pick an idle at least as
+// long as it takes under your longest limit, i.e. the time to refill (or drain) a bucket of
+// Burst for the bucket strategies, two periods for SlidingWindow and one for FixedWindow.
+func WithCleanup(idle time.Duration) Option {
+	return func(o *options) {
+		o.cleanupIdle = idle
+	}
+}
+
+// WithLRU caps the number of keys a strategy keeps at maxKeys, dropping the least recently
+// used key to make room for a new one. It bounds memory even under a flood of distinct keys
+// (e.g. spoofed addresses), at the risk of giving an evicted key a fresh budget early.
+// Zero means no cap.
+func WithLRU(maxKeys int) Option {
+	return func(o *options) {
+		o.maxKeys = maxKeys
+	}
+}
+
+// WithDenialTracking records when each key was last denied, reported as Quota.LastDenied,
+// e.g. to alert on keys that have been throttled for a long time. It costs a timestamp per
+// key, and for Redis a write on every denial, so it is off by default.
+func WithDenialTracking() Option {
+	return func(o *options) {
+		o.trackDenials = true
+	}
+}
+
+// janitor schedules the sweeps of idle keys enabled by WithCleanup.
+type janitor struct {
+	idle      time.Duration
+	lastSweep time.Time
+}
+
+// due reports whether the keys idle at now should be swept, at most once per idle duration.
+func (j *janitor) due(now time.Time) bool {
+	if j.idle <= 0 || now.Sub(j.lastSweep) < j.idle {
+		return false
+	}
+	j.lastSweep = now
+	return true
+}
+
+// Clock tells the in-memory strategies the time.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock. Its readings carry Go's monotonic clock, so the time
+// elapsed between two of them is immune to wall clock adjustments.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock makes a strategy read the time from c instead of the system clock, e.g. to drive
+// it with a fake clock in tests. It applies to the in-memory strategies.
+//
+// The strategies measure elapsed time with Time.Sub, which uses the monotonic readings when
+// both times have one. Times without one, as returned by Round, Truncate, UTC or after
+// serialization, are compared by wall clock and can step backwards when it is adjusted:
+// the strategies then neither refill nor forget anything until the clock catches up, and a
+// jump forward counts at most as the time needed to refill or reset. Keep the monotonic
+// reading in Clock implementations where possible.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
@@ -0,0 +1,107 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConstructorsWithoutOptions(t *testing.T) {
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 1}
+	for name, s := range map[string]Strategy{
+		"token_bucket":         NewTokenBucket(),
+		"sharded_token_bucket": NewShardedTokenBucketAuto(),
+		"sliding_window":       NewSlidingWindow(),
+		"sliding_window_ring":  NewSlidingWindowRing(4),
+		"fixed_window":         NewFixedWindow(),
+		"leaky_bucket":         NewLeakyBucket(),
+		"min_interval":         NewMinInterval(),
+		"global":               NewGlobalLimiter(),
+	} {
+		if n := exhaust(t, s, "k", limit); n != 1 {
+			t.Errorf("%s admitted %d, want 1", name, n)
+		}
+	}
+}
+
+func TestWithCleanup(t *testing.T) {
+	ctx := context.Background()
+	// Nothing recovers within the test, so only a dropped key gets its budget back
+	limit := Limit{Rate: 1, Period: time.Hour, Burst: 1}
+
+	for name, newStrategy := range map[string]func(opts ...Option) Strategy{
+		"token_bucket":         func(opts ...Option) Strategy { return NewTokenBucket(opts...) },
+		"sharded_token_bucket": func(opts ...Option) Strategy { return NewShardedTokenBucket(1, opts...) },
+		"sliding_window":       func(opts ...Option) Strategy { return NewSlidingWindow(opts...) },
+		"sliding_window_ring":  func(opts ...Option) Strategy { return NewSlidingWindowRing(4, opts...) },
+		"fixed_window":         func(opts ...Option) Strategy { return NewFixedWindow(opts...) },
+		"leaky_bucket":         func(opts ...Option) Strategy { return NewLeakyBucket(opts...) },
+		"min_interval":         func(opts ...Option) Strategy { return NewMinInterval(opts...) },
+	} {
+		for _, cleanup := range []bool{false, true} {
+			clock := newFakeClock()
+			opts := []Option{WithClock(clock)}
+			if cleanup {
+				opts = append(opts, WithCleanup(time.Minute))
+			}
+			s := newStrategy(opts...)
+
+			exhaust(t, s, "idle", limit)
+			clock.Advance(2 * time.Minute)
+			// Any request sweeps the idle keys
+			must(s.Allow(ctx, "other", limit))
+
+			if res := must(s.Allow(ctx, "idle", limit)); res.Allowed != cleanup {
+				t.Errorf("%s with cleanup %v: idle key allowed = %v, want %v", name, cleanup, res.Allowed, cleanup)
+			}
+		}
+	}
+}
+
+func TestWithCleanupKeepsActiveKeys(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	tb := NewTokenBucket(WithCleanup(time.Minute), WithClock(clock))
+	limit := Limit{Rate: 1, Period: time.Hour, Burst: 2}
+
+	// Touched every 40s, the key is never idle for a minute
+	for i := 0; i < 5; i++ {
+		must(tb.Allow(ctx, "busy", limit))
+		clock.Advance(40 * time.Second)
+	}
+	if res := must(tb.Allow(ctx, "busy", limit)); res.Allowed {
+		t.Error("busy key got a fresh budget, want it kept")
+	}
+}
+
+func TestWithLRU(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	tb := NewTokenBucket(WithLRU(2), WithClock(clock))
+	limit := Limit{Rate: 1, Period: time.Hour, Burst: 1}
+
+	exhaust(t, tb, "a", limit)
+	exhaust(t, tb, "b", limit)
+	// Using a makes b the least recently used, so c evicts it
+	must(tb.Allow(ctx, "a", limit))
+	exhaust(t, tb, "c", limit)
+
+	if n := len(tb.buckets); n != 2 {
+		t.Fatalf("%d buckets kept, want the cap of 2", n)
+	}
+	if res := must(tb.Allow(ctx, "a", limit)); res.Allowed {
+		t.Error("recently used key a got a fresh budget, want it kept")
+	}
+	if res := must(tb.Allow(ctx, "b", limit)); !res.Allowed {
+		t.Error("evicted key b denied, want a fresh budget")
+	}
+
+	// Without a cap, every key is kept
+	tb = NewTokenBucket(WithClock(clock))
+	for _, key := range []string{"a", "b", "c"} {
+		exhaust(t, tb, key, limit)
+	}
+	if n := len(tb.buckets); n != 3 {
+		t.Errorf("%d buckets kept without WithLRU, want 3", n)
+	}
+}
@@ -0,0 +1,107 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseLimit parses a limit from its string form, as found in config files or env vars.
+//
+// The grammar is, with whitespace allowed around every token:
+//
+//	limit  = "unlimited" | rate "/" period [ ";" "burst" "=" burst ]
+//	rate   = non-negative integer, 0 denying every request
+//	period = positive Go duration ("10s", "1m", "1h30m") or a bare unit ("s", "m", "h")
+//	burst  = non-negative integer, defaults to rate
+//
+// Examples: "100/1m", "5/10s", "100/1h;burst=200", "10/s", "0/1s".
+func ParseLimit(s string) (Limit, error) {
+	fail := func(format string, args ...interface{}) (Limit, error) {
+		return Limit{}, fmt.Errorf("limiter: invalid limit %q: %s", s, fmt.Sprintf(format, args...))
+	}
+
+	spec := strings.TrimSpace(s)
+	if spec == "unlimited" {
+		return Unlimited, nil
+	}
+
+	spec, options, _ := strings.Cut(spec, ";")
+	rateStr, periodStr, ok := strings.Cut(spec, "/")
+	if !ok {
+		return fail(`expected "rate/period"`)
+	}
+
+	rate, err := strconv.Atoi(strings.TrimSpace(rateStr))
+	if err != nil || rate < 0 {
+		return fail("rate %q must be a non-negative integer", strings.TrimSpace(rateStr))
+	}
+
+	period, err := parsePeriod(strings.TrimSpace(periodStr))
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	limit := Limit{Rate: rate, Period: period, Burst: rate}
+	if strings.TrimSpace(options) == "" {
+		if strings.Contains(s, ";") {
+			return fail("empty option after ';'")
+		}
+		return limit, nil
+	}
+
+	name, value, ok := strings.Cut(options, "=")
+	if !ok || strings.TrimSpace(name) != "burst" {
+		return fail(`unknown option %q, expected "burst=N"`, strings.TrimSpace(options))
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || burst < 0 {
+		return fail("burst %q must be a non-negative integer", strings.TrimSpace(value))
+	}
+	limit.Burst = burst
+
+	return limit, nil
+}
+
+func parsePeriod(s string) (time.Duration, error) {
+	switch s {
+	case "":
+		return 0, errors.New("missing period")
+	case "s", "m", "h":
+		// A bare unit means one of it
+		s = "1" + s
+	}
+
+	period, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("period %q is not a duration", s)
+	}
+	if period <= 0 {
+		return 0, fmt.Errorf("period %q must be positive", s)
+	}
+	return period, nil
+}
+
+// String formats the limit so that ParseLimit can read it back, e.g. "100/1h;burst=200".
+// The burst is omitted when it equals the rate.
+func (l Limit) String() string {
+	if l.IsUnlimited() {
+		return "unlimited"
+	}
+
+	period := l.Period.String()
+	// Drop zero trailing units: "1m0s" -> "1m", "1h0m" -> "1h"
+	if strings.HasSuffix(period, "m0s") {
+		period = period[:len(period)-2]
+	}
+	if strings.HasSuffix(period, "h0m") {
+		period = period[:len(period)-2]
+	}
+
+	if l.Burst == l.Rate {
+		return fmt.Sprintf("%d/%s", l.Rate, period)
+	}
+	return fmt.Sprintf("%d/%s;burst=%d", l.Rate, period, l.Burst)
+}
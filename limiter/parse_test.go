@@ -0,0 +1,84 @@
+package limiter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Limit
+	}{
+		{"100/1m", Limit{Rate: 100, Period: time.Minute, Burst: 100}},
+		{"5/10s", Limit{Rate: 5, Period: 10 * time.Second, Burst: 5}},
+		{"100/1h;burst=200", Limit{Rate: 100, Period: time.Hour, Burst: 200}},
+		{" 10 / s ; burst = 3 ", Limit{Rate: 10, Period: time.Second, Burst: 3}},
+		{"1/1h30m", Limit{Rate: 1, Period: 90 * time.Minute, Burst: 1}},
+		{"0/1s", Limit{Rate: 0, Period: time.Second, Burst: 0}},
+		{"0/1s;burst=0", Limit{Rate: 0, Period: time.Second, Burst: 0}},
+		{"unlimited", Unlimited},
+	} {
+		got, err := ParseLimit(tc.in)
+		if err != nil {
+			t.Errorf("ParseLimit(%q): %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseLimitMalformed(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"100",
+		"/1m",
+		"100/",
+		"-1/1m",
+		"1.5/1m",
+		"abc/1m",
+		"100/0s",
+		"100/-1m",
+		"100/1x",
+		"100/1m;",
+		"100/1m;burst",
+		"100/1m;burst=",
+		"100/1m;burst=-1",
+		"100/1m;burst=1.5",
+		"100/1m;size=10",
+		"100/1m;burst=10;burst=20",
+	} {
+		if got, err := ParseLimit(in); err == nil {
+			t.Errorf("ParseLimit(%q) = %+v, want an error", in, got)
+		} else if !strings.Contains(err.Error(), "invalid limit") {
+			t.Errorf("ParseLimit(%q) error %q is not descriptive", in, err)
+		}
+	}
+}
+
+func TestLimitStringRoundTrip(t *testing.T) {
+	for _, limit := range []Limit{
+		{Rate: 100, Period: time.Minute, Burst: 100},
+		{Rate: 100, Period: time.Hour, Burst: 200},
+		{Rate: 1, Period: 90 * time.Minute, Burst: 1},
+		{Rate: 5, Period: 1500 * time.Millisecond, Burst: 1},
+		{Rate: 0, Period: time.Second, Burst: 0},
+		{Rate: 0, Period: time.Second, Burst: 5},
+		PerSecond(0, 0),
+		PerSecond(2.5, 10),
+		Unlimited,
+	} {
+		s := limit.String()
+		got, err := ParseLimit(s)
+		if err != nil {
+			t.Errorf("ParseLimit(%+v.String() = %q): %v", limit, s, err)
+			continue
+		}
+		if got != limit {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", s, got, limit)
+		}
+	}
+}
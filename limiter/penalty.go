@@ -0,0 +1,194 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// PenaltyLimiter implements the Strategy interface by shrinking the limit of keys that keep
+// getting denied. Every denial raises the key's penalty level, and each level scales the rate
+// and burst passed to the inner strategy by the penalty curve. Levels decay one at a time for
+// every decay interval the key goes without a denial.
+type PenaltyLimiter struct {
+	inner         Strategy
+	curve         func(level int) float64
+	maxLevel      int
+	decayInterval time.Duration
+	resetPeriods  int
+	clock         Clock
+
+	mu        sync.Mutex
+	penalties map[string]*penaltyState
+	janitor   janitor
+}
+
+type penaltyState struct {
+	level      int
+	lastChange time.Time
+	lastDenial time.Time
+	period     time.Duration // Period of the limit at the last denial
+}
+
+// WithPenaltyCurve sets the fraction of the limit a key keeps at a given penalty level.
+// The default halves the limit for every level. It applies to PenaltyLimiter.
+func WithPenaltyCurve(curve func(level int) float64) Option {
+	return func(o *options) {
+		o.penaltyCurve = curve
+	}
+}
+
+// WithMaxPenaltyLevel caps how far a key can be penalized. The default is 5. It applies to
+// PenaltyLimiter.
+func WithMaxPenaltyLevel(level int) Option {
+	return func(o *options) {
+		o.maxPenaltyLevel = max(0, level)
+	}
+}
+
+// WithPenaltyDecay sets how long a key must go without a denial to drop one penalty level.
+// The default is one minute, which is also used for a non-positive interval. It applies to
+// PenaltyLimiter.
+func WithPenaltyDecay(interval time.Duration) Option {
+	return func(o *options) {
+		o.penaltyDecay = interval
+	}
+}
+
+// WithGoodBehaviorReset clears a key's penalty entirely once it goes periods full periods
+// of its limit without a denial, instead of waiting for the levels to decay one by one.
+// Reformed clients then get their full limit back quickly. Zero, the default, disables it.
+// It applies to PenaltyLimiter.
+func WithGoodBehaviorReset(periods int) Option {
+	return func(o *options) {
+		o.goodBehaviorReset = periods
+	}
+}
+
+// NewPenaltyLimiter creates a new PenaltyLimiter wrapping inner. Keys whose penalty has
+// fully decayed are swept once per decay interval, so offenders that went away don't
+// accumulate. It accepts WithPenaltyCurve, WithMaxPenaltyLevel, WithPenaltyDecay,
+// WithGoodBehaviorReset and WithClock, which penalties decay by.
+func NewPenaltyLimiter(inner Strategy, opts ...Option) *PenaltyLimiter {
+	o := applyOptions(opts)
+	p := &PenaltyLimiter{
+		inner:         inner,
+		curve:         o.penaltyCurve,
+		maxLevel:      o.maxPenaltyLevel,
+		decayInterval: o.penaltyDecay,
+		resetPeriods:  o.goodBehaviorReset,
+		clock:         o.clock,
+		penalties:     make(map[string]*penaltyState),
+	}
+	if p.curve == nil {
+		p.curve = func(level int) float64 {
+			return math.Pow(0.5, float64(level))
+		}
+	}
+	if p.maxLevel < 0 {
+		p.maxLevel = 5
+	}
+	if p.decayInterval <= 0 {
+		p.decayInterval = time.Minute
+	}
+	p.janitor = janitor{idle: p.decayInterval, lastSweep: p.clock.Now()}
+	return p
+}
+
+// Allow checks the request against the inner strategy using the key's penalized limit.
+func (p *PenaltyLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return p.inner.Allow(ctx, key, limit)
+	}
+
+	now := p.clock.Now()
+
+	// Don't hold the lock while calling the inner strategy, it may be remote
+	p.mu.Lock()
+	if p.janitor.due(now) {
+		p.sweep(now)
+	}
+	level := p.decay(key, now)
+	p.mu.Unlock()
+
+	res, err := p.inner.Allow(ctx, key, p.scale(limit, level))
+	if err != nil {
+		return nil, err
+	}
+
+	if !res.Allowed {
+		p.mu.Lock()
+		p.escalate(key, limit, now)
+		p.mu.Unlock()
+	}
+
+	return res, nil
+}
+
+// Level returns the current penalty level of key, 0 meaning unpenalized.
+func (p *PenaltyLimiter) Level(key string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.decay(key, p.clock.Now())
+}
+
+// sweep drops the penalties that have fully decayed. Must be called with the lock held.
+func (p *PenaltyLimiter) sweep(now time.Time) {
+	for key := range p.penalties {
+		p.decay(key, now)
+	}
+}
+
+// decay drops the levels earned back since the last change and returns the current level.
+// Must be called with the lock held.
+func (p *PenaltyLimiter) decay(key string, now time.Time) int {
+	s, exists := p.penalties[key]
+	if !exists {
+		return 0
+	}
+	if p.resetPeriods > 0 && now.Sub(s.lastDenial) >= time.Duration(p.resetPeriods)*s.period {
+		delete(p.penalties, key)
+		return 0
+	}
+
+	steps := int(now.Sub(s.lastChange) / p.decayInterval)
+	if steps > 0 {
+		s.level -= steps
+		s.lastChange = s.lastChange.Add(time.Duration(steps) * p.decayInterval)
+	}
+	if s.level <= 0 {
+		delete(p.penalties, key)
+		return 0
+	}
+	return s.level
+}
+
+// escalate raises the penalty level of key. Must be called with the lock held.
+func (p *PenaltyLimiter) escalate(key string, limit Limit, now time.Time) {
+	s, exists := p.penalties[key]
+	if !exists {
+		s = &penaltyState{}
+		p.penalties[key] = s
+	}
+
+	if s.level < p.maxLevel {
+		s.level++
+	}
+	s.lastChange = now
+	s.lastDenial = now
+	s.period = limit.Period
+}
+
+// scale applies the penalty curve to the limit, never going below one request.
+func (p *PenaltyLimiter) scale(limit Limit, level int) Limit {
+	if level == 0 {
+		return limit
+	}
+
+	f := p.curve(level)
+	limit.Rate = max(1, wholeUnits(float64(limit.Rate)*f))
+	limit.Burst = max(1, wholeUnits(float64(limit.Burst)*f))
+	return limit
+}
@@ -0,0 +1,139 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// exhaust sends requests for key until one is denied, and returns how many were allowed.
+func exhaust(t *testing.T, s Strategy, key string, limit Limit) int {
+	t.Helper()
+	for n := 0; n < 1000; n++ {
+		res, err := s.Allow(context.Background(), key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			return n
+		}
+	}
+	t.Fatalf("%s never denied", key)
+	return 0
+}
+
+func TestPenaltyEscalationAndRecovery(t *testing.T) {
+	clock := newFakeClock()
+	inner := NewFixedWindow(WithClock(clock))
+	p := NewPenaltyLimiter(inner, WithClock(clock), WithPenaltyDecay(10*time.Minute))
+	limit := Limit{Rate: 8, Period: time.Second, Burst: 8}
+
+	if n := exhaust(t, p, "k", limit); n != 8 {
+		t.Fatalf("unpenalized key got %d requests, want 8", n)
+	}
+	if got := p.Level("k"); got != 1 {
+		t.Fatalf("level after one denial = %d, want 1", got)
+	}
+
+	// Each denial halves the next window's budget
+	for i, want := range []int{4, 2, 1} {
+		clock.Advance(time.Second)
+		if n := exhaust(t, p, "k", limit); n != want {
+			t.Fatalf("window %d: got %d requests, want %d", i+2, n, want)
+		}
+	}
+	if got := p.Level("k"); got != 4 {
+		t.Fatalf("level = %d, want 4", got)
+	}
+
+	// Levels decay one per interval without a denial
+	clock.Advance(10 * time.Minute)
+	if got := p.Level("k"); got != 3 {
+		t.Fatalf("level after one decay interval = %d, want 3", got)
+	}
+	clock.Advance(30 * time.Minute)
+	if got := p.Level("k"); got != 0 {
+		t.Fatalf("level after recovery = %d, want 0", got)
+	}
+	if n := exhaust(t, p, "k", limit); n != 8 {
+		t.Fatalf("recovered key got %d requests, want 8", n)
+	}
+}
+
+func TestPenaltyMaxLevel(t *testing.T) {
+	clock := newFakeClock()
+	p := NewPenaltyLimiter(NewFixedWindow(WithClock(clock)), WithClock(clock), WithMaxPenaltyLevel(2))
+	limit := Limit{Rate: 8, Period: time.Second, Burst: 8}
+
+	for i := 0; i < 5; i++ {
+		exhaust(t, p, "k", limit)
+		clock.Advance(time.Second)
+	}
+	if got := p.Level("k"); got != 2 {
+		t.Fatalf("level = %d, want capped at 2", got)
+	}
+}
+
+func TestPenaltyGoodBehaviorReset(t *testing.T) {
+	clock := newFakeClock()
+	p := NewPenaltyLimiter(NewFixedWindow(WithClock(clock)), WithClock(clock), WithGoodBehaviorReset(3))
+	limit := Limit{Rate: 8, Period: time.Second, Burst: 8}
+
+	exhaust(t, p, "k", limit)
+	clock.Advance(time.Second)
+	exhaust(t, p, "k", limit)
+	if got := p.Level("k"); got != 2 {
+		t.Fatalf("level = %d, want 2", got)
+	}
+
+	// Two clean periods aren't enough, and the default one-minute decay hasn't kicked in
+	clock.Advance(2 * time.Second)
+	if got := p.Level("k"); got != 2 {
+		t.Fatalf("level after 2 clean periods = %d, want 2", got)
+	}
+
+	clock.Advance(time.Second)
+	if got := p.Level("k"); got != 0 {
+		t.Fatalf("level after 3 clean periods = %d, want 0", got)
+	}
+	if n := exhaust(t, p, "k", limit); n != limit.Rate {
+		t.Errorf("reformed key admitted %d, want the full limit of %d", n, limit.Rate)
+	}
+}
+
+func TestPenaltyNonPositiveDecay(t *testing.T) {
+	clock := newFakeClock()
+	p := NewPenaltyLimiter(NewFixedWindow(WithClock(clock)), WithClock(clock), WithPenaltyDecay(0))
+	limit := Limit{Rate: 2, Period: time.Second, Burst: 2}
+
+	// Used to divide by zero
+	exhaust(t, p, "k", limit)
+	if got := p.Level("k"); got != 1 {
+		t.Fatalf("level = %d, want 1", got)
+	}
+	clock.Advance(time.Minute)
+	if got := p.Level("k"); got != 0 {
+		t.Fatalf("level after the default decay = %d, want 0", got)
+	}
+}
+
+func TestPenaltySweepsDecayedKeys(t *testing.T) {
+	clock := newFakeClock()
+	p := NewPenaltyLimiter(NewFixedWindow(WithClock(clock)), WithClock(clock))
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 1}
+
+	for _, key := range []string{"a", "b", "c"} {
+		exhaust(t, p, key, limit)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, err := p.Allow(context.Background(), "d", limit); err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.penalties) != 0 {
+		t.Fatalf("%d penalties left after they decayed, want 0", len(p.penalties))
+	}
+}
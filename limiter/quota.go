@@ -0,0 +1,58 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Quota describes where a key stands against its limit, e.g. for a /quota endpoint.
+type Quota struct {
+	// Limit is the capacity of the key: Burst for the bucket strategies, Rate for the window strategies.
+	Limit int
+	// Used is how much of Limit is currently consumed.
+	Used int
+	// Remaining is how many single-unit requests would be allowed right now.
+	Remaining int
+	// ResetAt is when the key will be back at full capacity if it makes no more requests.
+	// Under a zero Rate the bucket strategies admit nothing, and report a zero Limit that
+	// is reset now.
+	ResetAt time.Time
+	// LastDenied is when a request of the key was last denied, or the zero time if none was
+	// or the strategy doesn't track denials (see WithDenialTracking).
+	LastDenied time.Time
+}
+
+// QuotaReporter is implemented by strategies that can describe a key's quota without consuming it.
+type QuotaReporter interface {
+	// Quota returns the quota of key under limit, like a richer Peek.
+	Quota(ctx context.Context, key string, limit Limit) (*Quota, error)
+}
+
+// unlimitedQuota is the quota strategies report for an Unlimited limit.
+func unlimitedQuota(now time.Time) *Quota {
+	return &Quota{Limit: math.MaxInt, Remaining: math.MaxInt, ResetAt: now}
+}
+
+// newQuota builds a Quota from the capacity and the remaining whole units.
+func newQuota(capacity, remaining int, resetAt time.Time) *Quota {
+	remaining = max(0, min(capacity, remaining))
+	return &Quota{
+		Limit:     capacity,
+		Used:      capacity - remaining,
+		Remaining: remaining,
+		ResetAt:   resetAt,
+	}
+}
+
+// secondsToDuration converts a wait in seconds to a Duration, never negative and capped at
+// the longest Duration. NaN converts to zero.
+func secondsToDuration(sec float64) time.Duration {
+	if !(sec > 0) {
+		return 0
+	}
+	if sec >= float64(math.MaxInt64)/float64(time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(sec * float64(time.Second))
+}
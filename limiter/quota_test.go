@@ -0,0 +1,115 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSecondsToDuration(t *testing.T) {
+	for _, tc := range []struct {
+		sec  float64
+		want time.Duration
+	}{
+		{1.5, 1500 * time.Millisecond},
+		{-1, 0},
+		{math.NaN(), 0},
+		{math.Inf(1), math.MaxInt64},
+		{1e300, math.MaxInt64},
+	} {
+		if got := secondsToDuration(tc.sec); got != tc.want {
+			t.Errorf("secondsToDuration(%g) = %s, want %s", tc.sec, got, tc.want)
+		}
+	}
+}
+
+func TestQuotaAfterAllows(t *testing.T) {
+	ctx := context.Background()
+	// One token, or window slot, per second
+	limit := Limit{Rate: 10, Period: 10 * time.Second, Burst: 5}
+
+	for _, tc := range []struct {
+		name        string
+		newStrategy func(Clock) QuotaReporter
+		// Quota after 3 requests and one second, and when it resets counting from the first request
+		want    Quota
+		resetIn time.Duration
+	}{
+		// The bucket refilled one of the 3 tokens and is full again 2 seconds later
+		{"token_bucket", func(c Clock) QuotaReporter { return NewTokenBucket(WithClock(c)) },
+			Quota{Limit: 5, Used: 2, Remaining: 3}, 3 * time.Second},
+		{"leaky_bucket", func(c Clock) QuotaReporter { return NewLeakyBucket(WithClock(c)) },
+			Quota{Limit: 5, Used: 2, Remaining: 3}, 3 * time.Second},
+		// The window counts all 3 requests until it ends
+		{"fixed_window", func(c Clock) QuotaReporter { return NewFixedWindow(WithClock(c)) },
+			Quota{Limit: 10, Used: 3, Remaining: 7}, 10 * time.Second},
+		// The requests slide out of the window after the window following theirs
+		{"sliding_window", func(c Clock) QuotaReporter { return NewSlidingWindow(WithClock(c)) },
+			Quota{Limit: 10, Used: 3, Remaining: 7}, 20 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newFakeClock()
+			start := clock.Now()
+			tc.want.ResetAt = start.Add(tc.resetIn)
+			q := tc.newStrategy(clock)
+			s := q.(Strategy)
+
+			fresh, err := q.Quota(ctx, "k", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fresh.Used != 0 || fresh.Remaining != fresh.Limit || !fresh.ResetAt.Equal(start) {
+				t.Errorf("quota of an unseen key = %+v, want full and reset now", fresh)
+			}
+
+			for i := 0; i < 3; i++ {
+				if res := must(s.Allow(ctx, "k", limit)); !res.Allowed {
+					t.Fatalf("request %d denied", i)
+				}
+			}
+			clock.Advance(time.Second)
+
+			// Asking twice shows Quota consumes nothing
+			for i := 0; i < 2; i++ {
+				got, err := q.Quota(ctx, "k", limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Limit != tc.want.Limit || got.Used != tc.want.Used || got.Remaining != tc.want.Remaining ||
+					!got.ResetAt.Equal(tc.want.ResetAt) {
+					t.Fatalf("quota = %+v, want %+v", *got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisQuotaAfterAllows(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client)
+	// Slow enough that the bucket doesn't measurably refill during the test
+	limit := Limit{Rate: 1, Period: time.Hour, Burst: 5}
+
+	for i := 0; i < 3; i++ {
+		if res := must(r.Allow(ctx, "k", limit)); !res.Allowed {
+			t.Fatalf("request %d denied", i)
+		}
+	}
+
+	before := time.Now()
+	for i := 0; i < 2; i++ {
+		q, err := r.Quota(ctx, "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Limit != 5 || q.Used != 3 || q.Remaining != 2 {
+			t.Fatalf("quota = %+v, want 3 of 5 used", *q)
+		}
+		// 3 tokens take 3 hours to refill
+		if wait := q.ResetAt.Sub(before); wait < 3*time.Hour-time.Minute || wait > 3*time.Hour+time.Minute {
+			t.Errorf("ResetAt %s from now, want about 3h", wait)
+		}
+	}
+}
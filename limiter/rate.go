@@ -0,0 +1,186 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RateLimiter mirrors the API of golang.org/x/time/rate.Limiter on top of a TokenBucket,
+// easing migration from the standard package to the keyed and distributed strategies.
+//
+// A rate.Limit is a number of events per second, so rate.NewLimiter(r, b) maps to
+// Limit{Rate: r, Period: time.Second, Burst: b} for whole rates, and
+// rate.NewLimiter(rate.Every(d), b) maps to Limit{Rate: 1, Period: d, Burst: b}.
+type RateLimiter struct {
+	tb    *TokenBucket
+	limit Limit
+}
+
+// rateLimiterKey is the single key a RateLimiter uses in its TokenBucket.
+const rateLimiterKey = ""
+
+// NewRateLimiter creates a new RateLimiter enforcing limit.
+func NewRateLimiter(limit Limit) *RateLimiter {
+	return &RateLimiter{
+		tb:    NewTokenBucket(),
+		limit: limit,
+	}
+}
+
+// Limit returns the limit enforced by the limiter.
+func (l *RateLimiter) Limit() Limit {
+	return l.limit
+}
+
+// Burst returns the maximum burst size.
+func (l *RateLimiter) Burst() int {
+	return l.limit.Burst
+}
+
+// Allow reports whether an event may happen now.
+func (l *RateLimiter) Allow() bool {
+	return l.AllowN(time.Now(), 1)
+}
+
+// AllowN reports whether n events may happen at time t. Like x/time/rate, zero events are
+// always allowed without taking anything, while a negative n is never allowed. Unlike it,
+// a zero Rate allows no events at all, even within the burst.
+func (l *RateLimiter) AllowN(t time.Time, n int) bool {
+	if n < 0 {
+		return false
+	}
+	if n == 0 || l.limit.IsUnlimited() {
+		return true
+	}
+	if exceedsBurst(float64(n), l.limit) {
+		return false
+	}
+
+	l.tb.mu.Lock()
+	defer l.tb.mu.Unlock()
+
+	res := l.tb.get(rateLimiterKey, l.limit, t).take(l.limit, float64(n), t)
+	defer ReleaseResult(res)
+	return res.Allowed
+}
+
+// Reserve is shorthand for ReserveN(time.Now(), 1).
+func (l *RateLimiter) Reserve() *Reservation {
+	return l.ReserveN(time.Now(), 1)
+}
+
+// ReserveN returns a Reservation that indicates how long the caller must wait before n events happen.
+// The tokens are taken immediately, so the caller must either act after the delay or call Cancel.
+// Reserving zero events takes nothing and needs no wait; a negative n, like one above the
+// burst or under a zero Rate, gives a Reservation that isn't OK.
+func (l *RateLimiter) ReserveN(t time.Time, n int) *Reservation {
+	if n < 0 {
+		return &Reservation{}
+	}
+	if n == 0 || l.limit.IsUnlimited() {
+		return &Reservation{ok: true, timeToAct: t}
+	}
+	if exceedsBurst(float64(n), l.limit) {
+		return &Reservation{}
+	}
+
+	l.tb.mu.Lock()
+	defer l.tb.mu.Unlock()
+
+	delay := l.tb.get(rateLimiterKey, l.limit, t).reserve(l.limit, float64(n), t)
+	return &Reservation{
+		ok:        true,
+		l:         l,
+		tokens:    n,
+		timeToAct: t.Add(delay),
+	}
+}
+
+// Wait is shorthand for WaitN(ctx, 1).
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n events may happen. It returns an error if n is negative or exceeds
+// the burst, the Rate is zero, the context is canceled, or the wait would outlast the
+// context's deadline.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if n < 0 {
+		return fmt.Errorf("limiter: WaitN(n=%d): %w", n, ErrInvalidCost)
+	}
+	now := time.Now()
+	r := l.ReserveN(now, n)
+	if !r.OK() {
+		return fmt.Errorf("limiter: WaitN(n=%d) exceeds burst %d", n, l.limit.Burst)
+	}
+
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(delay)) {
+		r.Cancel()
+		return fmt.Errorf("limiter: WaitN(n=%d) would exceed context deadline", n)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// Reservation holds tokens taken by RateLimiter.ReserveN that become usable after a delay.
+type Reservation struct {
+	ok        bool
+	l         *RateLimiter
+	tokens    int
+	timeToAct time.Time
+}
+
+// OK reports whether the limiter can provide the requested tokens.
+// If false, Delay returns a very long duration and Cancel does nothing.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay is shorthand for DelayFrom(time.Now()).
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns how long the caller must wait from t before acting on the reservation.
+func (r *Reservation) DelayFrom(t time.Time) time.Duration {
+	if !r.ok {
+		return time.Duration(math.MaxInt64)
+	}
+
+	delay := r.timeToAct.Sub(t)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Cancel is shorthand for CancelAt(time.Now()).
+func (r *Reservation) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+// CancelAt gives the reserved tokens back to the limiter, as if the reservation was
+// never made. Like x/time/rate, it does nothing once the time to act has passed at t,
+// since the caller may already have acted. It is safe to call more than once.
+func (r *Reservation) CancelAt(t time.Time) {
+	if !r.ok || r.l == nil || r.tokens == 0 || r.timeToAct.Before(t) {
+		return
+	}
+
+	_ = r.l.tb.Refund(context.Background(), rateLimiterKey, r.l.limit, r.tokens)
+	r.tokens = 0
+}
@@ -0,0 +1,139 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllowN(t *testing.T) {
+	l := NewRateLimiter(Limit{Rate: 10, Period: time.Second, Burst: 2})
+	now := time.Now()
+
+	if !l.AllowN(now, 2) {
+		t.Fatal("AllowN(2) on a full bucket denied")
+	}
+	if l.AllowN(now, 1) {
+		t.Fatal("AllowN(1) on an empty bucket allowed")
+	}
+	if !l.AllowN(now.Add(100*time.Millisecond), 1) {
+		t.Fatal("AllowN(1) after a refill denied")
+	}
+	if l.AllowN(now.Add(time.Hour), 3) {
+		t.Fatal("AllowN over the burst allowed")
+	}
+}
+
+func TestRateLimiterReserveN(t *testing.T) {
+	l := NewRateLimiter(Limit{Rate: 10, Period: time.Second, Burst: 1})
+	now := time.Now()
+
+	if r := l.ReserveN(now, 1); !r.OK() || r.DelayFrom(now) != 0 {
+		t.Fatalf("first reservation delay %s, want 0", r.DelayFrom(now))
+	}
+	r := l.ReserveN(now, 1)
+	if !r.OK() || r.DelayFrom(now) != 100*time.Millisecond {
+		t.Fatalf("second reservation delay %s, want 100ms", r.DelayFrom(now))
+	}
+	if r := l.ReserveN(now, 2); r.OK() {
+		t.Fatal("reservation over the burst OK")
+	}
+}
+
+func TestRateLimiterNonPositiveCounts(t *testing.T) {
+	l := NewRateLimiter(Limit{Rate: 1, Period: time.Hour, Burst: 1})
+	now := time.Now()
+
+	// A negative count must not add tokens
+	if l.AllowN(now, -5) {
+		t.Error("AllowN(-5) allowed")
+	}
+	if r := l.ReserveN(now, -5); r.OK() {
+		t.Error("ReserveN(-5) OK")
+	}
+	if err := l.WaitN(context.Background(), -5); !errors.Is(err, ErrInvalidCost) {
+		t.Errorf("WaitN(-5) error = %v, want ErrInvalidCost", err)
+	}
+
+	// Zero events are allowed at once, and take nothing
+	if !l.AllowN(now, 0) {
+		t.Error("AllowN(0) denied")
+	}
+	if r := l.ReserveN(now, 0); !r.OK() || r.DelayFrom(now) != 0 {
+		t.Errorf("ReserveN(0) = OK %v with a delay of %s, want OK without one", r.OK(), r.DelayFrom(now))
+	}
+	if err := l.WaitN(context.Background(), 0); err != nil {
+		t.Errorf("WaitN(0) error = %v", err)
+	}
+	if !l.AllowN(now, 1) {
+		t.Fatal("AllowN(1) denied, want the one token of the burst")
+	}
+	if l.AllowN(now, 1) {
+		t.Error("AllowN(1) allowed a second token, so the negative counts added some")
+	}
+}
+
+func TestRateLimiterZeroRate(t *testing.T) {
+	l := NewRateLimiter(Limit{Rate: 0, Period: time.Second, Burst: 5})
+	now := time.Now()
+
+	if l.AllowN(now, 1) {
+		t.Error("AllowN(1) allowed under a zero rate")
+	}
+	if r := l.ReserveN(now, 1); r.OK() {
+		t.Errorf("ReserveN(1) OK with a delay of %s, want a reservation that never comes", r.DelayFrom(now))
+	}
+	if err := l.WaitN(context.Background(), 1); err == nil {
+		t.Error("WaitN(1) succeeded under a zero rate")
+	}
+}
+
+func TestReservationCancelRefunds(t *testing.T) {
+	l := NewRateLimiter(Limit{Rate: 1, Period: time.Minute, Burst: 1})
+	now := time.Now()
+
+	l.ReserveN(now, 1)
+	r := l.ReserveN(now, 1)
+	if !r.OK() || r.DelayFrom(now) != time.Minute {
+		t.Fatalf("reservation delay %s, want 1m", r.DelayFrom(now))
+	}
+	r.CancelAt(now)
+	r.CancelAt(now)
+	if next := l.ReserveN(now, 1); next.DelayFrom(now) != time.Minute {
+		t.Fatalf("next reservation delay %s, want 1m once the token is given back", next.DelayFrom(now))
+	}
+}
+
+func TestReservationCancelAfterTimeToAct(t *testing.T) {
+	l := NewRateLimiter(Limit{Rate: 1, Period: time.Minute, Burst: 1})
+	now := time.Now()
+
+	r := l.ReserveN(now, 1)
+	// The caller may have acted on the reservation already, so its token stays spent
+	r.CancelAt(now.Add(time.Second))
+	if l.AllowN(now.Add(time.Second), 1) {
+		t.Fatal("Cancel after the time to act refunded the token")
+	}
+}
+
+func TestRateLimiterWaitN(t *testing.T) {
+	l := NewRateLimiter(Limit{Rate: 1, Period: time.Hour, Burst: 1})
+
+	if err := l.WaitN(context.Background(), 2); err == nil {
+		t.Fatal("WaitN over the burst succeeded")
+	}
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Fatal("Wait past the context deadline succeeded")
+	}
+	// The failed wait must not have kept its reservation
+	if r := l.Reserve(); r.Delay() > time.Hour {
+		t.Fatalf("next reservation waits %s, want at most an hour", r.Delay())
+	}
+}
@@ -2,6 +2,11 @@ package limiter
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -9,32 +14,83 @@ import (
 
 // RedisTokenBucket implements the Strategy interface using a Redis-backed token bucket.
 type RedisTokenBucket struct {
-	client *redis.Client
+	client        redis.Scripter
+	keyPrefix     string
+	keyTTL        time.Duration
+	noAutoExpire  bool
+	initialTokens float64 // Negative for a full bucket
+	trackDenials  bool
+}
+
+// WithKeyTTL sets how long an idle bucket is kept in Redis before it expires.
+// By default the TTL is derived from the limit: twice the period, or the time
+// needed to refill the bucket if that is longer.
+func WithKeyTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.keyTTL = ttl
+	}
+}
+
+// WithKeyPrefix stores the bucket of each key under prefix+key in Redis, e.g. "ratelimit:",
+// keeping buckets apart from other data and from limiters sharing the database. Flush
+// needs a prefix, so it only deletes the keys under it.
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) {
+		o.keyPrefix = prefix
+	}
+}
+
+// WithoutAutoExpire stops buckets from expiring when idle, so keys persist until
+// explicitly deleted. Every distinct key then stays in Redis forever, so only use it
+// with a bounded key space or your own cleanup.
+func WithoutAutoExpire() Option {
+	return func(o *options) {
+		o.noAutoExpire = true
+	}
 }
 
 // NewRedisTokenBucket creates a new instance of RedisTokenBucket.
-func NewRedisTokenBucket(client *redis.Client) *RedisTokenBucket {
+// The client is usually a *redis.Client, but any redis.Scripter works, such as
+// a cluster client or the fake from the limitertest package.
+// It accepts WithKeyPrefix, WithKeyTTL, WithoutAutoExpire, WithInitialTokens and WithDenialTracking.
+// Denials are tracked in the "last_denied" field of the bucket's hash.
+func NewRedisTokenBucket(client redis.Scripter, opts ...Option) *RedisTokenBucket {
+	o := applyOptions(opts)
 	return &RedisTokenBucket{
-		client: client,
+		client:        client,
+		keyPrefix:     o.keyPrefix,
+		keyTTL:        o.keyTTL,
+		noAutoExpire:  o.noAutoExpire,
+		initialTokens: o.initialTokens,
+		trackDenials:  o.trackDenials,
 	}
 }
 
 // Lua script for token bucket
 // Keys: [1] bucket_key
-// Args: [1] rate (tokens/sec), [2] capacity, [3] now (unixtime float), [4] requested (tokens), [5] ttl (ms)
+// Args: [1] rate (tokens/sec), [2] capacity, [3] now (unixtime float), [4] requested (tokens), [5] ttl (ms, 0 to never expire),
+// [6] initial tokens of a new bucket (negative for a full one), [7] 1 to record the time of denials in last_denied
+// Returns: {allowed, remaining, reset_after (sec)}. When allowed, reset_after is the time until
+// the bucket is full again, otherwise the time until the request would fit. Fractional values are returned as strings
+// because Redis truncates Lua numbers to integers in replies.
 var tokenBucketScript = redis.NewScript(`
 local key = KEYS[1]
-local rate = tonumber(ARGS[1])
-local capacity = tonumber(ARGS[2])
-local now = tonumber(ARGS[3])
-local requested = tonumber(ARGS[4])
-local ttl = tonumber(ARGS[5])
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local initial = tonumber(ARGV[6])
+local track_denials = tonumber(ARGV[7])
 
 local last_tokens = tonumber(redis.call("HGET", key, "tokens"))
 local last_updated = tonumber(redis.call("HGET", key, "last_updated"))
 
 if last_tokens == nil then
     last_tokens = capacity
+    if initial >= 0 then
+        last_tokens = math.min(capacity, initial)
+    end
     last_updated = now
 end
 
@@ -48,67 +104,547 @@ local reset_after = 0
 if filled_tokens >= requested then
     allowed = 1
     remaining = filled_tokens - requested
+    reset_after = (capacity - remaining) / rate
     redis.call("HSET", key, "tokens", remaining, "last_updated", now)
-    redis.call("PEXPIRE", key, ttl) 
+    if ttl > 0 then
+        redis.call("PEXPIRE", key, ttl)
+    end
 else
     allowed = 0
     remaining = filled_tokens
     reset_after = (requested - filled_tokens) / rate
+    if track_denials == 1 then
+        -- Storing the refilled tokens as of now leaves the bucket unchanged
+        redis.call("HSET", key, "tokens", filled_tokens, "last_updated", now, "last_denied", now)
+        if ttl > 0 then
+            redis.call("PEXPIRE", key, ttl)
+        end
+    end
 end
 
-return {allowed, remaining, reset_after}
+return {allowed, tostring(remaining), tostring(reset_after)}
 `)
 
-func (r *RedisTokenBucket) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
-	// Rate is requests per period.
+// Lua script seeding a token bucket
+// Keys: [1] bucket_key
+// Args: [1] tokens, [2] last_updated (unixtime float), [3] ttl (ms, 0 to persist)
+var seedScript = redis.NewScript(`
+local key = KEYS[1]
+local ttl = tonumber(ARGV[3])
+
+redis.call("HSET", key, "tokens", ARGV[1], "last_updated", ARGV[2])
+if ttl > 0 then
+    redis.call("PEXPIRE", key, ttl)
+else
+    redis.call("PERSIST", key)
+end
+
+return 1
+`)
+
+// Seed sets the bucket for key to hold tokens as of time at.
+// Tokens above the burst are kept until the next request caps them. The key expires
+// after the WithKeyTTL duration if set; otherwise it persists until the next request
+// sets an expiry derived from the limit (or forever with WithoutAutoExpire).
+func (r *RedisTokenBucket) Seed(ctx context.Context, key string, tokens float64, at time.Time) error {
+	lastUpdated := float64(at.UnixMicro()) / 1e6
+	var ttlMs int64
+	if !r.noAutoExpire {
+		ttlMs = r.keyTTL.Milliseconds()
+	}
+	return seedScript.Run(ctx, r.client, []string{r.keyPrefix + key}, tokens, lastUpdated, ttlMs).Err()
+}
+
+// Lua script reading a token bucket without changing it
+// Keys: [1] bucket_key
+// Args: [1] rate (tokens/sec), [2] capacity, [3] now (unixtime float), [4] initial tokens of a new bucket (negative for a full one),
+// [5] 1 to also return last_denied
+// Returns: {allowed, remaining, reset_after (sec)} for a request of one token, where remaining
+// counts the tokens before the request, followed by last_denied (unixtime float, 0 if never) if requested.
+// Fractional values are returned as strings.
+var peekScript = redis.NewScript(`
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local initial = tonumber(ARGV[4])
+local with_denied = tonumber(ARGV[5])
+
+local last_tokens = tonumber(redis.call("HGET", key, "tokens"))
+local last_updated = tonumber(redis.call("HGET", key, "last_updated"))
+
+if last_tokens == nil then
+    last_tokens = capacity
+    if initial >= 0 then
+        last_tokens = math.min(capacity, initial)
+    end
+    last_updated = now
+end
+
+local delta = math.max(0, now - last_updated)
+local filled_tokens = math.min(capacity, last_tokens + (delta * rate))
+
+local reply = {0, tostring(filled_tokens), tostring((1 - filled_tokens) / rate)}
+if filled_tokens >= 1 then
+    reply = {1, tostring(filled_tokens), tostring((capacity - filled_tokens) / rate)}
+end
+if with_denied == 1 then
+    table.insert(reply, redis.call("HGET", key, "last_denied") or "0")
+end
+return reply
+`)
+
+// PeekScriptHash returns the SHA1 of the Lua script run by RedisTokenBucket.Peek.
+func PeekScriptHash() string {
+	return peekScript.Hash()
+}
+
+// Peek reports whether a request for key would be allowed, without taking a token.
+// Remaining counts the whole tokens in the bucket, including the one the request would take.
+func (r *RedisTokenBucket) Peek(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("redis"), nil
+	}
+	if exceedsBurst(1, limit) {
+		return burstExceededResult("redis"), nil
+	}
+
 	ratePerSec := float64(limit.Rate) / limit.Period.Seconds()
-	
-	// Use microsecond precision for smoother updates
 	now := float64(time.Now().UnixMicro()) / 1e6
 
-	keys := []string{key}
-	
-	// Calculate a safe TTL for the key
-	// We must keep the key at least as long as it takes to refill the bucket.
-	// If it expires early, it resets to "Full", which would allow cheating the limit.
-	fillTimeSeconds := float64(limit.Burst) / ratePerSec
-	ttlMs := int(fillTimeSeconds * 1000 * 1.5) // 50% safety margin directly in ms
-	if ttlMs < 1000 {
-		ttlMs = 1000 // Minimum 1s
+	res, err := peekScript.Run(ctx, r.client, []string{r.keyPrefix + key}, ratePerSec, limit.Burst, now, r.initialTokens, 0).Result()
+	if err != nil {
+		return nil, err
 	}
+	return parseBucketReply(res, "redis")
+}
 
-	args := []interface{}{ratePerSec, limit.Burst, now, 1, ttlMs}
+// Quota reports how many tokens key holds and when its bucket will be full again.
+func (r *RedisTokenBucket) Quota(ctx context.Context, key string, limit Limit) (*Quota, error) {
+	now := time.Now()
+	if limit.IsUnlimited() {
+		return unlimitedQuota(now), nil
+	}
+	if limit.Rate == 0 {
+		return newQuota(0, 0, now), nil
+	}
 
-	// Helper to cast interface{} to float64 safely
-	toFloat := func(v interface{}) float64 {
-		switch t := v.(type) {
-		case float64:
-			return t
-		case int64:
-			return float64(t)
-		default:
-			return 0
+	ratePerSec := float64(limit.Rate) / limit.Period.Seconds()
+	nowSec := float64(now.UnixMicro()) / 1e6
+
+	withDenied, replyLen := 0, 3
+	if r.trackDenials {
+		withDenied, replyLen = 1, 4
+	}
+	res, err := peekScript.Run(ctx, r.client, []string{r.keyPrefix + key}, ratePerSec, limit.Burst, nowSec, r.initialTokens, withDenied).Result()
+	if err != nil {
+		return nil, err
+	}
+	// The reply carries the fractional token count, which the fill time needs
+	vals, err := replyValues(res, replyLen)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := toFloat(vals[1])
+	if err != nil {
+		return nil, err
+	}
+	fill := secondsToDuration((float64(limit.Burst) - tokens) / ratePerSec)
+	q := newQuota(limit.Burst, wholeUnits(tokens), now.Add(fill))
+	if r.trackDenials {
+		lastDenied, err := toFloat(vals[3])
+		if err != nil {
+			return nil, err
+		}
+		if lastDenied > 0 {
+			q.LastDenied = time.UnixMicro(int64(math.Round(lastDenied * 1e6)))
 		}
 	}
+	return q, nil
+}
+
+// Lua script deleting a key, used to reset buckets
+// Keys: [1] bucket_key
+// Returns: number of keys deleted
+var deleteScript = redis.NewScript(`
+return redis.call("DEL", KEYS[1])
+`)
+
+// DeleteScriptHash returns the SHA1 of the Lua script used to reset Redis buckets.
+func DeleteScriptHash() string {
+	return deleteScript.Hash()
+}
+
+// resetKey deletes key and reports whether it existed.
+func resetKey(ctx context.Context, client redis.Scripter, key string) (bool, error) {
+	deleted, err := deleteScript.Run(ctx, client, []string{key}).Int64()
+	if err != nil {
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+// Reset deletes the bucket for key, restoring its full budget.
+func (r *RedisTokenBucket) Reset(ctx context.Context, key string) (bool, error) {
+	return resetKey(ctx, r.client, r.keyPrefix+key)
+}
+
+// SeedScriptHash returns the SHA1 of the Lua script run by RedisTokenBucket.Seed.
+func SeedScriptHash() string {
+	return seedScript.Hash()
+}
+
+// TokenBucketScriptHash returns the SHA1 of the Lua script run by RedisTokenBucket.
+func TokenBucketScriptHash() string {
+	return tokenBucketScript.Hash()
+}
+
+// Preload loads the scripts of RedisTokenBucket into the Redis script cache.
+func (r *RedisTokenBucket) Preload(ctx context.Context) error {
+	return loadScripts(ctx, r.client, tokenBucketScript, seedScript, peekScript, deleteScript)
+}
+
+// loadScripts loads scripts into the script cache of client, stopping at the first error.
+func loadScripts(ctx context.Context, client redis.Scripter, scripts ...*redis.Script) error {
+	for _, script := range scripts {
+		if err := script.Load(ctx, client).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Allow checks if the request is allowed based on the token bucket algorithm.
+func (r *RedisTokenBucket) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return r.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN atomically checks if a request consuming n tokens is allowed.
+// It returns ErrExceedsBurst without calling Redis if n can never fit in the bucket.
+func (r *RedisTokenBucket) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	if n <= 0 {
+		return nil, ErrInvalidCost
+	}
+	// Skip the round trip entirely for unlimited tiers
+	if limit.IsUnlimited() {
+		return unlimitedResult("redis"), nil
+	}
+	if exceedsBurst(float64(n), limit) {
+		return nil, ErrExceedsBurst
+	}
+
+	// Use microsecond precision for smoother updates
+	now := float64(time.Now().UnixMicro()) / 1e6
+
+	keys := []string{r.keyPrefix + key}
+	args := r.scriptArgs(limit, now, n)
 
 	res, err := tokenBucketScript.Run(ctx, r.client, keys, args...).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	vals := res.([]interface{})
-	allowedVal := vals[0].(int64)
-	remainingVal := toFloat(vals[1]) // Lua might return int or float
-	resetAfterVal := toFloat(vals[2])
+	return parseBucketReply(res, "redis")
+}
+
+// scriptArgs returns the ARGV of tokenBucketScript for a request of n tokens at now.
+func (r *RedisTokenBucket) scriptArgs(limit Limit, now float64, n int) []interface{} {
+	// Rate is requests per period.
+	ratePerSec := float64(limit.Rate) / limit.Period.Seconds()
 
-	result := &Result{
-		Allowed:   allowedVal == 1,
-		Remaining: int(remainingVal),
+	var ttlMs int64
+	if !r.noAutoExpire {
+		ttlMs = r.ttl(limit, ratePerSec).Milliseconds()
+	}
+
+	trackDenials := 0
+	if r.trackDenials {
+		trackDenials = 1
+	}
+	return []interface{}{ratePerSec, limit.Burst, now, n, ttlMs, r.initialTokens, trackDenials}
+}
+
+// pipeliner is implemented by Redis clients that support pipelining, such as *redis.Client.
+type pipeliner interface {
+	Pipeline() redis.Pipeliner
+}
+
+// AllowMulti checks each key independently, see the package-level AllowMulti.
+// The scripts are sent in a single pipeline when the client supports it (which a
+// *redis.Client does), and one at a time otherwise. Keys aren't checked atomically
+// together, so they may live on different cluster slots.
+func (r *RedisTokenBucket) AllowMulti(ctx context.Context, reqs []KeyLimit) ([]*Result, error) {
+	p, ok := r.client.(pipeliner)
+	if !ok {
+		results := make([]*Result, len(reqs))
+		for i, req := range reqs {
+			if !req.Limit.IsUnlimited() && exceedsBurst(1, req.Limit) {
+				results[i] = burstExceededResult("redis")
+				continue
+			}
+			res, err := r.Allow(ctx, req.Key, req.Limit)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = res
+		}
+		return results, nil
+	}
+
+	results, err := r.allowPipelined(ctx, p, reqs)
+	if err != nil && redis.HasErrorPrefix(err, "NOSCRIPT") {
+		// The script isn't cached on the server yet. Nothing ran, so load it and retry.
+		if err := tokenBucketScript.Load(ctx, r.client).Err(); err != nil {
+			return nil, err
+		}
+		results, err = r.allowPipelined(ctx, p, reqs)
+	}
+	return results, err
+}
+
+func (r *RedisTokenBucket) allowPipelined(ctx context.Context, p pipeliner, reqs []KeyLimit) ([]*Result, error) {
+	now := float64(time.Now().UnixMicro()) / 1e6
+
+	pipe := p.Pipeline()
+	cmds := make([]*redis.Cmd, len(reqs))
+	for i, req := range reqs {
+		if req.Limit.IsUnlimited() || exceedsBurst(1, req.Limit) {
+			continue
+		}
+		cmds[i] = tokenBucketScript.EvalSha(ctx, pipe, []string{r.keyPrefix + req.Key}, r.scriptArgs(req.Limit, now, 1)...)
+	}
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]*Result, len(reqs))
+	for i, cmd := range cmds {
+		if cmd == nil {
+			if reqs[i].Limit.IsUnlimited() {
+				results[i] = unlimitedResult("redis")
+			} else {
+				results[i] = burstExceededResult("redis")
+			}
+			continue
+		}
+		res, err := parseBucketReply(cmd.Val(), "redis")
+		if err != nil {
+			return nil, err
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// ErrUnexpectedReply is returned when a Redis script replies with values of the wrong shape or
+// type. It points to a bug, such as a script out of sync with the code, rather than a decision,
+// so it is never turned into an allowed or denied result.
+var ErrUnexpectedReply = errors.New("limiter: unexpected reply from redis script")
+
+// parseBucketReply converts the {allowed, remaining, reset_after} reply of the bucket scripts into a Result.
+func parseBucketReply(res interface{}, source string) (*Result, error) {
+	vals, err := replyValues(res, 3)
+	if err != nil {
+		return nil, err
+	}
+	allowedVal, err := replyInt(vals[0])
+	if err != nil {
+		return nil, err
+	}
+	if allowedVal != 0 && allowedVal != 1 {
+		return nil, fmt.Errorf("%w: allowed flag is %d", ErrUnexpectedReply, allowedVal)
+	}
+	remainingVal, err := toFloat(vals[1])
+	if err != nil {
+		return nil, err
+	}
+	resetAfterVal, err := toFloat(vals[2])
+	if err != nil {
+		return nil, err
+	}
+
+	result := newResult(source)
+	result.Allowed = allowedVal == 1
+	result.Remaining = wholeUnits(remainingVal)
+
+	if !result.Allowed {
+		result.Reason = ReasonRateExceeded
 	}
-	
 	if resetAfterVal > 0 {
-		result.ResetAfter = time.Duration(resetAfterVal * float64(time.Second))
+		result.ResetAfter = computeResetAfter(time.Duration(resetAfterVal * float64(time.Second)))
 	}
 
 	return result, nil
 }
+
+// replyValues checks that a script reply is an array of n values.
+func replyValues(res interface{}, n int) ([]interface{}, error) {
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != n {
+		return nil, fmt.Errorf("%w: want an array of %d values, got %#v", ErrUnexpectedReply, n, res)
+	}
+	return vals, nil
+}
+
+// replyInt casts a script reply value to int64, failing on any other type.
+func replyInt(v interface{}) (int64, error) {
+	i, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%w: want an integer, got %#v", ErrUnexpectedReply, v)
+	}
+	return i, nil
+}
+
+// toFloat casts a script reply value to float64, failing on any other type.
+// Fractional values arrive as strings, since Redis truncates Lua numbers.
+func toFloat(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case int64:
+		return float64(t), nil
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrUnexpectedReply, err)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("%w: want a number, got %#v", ErrUnexpectedReply, v)
+	}
+}
+
+// ErrScanUnsupported is returned by ActiveKeys and Flush when the Redis client can only run scripts.
+var ErrScanUnsupported = errors.New("limiter: redis client does not support SCAN")
+
+// ErrNoKeyPrefix is returned by Flush on a Redis strategy created without WithKeyPrefix,
+// since it couldn't tell its buckets from the rest of the database.
+var ErrNoKeyPrefix = errors.New("limiter: flushing a redis strategy needs WithKeyPrefix")
+
+// keyScanner is the part of a Redis client ActiveKeys and Flush need beyond redis.Scripter.
+// *redis.Client implements it.
+type keyScanner interface {
+	ScanType(ctx context.Context, cursor uint64, match string, count int64, keyType string) *redis.ScanCmd
+	HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd
+}
+
+// ActiveKeys SCANs the hashes under the key prefix (or the whole database without one) and
+// returns the keys of the token buckets holding less than one token under limit, without the
+// prefix. It is approximate, since buckets keep changing during the scan, and expensive: SCAN
+// walks the whole keyspace whatever the prefix, and each bucket is read, so keep it to admin
+// tools and avoid it on large databases. Only the node the client is connected to is scanned.
+// It returns ErrScanUnsupported if the client doesn't implement SCAN and HMGET.
+func (r *RedisTokenBucket) ActiveKeys(ctx context.Context, limit Limit) ([]string, error) {
+	if limit.IsUnlimited() {
+		return nil, nil
+	}
+	client, ok := r.client.(keyScanner)
+	if !ok {
+		return nil, ErrScanUnsupported
+	}
+
+	ratePerSec := float64(limit.Rate) / limit.Period.Seconds()
+	now := float64(time.Now().UnixMicro()) / 1e6
+
+	var keys []string
+	err := scanBuckets(ctx, client, r.keyPrefix, []string{"tokens", "last_updated"}, func(key string, vals []string) error {
+		tokens, err1 := strconv.ParseFloat(vals[0], 64)
+		lastUpdated, err2 := strconv.ParseFloat(vals[1], 64)
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+
+		filled := math.Min(float64(limit.Burst), tokens+math.Max(0, now-lastUpdated)*ratePerSec)
+		if filled < 1 {
+			keys = append(keys, strings.TrimPrefix(key, r.keyPrefix))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Flush SCANs the hashes under the key prefix and deletes the token buckets, i.e. those
+// with "tokens" and "last_updated" fields. SCAN walks the whole keyspace to find them, with
+// a round trip per bucket, so it suits test teardown and rare admin actions, and only the
+// node the client is connected to is scanned.
+// It returns ErrNoKeyPrefix without WithKeyPrefix, rather than touching every hash in the
+// database, and ErrScanUnsupported if the client doesn't implement SCAN and HMGET.
+func (r *RedisTokenBucket) Flush(ctx context.Context) error {
+	return flushBuckets(ctx, r.client, r.keyPrefix, []string{"tokens", "last_updated"})
+}
+
+// flushBuckets deletes the hashes under prefix holding all of fields.
+func flushBuckets(ctx context.Context, client redis.Scripter, prefix string, fields []string) error {
+	if prefix == "" {
+		return ErrNoKeyPrefix
+	}
+	scanner, ok := client.(keyScanner)
+	if !ok {
+		return ErrScanUnsupported
+	}
+	return scanBuckets(ctx, scanner, prefix, fields, func(key string, vals []string) error {
+		_, err := resetKey(ctx, client, key)
+		return err
+	})
+}
+
+// globEscaper escapes the characters SCAN MATCH patterns treat specially.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// scanBuckets SCANs the hashes whose names start with prefix and calls fn with each one
+// holding all of fields, along with their values.
+func scanBuckets(ctx context.Context, client keyScanner, prefix string, fields []string, fn func(key string, vals []string) error) error {
+	iter := client.ScanType(ctx, 0, globEscaper.Replace(prefix)+"*", 100, "hash").Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		raw, err := client.HMGet(ctx, key, fields...).Result()
+		if err != nil {
+			return err
+		}
+		// Skip hashes that aren't buckets, or expired since the scan saw them
+		vals := make([]string, len(raw))
+		complete := true
+		for i, v := range raw {
+			str, ok := v.(string)
+			vals[i] = str
+			complete = complete && ok
+		}
+		if !complete {
+			continue
+		}
+		if err := fn(key, vals); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// ttl returns how long an idle bucket key is kept in Redis.
+func (r *RedisTokenBucket) ttl(limit Limit, ratePerSec float64) time.Duration {
+	if r.keyTTL > 0 {
+		return r.keyTTL
+	}
+	return bucketTTL(limit, ratePerSec)
+}
+
+// bucketTTL derives how long an idle bucket key is kept in Redis from its limit.
+func bucketTTL(limit Limit, ratePerSec float64) time.Duration {
+	// Keep active windows alive for two periods so slow limits (e.g. 100/day) don't reset mid-period.
+	ttl := 2 * limit.Period
+
+	// We must also keep the key at least as long as it takes to refill the bucket.
+	// If it expires early, it resets to "Full", which would allow cheating the limit.
+	fillTime := secondsToDuration(float64(limit.Burst) / ratePerSec)
+	if fillTime > ttl {
+		ttl = fillTime
+	}
+	if ttl < time.Second {
+		ttl = time.Second // Minimum 1s
+	}
+	return ttl
+}
@@ -0,0 +1,209 @@
+package limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConcurrencyLimiter caps how many requests per key may be in flight at once across
+// all instances. Each in-flight request holds a lease, stored as a member of a Redis sorted
+// set scored by its expiry time.
+//
+// Leases expire after the lease TTL even if they are never released, so a crashed holder
+// only blocks its slot until then. Choose a TTL longer than the slowest request: a lease
+// held past its TTL is evicted and its slot handed to someone else while the request
+// still runs. Expired leases are evicted on every Acquire, and with WithReapInterval also
+// by a background reaper visiting the keys this instance acquired on.
+type RedisConcurrencyLimiter struct {
+	client       redis.Scripter
+	leaseTTL     time.Duration
+	reapInterval time.Duration
+
+	mu   sync.Mutex
+	keys map[string]struct{} // Keys the reaper visits until they hold no lease; nil without a reaper
+	quit chan struct{}
+	done chan struct{}
+}
+
+// defaultLeaseTTL is the lease TTL used when NewRedisConcurrencyLimiter is given a non-positive one.
+const defaultLeaseTTL = 30 * time.Second
+
+// WithReapInterval runs a background reaper that evicts the expired leases of the keys
+// RedisConcurrencyLimiter acquired on every interval, so a crashed holder's slot frees up
+// even if no Acquire comes for its key. The limiter must then be closed to stop it.
+func WithReapInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.reapInterval = interval
+	}
+}
+
+// NewRedisConcurrencyLimiter creates a new RedisConcurrencyLimiter whose leases expire after
+// leaseTTL, or 30 seconds if leaseTTL is not positive. It accepts WithReapInterval; with
+// it, Close must be called once the limiter is no longer used, or the reaper leaks.
+func NewRedisConcurrencyLimiter(client redis.Scripter, leaseTTL time.Duration, opts ...Option) *RedisConcurrencyLimiter {
+	if leaseTTL <= 0 {
+		leaseTTL = defaultLeaseTTL
+	}
+	o := applyOptions(opts)
+	c := &RedisConcurrencyLimiter{
+		client:       client,
+		leaseTTL:     leaseTTL,
+		reapInterval: o.reapInterval,
+	}
+	if c.reapInterval > 0 {
+		c.keys = make(map[string]struct{})
+		c.quit = make(chan struct{})
+		c.done = make(chan struct{})
+		go c.reap()
+	}
+	return c
+}
+
+// Lua script acquiring a lease
+// Keys: [1] lease_set_key
+// Args: [1] now (unix ms), [2] lease expiry (unix ms), [3] max leases, [4] lease id, [5] ttl (ms)
+// Returns: 1 if the lease was added, 0 if the key is at capacity
+var acquireLeaseScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local max = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now)
+if redis.call("ZCARD", key) < max then
+    redis.call("ZADD", key, ARGV[2], ARGV[4])
+    redis.call("PEXPIRE", key, ARGV[5])
+    return 1
+end
+return 0
+`)
+
+// Lua script releasing a lease
+// Keys: [1] lease_set_key
+// Args: [1] lease id
+// Returns: number of leases removed
+var releaseLeaseScript = redis.NewScript(`
+return redis.call("ZREM", KEYS[1], ARGV[1])
+`)
+
+// Lua script evicting expired leases
+// Keys: [1] lease_set_key
+// Args: [1] now (unix ms)
+// Returns: number of leases still held
+var reapLeasesScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+return redis.call("ZCARD", KEYS[1])
+`)
+
+// AcquireLeaseScriptHash returns the SHA1 of the Lua script run by RedisConcurrencyLimiter.Acquire.
+func AcquireLeaseScriptHash() string {
+	return acquireLeaseScript.Hash()
+}
+
+// ReleaseLeaseScriptHash returns the SHA1 of the Lua script run by RedisConcurrencyLimiter.Release.
+func ReleaseLeaseScriptHash() string {
+	return releaseLeaseScript.Hash()
+}
+
+// ReapLeasesScriptHash returns the SHA1 of the Lua script run by the RedisConcurrencyLimiter reaper.
+func ReapLeasesScriptHash() string {
+	return reapLeasesScript.Hash()
+}
+
+// Preload loads the scripts of RedisConcurrencyLimiter into the Redis script cache.
+func (c *RedisConcurrencyLimiter) Preload(ctx context.Context) error {
+	return loadScripts(ctx, c.client, acquireLeaseScript, releaseLeaseScript, reapLeasesScript)
+}
+
+// Acquire takes one of the max slots of key. It returns the lease to pass to Release once
+// the request completes, and false if all slots are taken.
+func (c *RedisConcurrencyLimiter) Acquire(ctx context.Context, key string, max int) (string, bool, error) {
+	lease, err := newLeaseID()
+	if err != nil {
+		return "", false, err
+	}
+
+	now := time.Now()
+	ttlMs := c.leaseTTL.Milliseconds()
+	args := []interface{}{now.UnixMilli(), now.Add(c.leaseTTL).UnixMilli(), max, lease, ttlMs}
+
+	acquired, err := acquireLeaseScript.Run(ctx, c.client, []string{key}, args...).Int64()
+	if err != nil {
+		return "", false, err
+	}
+	if acquired == 0 {
+		return "", false, nil
+	}
+
+	if c.keys != nil {
+		c.mu.Lock()
+		c.keys[key] = struct{}{}
+		c.mu.Unlock()
+	}
+
+	return lease, true, nil
+}
+
+// Release gives back the slot held by lease. Releasing an expired or unknown lease is a no-op.
+func (c *RedisConcurrencyLimiter) Release(ctx context.Context, key, lease string) error {
+	return releaseLeaseScript.Run(ctx, c.client, []string{key}, lease).Err()
+}
+
+// Close stops the reaper started by WithReapInterval, if any. Leases still held keep
+// expiring in Redis.
+func (c *RedisConcurrencyLimiter) Close() {
+	if c.quit == nil {
+		return
+	}
+	close(c.quit)
+	<-c.done
+}
+
+func (c *RedisConcurrencyLimiter) reap() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.reapOnce(context.Background())
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// reapOnce evicts expired leases of every tracked key, forgetting keys left empty.
+// Errors are ignored since Acquire evicts expired leases on its own.
+func (c *RedisConcurrencyLimiter) reapOnce(ctx context.Context) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.keys))
+	for key := range c.keys {
+		keys = append(keys, key)
+	}
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		held, err := reapLeasesScript.Run(ctx, c.client, []string{key}, time.Now().UnixMilli()).Int64()
+		if err == nil && held == 0 {
+			c.mu.Lock()
+			delete(c.keys, key)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// newLeaseID returns a random lease identifier.
+func newLeaseID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
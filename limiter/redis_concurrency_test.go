@@ -0,0 +1,116 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisConcurrencyLimiterCapsLeases(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	c := NewRedisConcurrencyLimiter(client, time.Minute)
+	defer c.Close()
+
+	var leases []string
+	for i := 0; i < 2; i++ {
+		lease, ok, err := c.Acquire(ctx, "k", 2)
+		if err != nil || !ok {
+			t.Fatalf("Acquire %d = %v, %v, want a lease", i, ok, err)
+		}
+		leases = append(leases, lease)
+	}
+	if _, ok, err := c.Acquire(ctx, "k", 2); err != nil || ok {
+		t.Fatalf("Acquire over the cap = %v, %v, want no lease", ok, err)
+	}
+
+	// Another instance shares the same slots
+	other := NewRedisConcurrencyLimiter(client, time.Minute)
+	defer other.Close()
+	if _, ok, _ := other.Acquire(ctx, "k", 2); ok {
+		t.Fatal("another instance got a lease over the cap")
+	}
+
+	if err := c.Release(ctx, "k", leases[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := other.Acquire(ctx, "k", 2); err != nil || !ok {
+		t.Fatalf("Acquire after Release = %v, %v, want a lease", ok, err)
+	}
+
+	// Releasing twice is a no-op and doesn't free a slot held by someone else
+	if err := c.Release(ctx, "k", leases[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := c.Acquire(ctx, "k", 2); ok {
+		t.Fatal("double Release freed a slot")
+	}
+}
+
+func TestRedisConcurrencyLimiterExpiresCrashedHolders(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	c := NewRedisConcurrencyLimiter(client, 50*time.Millisecond, WithReapInterval(10*time.Millisecond))
+	defer c.Close()
+
+	// The holder never releases, as if it crashed
+	if _, ok, err := c.Acquire(ctx, "k", 1); err != nil || !ok {
+		t.Fatalf("Acquire = %v, %v, want a lease", ok, err)
+	}
+	if _, ok, _ := c.Acquire(ctx, "k", 1); ok {
+		t.Fatal("Acquire over the cap got a lease")
+	}
+
+	// The reaper evicts the lease without waiting for another Acquire
+	time.Sleep(200 * time.Millisecond)
+	if members, _ := mr.ZMembers("k"); len(members) != 0 {
+		t.Fatalf("leases %v still held after their TTL", members)
+	}
+	if _, ok, err := c.Acquire(ctx, "k", 1); err != nil || !ok {
+		t.Fatalf("Acquire after the lease expired = %v, %v, want a lease", ok, err)
+	}
+}
+
+func TestRedisConcurrencyLimiterDefaultLeaseTTL(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		c := NewRedisConcurrencyLimiter(client, ttl)
+		if _, ok, err := c.Acquire(ctx, "k", 10); err != nil || !ok {
+			t.Fatalf("lease TTL %s: Acquire = %v, %v, want a lease", ttl, ok, err)
+		}
+		if got := mr.TTL("k"); got != defaultLeaseTTL {
+			t.Errorf("lease TTL %s: key TTL = %s, want the default %s", ttl, got, defaultLeaseTTL)
+		}
+		c.Close()
+	}
+}
+
+func TestRedisConcurrencyLimiterReapsOnlyWhenAsked(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+
+	// Without WithReapInterval no reaper runs, no keys are tracked and Close is a no-op
+	c := NewRedisConcurrencyLimiter(client, time.Minute)
+	if _, ok, err := c.Acquire(ctx, "k", 1); err != nil || !ok {
+		t.Fatalf("Acquire = %v, %v, want a lease", ok, err)
+	}
+	if c.keys != nil || c.quit != nil {
+		t.Error("limiter without WithReapInterval started a reaper")
+	}
+	c.Close()
+	c.Close()
+
+	reaped := NewRedisConcurrencyLimiter(client, time.Minute, WithReapInterval(time.Hour))
+	if _, _, err := reaped.Acquire(ctx, "other", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reaped.keys["other"]; !ok {
+		t.Error("reaper is not tracking the acquired key")
+	}
+	if reaped.reapInterval != time.Hour {
+		t.Errorf("reap interval = %s, want 1h", reaped.reapInterval)
+	}
+	reaped.Close()
+}
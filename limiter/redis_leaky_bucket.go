@@ -0,0 +1,124 @@
+package limiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisLeakyBucket implements the Strategy interface using a Redis-backed leaky bucket.
+// It admits the same requests as LeakyBucket, atomically across instances.
+type RedisLeakyBucket struct {
+	client    redis.Scripter
+	keyPrefix string
+	keyTTL    time.Duration
+}
+
+// NewRedisLeakyBucket creates a new instance of RedisLeakyBucket.
+// It accepts WithKeyPrefix and WithKeyTTL.
+func NewRedisLeakyBucket(client redis.Scripter, opts ...Option) *RedisLeakyBucket {
+	o := applyOptions(opts)
+	return &RedisLeakyBucket{
+		client:    client,
+		keyPrefix: o.keyPrefix,
+		keyTTL:    o.keyTTL,
+	}
+}
+
+// Lua script for leaky bucket
+// Keys: [1] bucket_key
+// Args: [1] leak rate (units/sec), [2] capacity, [3] now (unixtime float), [4] requested (units), [5] ttl (ms)
+// Returns: {allowed, remaining, reset_after (sec)}, fractional values as strings
+var leakyBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+
+local level = tonumber(redis.call("HGET", key, "level"))
+local last_leak = tonumber(redis.call("HGET", key, "last_leak"))
+
+if level == nil then
+    level = 0
+    last_leak = now
+end
+
+local delta = math.max(0, now - last_leak)
+level = math.max(0, level - (delta * rate))
+
+local allowed = 0
+local reset_after = 0
+
+if level + requested <= capacity then
+    allowed = 1
+    level = level + requested
+    redis.call("HSET", key, "level", level, "last_leak", now)
+    redis.call("PEXPIRE", key, ttl)
+else
+    reset_after = (level + requested - capacity) / rate
+end
+
+return {allowed, tostring(capacity - level), tostring(reset_after)}
+`)
+
+// LeakyBucketScriptHash returns the SHA1 of the Lua script run by RedisLeakyBucket.
+func LeakyBucketScriptHash() string {
+	return leakyBucketScript.Hash()
+}
+
+// Allow checks if the request is allowed based on the leaky bucket algorithm.
+func (r *RedisLeakyBucket) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return r.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN atomically checks if a request adding n to the bucket is allowed.
+// It returns ErrExceedsBurst without calling Redis if n can never fit in the bucket.
+func (r *RedisLeakyBucket) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	if n <= 0 {
+		return nil, ErrInvalidCost
+	}
+	if limit.IsUnlimited() {
+		return unlimitedResult("redis_leaky_bucket"), nil
+	}
+	if exceedsBurst(float64(n), limit) {
+		return nil, ErrExceedsBurst
+	}
+
+	leakPerSec := float64(limit.Rate) / limit.Period.Seconds()
+	now := float64(time.Now().UnixMicro()) / 1e6
+	// A full bucket takes as long to drain as an empty token bucket takes to refill
+	ttl := bucketTTL(limit, leakPerSec)
+	if r.keyTTL > 0 {
+		ttl = r.keyTTL
+	}
+	ttlMs := ttl.Milliseconds()
+
+	args := []interface{}{leakPerSec, limit.Burst, now, n, ttlMs}
+	res, err := leakyBucketScript.Run(ctx, r.client, []string{r.keyPrefix + key}, args...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseBucketReply(res, "redis_leaky_bucket")
+}
+
+// Reset deletes the bucket for key, restoring its full budget.
+func (r *RedisLeakyBucket) Reset(ctx context.Context, key string) (bool, error) {
+	return resetKey(ctx, r.client, r.keyPrefix+key)
+}
+
+// Flush SCANs the hashes under the key prefix and deletes the leaky buckets, i.e. those
+// with "level" and "last_leak" fields. It is as expensive as RedisTokenBucket.Flush.
+// It returns ErrNoKeyPrefix without WithKeyPrefix and ErrScanUnsupported if the client
+// doesn't implement SCAN and HMGET.
+func (r *RedisLeakyBucket) Flush(ctx context.Context) error {
+	return flushBuckets(ctx, r.client, r.keyPrefix, []string{"level", "last_leak"})
+}
+
+// Preload loads the scripts of RedisLeakyBucket into the Redis script cache.
+func (r *RedisLeakyBucket) Preload(ctx context.Context) error {
+	return loadScripts(ctx, r.client, leakyBucketScript, deleteScript)
+}
@@ -0,0 +1,174 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisMultiBucket is a RedisTokenBucket that can also check several keys in one atomic
+// round trip, consuming from all of them only if all allow the request. AllowAll (and
+// therefore the middleware's KeysFunc) uses it automatically, so no dimension leaks
+// budget when another one denies.
+//
+// Redis Cluster only runs a script over keys in the same hash slot, so keys checked
+// together must share a hash tag, e.g. "{user:42}:ip" and "{user:42}:endpoint".
+type RedisMultiBucket struct {
+	*RedisTokenBucket
+}
+
+// NewRedisMultiBucket creates a new instance of RedisMultiBucket.
+// It accepts the same options as NewRedisTokenBucket.
+func NewRedisMultiBucket(client redis.Scripter, opts ...Option) *RedisMultiBucket {
+	return &RedisMultiBucket{
+		RedisTokenBucket: NewRedisTokenBucket(client, opts...),
+	}
+}
+
+// Lua script for checking several token buckets at once
+// Keys: bucket keys
+// Args: [1] now (unixtime float), [2] 1 to record the time of a denial in last_denied of the denying bucket,
+// then for each key: rate (tokens/sec), capacity, requested (tokens), ttl (ms, 0 to never expire),
+// initial tokens of a new bucket (negative for a full one)
+// Returns: {allowed, index (0-based) of the deciding key, remaining, reset_after (sec)}, fractional values as strings
+var multiBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local track_denials = tonumber(ARGV[2])
+local filled = {}
+
+for i = 1, #KEYS do
+    local base = 3 + (i - 1) * 5
+    local rate = tonumber(ARGV[base])
+    local capacity = tonumber(ARGV[base + 1])
+    local requested = tonumber(ARGV[base + 2])
+
+    local last_tokens = tonumber(redis.call("HGET", KEYS[i], "tokens"))
+    local last_updated = tonumber(redis.call("HGET", KEYS[i], "last_updated"))
+    if last_tokens == nil then
+        last_tokens = capacity
+        local initial = tonumber(ARGV[base + 4])
+        if initial >= 0 then
+            last_tokens = math.min(capacity, initial)
+        end
+        last_updated = now
+    end
+
+    local delta = math.max(0, now - last_updated)
+    filled[i] = math.min(capacity, last_tokens + (delta * rate))
+
+    if filled[i] < requested then
+        if track_denials == 1 then
+            -- Storing the refilled tokens as of now leaves the bucket unchanged
+            redis.call("HSET", KEYS[i], "tokens", filled[i], "last_updated", now, "last_denied", now)
+            local ttl = tonumber(ARGV[base + 3])
+            if ttl > 0 then
+                redis.call("PEXPIRE", KEYS[i], ttl)
+            end
+        end
+        return {0, i - 1, tostring(filled[i]), tostring((requested - filled[i]) / rate)}
+    end
+end
+
+local decided = 1
+local min_remaining = nil
+local reset_after = 0
+for i = 1, #KEYS do
+    local base = 3 + (i - 1) * 5
+    local requested = tonumber(ARGV[base + 2])
+    local ttl = tonumber(ARGV[base + 3])
+
+    local remaining = filled[i] - requested
+    redis.call("HSET", KEYS[i], "tokens", remaining, "last_updated", now)
+    if ttl > 0 then
+        redis.call("PEXPIRE", KEYS[i], ttl)
+    end
+
+    if min_remaining == nil or remaining < min_remaining then
+        min_remaining = remaining
+        decided = i
+        -- Time until the deciding bucket is full again
+        reset_after = (tonumber(ARGV[base + 1]) - remaining) / tonumber(ARGV[base])
+    end
+end
+
+return {1, decided - 1, tostring(min_remaining), tostring(reset_after)}
+`)
+
+// MultiBucketScriptHash returns the SHA1 of the Lua script run by RedisMultiBucket.AllowAll.
+func MultiBucketScriptHash() string {
+	return multiBucketScript.Hash()
+}
+
+// Preload loads the scripts of RedisMultiBucket into the Redis script cache.
+func (r *RedisMultiBucket) Preload(ctx context.Context) error {
+	if err := r.RedisTokenBucket.Preload(ctx); err != nil {
+		return err
+	}
+	return loadScripts(ctx, r.client, multiBucketScript)
+}
+
+// AllowAll atomically checks every key, consuming one token from each only if all of them allow it.
+// The returned index identifies the deciding key like the package-level AllowAll.
+// Unlimited keys are always allowed and never sent to Redis. With WithDenialTracking, a
+// denial is recorded on the key that denied the request only.
+func (r *RedisMultiBucket) AllowAll(ctx context.Context, reqs []KeyLimit) (*Result, int, error) {
+	keys := make([]string, 0, len(reqs))
+	indexes := make([]int, 0, len(reqs))
+	trackDenials := 0
+	if r.trackDenials {
+		trackDenials = 1
+	}
+	args := []interface{}{float64(time.Now().UnixMicro()) / 1e6, trackDenials}
+
+	for i, req := range reqs {
+		if req.Limit.IsUnlimited() {
+			continue
+		}
+		if exceedsBurst(1, req.Limit) {
+			// Nothing was consumed yet, so the request can be denied without calling Redis
+			return burstExceededResult("redis"), i, nil
+		}
+
+		ratePerSec := float64(req.Limit.Rate) / req.Limit.Period.Seconds()
+		var ttlMs int64
+		if !r.noAutoExpire {
+			ttlMs = r.ttl(req.Limit, ratePerSec).Milliseconds()
+		}
+
+		keys = append(keys, r.keyPrefix+req.Key)
+		indexes = append(indexes, i)
+		args = append(args, ratePerSec, req.Limit.Burst, 1, ttlMs, r.initialTokens)
+	}
+
+	if len(keys) == 0 {
+		if len(reqs) == 0 {
+			return &Result{Allowed: true}, -1, nil
+		}
+		return unlimitedResult("redis"), 0, nil
+	}
+
+	res, err := multiBucketScript.Run(ctx, r.client, keys, args...).Result()
+	if err != nil {
+		return nil, -1, err
+	}
+
+	vals, err := replyValues(res, 4)
+	if err != nil {
+		return nil, -1, err
+	}
+	idx, err := replyInt(vals[1])
+	if err != nil {
+		return nil, -1, err
+	}
+	if idx < 0 || idx >= int64(len(indexes)) {
+		return nil, -1, fmt.Errorf("%w: key index %d out of range", ErrUnexpectedReply, idx)
+	}
+
+	result, err := parseBucketReply([]interface{}{vals[0], vals[2], vals[3]}, "redis")
+	if err != nil {
+		return nil, -1, err
+	}
+	return result, indexes[idx], nil
+}
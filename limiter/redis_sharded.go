@@ -0,0 +1,77 @@
+package limiter
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisShardedTokenBucket implements the Strategy interface by spreading token buckets over
+// several independent Redis instances, for scaling past one instance without cluster mode.
+// Each key is hashed to one shard, where its bucket lives and the script runs, so every key
+// is still limited exactly. Limits spanning several keys (e.g. a global limit over all keys)
+// can't be enforced across shards.
+//
+// The key-to-shard mapping depends on the number and order of clients, so every instance
+// must be given the same list. Changing it moves most keys to another shard, where they
+// start over with a full bucket.
+type RedisShardedTokenBucket struct {
+	shards []*RedisTokenBucket
+}
+
+// NewRedisShardedTokenBucket creates a new RedisShardedTokenBucket over clients, applying
+// opts to the bucket of every shard.
+//
+// It panics if clients is empty, as that is a programming error.
+func NewRedisShardedTokenBucket(clients []*redis.Client, opts ...Option) *RedisShardedTokenBucket {
+	if len(clients) == 0 {
+		panic("limiter: NewRedisShardedTokenBucket needs at least one client")
+	}
+	shards := make([]*RedisTokenBucket, len(clients))
+	for i, client := range clients {
+		shards[i] = NewRedisTokenBucket(client, opts...)
+	}
+	return &RedisShardedTokenBucket{
+		shards: shards,
+	}
+}
+
+// Shard returns the index of the client that holds the bucket for key.
+func (s *RedisShardedTokenBucket) Shard(key string) int {
+	return int(hashKey(key) % uint32(len(s.shards)))
+}
+
+// Allow checks the request against the bucket for key on its shard.
+func (s *RedisShardedTokenBucket) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return s.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN checks a request consuming n tokens against the bucket for key on its shard.
+func (s *RedisShardedTokenBucket) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	return s.shards[s.Shard(key)].AllowN(ctx, key, limit, n)
+}
+
+// Reset deletes the bucket for key from its shard, restoring its full budget.
+func (s *RedisShardedTokenBucket) Reset(ctx context.Context, key string) (bool, error) {
+	return s.shards[s.Shard(key)].Reset(ctx, key)
+}
+
+// Flush deletes the token buckets of every shard, see RedisTokenBucket.Flush.
+func (s *RedisShardedTokenBucket) Flush(ctx context.Context) error {
+	for _, shard := range s.shards {
+		if err := shard.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Preload loads the scripts into the script cache of every shard.
+func (s *RedisShardedTokenBucket) Preload(ctx context.Context) error {
+	for _, shard := range s.shards {
+		if err := shard.Preload(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
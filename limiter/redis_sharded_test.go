@@ -0,0 +1,70 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisShardedTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	var (
+		servers []*miniredis.Miniredis
+		clients []*redis.Client
+	)
+	for i := 0; i < 3; i++ {
+		mr, client := newTestRedis(t)
+		servers = append(servers, mr)
+		clients = append(clients, client)
+	}
+	s := NewRedisShardedTokenBucket(clients)
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+
+	used := make(map[int]bool)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		shard := s.Shard(key)
+		if again := NewRedisShardedTokenBucket(clients).Shard(key); again != shard {
+			t.Fatalf("%s: shard %d, then %d", key, shard, again)
+		}
+		used[shard] = true
+
+		if !must(s.Allow(ctx, key, limit)).Allowed {
+			t.Fatalf("%s: first request denied", key)
+		}
+		// The bucket lives on its shard only
+		for j, mr := range servers {
+			if mr.Exists(key) != (j == shard) {
+				t.Fatalf("%s: bucket on shard %d is %t, want it only on shard %d", key, j, mr.Exists(key), shard)
+			}
+		}
+		if must(s.Allow(ctx, key, limit)).Allowed {
+			t.Fatalf("%s: second request allowed", key)
+		}
+	}
+	if len(used) != len(clients) {
+		t.Errorf("keys spread over %d shards, want all %d", len(used), len(clients))
+	}
+
+	// Flushing one shard resets only the keys it holds
+	servers[0].FlushAll()
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if got, want := must(s.Allow(ctx, key, limit)).Allowed, s.Shard(key) == 0; got != want {
+			t.Errorf("%s on shard %d: allowed %t after flushing shard 0, want %t", key, s.Shard(key), got, want)
+		}
+	}
+}
+
+func TestRedisShardedTokenBucketNeedsClients(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic without clients")
+		}
+	}()
+	NewRedisShardedTokenBucket(nil)
+}
@@ -0,0 +1,52 @@
+package limiter
+
+import (
+	"github.com/redis/go-redis/v9"
+)
+
+// PoolStats reports the health of the connection pool behind a Redis-backed strategy.
+// Alert on rising Timeouts or TotalConns stuck at the pool size: an exhausted pool
+// makes Allow fail, which the middleware surfaces as errors.
+type PoolStats struct {
+	Hits       uint32 // Times a free connection was found in the pool
+	Misses     uint32 // Times a free connection was NOT found in the pool
+	Timeouts   uint32 // Times waiting for a connection timed out
+	TotalConns uint32 // Connections in the pool
+	IdleConns  uint32 // Idle connections in the pool
+	StaleConns uint32 // Stale connections removed from the pool
+}
+
+// poolStatser is implemented by *redis.Client, *redis.ClusterClient and *redis.Ring.
+type poolStatser interface {
+	PoolStats() *redis.PoolStats
+}
+
+// poolStats returns the pool stats of client, or false if it doesn't expose any.
+func poolStats(client redis.Scripter) (PoolStats, bool) {
+	ps, ok := client.(poolStatser)
+	if !ok {
+		return PoolStats{}, false
+	}
+
+	s := ps.PoolStats()
+	return PoolStats{
+		Hits:       s.Hits,
+		Misses:     s.Misses,
+		Timeouts:   s.Timeouts,
+		TotalConns: s.TotalConns,
+		IdleConns:  s.IdleConns,
+		StaleConns: s.StaleConns,
+	}, true
+}
+
+// PoolStats returns the connection pool stats of the underlying client.
+// It returns false if the client doesn't expose pool stats (e.g. a test fake).
+func (r *RedisTokenBucket) PoolStats() (PoolStats, bool) {
+	return poolStats(r.client)
+}
+
+// PoolStats returns the connection pool stats of the underlying client.
+// It returns false if the client doesn't expose pool stats (e.g. a test fake).
+func (r *RedisLeakyBucket) PoolStats() (PoolStats, bool) {
+	return poolStats(r.client)
+}
@@ -0,0 +1,647 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis starts an in-process Redis for the test and returns a client for it.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestRedisTokenBucketKeyTTL(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client)
+
+	// A slow limit must outlive its period, or the key resets mid-period
+	limit := Limit{Rate: 100, Period: 10 * time.Minute, Burst: 100}
+	if _, err := r.Allow(ctx, "slow", limit); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("slow"); ttl != 20*time.Minute {
+		t.Fatalf("TTL = %s, want twice the period", ttl)
+	}
+
+	mr.FastForward(15 * time.Minute)
+	if !mr.Exists("slow") {
+		t.Fatal("bucket expired within its window")
+	}
+	mr.FastForward(10 * time.Minute)
+	if mr.Exists("slow") {
+		t.Fatal("idle bucket never expired")
+	}
+}
+
+func TestRedisTokenBucketKeyTTLOptions(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 1}
+
+	if _, err := NewRedisTokenBucket(client, WithKeyTTL(time.Hour)).Allow(ctx, "fixed", limit); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("fixed"); ttl != time.Hour {
+		t.Errorf("TTL with WithKeyTTL = %s, want 1h", ttl)
+	}
+
+	if _, err := NewRedisTokenBucket(client, WithoutAutoExpire()).Allow(ctx, "forever", limit); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("forever"); ttl != 0 {
+		t.Errorf("TTL with WithoutAutoExpire = %s, want none", ttl)
+	}
+}
+
+func TestRedisStrategiesSkipScriptsWhenUnlimited(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+
+	for name, s := range map[string]Strategy{
+		"token_bucket": NewRedisTokenBucket(client),
+		"leaky_bucket": NewRedisLeakyBucket(client),
+	} {
+		res, err := s.Allow(ctx, "k", Unlimited)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !res.Allowed {
+			t.Fatalf("%s: unlimited request denied", name)
+		}
+	}
+	if n := mr.CommandCount(); n != 0 {
+		t.Errorf("%d Redis commands sent for unlimited requests, want none", n)
+	}
+}
+
+func TestRedisTokenBucketAllowN(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client)
+	// One token every 100ms
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 5}
+
+	if _, err := r.AllowN(ctx, "k", limit, 6); !errors.Is(err, ErrExceedsBurst) {
+		t.Fatalf("AllowN(6) error = %v, want ErrExceedsBurst", err)
+	}
+
+	res, err := r.AllowN(ctx, "k", limit, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("AllowN(3) = %+v, want allowed with 2 remaining", res)
+	}
+
+	res, err = r.AllowN(ctx, "k", limit, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.Remaining != 2 {
+		t.Fatalf("AllowN(4) = %+v, want denied with 2 remaining", res)
+	}
+	// Two tokens short, at 100ms each
+	if res.ResetAfter < 150*time.Millisecond || res.ResetAfter > 200*time.Millisecond {
+		t.Errorf("AllowN(4) ResetAfter = %s, want about 200ms", res.ResetAfter)
+	}
+
+	// The denial consumed nothing
+	res, err = r.AllowN(ctx, "k", limit, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("AllowN(2) = %+v, want allowed with 0 remaining", res)
+	}
+}
+
+func TestRedisTokenBucketSeed(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client)
+	limit := Limit{Rate: 1, Period: time.Hour, Burst: 5}
+
+	if err := r.Seed(ctx, "low", 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := mr.HGet("low", "tokens"); got != "1" {
+		t.Fatalf("seeded tokens = %q, want 1", got)
+	}
+	if n := exhaust(t, r, "low", limit); n != 1 {
+		t.Errorf("seeded with 1 token, admitted %d", n)
+	}
+
+	// Seeding above the burst is accepted and capped on the next refill
+	if err := r.Seed(ctx, "high", 50, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := exhaust(t, r, "high", limit); n != 5 {
+		t.Errorf("seeded with 50 tokens, admitted %d, want the burst of 5", n)
+	}
+}
+
+func TestRedisLeakyBucketMatchesInMemory(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	redisBucket := NewRedisLeakyBucket(client)
+	memBucket := NewLeakyBucket(WithClock(newFakeClock()))
+	// Leaks too slowly for the test to notice
+	limit := Limit{Rate: 4, Period: time.Hour, Burst: 4}
+
+	for i, n := range []int{1, 2, 2, 1, 1, 3} {
+		want, err := memBucket.AllowN(ctx, "k", limit, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := redisBucket.AllowN(ctx, "k", limit, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Allowed != want.Allowed || got.Remaining != want.Remaining {
+			t.Fatalf("request %d of %d: redis allowed %v with %d remaining, in-memory %v with %d",
+				i, n, got.Allowed, got.Remaining, want.Allowed, want.Remaining)
+		}
+	}
+	if ttl := mr.TTL("k"); ttl <= 0 {
+		t.Errorf("leaky bucket key has no TTL")
+	}
+}
+
+func TestRedisTokenBucketWithoutAutoExpireSurvivesIdle(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client, WithoutAutoExpire())
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 1}
+
+	if _, err := r.Allow(ctx, "k", limit); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(time.Hour)
+	if !mr.Exists("k") {
+		t.Fatal("bucket expired with WithoutAutoExpire")
+	}
+
+	// The same limit expires the key by default
+	if _, err := NewRedisTokenBucket(client).Allow(ctx, "default", limit); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(time.Minute)
+	if mr.Exists("default") {
+		t.Fatal("idle bucket kept without WithoutAutoExpire")
+	}
+}
+
+func TestRedisPoolStats(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client)
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.Allow(ctx, "k", limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, ok := r.PoolStats()
+	if !ok {
+		t.Fatal("no pool stats from a *redis.Client")
+	}
+	if stats.TotalConns != 1 || stats.IdleConns != 1 || stats.Hits+stats.Misses < 3 {
+		t.Errorf("stats %+v, want one idle connection used for every call", stats)
+	}
+	if _, ok := NewRedisLeakyBucket(client).PoolStats(); !ok {
+		t.Error("no pool stats from the leaky bucket")
+	}
+
+	// Clients without a pool, like test fakes, report none
+	if _, ok := NewRedisTokenBucket(struct{ redis.Scripter }{client}).PoolStats(); ok {
+		t.Error("pool stats reported for a client without them")
+	}
+}
+
+func TestRedisTokenBucketZeroBurst(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisMultiBucket(client)
+	limit := Limit{Rate: 10, Period: time.Second}
+	roomy := Limit{Rate: 10, Period: time.Second, Burst: 10}
+
+	if _, err := r.Allow(ctx, "k", limit); !errors.Is(err, ErrExceedsBurst) {
+		t.Errorf("Allow() error = %v, want ErrExceedsBurst", err)
+	}
+
+	// The calls reporting per key deny with no time to wait, and never reach Redis
+	results, err := r.AllowMulti(ctx, []KeyLimit{{Key: "roomy", Limit: roomy}, {Key: "k", Limit: limit}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Allowed {
+		t.Errorf("AllowMulti = %+v for the key with a burst, want allowed", results[0])
+	}
+	all, i, err := r.AllowAll(ctx, []KeyLimit{{Key: "other", Limit: roomy}, {Key: "k", Limit: limit}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1 {
+		t.Errorf("AllowAll decided by %d, want the zero-burst key", i)
+	}
+	peek := must(r.Peek(ctx, "k", limit))
+	for name, res := range map[string]*Result{"AllowMulti": results[1], "AllowAll": all, "Peek": peek} {
+		if res.Allowed || res.Reason != ReasonBurstExceeded || res.ResetAfter != 0 {
+			t.Errorf("%s = %+v, want denied as burst exceeded without a ResetAfter", name, res)
+		}
+	}
+	if mr.Exists("k") || mr.Exists("other") {
+		t.Error("denials for the zero-burst key touched Redis")
+	}
+}
+
+func TestRedisZeroRate(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisMultiBucket(client)
+	limit := Limit{Rate: 0, Period: time.Second, Burst: 5}
+
+	// Nothing is ever admitted, so no script dividing by the rate runs
+	if _, err := r.Allow(ctx, "k", limit); !errors.Is(err, ErrExceedsBurst) {
+		t.Errorf("Allow() error = %v, want ErrExceedsBurst", err)
+	}
+	if _, err := NewRedisLeakyBucket(client).Allow(ctx, "k", limit); !errors.Is(err, ErrExceedsBurst) {
+		t.Errorf("RedisLeakyBucket.Allow() error = %v, want ErrExceedsBurst", err)
+	}
+	all, _, err := r.AllowAll(ctx, []KeyLimit{{Key: "k", Limit: limit}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peek := must(r.Peek(ctx, "k", limit))
+	for name, res := range map[string]*Result{"AllowAll": all, "Peek": peek} {
+		if res.Allowed || res.Reason != ReasonBurstExceeded || res.ResetAfter != 0 {
+			t.Errorf("%s = %+v, want denied as burst exceeded without a ResetAfter", name, res)
+		}
+	}
+	q, err := r.Quota(ctx, "k", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != 0 || q.Remaining != 0 {
+		t.Errorf("Quota = %+v, want nothing to admit", q)
+	}
+	if mr.Exists("k") {
+		t.Error("requests under a zero rate touched Redis")
+	}
+}
+
+func TestRedisMultiBucketPartialDenyConsumesNothing(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisMultiBucket(client)
+	roomy := Limit{Rate: 10, Period: time.Hour, Burst: 10}
+	tight := Limit{Rate: 1, Period: time.Hour, Burst: 1}
+	reqs := []KeyLimit{{Key: "user", Limit: roomy}, {Key: "org", Limit: tight}}
+
+	res, i, err := r.AllowAll(ctx, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || i != 1 {
+		t.Fatalf("first AllowAll = %+v at %d, want allowed, decided by org", res, i)
+	}
+	user := mr.HGet("user", "tokens")
+	for n := 0; n < 3; n++ {
+		res, i, err = r.AllowAll(ctx, reqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed || i != 1 {
+			t.Fatalf("AllowAll = %+v at %d, want denied by org", res, i)
+		}
+	}
+	if got := mr.HGet("user", "tokens"); got != user {
+		t.Errorf("user tokens = %s after denials, want %s", got, user)
+	}
+
+	// The passing key still has all of its remaining budget
+	for n := 0; n < 9; n++ {
+		res, _, err := r.AllowAll(ctx, reqs[:1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Fatalf("request %d for user denied, want its 9 remaining tokens", n)
+		}
+	}
+}
+
+func TestRedisMultiBucketTracksDenials(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisMultiBucket(client, WithDenialTracking())
+	roomy := Limit{Rate: 10, Period: time.Hour, Burst: 10}
+	tight := Limit{Rate: 1, Period: time.Hour, Burst: 1}
+	reqs := []KeyLimit{{Key: "user", Limit: roomy}, {Key: "org", Limit: tight}}
+
+	start := time.Now()
+	for n := 0; n < 2; n++ {
+		if _, _, err := r.AllowAll(ctx, reqs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := r.Quota(ctx, "org", tight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.LastDenied.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("org LastDenied = %s, want the time of the denial", q.LastDenied)
+	}
+	if mr.TTL("org") <= 0 {
+		t.Error("denial left org without a TTL")
+	}
+
+	q, err = r.Quota(ctx, "user", roomy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.LastDenied.IsZero() {
+		t.Errorf("user LastDenied = %s, want zero for a key that passed", q.LastDenied)
+	}
+}
+
+func TestRedisTokenBucketAllowedResetAfter(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client)
+	// One token every 100ms
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 5}
+
+	res, err := r.AllowN(ctx, "k", limit, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed {
+		t.Fatalf("AllowN(2) = %+v, want allowed", res)
+	}
+	// Two tokens short of full
+	if res.ResetAfter < 150*time.Millisecond || res.ResetAfter > 200*time.Millisecond {
+		t.Errorf("allowed ResetAfter = %s, want about 200ms to refill", res.ResetAfter)
+	}
+
+	res, _, err = NewRedisMultiBucket(client).AllowAll(ctx, []KeyLimit{{Key: "multi", Limit: limit}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.ResetAfter <= 0 {
+		t.Errorf("multi-key AllowAll = %+v, want allowed with a non-zero ResetAfter", res)
+	}
+}
+
+func TestParseBucketReplyRejectsMalformedReplies(t *testing.T) {
+	res, err := parseBucketReply([]interface{}{int64(1), "4", "0.5"}, "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Remaining != 4 || res.ResetAfter != 500*time.Millisecond {
+		t.Fatalf("well-formed reply parsed as %+v", res)
+	}
+
+	for name, reply := range map[string]interface{}{
+		"not an array":            "OK",
+		"nil":                     nil,
+		"too short":               []interface{}{int64(1), "4"},
+		"too long":                []interface{}{int64(1), "4", "0", "extra"},
+		"allowed as string":       []interface{}{"1", "4", "0"},
+		"allowed flag out of set": []interface{}{int64(2), "4", "0"},
+		"remaining not a number":  []interface{}{int64(1), "four", "0"},
+		"reset after wrong type":  []interface{}{int64(0), "0", []interface{}{}},
+	} {
+		res, err := parseBucketReply(reply, "redis")
+		if !errors.Is(err, ErrUnexpectedReply) {
+			t.Errorf("%s: error = %v, want ErrUnexpectedReply", name, err)
+		}
+		if res != nil {
+			t.Errorf("%s: got result %+v alongside the error", name, res)
+		}
+	}
+}
+
+func TestRedisPreloadLoadsScripts(t *testing.T) {
+	ctx := context.Background()
+	_, shard1 := newTestRedis(t)
+	_, shard2 := newTestRedis(t)
+	bucketScripts := []string{TokenBucketScriptHash(), SeedScriptHash(), PeekScriptHash(), DeleteScriptHash()}
+
+	for _, tc := range []struct {
+		name         string
+		newPreloader func(client *redis.Client) Preloader
+		scripts      []string
+	}{
+		{"token_bucket", func(c *redis.Client) Preloader { return NewRedisTokenBucket(c) }, bucketScripts},
+		{"leaky_bucket", func(c *redis.Client) Preloader { return NewRedisLeakyBucket(c) },
+			[]string{LeakyBucketScriptHash(), DeleteScriptHash()}},
+		{"multi_bucket", func(c *redis.Client) Preloader { return NewRedisMultiBucket(c) },
+			append([]string{MultiBucketScriptHash()}, bucketScripts...)},
+		{"concurrency", func(c *redis.Client) Preloader { return NewRedisConcurrencyLimiter(c, time.Minute) },
+			[]string{AcquireLeaseScriptHash(), ReleaseLeaseScriptHash(), ReapLeasesScriptHash()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, client := newTestRedis(t)
+			if exists := client.ScriptExists(ctx, tc.scripts...).Val(); slices.Contains(exists, true) {
+				t.Fatalf("scripts cached before Preload: %v", exists)
+			}
+			if err := tc.newPreloader(client).Preload(ctx); err != nil {
+				t.Fatal(err)
+			}
+			exists, err := client.ScriptExists(ctx, tc.scripts...).Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slices.Contains(exists, false) {
+				t.Errorf("scripts cached after Preload: %v, want all", exists)
+			}
+		})
+	}
+
+	// The sharded bucket preloads every shard
+	if err := NewRedisShardedTokenBucket([]*redis.Client{shard1, shard2}).Preload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, client := range []*redis.Client{shard1, shard2} {
+		if exists := client.ScriptExists(ctx, bucketScripts...).Val(); slices.Contains(exists, false) {
+			t.Errorf("shard %d: scripts cached after Preload: %v, want all", i, exists)
+		}
+	}
+}
+
+func TestRedisTokenBucketInitialTokensWarmup(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	// Fast enough to watch the bucket fill, with a burst well above the initial tokens
+	limit := Limit{Rate: 20, Period: time.Second, Burst: 10}
+
+	for name, s := range map[string]StrategyN{
+		"token_bucket": NewRedisTokenBucket(client, WithInitialTokens(2)),
+		"multi_bucket": NewRedisMultiBucket(client, WithInitialTokens(2)),
+	} {
+		key := name + ":k"
+		if n := exhaust(t, s, key, limit); n != 2 {
+			t.Fatalf("%s: new key admitted %d, want the 2 initial tokens", name, n)
+		}
+		// 600ms refill 12 tokens, capped at the burst
+		time.Sleep(600 * time.Millisecond)
+		if n := exhaust(t, s, key, limit); n != limit.Burst {
+			t.Errorf("%s: after refilling admitted %d, want the burst of %d", name, n, limit.Burst)
+		}
+	}
+
+	// AllowAll creates every key with the initial tokens too
+	m := NewRedisMultiBucket(client, WithInitialTokens(1))
+	reqs := []KeyLimit{{Key: "all:a", Limit: limit}, {Key: "all:b", Limit: limit}}
+	if res, _, err := m.AllowAll(ctx, reqs); err != nil || !res.Allowed {
+		t.Fatalf("first AllowAll = %+v, %v, want allowed", res, err)
+	}
+	if res, _, err := m.AllowAll(ctx, reqs); err != nil || res.Allowed {
+		t.Errorf("second AllowAll = %+v, %v, want denied with the initial token spent", res, err)
+	}
+}
+
+func TestRedisKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client, WithKeyPrefix("rl:"))
+	limit := Limit{Rate: 2, Period: time.Hour, Burst: 2}
+
+	exhaust(t, r, "hot", limit)
+	if !mr.Exists("rl:hot") || mr.Exists("hot") {
+		t.Fatalf("keys = %v, want the bucket stored under the prefix", mr.Keys())
+	}
+	if keys, err := r.ActiveKeys(ctx, limit); err != nil || !slices.Equal(keys, []string{"hot"}) {
+		t.Errorf("ActiveKeys = %v, %v, want [hot] without the prefix", keys, err)
+	}
+	if q, err := r.Quota(ctx, "hot", limit); err != nil || q.Remaining != 0 {
+		t.Errorf("Quota = %+v, %v, want the prefixed bucket's 0 remaining", q, err)
+	}
+	if ok, err := r.Reset(ctx, "hot"); err != nil || !ok {
+		t.Errorf("Reset = %v, %v, want the prefixed bucket deleted", ok, err)
+	}
+}
+
+func TestRedisFlush(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	// Glob characters in the prefix are matched literally
+	tb := NewRedisTokenBucket(client, WithKeyPrefix("tb[1]:"))
+	lb := NewRedisLeakyBucket(client, WithKeyPrefix("lb:"))
+	limit := Limit{Rate: 2, Period: time.Hour, Burst: 2}
+
+	for _, key := range []string{"a", "b"} {
+		exhaust(t, tb, key, limit)
+		exhaust(t, lb, key, limit)
+	}
+	// Buckets of other limiters, and data that merely looks like a bucket
+	other := NewRedisTokenBucket(client, WithKeyPrefix("tb1:"))
+	exhaust(t, other, "a", limit)
+	mr.HSet("unprefixed", "tokens", "0", "last_updated", "0")
+
+	if err := tb.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := lb.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err := tb.ActiveKeys(ctx, limit); err != nil || len(keys) != 0 {
+		t.Errorf("ActiveKeys after Flush = %v, %v, want none", keys, err)
+	}
+	for _, s := range []Strategy{tb, lb} {
+		for _, key := range []string{"a", "b"} {
+			if res, err := s.Allow(ctx, key, limit); err != nil || !res.Allowed {
+				t.Errorf("%s after Flush = %+v, %v, want allowed", key, res, err)
+			}
+		}
+	}
+	if !mr.Exists("tb1:a") || !mr.Exists("unprefixed") {
+		t.Errorf("keys after Flush = %v, want the keys outside the prefixes kept", mr.Keys())
+	}
+}
+
+func TestRedisFlushNeedsKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	tb := NewRedisTokenBucket(client)
+	lb := NewRedisLeakyBucket(client)
+	limit := Limit{Rate: 2, Period: time.Hour, Burst: 2}
+	exhaust(t, tb, "a", limit)
+
+	if err := tb.Flush(ctx); !errors.Is(err, ErrNoKeyPrefix) {
+		t.Errorf("token bucket Flush = %v, want ErrNoKeyPrefix", err)
+	}
+	if err := lb.Flush(ctx); !errors.Is(err, ErrNoKeyPrefix) {
+		t.Errorf("leaky bucket Flush = %v, want ErrNoKeyPrefix", err)
+	}
+	if !mr.Exists("a") {
+		t.Error("Flush without a prefix deleted a bucket")
+	}
+}
+
+func TestRedisTokenBucketTracksDenials(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	r := NewRedisTokenBucket(client, WithDenialTracking())
+	limit := Limit{Rate: 1, Period: time.Hour, Burst: 1}
+	lastDenied := func() time.Time {
+		q, err := r.Quota(ctx, "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q.LastDenied
+	}
+
+	must(r.Allow(ctx, "k", limit))
+	if mr.HGet("k", "last_denied") != "" {
+		t.Error("allowed request stored last_denied")
+	}
+	if got := lastDenied(); !got.IsZero() {
+		t.Errorf("LastDenied after an allowed request = %s, want zero", got)
+	}
+
+	before := time.Now().Truncate(time.Microsecond)
+	if res := must(r.Allow(ctx, "k", limit)); res.Allowed {
+		t.Fatal("second request allowed")
+	}
+	after := time.Now()
+	first := lastDenied()
+	if first.Before(before) || first.After(after) {
+		t.Errorf("LastDenied = %s, want the denial between %s and %s", first, before, after)
+	}
+
+	// Peeking leaves it alone, and a new denial moves it
+	time.Sleep(2 * time.Millisecond)
+	if got := lastDenied(); !got.Equal(first) {
+		t.Errorf("LastDenied after a Quota = %s, want still %s", got, first)
+	}
+	must(r.Allow(ctx, "k", limit))
+	if second := lastDenied(); !second.After(first) {
+		t.Errorf("LastDenied after a second denial = %s, want after %s", second, first)
+	}
+
+	// Off by default: nothing is written on denial
+	plain := NewRedisTokenBucket(client)
+	exhaust(t, plain, "plain", limit)
+	if mr.HGet("plain", "last_denied") != "" {
+		t.Error("denial stored last_denied without WithDenialTracking")
+	}
+	if q, err := plain.Quota(ctx, "plain", limit); err != nil || !q.LastDenied.IsZero() {
+		t.Errorf("Quota without WithDenialTracking = %+v, %v, want no LastDenied", q, err)
+	}
+}
@@ -0,0 +1,51 @@
+package limiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RoundingMode controls how strategies round Result.ResetAfter.
+type RoundingMode int32
+
+const (
+	// RoundExact keeps the exact computed duration. This is the default.
+	RoundExact RoundingMode = iota
+	// RoundCeilSecond rounds up to the next whole second, matching the
+	// granularity of the Retry-After header.
+	RoundCeilSecond
+	// RoundCeilMillisecond rounds up to the next whole millisecond.
+	RoundCeilMillisecond
+)
+
+var resetAfterRounding atomic.Int32
+
+// SetResetAfterRounding sets the rounding mode every strategy applies to Result.ResetAfter,
+// so retry hints are consistent regardless of the backing strategy.
+func SetResetAfterRounding(mode RoundingMode) {
+	resetAfterRounding.Store(int32(mode))
+}
+
+// computeResetAfter converts a raw wait into the ResetAfter reported to callers.
+// Negative waits are reported as zero.
+func computeResetAfter(wait time.Duration) time.Duration {
+	if wait <= 0 {
+		return 0
+	}
+
+	switch RoundingMode(resetAfterRounding.Load()) {
+	case RoundCeilSecond:
+		return ceilDuration(wait, time.Second)
+	case RoundCeilMillisecond:
+		return ceilDuration(wait, time.Millisecond)
+	default:
+		return wait
+	}
+}
+
+func ceilDuration(d, unit time.Duration) time.Duration {
+	if r := d % unit; r != 0 {
+		return d + unit - r
+	}
+	return d
+}
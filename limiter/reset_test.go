@@ -0,0 +1,61 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// setRounding sets the ResetAfter rounding mode for the rest of the test.
+func setRounding(t *testing.T, mode RoundingMode) {
+	t.Helper()
+	SetResetAfterRounding(mode)
+	t.Cleanup(func() { SetResetAfterRounding(RoundExact) })
+}
+
+func TestComputeResetAfter(t *testing.T) {
+	wait := 1500*time.Millisecond + 300*time.Microsecond
+	for _, tc := range []struct {
+		mode RoundingMode
+		want time.Duration
+	}{
+		{RoundExact, wait},
+		{RoundCeilSecond, 2 * time.Second},
+		{RoundCeilMillisecond, 1501 * time.Millisecond},
+	} {
+		setRounding(t, tc.mode)
+		if got := computeResetAfter(wait); got != tc.want {
+			t.Errorf("mode %d: %s, want %s", tc.mode, got, tc.want)
+		}
+		if got := computeResetAfter(-time.Second); got != 0 {
+			t.Errorf("mode %d: negative wait reported as %s, want 0", tc.mode, got)
+		}
+	}
+}
+
+func TestStrategiesRoundResetAfterAlike(t *testing.T) {
+	setRounding(t, RoundCeilSecond)
+	ctx := context.Background()
+	limit := Limit{Rate: 3, Period: 10 * time.Second, Burst: 3}
+
+	for _, name := range []string{"token_bucket", "sliding_window", "fixed_window", "leaky_bucket"} {
+		clock := newFakeClock()
+		s, err := NewStrategy(name, WithClock(clock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		exhaust(t, s, "k", limit)
+		clock.Advance(1234 * time.Millisecond)
+
+		res, err := s.Allow(ctx, "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed {
+			t.Fatalf("%s: allowed, want denied", name)
+		}
+		if res.ResetAfter <= 0 || res.ResetAfter%time.Second != 0 {
+			t.Errorf("%s: ResetAfter = %s, want a positive whole number of seconds", name, res.ResetAfter)
+		}
+	}
+}
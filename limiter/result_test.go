@@ -0,0 +1,53 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReleaseResultRemovesAllocation(t *testing.T) {
+	ctx := context.Background()
+	tb := NewTokenBucket()
+	limit := Limit{Rate: 1e9, Period: time.Second, Burst: 1e9}
+	// Create the bucket outside the measurement
+	ReleaseResult(must(tb.Allow(ctx, "k", limit)))
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		res, _ := tb.Allow(ctx, "k", limit)
+		ReleaseResult(res)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per released Allow, want 0", allocs)
+	}
+}
+
+func TestUnreleasedResultIsNotReused(t *testing.T) {
+	ctx := context.Background()
+	tb := NewTokenBucket()
+	limit := Limit{Rate: 1, Period: time.Minute, Burst: 2}
+
+	kept := must(tb.Allow(ctx, "k", limit))
+	want := *kept
+	for i := 0; i < 10; i++ {
+		ReleaseResult(must(tb.Allow(ctx, "k", limit)))
+	}
+	if *kept != want {
+		t.Fatalf("kept result changed to %+v, want %+v", *kept, want)
+	}
+}
+
+func TestReleaseResultNil(t *testing.T) {
+	ReleaseResult(nil)
+	if res := newResult("x"); res == nil || res.Source != "x" {
+		t.Fatalf("newResult after releasing nil = %+v", res)
+	}
+}
+
+// must returns res, failing the test run on err.
+func must(res *Result, err error) *Result {
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
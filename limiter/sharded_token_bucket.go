@@ -0,0 +1,114 @@
+package limiter
+
+import (
+	"context"
+	"math/bits"
+	"runtime"
+)
+
+// defaultMaxShards caps the shard count NewShardedTokenBucketAuto derives, see WithMaxShards.
+const defaultMaxShards = 256
+
+// ShardedTokenBucket implements the Strategy interface with several TokenBuckets, each behind
+// its own lock, to cut lock contention when many goroutines check different keys at once.
+// Each key is hashed to one shard, so every key is still limited exactly as by a single
+// TokenBucket. Options apply to every shard, so WithLRU caps the keys of each shard.
+type ShardedTokenBucket struct {
+	shards []*TokenBucket
+	mask   uint32
+}
+
+// WithMaxShards caps the number of shards NewShardedTokenBucketAuto picks. It is rounded
+// down to a power of two. Default: 256.
+func WithMaxShards(n int) Option {
+	return func(o *options) {
+		o.maxShards = n
+	}
+}
+
+// NewShardedTokenBucket creates a new ShardedTokenBucket with shards shards, rounded up to
+// a power of two so a key's shard is a mask of its hash. It accepts the options of
+// NewTokenBucket.
+func NewShardedTokenBucket(shards int, opts ...Option) *ShardedTokenBucket {
+	n := ceilPowerOfTwo(shards)
+	s := &ShardedTokenBucket{
+		shards: make([]*TokenBucket, n),
+		mask:   uint32(n - 1),
+	}
+	for i := range s.shards {
+		s.shards[i] = NewTokenBucket(opts...)
+	}
+	return s
+}
+
+// NewShardedTokenBucketAuto creates a new ShardedTokenBucket sized for the machine: the
+// smallest power of two of at least four shards per GOMAXPROCS, so goroutines running in
+// parallel rarely hash to the same shard, capped at WithMaxShards. Use NewShardedTokenBucket
+// to pick the count yourself. It accepts WithMaxShards and the options of NewTokenBucket.
+func NewShardedTokenBucketAuto(opts ...Option) *ShardedTokenBucket {
+	o := applyOptions(opts)
+	maxShards := defaultMaxShards
+	if o.maxShards > 0 {
+		// Round down, so the cap is never exceeded
+		maxShards = 1 << (bits.Len(uint(o.maxShards)) - 1)
+	}
+	return NewShardedTokenBucket(min(ceilPowerOfTwo(runtime.GOMAXPROCS(0)*4), maxShards), opts...)
+}
+
+// ceilPowerOfTwo returns the smallest power of two of at least n, and 1 for n < 1.
+func ceilPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// Shards returns the number of shards.
+func (s *ShardedTokenBucket) Shards() int {
+	return len(s.shards)
+}
+
+// shard returns the bucket holding key.
+func (s *ShardedTokenBucket) shard(key string) *TokenBucket {
+	return s.shards[hashKey(key)&s.mask]
+}
+
+// Allow checks the request against the bucket for key on its shard.
+func (s *ShardedTokenBucket) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return s.shard(key).Allow(ctx, key, limit)
+}
+
+// AllowN checks a request consuming n tokens against the bucket for key on its shard.
+func (s *ShardedTokenBucket) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	return s.shard(key).AllowN(ctx, key, limit, n)
+}
+
+// Peek reports whether a request for key would be allowed, without taking a token.
+func (s *ShardedTokenBucket) Peek(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return s.shard(key).Peek(ctx, key, limit)
+}
+
+// Quota reports how many tokens key holds and when its bucket will be full again.
+func (s *ShardedTokenBucket) Quota(ctx context.Context, key string, limit Limit) (*Quota, error) {
+	return s.shard(key).Quota(ctx, key, limit)
+}
+
+// Refund returns n tokens to the bucket for key, capped at the burst size.
+func (s *ShardedTokenBucket) Refund(ctx context.Context, key string, limit Limit, n int) error {
+	return s.shard(key).Refund(ctx, key, limit, n)
+}
+
+// Reset clears the state of key, restoring its full budget.
+func (s *ShardedTokenBucket) Reset(ctx context.Context, key string) (bool, error) {
+	return s.shard(key).Reset(ctx, key)
+}
+
+// Flush clears the state of every key on every shard.
+func (s *ShardedTokenBucket) Flush(ctx context.Context) error {
+	for _, shard := range s.shards {
+		if err := shard.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
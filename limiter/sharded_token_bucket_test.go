@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestShardedTokenBucketAutoShardCount(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	for _, tc := range []struct {
+		procs int
+		opts  []Option
+		want  int
+	}{
+		// At least four shards per processor, rounded up to a power of two
+		{1, nil, 4},
+		{3, nil, 16},
+		{8, nil, 32},
+		// Capped at 256 by default
+		{100, nil, 256},
+		{100, []Option{WithMaxShards(1024)}, 512},
+		// WithMaxShards rounds down, so the cap holds
+		{8, []Option{WithMaxShards(10)}, 8},
+		{8, []Option{WithMaxShards(1)}, 1},
+		{8, []Option{WithMaxShards(0)}, 32},
+	} {
+		runtime.GOMAXPROCS(tc.procs)
+		got := NewShardedTokenBucketAuto(tc.opts...).Shards()
+		if bits.OnesCount(uint(got)) != 1 {
+			t.Errorf("GOMAXPROCS %d: %d shards, not a power of two", tc.procs, got)
+		}
+		if got != tc.want {
+			t.Errorf("GOMAXPROCS %d with %d options: %d shards, want %d", tc.procs, len(tc.opts), got, tc.want)
+		}
+	}
+}
+
+func TestNewShardedTokenBucketRoundsUp(t *testing.T) {
+	for shards, want := range map[int]int{-1: 1, 0: 1, 1: 1, 3: 4, 8: 8, 9: 16} {
+		if got := NewShardedTokenBucket(shards).Shards(); got != want {
+			t.Errorf("NewShardedTokenBucket(%d) has %d shards, want %d", shards, got, want)
+		}
+	}
+}
+
+func TestShardedTokenBucketLimitsEachKeyExactly(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	s := NewShardedTokenBucket(8, WithClock(clock))
+	limit := Limit{Rate: 4, Period: time.Second, Burst: 4}
+
+	// Keys spread over the shards, each with a budget of its own
+	for i := 0; i < 64; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if n := exhaust(t, s, key, limit); n != limit.Burst {
+			t.Errorf("%s admitted %d, want %d", key, n, limit.Burst)
+		}
+	}
+
+	// The shard of a key keeps its state across calls
+	clock.Advance(250 * time.Millisecond)
+	if res := must(s.Allow(ctx, "key-0", limit)); !res.Allowed {
+		t.Error("key-0 denied after refilling a token")
+	}
+	if res := must(s.Allow(ctx, "key-0", limit)); res.Allowed {
+		t.Error("key-0 allowed a second token after refilling one")
+	}
+}
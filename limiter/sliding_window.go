@@ -9,9 +9,18 @@ import (
 
 // SlidingWindow implements the Strategy interface using the sliding window counter algorithm.
 // It approximates the request rate by combining the count of the current window and the previous window.
+// It allows up to Rate requests per sliding period and ignores Burst: unlike the token bucket,
+// it never lets a client overshoot the rate after an idle spell.
+//
+// By default the previous window is weighted linearly by how much of it still overlaps the
+// sliding window, which is exact when requests were spread evenly. WithDecay weights it
+// exponentially instead.
 type SlidingWindow struct {
-	mu      sync.Mutex
-	windows map[string]*windowState
+	mu       sync.Mutex
+	windows  map[string]*windowState
+	halfLife time.Duration
+	clock    Clock
+	janitor  janitor
 }
 
 type windowState struct {
@@ -20,19 +29,70 @@ type windowState struct {
 	prevCount       int
 }
 
+// WithDecay weights the previous window by 0.5^(t/halfLife), where t is the time elapsed in
+// the current window, instead of interpolating linearly. A burst that landed at the end of the
+// previous window then keeps counting almost fully early in the next one and fades smoothly,
+// rather than being assumed spread over the whole window. Prefer it for spiky traffic that
+// clusters around window boundaries; keep the linear default for steady traffic, where it is
+// more accurate. A halfLife of about a quarter of the period is a reasonable start.
+func WithDecay(halfLife time.Duration) Option {
+	return func(o *options) {
+		o.halfLife = halfLife
+	}
+}
+
 // NewSlidingWindow creates a new instance of SlidingWindow strategy.
-func NewSlidingWindow() *SlidingWindow {
+// It accepts WithDecay, WithCleanup and WithClock.
+func NewSlidingWindow(opts ...Option) *SlidingWindow {
+	o := applyOptions(opts)
 	return &SlidingWindow{
-		windows: make(map[string]*windowState),
+		windows:  make(map[string]*windowState),
+		halfLife: o.halfLife,
+		clock:    o.clock,
+		janitor:  janitor{idle: o.cleanupIdle},
 	}
 }
 
 // Allow checks if the request is allowed based on the sliding window algorithm.
 func (sw *SlidingWindow) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("sliding_window"), nil
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return sw.allow(key, limit, sw.clock.Now()), nil
+}
+
+// AllowMulti checks each key independently under a single lock, see the package-level AllowMulti.
+func (sw *SlidingWindow) AllowMulti(ctx context.Context, reqs []KeyLimit) ([]*Result, error) {
+	results := make([]*Result, len(reqs))
+
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
 
-	now := time.Now()
+	now := sw.clock.Now()
+	for i, req := range reqs {
+		if req.Limit.IsUnlimited() {
+			results[i] = unlimitedResult("sliding_window")
+			continue
+		}
+		results[i] = sw.allow(req.Key, req.Limit, now)
+	}
+	return results, nil
+}
+
+// allow checks and counts a request for key. Must be called with the lock held.
+func (sw *SlidingWindow) allow(key string, limit Limit, now time.Time) *Result {
+	if sw.janitor.due(now) {
+		for k, w := range sw.windows {
+			if now.Sub(w.currWindowStart) >= sw.janitor.idle {
+				delete(sw.windows, k)
+			}
+		}
+	}
+
 	w, exists := sw.windows[key]
 	if !exists {
 		w = &windowState{
@@ -42,50 +102,194 @@ func (sw *SlidingWindow) Allow(ctx context.Context, key string, limit Limit) (*R
 		}
 		sw.windows[key] = w
 	}
+	return sw.admit(w, limit, now)
+}
+
+// Peek reports whether a request for key would be allowed, without counting it.
+func (sw *SlidingWindow) Peek(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("sliding_window"), nil
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.clock.Now()
+	peek := windowState{currWindowStart: now}
+	if w, exists := sw.windows[key]; exists {
+		peek = *w
+	}
+	return peekResult(sw.admit(&peek, limit, now)), nil
+}
+
+// Quota reports the estimated count of key. It is fully reset once every request it
+// counts has slid out of the window.
+func (sw *SlidingWindow) Quota(ctx context.Context, key string, limit Limit) (*Quota, error) {
+	now := sw.clock.Now()
+	if limit.IsUnlimited() {
+		return unlimitedQuota(now), nil
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	w, exists := sw.windows[key]
+	if !exists {
+		return newQuota(limit.Rate, limit.Rate, now), nil
+	}
+	peek := *w
+	peek.advance(limit, now)
+	estimate := peek.estimate(limit, now, sw.halfLife)
+
+	resetAt := now
+	switch {
+	case peek.currCount > 0:
+		resetAt = peek.currWindowStart.Add(2 * limit.Period)
+	case peek.prevCount > 0:
+		resetAt = peek.currWindowStart.Add(limit.Period)
+	}
+	return newQuota(limit.Rate, wholeUnits(math.Ceil(float64(limit.Rate)-estimate)), resetAt), nil
+}
+
+// admit rolls w forward to now and counts a request in it if the estimate allows it.
+func (sw *SlidingWindow) admit(w *windowState, limit Limit, now time.Time) *Result {
+	w.advance(limit, now)
+	estimatedCount := w.estimate(limit, now, sw.halfLife)
+
+	// A request is admitted while the estimated count before it is below Rate,
+	// so an empty window admits exactly Rate requests. When the weighted previous
+	// window leaves a fractional last slot (e.g. an estimate of 9.4 of 10), that
+	// slot still admits one request.
+	result := newResult("sliding_window")
+	if estimatedCount < float64(limit.Rate) {
+		w.currCount++
+		result.Allowed = true
+		result.Remaining = wholeUnits(float64(limit.Rate) - estimatedCount - 1)
+		result.ResetAfter = 0
+	} else {
+		result.Allowed = false
+		result.Reason = ReasonRateExceeded
+		result.Remaining = 0
+		// Roughly estimate wait time as time until end of current window, which is never
+		// more than a period away unless the clock stepped backwards
+		result.ResetAfter = computeResetAfter(min(limit.Period, w.currWindowStart.Add(limit.Period).Sub(now)))
+	}
+
+	return result
+}
+
+// advance rolls the windows forward to the one containing now. Windows are half-open, so a
+// request exactly on a boundary belongs to the window starting there. A clock stepping
+// backwards leaves the windows as they are.
+func (w *windowState) advance(limit Limit, now time.Time) {
+	if limit.Period <= 0 {
+		// Windows of no duration never hold past requests (and can't be divided by)
+		w.prevCount, w.currCount = 0, 0
+		w.currWindowStart = now
+		return
+	}
 
 	// Calculate how many windows have passed
 	elapsed := now.Sub(w.currWindowStart)
 	if elapsed >= limit.Period {
-		windowsPassed := int(elapsed / limit.Period)
+		windowsPassed := elapsed / limit.Period
 
 		// If 1 window passed, the current becomes previous
 		if windowsPassed == 1 {
 			w.prevCount = w.currCount
 		} else {
-			// If more than 1 window passed, previous window is too old
+			// If more than 1 window passed, the window before the new current one saw no requests
 			w.prevCount = 0
 		}
 		// Reset current count
 		w.currCount = 0
-		// Update window start time
-		w.currWindowStart = w.currWindowStart.Add(time.Duration(windowsPassed) * limit.Period)
+		// Update window start time. windowsPassed*Period <= elapsed, so this can't overflow,
+		// however long the key was idle, and now stays within the new current window.
+		w.currWindowStart = w.currWindowStart.Add(windowsPassed * limit.Period)
 	}
+}
 
+// estimate returns the weighted request count at now, decaying the previous window
+// exponentially if halfLife is set. The windows must have been advanced to now.
+func (w *windowState) estimate(limit Limit, now time.Time, halfLife time.Duration) float64 {
 	// Calculate the weighted count
 	// Requests in previous window * (Time remaining in current window / Window size) + Requests in current window
-	timeInCurrent := now.Sub(w.currWindowStart).Seconds()
+	// Clamped so a clock stepping backwards doesn't weigh the previous window above 1
+	timeInCurrent := math.Max(0, now.Sub(w.currWindowStart).Seconds())
 	windowSize := limit.Period.Seconds()
+	if windowSize <= 0 {
+		return float64(w.currCount)
+	}
 
 	// Weight of the previous window
 	weight := math.Max(0, (windowSize-timeInCurrent)/windowSize)
+	if halfLife > 0 {
+		weight = math.Pow(0.5, timeInCurrent/halfLife.Seconds())
+	}
 
 	estimatedCount := float64(w.prevCount)*weight + float64(w.currCount)
+	// Round away float noise from the weighting so a count that is mathematically
+	// equal to an integer compares deterministically against Rate.
+	return math.Round(estimatedCount*1e9) / 1e9
+}
 
-	result := &Result{}
-	if estimatedCount < float64(limit.Rate) {
-		w.currCount++
-		result.Allowed = true
-		result.Remaining = int(float64(limit.Rate) - estimatedCount - 1)
-		if result.Remaining < 0 {
-			result.Remaining = 0
+// ActiveKeys returns the keys whose estimated count has reached limit.
+func (sw *SlidingWindow) ActiveKeys(ctx context.Context, limit Limit) ([]string, error) {
+	if limit.IsUnlimited() {
+		return nil, nil
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.clock.Now()
+	var keys []string
+	for key, w := range sw.windows {
+		peek := *w
+		peek.advance(limit, now)
+		if peek.estimate(limit, now, sw.halfLife) >= float64(limit.Rate) {
+			keys = append(keys, key)
 		}
-		result.ResetAfter = 0
-	} else {
-		result.Allowed = false
-		result.Remaining = 0
-		// Roughly estimate wait time as time until end of current window
-		result.ResetAfter = w.currWindowStart.Add(limit.Period).Sub(now)
 	}
+	return keys, nil
+}
+
+// Refund removes n requests from the current window count for key.
+func (sw *SlidingWindow) Refund(ctx context.Context, key string, limit Limit, n int) error {
+	if limit.IsUnlimited() {
+		return nil
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	w, exists := sw.windows[key]
+	if !exists {
+		return nil
+	}
+
+	w.currCount -= n
+	if w.currCount < 0 {
+		w.currCount = 0
+	}
+	return nil
+}
+
+// Reset clears the state of key, restoring its full budget.
+func (sw *SlidingWindow) Reset(ctx context.Context, key string) (bool, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	_, exists := sw.windows[key]
+	delete(sw.windows, key)
+	return exists, nil
+}
+
+// Flush clears the state of every key, restoring their full budgets.
+func (sw *SlidingWindow) Flush(ctx context.Context) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
 
-	return result, nil
+	sw.windows = make(map[string]*windowState)
+	return nil
 }
@@ -0,0 +1,148 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SlidingWindowRing implements the Strategy interface using a sliding window split into
+// sub-windows. Each key keeps a ring buffer of request counts, one per sub-window of
+// Period/subBuckets, and a request is allowed while the counts of the last subBuckets
+// sub-windows add up to less than Rate. It ignores Burst. A period that doesn't split
+// evenly is rounded up to the next whole number of nanoseconds per sub-window.
+//
+// The window slides in steps of one sub-window, so at most one sub-window's worth of old
+// requests is still counted, or already forgotten, at any time: accuracy improves with
+// more sub-buckets at the cost of one int per sub-bucket per key. SlidingWindow is the
+// cheapest (two counts per key) but assumes the previous window's requests were spread
+// evenly; a log of timestamps is exact but needs memory per request.
+type SlidingWindowRing struct {
+	mu         sync.Mutex
+	subBuckets int
+	rings      map[string]*ringState
+	clock      Clock
+	janitor    janitor
+}
+
+type ringState struct {
+	counts    []int
+	head      int       // Index of the current sub-window
+	headStart time.Time // Start of the current sub-window
+	total     int
+}
+
+// NewSlidingWindowRing creates a new instance of SlidingWindowRing strategy splitting
+// each period into subBuckets sub-windows (at least one). It accepts WithCleanup and WithClock.
+func NewSlidingWindowRing(subBuckets int, opts ...Option) *SlidingWindowRing {
+	o := applyOptions(opts)
+	return &SlidingWindowRing{
+		subBuckets: max(1, subBuckets),
+		rings:      make(map[string]*ringState),
+		clock:      o.clock,
+		janitor:    janitor{idle: o.cleanupIdle},
+	}
+}
+
+// Allow checks if the request is allowed based on the sub-window counts of key.
+func (sr *SlidingWindowRing) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("sliding_window_ring"), nil
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	now := sr.clock.Now()
+	// Round up so the sub-windows span at least the period when it doesn't divide evenly
+	size := max(time.Nanosecond, (limit.Period+time.Duration(sr.subBuckets)-1)/time.Duration(sr.subBuckets))
+
+	if sr.janitor.due(now) {
+		for k, r := range sr.rings {
+			if now.Sub(r.headStart) >= sr.janitor.idle {
+				delete(sr.rings, k)
+			}
+		}
+	}
+
+	r, exists := sr.rings[key]
+	if !exists {
+		// Align the sub-windows like Truncate, but by moving now back by its offset into
+		// the sub-window, which keeps the monotonic reading Truncate would strip
+		r = &ringState{
+			counts:    make([]int, sr.subBuckets),
+			headStart: now.Add(-now.Sub(now.Truncate(size))),
+		}
+		sr.rings[key] = r
+	}
+	r.advance(now, size)
+
+	result := newResult("sliding_window_ring")
+	if r.total < limit.Rate {
+		r.counts[r.head]++
+		r.total++
+		result.Allowed = true
+		result.Remaining = limit.Rate - r.total
+	} else {
+		result.Allowed = false
+		result.Reason = ReasonRateExceeded
+		// Never more than a period away, unless the clock stepped backwards
+		result.ResetAfter = computeResetAfter(min(limit.Period, r.waitFor(limit.Rate, size).Sub(now)))
+	}
+
+	return result, nil
+}
+
+// advance moves the ring forward to the sub-window containing now, forgetting the counts
+// that slid out of the window. A clock stepping backwards leaves the ring as it is.
+func (r *ringState) advance(now time.Time, size time.Duration) {
+	steps := int(now.Sub(r.headStart) / size)
+	if steps <= 0 {
+		return
+	}
+
+	if steps >= len(r.counts) {
+		clear(r.counts)
+		r.total = 0
+	} else {
+		for i := 0; i < steps; i++ {
+			r.head = (r.head + 1) % len(r.counts)
+			r.total -= r.counts[r.head]
+			r.counts[r.head] = 0
+		}
+	}
+	r.headStart = r.headStart.Add(time.Duration(steps) * size)
+}
+
+// waitFor returns when enough sub-windows will have slid out for the total to drop below rate.
+func (r *ringState) waitFor(rate int, size time.Duration) time.Time {
+	k := len(r.counts)
+	total := r.total
+	// The oldest sub-window is the one after the head, and slides out first
+	for j := 1; j <= k; j++ {
+		total -= r.counts[(r.head+j)%k]
+		if total < rate {
+			return r.headStart.Add(time.Duration(j) * size)
+		}
+	}
+	return r.headStart.Add(time.Duration(k) * size)
+}
+
+// Reset clears the state of key, restoring its full budget.
+func (sr *SlidingWindowRing) Reset(ctx context.Context, key string) (bool, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	_, exists := sr.rings[key]
+	delete(sr.rings, key)
+	return exists, nil
+}
+
+// Flush clears the state of every key, restoring their full budgets.
+func (sr *SlidingWindowRing) Flush(ctx context.Context) error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sr.rings = make(map[string]*ringState)
+	return nil
+}
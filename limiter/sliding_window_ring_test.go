@@ -0,0 +1,133 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// slidingLog is an exact sliding window keeping the time of every admitted request, the
+// reference SlidingWindowRing approximates.
+type slidingLog struct {
+	times []time.Time
+}
+
+func (l *slidingLog) allow(now time.Time, limit Limit) bool {
+	kept := l.times[:0]
+	for _, t := range l.times {
+		if now.Sub(t) < limit.Period {
+			kept = append(kept, t)
+		}
+	}
+	l.times = kept
+	if len(l.times) >= limit.Rate {
+		return false
+	}
+	l.times = append(l.times, now)
+	return true
+}
+
+func TestSlidingWindowRingSlidesBySubWindow(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	sr := NewSlidingWindowRing(4, WithClock(clock))
+	limit := Limit{Rate: 4, Period: time.Second}
+
+	// Two requests in each of the first two sub-windows fill the window
+	for _, step := range []time.Duration{0, 0, 250 * time.Millisecond, 0} {
+		clock.Advance(step)
+		if !must(sr.Allow(ctx, "k", limit)).Allowed {
+			t.Fatal("request within the limit denied")
+		}
+	}
+	clock.Advance(250 * time.Millisecond)
+	res := must(sr.Allow(ctx, "k", limit))
+	if res.Allowed || res.ResetAfter != 500*time.Millisecond {
+		t.Fatalf("request over the limit = %+v, want denied until the first sub-window slides out", res)
+	}
+
+	// The first sub-window slid out, freeing exactly its two requests
+	clock.Advance(500 * time.Millisecond)
+	if n := exhaust(t, sr, "k", limit); n != 2 {
+		t.Errorf("admitted %d after the first sub-window slid out, want 2", n)
+	}
+}
+
+func TestSlidingWindowRingIndivisiblePeriod(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Period: 10 * time.Nanosecond}
+
+	for _, k := range []int{3, 4, 7} {
+		clock := newFakeClock()
+		sr := NewSlidingWindowRing(k, WithClock(clock))
+
+		// A request every nanosecond is admitted once the previous one slid out, which
+		// must take at least the period however it splits into sub-windows
+		var last time.Time
+		for i := 0; i < 100; i++ {
+			clock.Advance(time.Nanosecond)
+			if !must(sr.Allow(ctx, "k", limit)).Allowed {
+				continue
+			}
+			if gap := clock.Now().Sub(last); !last.IsZero() && gap < limit.Period {
+				t.Fatalf("%d sub-buckets: requests admitted %s apart, want at least the period %s", k, gap, limit.Period)
+			}
+			last = clock.Now()
+		}
+	}
+}
+
+func TestSlidingWindowRingKeepsMonotonicReading(t *testing.T) {
+	sr := NewSlidingWindowRing(4)
+	limit := Limit{Rate: 4, Period: time.Second}
+
+	must(sr.Allow(context.Background(), "k", limit))
+	start := sr.rings["k"].headStart
+	// Round(0) strips the monotonic reading, so they only match if there was none
+	if start == start.Round(0) {
+		t.Error("sub-window start has no monotonic reading, so wall clock steps move the window")
+	}
+	if !start.Equal(start.Truncate(250 * time.Millisecond)) {
+		t.Errorf("sub-window start %s isn't aligned to the sub-window size", start)
+	}
+}
+
+func TestSlidingWindowRingApproachesLog(t *testing.T) {
+	limit := Limit{Rate: 10, Period: time.Second}
+
+	// Bursty traffic: a burst of requests every 130ms, with a quiet spell every 2s
+	var offsets []time.Duration
+	for at := time.Duration(0); at < 20*time.Second; at += 130 * time.Millisecond {
+		if at%(2*time.Second) < 500*time.Millisecond {
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			offsets = append(offsets, at+time.Duration(i)*time.Millisecond)
+		}
+	}
+
+	// mismatches counts the requests on which a ring of k sub-buckets and the log disagree
+	mismatches := func(k int) int {
+		clock := newFakeClock()
+		start := clock.Now()
+		sr := NewSlidingWindowRing(k, WithClock(clock))
+		log := &slidingLog{}
+		n := 0
+		for _, offset := range offsets {
+			clock.Advance(start.Add(offset).Sub(clock.Now()))
+			if must(sr.Allow(context.Background(), "k", limit)).Allowed != log.allow(clock.Now(), limit) {
+				n++
+			}
+		}
+		return n
+	}
+
+	coarse, fine := mismatches(2), mismatches(100)
+	t.Logf("disagreements with the log over %d requests: %d with 2 sub-buckets, %d with 100", len(offsets), coarse, fine)
+	if fine >= coarse {
+		t.Errorf("100 sub-buckets disagree with the log %d times, 2 do %d times, want fewer", fine, coarse)
+	}
+	if fine > len(offsets)/20 {
+		t.Errorf("100 sub-buckets disagree with the log on %d of %d requests, want under 5%%", fine, len(offsets))
+	}
+}
@@ -0,0 +1,162 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowAdmitsPerWindow(t *testing.T) {
+	limit := Limit{Rate: 10, Period: time.Second}
+
+	for _, tc := range []struct {
+		name string
+		// The previous window held Rate requests, and the next ones are sent this far into
+		// the current window
+		offset time.Duration
+		want   int
+	}{
+		{"window start, previous fully weighted", 0, 0},
+		{"quarter window", 250 * time.Millisecond, 3},
+		{"half window", 500 * time.Millisecond, 5},
+		{"fractional last slot", 940 * time.Millisecond, 10},
+		{"two windows later", time.Second, 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newFakeClock()
+			sw := NewSlidingWindow(WithClock(clock))
+
+			if n := exhaust(t, sw, "k", limit); n != limit.Rate {
+				t.Fatalf("empty window admitted %d, want exactly %d", n, limit.Rate)
+			}
+			clock.Advance(limit.Period + tc.offset)
+			if n := exhaust(t, sw, "k", limit); n != tc.want {
+				t.Fatalf("admitted %d, want %d", n, tc.want)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowBoundaryBelongsToNextWindow(t *testing.T) {
+	clock := newFakeClock()
+	sw := NewSlidingWindow(WithClock(clock))
+	limit := Limit{Rate: 3, Period: time.Second}
+
+	exhaust(t, sw, "k", limit)
+	clock.Advance(time.Second - time.Nanosecond)
+	if n := exhaust(t, sw, "k", limit); n != 0 {
+		t.Fatalf("admitted %d just before the boundary, want 0", n)
+	}
+
+	// Exactly on the boundary, the full previous window still counts
+	clock.Advance(time.Nanosecond)
+	res, err := sw.Peek(context.Background(), "k", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Fatal("request on the boundary allowed, want the previous window fully weighted")
+	}
+}
+
+func TestSlidingWindowDecayAtBoundarySpike(t *testing.T) {
+	limit := Limit{Rate: 10, Period: time.Second}
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		// Requests admitted 100ms and 500ms into the window after the spike
+		early, mid int
+	}{
+		// The spike is assumed spread over its window, so half of it has slid out by mid-window
+		{"linear", nil, 1, 5},
+		// 0.5^(0.5s/1s) of the spike, about 7 requests, still counts at mid-window
+		{"decay", []Option{WithDecay(time.Second)}, 1, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, step := range []struct {
+				offset time.Duration
+				want   int
+			}{
+				{100 * time.Millisecond, tc.early},
+				{500 * time.Millisecond, tc.mid},
+			} {
+				clock := newFakeClock()
+				sw := NewSlidingWindow(append(tc.opts, WithClock(clock))...)
+
+				// One request opens the window, the rest of the budget lands right before it ends
+				if _, err := sw.Allow(context.Background(), "k", limit); err != nil {
+					t.Fatal(err)
+				}
+				clock.Advance(990 * time.Millisecond)
+				if n := exhaust(t, sw, "k", limit); n != limit.Rate-1 {
+					t.Fatalf("spike admitted %d, want %d", n, limit.Rate-1)
+				}
+
+				clock.Advance(10*time.Millisecond + step.offset)
+				if n := exhaust(t, sw, "k", limit); n != step.want {
+					t.Errorf("%s into the next window: admitted %d, want %d", step.offset, n, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSlidingWindowAdvance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := Limit{Rate: 10, Period: time.Second}
+
+	for _, tc := range []struct {
+		name  string
+		limit Limit
+		at    time.Duration // Since the start of the current window
+		// The windows after advancing
+		wantStart          time.Duration
+		wantPrev, wantCurr int
+	}{
+		{"within the window", limit, 999 * time.Millisecond, 0, 3, 4},
+		{"exactly on the boundary", limit, time.Second, time.Second, 4, 0},
+		{"past the boundary", limit, 1500 * time.Millisecond, time.Second, 4, 0},
+		{"exactly two windows", limit, 2 * time.Second, 2 * time.Second, 0, 0},
+		{"a million windows", limit, 1e6*time.Second + 300*time.Millisecond, 1e6 * time.Second, 0, 0},
+		{"a century", limit, 100 * 365 * 24 * time.Hour, 100 * 365 * 24 * time.Hour, 0, 0},
+		{"clock stepped back", limit, -time.Hour, 0, 3, 4},
+		{"zero period", Limit{Rate: 10}, 500 * time.Millisecond, 500 * time.Millisecond, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := windowState{currWindowStart: start, prevCount: 3, currCount: 4}
+			now := start.Add(tc.at)
+			w.advance(tc.limit, now)
+
+			if got := w.currWindowStart.Sub(start); got != tc.wantStart {
+				t.Errorf("window start moved by %v, want %v", got, tc.wantStart)
+			}
+			if w.prevCount != tc.wantPrev || w.currCount != tc.wantCurr {
+				t.Errorf("counts = %d previous, %d current, want %d, %d", w.prevCount, w.currCount, tc.wantPrev, tc.wantCurr)
+			}
+			if tc.at >= 0 {
+				if in := now.Sub(w.currWindowStart); in < 0 || (tc.limit.Period > 0 && in >= tc.limit.Period) {
+					t.Errorf("now is %v into the current window, want within [0, %v)", in, tc.limit.Period)
+				}
+			}
+		})
+	}
+}
+
+func TestSlidingWindowAfterLongIdle(t *testing.T) {
+	clock := newFakeClock()
+	sw := NewSlidingWindow(WithClock(clock))
+	limit := Limit{Rate: 5, Period: time.Second}
+
+	exhaust(t, sw, "k", limit)
+	// Thousands of windows later, half way into one, nothing of the old requests counts
+	clock.Advance(10000*limit.Period + 500*time.Millisecond)
+	if n := exhaust(t, sw, "k", limit); n != limit.Rate {
+		t.Fatalf("admitted %d after a long idle, want the full %d", n, limit.Rate)
+	}
+	// The windows stayed aligned: the current one ends half a period from now
+	res := must(sw.Allow(context.Background(), "k", limit))
+	if res.ResetAfter != 500*time.Millisecond {
+		t.Errorf("ResetAfter = %v, want the 500ms left in the window", res.ResetAfter)
+	}
+}
@@ -0,0 +1,30 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownStrategy is returned by NewStrategy for names it doesn't know.
+var ErrUnknownStrategy = errors.New("limiter: unknown strategy")
+
+// NewStrategy returns the in-memory strategy called name, configured with opts, so the
+// algorithm can be picked from config. The names are those the strategies report as
+// Result.Source: "token_bucket", "sliding_window", "fixed_window", "leaky_bucket" and
+// "min_interval". Unknown names return an error wrapping ErrUnknownStrategy.
+func NewStrategy(name string, opts ...Option) (Strategy, error) {
+	switch name {
+	case "token_bucket":
+		return NewTokenBucket(opts...), nil
+	case "sliding_window":
+		return NewSlidingWindow(opts...), nil
+	case "fixed_window":
+		return NewFixedWindow(opts...), nil
+	case "leaky_bucket":
+		return NewLeakyBucket(opts...), nil
+	case "min_interval":
+		return NewMinInterval(opts...), nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownStrategy, name)
+	}
+}
@@ -0,0 +1,396 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewStrategy(t *testing.T) {
+	for name, want := range map[string]Strategy{
+		"token_bucket":   &TokenBucket{},
+		"sliding_window": &SlidingWindow{},
+		"fixed_window":   &FixedWindow{},
+		"leaky_bucket":   &LeakyBucket{},
+		"min_interval":   &MinInterval{},
+	} {
+		s, err := NewStrategy(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if reflect.TypeOf(s) != reflect.TypeOf(want) {
+			t.Errorf("NewStrategy(%q) = %T, want %T", name, s, want)
+		}
+	}
+
+	// Options reach the strategy
+	clock := newFakeClock()
+	s, err := NewStrategy("sliding_window", WithDecay(time.Second), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sw := s.(*SlidingWindow); sw.halfLife != time.Second || sw.clock != clock {
+		t.Error("NewStrategy dropped the options")
+	}
+
+	for _, name := range []string{"", "Token_Bucket", "token-bucket", "redis"} {
+		s, err := NewStrategy(name)
+		if !errors.Is(err, ErrUnknownStrategy) || s != nil {
+			t.Errorf("NewStrategy(%q) = %v, %v, want ErrUnknownStrategy", name, s, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("%q", name)) {
+			t.Errorf("error %q doesn't name the strategy %q", err, name)
+		}
+	}
+}
+
+func TestStrategiesReportSource(t *testing.T) {
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	for _, name := range []string{"token_bucket", "sliding_window", "fixed_window", "leaky_bucket", "min_interval"} {
+		s, err := NewStrategy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.Allow(context.Background(), "k", limit)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if res.Source != name {
+			t.Errorf("%s: Source = %q", name, res.Source)
+		}
+	}
+}
+
+func TestStrategiesHonorUnlimited(t *testing.T) {
+	ctx := context.Background()
+	strategies := map[string]Strategy{
+		"token_bucket":         NewTokenBucket(),
+		"sliding_window":       NewSlidingWindow(),
+		"sliding_window_ring":  NewSlidingWindowRing(4),
+		"fixed_window":         NewFixedWindow(),
+		"leaky_bucket":         NewLeakyBucket(),
+		"min_interval":         NewMinInterval(),
+		"sharded_token_bucket": NewShardedTokenBucket(4),
+		"global":               NewGlobalLimiter(),
+	}
+	for name, s := range strategies {
+		for i := 0; i < 100; i++ {
+			res, err := s.Allow(ctx, "k", Unlimited)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !res.Allowed || res.Remaining != math.MaxInt {
+				t.Fatalf("%s: request %d = %+v, want allowed with MaxInt remaining", name, i, res)
+			}
+		}
+	}
+
+	// An unlimited request must not have touched the state of the key
+	tb := strategies["token_bucket"].(*TokenBucket)
+	q, err := tb.Quota(ctx, "k", Limit{Rate: 1, Period: time.Second, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Used != 0 {
+		t.Errorf("token bucket used %d tokens on unlimited requests, want 0", q.Used)
+	}
+}
+
+func TestActiveKeys(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Period: time.Second, Burst: 2}
+
+	for name, newStrategy := range map[string]func(Clock) KeyLister{
+		"token_bucket":   func(c Clock) KeyLister { return NewTokenBucket(WithClock(c)) },
+		"sliding_window": func(c Clock) KeyLister { return NewSlidingWindow(WithClock(c)) },
+		"fixed_window":   func(c Clock) KeyLister { return NewFixedWindow(WithClock(c)) },
+		"leaky_bucket":   func(c Clock) KeyLister { return NewLeakyBucket(WithClock(c)) },
+	} {
+		clock := newFakeClock()
+		kl := newStrategy(clock)
+		s := kl.(Strategy)
+
+		exhaust(t, s, "hot", limit)
+		if _, err := s.Allow(ctx, "warm", limit); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := kl.ActiveKeys(ctx, limit)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(keys) != 1 || keys[0] != "hot" {
+			t.Errorf("%s: ActiveKeys = %v, want [hot]", name, keys)
+		}
+
+		// Keys are only active while they would be denied
+		clock.Advance(2 * limit.Period)
+		if keys, _ := kl.ActiveKeys(ctx, limit); len(keys) != 0 {
+			t.Errorf("%s: ActiveKeys after recovery = %v, want none", name, keys)
+		}
+
+		if keys, _ := kl.ActiveKeys(ctx, Unlimited); keys != nil {
+			t.Errorf("%s: ActiveKeys(Unlimited) = %v, want none", name, keys)
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Period: time.Second, Burst: 2}
+
+	for name, newStrategy := range map[string]func(Clock) Flusher{
+		"token_bucket":        func(c Clock) Flusher { return NewTokenBucket(WithClock(c)) },
+		"sliding_window":      func(c Clock) Flusher { return NewSlidingWindow(WithClock(c)) },
+		"sliding_window_ring": func(c Clock) Flusher { return NewSlidingWindowRing(10, WithClock(c)) },
+		"fixed_window":        func(c Clock) Flusher { return NewFixedWindow(WithClock(c)) },
+		"leaky_bucket":        func(c Clock) Flusher { return NewLeakyBucket(WithClock(c)) },
+		"min_interval":        func(c Clock) Flusher { return NewMinInterval(WithClock(c)) },
+	} {
+		// The clock never moves, so only the flush can restore the budgets
+		f := newStrategy(newFakeClock())
+		s := f.(Strategy)
+		exhaust(t, s, "a", limit)
+		exhaust(t, s, "b", limit)
+
+		if err := f.Flush(ctx); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if kl, ok := f.(KeyLister); ok {
+			if keys, _ := kl.ActiveKeys(ctx, limit); len(keys) != 0 {
+				t.Errorf("%s: ActiveKeys after Flush = %v, want none", name, keys)
+			}
+		}
+		for _, key := range []string{"a", "b"} {
+			if res := must(s.Allow(ctx, key, limit)); !res.Allowed {
+				t.Errorf("%s: %s denied after Flush", name, key)
+			}
+		}
+	}
+}
+
+func TestClockSteppingBackwards(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+
+	for name, newStrategy := range map[string]func(Clock) Strategy{
+		"token_bucket":   func(c Clock) Strategy { return NewTokenBucket(WithClock(c)) },
+		"sliding_window": func(c Clock) Strategy { return NewSlidingWindow(WithClock(c)) },
+		"fixed_window":   func(c Clock) Strategy { return NewFixedWindow(WithClock(c)) },
+		"leaky_bucket":   func(c Clock) Strategy { return NewLeakyBucket(WithClock(c)) },
+	} {
+		clock := newFakeClock()
+		s := newStrategy(clock)
+		for i := 0; i < 5; i++ {
+			if _, err := s.Allow(ctx, "k", limit); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// An NTP correction neither takes away nor hands out budget
+		clock.Advance(-time.Minute)
+		if n := exhaust(t, s, "k", limit); n != 5 {
+			t.Errorf("%s: admitted %d after the clock stepped back, want the 5 left", name, n)
+		}
+
+		// Once the clock catches up, the key recovers as usual
+		clock.Advance(time.Minute + 2*limit.Period)
+		if n := exhaust(t, s, "k", limit); n != 10 {
+			t.Errorf("%s: admitted %d after recovering, want 10", name, n)
+		}
+	}
+}
+
+// wallClock reads the system clock without its monotonic reading, as a Clock built from
+// serialized or rounded times would, offset by a wall clock adjustment.
+type wallClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *wallClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Round(0).Add(c.offset)
+}
+
+func (c *wallClock) Adjust(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset += d
+}
+
+func TestClockWithoutMonotonicReading(t *testing.T) {
+	ctx := context.Background()
+	// Slow enough that the test's own run time doesn't refill anything
+	limit := Limit{Rate: 10, Period: time.Hour, Burst: 10}
+
+	clock := &wallClock{}
+	if strings.Contains(clock.Now().String(), "m=") {
+		t.Fatal("wallClock readings carry a monotonic reading")
+	}
+
+	for name, newStrategy := range map[string]func(Clock) Strategy{
+		"token_bucket":        func(c Clock) Strategy { return NewTokenBucket(WithClock(c)) },
+		"sliding_window":      func(c Clock) Strategy { return NewSlidingWindow(WithClock(c)) },
+		"sliding_window_ring": func(c Clock) Strategy { return NewSlidingWindowRing(10, WithClock(c)) },
+		"fixed_window":        func(c Clock) Strategy { return NewFixedWindow(WithClock(c)) },
+		"leaky_bucket":        func(c Clock) Strategy { return NewLeakyBucket(WithClock(c)) },
+	} {
+		clock := &wallClock{}
+		s := newStrategy(clock)
+		for i := 0; i < 5; i++ {
+			must(s.Allow(ctx, "k", limit))
+		}
+
+		// The wall clock is set back a day: nothing is refilled or taken away, and the wait
+		// advertised to denied requests stays within a period
+		clock.Adjust(-24 * time.Hour)
+		if n := exhaust(t, s, "k", limit); n != 5 {
+			t.Errorf("%s: admitted %d after the clock was set back, want the 5 left", name, n)
+		}
+		res := must(s.Allow(ctx, "k", limit))
+		if res.Allowed || res.ResetAfter < 0 || res.ResetAfter > limit.Period {
+			t.Errorf("%s: denial after the clock was set back = %+v, want a wait within a period", name, res)
+		}
+
+		// Set forward a year: the key recovers its full budget and no more
+		clock.Adjust(365 * 24 * time.Hour)
+		if n := exhaust(t, s, "k", limit); n != 10 {
+			t.Errorf("%s: admitted %d after the clock was set forward, want 10", name, n)
+		}
+	}
+}
+
+func TestBurstAcrossStrategies(t *testing.T) {
+	// Burst above Rate lets the buckets absorb a larger spike; the windows ignore it
+	limit := Limit{Rate: 2, Period: time.Second, Burst: 5}
+
+	for name, want := range map[string]int{
+		"token_bucket":   5,
+		"leaky_bucket":   5,
+		"sliding_window": 2,
+		"fixed_window":   2,
+	} {
+		s, err := NewStrategy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := exhaust(t, s, "k", limit); n != want {
+			t.Errorf("%s: spike admitted %d, want %d", name, n, want)
+		}
+	}
+
+	// With Burst equal to Rate they agree
+	limit.Burst = limit.Rate
+	for _, name := range []string{"token_bucket", "leaky_bucket", "sliding_window", "fixed_window"} {
+		s, _ := NewStrategy(name)
+		if n := exhaust(t, s, "k", limit); n != limit.Rate {
+			t.Errorf("%s: spike admitted %d with Burst = Rate, want %d", name, n, limit.Rate)
+		}
+	}
+}
+
+func TestPeekDoesNotConsume(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestRedis(t)
+	limit := Limit{Rate: 3, Period: time.Minute, Burst: 3}
+
+	for name, s := range map[string]Strategy{
+		"token_bucket":         NewTokenBucket(),
+		"sliding_window":       NewSlidingWindow(),
+		"fixed_window":         NewFixedWindow(),
+		"leaky_bucket":         NewLeakyBucket(),
+		"sharded_token_bucket": NewShardedTokenBucket(4),
+		"redis":                NewRedisTokenBucket(client),
+	} {
+		p := s.(Peekable)
+		for i := 0; i < 10; i++ {
+			if res := must(p.Peek(ctx, "k", limit)); !res.Allowed || res.Remaining != 3 {
+				t.Fatalf("%s: peek %d = %+v, want allowed with 3 remaining", name, i, res)
+			}
+		}
+		must(s.Allow(ctx, "k", limit))
+		if res := must(p.Peek(ctx, "k", limit)); !res.Allowed || res.Remaining != 2 {
+			t.Errorf("%s: peek after a request = %+v, want allowed with 2 remaining", name, res)
+		}
+		if n := exhaust(t, s, "k", limit); n != 2 {
+			t.Errorf("%s: admitted %d after peeking, want the 2 left", name, n)
+		}
+		if res := must(p.Peek(ctx, "k", limit)); res.Allowed || res.Remaining != 0 {
+			t.Errorf("%s: peek when exhausted = %+v, want denied", name, res)
+		}
+	}
+}
+
+func TestHugeLimitsDontOverflowRemaining(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: math.MaxInt, Period: time.Second, Burst: math.MaxInt}
+
+	for _, name := range []string{"token_bucket", "sliding_window", "fixed_window", "leaky_bucket"} {
+		s, err := NewStrategy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := must(s.Allow(ctx, "k", limit))
+		// Float rounding may lose a unit, but must never wrap around
+		if !res.Allowed || res.Remaining < math.MaxInt/2 {
+			t.Errorf("%s: result %+v, want allowed with a huge Remaining", name, res)
+		}
+		if q, ok := s.(QuotaReporter); ok {
+			quota, err := q.Quota(ctx, "k", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quota.Remaining < math.MaxInt/2 || quota.Used < 0 {
+				t.Errorf("%s: quota %+v, want a huge Remaining", name, *quota)
+			}
+		}
+	}
+
+	if got := wholeUnits(math.Inf(1)); got != math.MaxInt {
+		t.Errorf("wholeUnits(+Inf) = %d, want MaxInt", got)
+	}
+	if got := wholeUnits(-0.5); got != 0 {
+		t.Errorf("wholeUnits(-0.5) = %d, want 0", got)
+	}
+}
+
+func TestAllowNRejectsNonPositiveCosts(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 3, Period: time.Hour, Burst: 3}
+	_, client := newTestRedis(t)
+	_, client2 := newTestRedis(t)
+
+	for name, s := range map[string]StrategyN{
+		"token_bucket":         NewTokenBucket(),
+		"sharded_token_bucket": NewShardedTokenBucket(4),
+		"global":               NewGlobalLimiter(),
+		"leaky_bucket":         NewLeakyBucket(),
+		"redis":                NewRedisTokenBucket(client),
+		"redis_multi":          NewRedisMultiBucket(client),
+		"redis_sharded":        NewRedisShardedTokenBucket([]*redis.Client{client, client2}),
+		"redis_leaky_bucket":   NewRedisLeakyBucket(client),
+	} {
+		key := "invalid:" + name
+		for _, n := range []int{0, -1, math.MinInt} {
+			for _, l := range []Limit{limit, Unlimited} {
+				if res, err := s.AllowN(ctx, key, l, n); !errors.Is(err, ErrInvalidCost) || res != nil {
+					t.Errorf("%s: AllowN(%d) under %s = %+v, %v, want ErrInvalidCost", name, n, l, res, err)
+				}
+			}
+		}
+		// The key was left untouched
+		if n := exhaust(t, s, key, limit); n != limit.Burst {
+			t.Errorf("%s: admitted %d after invalid costs, want the full burst of %d", name, n, limit.Burst)
+		}
+	}
+}
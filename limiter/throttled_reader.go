@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ThrottledReader wraps an io.Reader, charging key one unit of limit per byte read, to shape
+// the bandwidth of e.g. an upload as it streams rather than only counting requests.
+//
+// Each Read reads at most limit.Burst bytes from the underlying reader, then blocks until
+// the strategy admits that many bytes, waiting out the ResetAfter of each denial. Reads
+// therefore proceed at the rate of the limit, and a slow reader pushes back on the sender
+// through TCP flow control. Since the bytes are charged once read, they are returned even
+// if the context ends while waiting, together with the context's error; they are not charged.
+// A limit with a Burst below one could never admit a byte, so reads then fail with
+// ErrExceedsBurst rather than block forever.
+//
+// Keys are shared with other users of the strategy, so several readers throttled under the
+// same key share one budget, e.g. all the uploads of a client.
+type ThrottledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	strategy StrategyN
+	key      string
+	limit    Limit
+}
+
+// NewThrottledReader creates a new ThrottledReader reading from r. ctx bounds every wait
+// for budget.
+func NewThrottledReader(ctx context.Context, r io.Reader, strategy StrategyN, key string, limit Limit) *ThrottledReader {
+	return &ThrottledReader{
+		ctx:      ctx,
+		r:        r,
+		strategy: strategy,
+		key:      key,
+		limit:    limit,
+	}
+}
+
+// Read reads up to len(p) bytes, blocking until they fit in the limit.
+func (t *ThrottledReader) Read(p []byte) (int, error) {
+	if t.limit.IsUnlimited() {
+		return t.r.Read(p)
+	}
+	if t.limit.Burst < 1 {
+		return 0, ErrExceedsBurst
+	}
+	if len(p) > t.limit.Burst {
+		p = p[:t.limit.Burst]
+	}
+
+	n, err := t.r.Read(p)
+	if n == 0 {
+		return 0, err
+	}
+	if werr := t.wait(n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// wait blocks until the strategy admits n bytes or the context ends.
+func (t *ThrottledReader) wait(n int) error {
+	for {
+		res, err := t.strategy.AllowN(t.ctx, t.key, t.limit, n)
+		if err != nil {
+			return err
+		}
+		allowed, delay := res.Allowed, res.ResetAfter
+		ReleaseResult(res)
+		if allowed {
+			return nil
+		}
+		if delay <= 0 {
+			delay = time.Millisecond
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			timer.Stop()
+			return t.ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingN is a StrategyN whose backend is down.
+type failingN struct{}
+
+func (failingN) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return nil, errBackend
+}
+
+func (failingN) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	return nil, errBackend
+}
+
+func TestThrottledReaderRate(t *testing.T) {
+	// 10KB/s with 1KB up front: 3KB takes about 200ms
+	limit := Limit{Rate: 10000, Period: time.Second, Burst: 1000}
+	body := bytes.Repeat([]byte("x"), 3000)
+	r := NewThrottledReader(context.Background(), bytes.NewReader(body), NewTokenBucket(), "upload", limit)
+
+	start := time.Now()
+	got, err := io.ReadAll(r)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("read %d bytes, want the %d of the body", len(got), len(body))
+	}
+	if elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("reading 3KB took %v, want about 200ms", elapsed)
+	}
+}
+
+func TestThrottledReaderReadsAtMostBurst(t *testing.T) {
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 4}
+	tb := NewTokenBucket(WithClock(newFakeClock()))
+	r := NewThrottledReader(context.Background(), strings.NewReader("abcdefgh"), tb, "k", limit)
+
+	buf := make([]byte, 100)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != "abcd" {
+		t.Fatalf("Read = %q, %v, want the burst of 4 bytes", buf[:n], err)
+	}
+	if res := must(tb.Allow(context.Background(), "k", limit)); res.Allowed {
+		t.Error("bucket not charged for the bytes read")
+	}
+}
+
+func TestThrottledReaderContextEndsWhileWaiting(t *testing.T) {
+	limit := Limit{Rate: 1, Period: time.Hour, Burst: 4}
+	tb := NewTokenBucket()
+	exhaust(t, tb, "k", limit)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r := NewThrottledReader(ctx, strings.NewReader("abcdefgh"), tb, "k", limit)
+
+	buf := make([]byte, 100)
+	n, err := r.Read(buf)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the context's", err)
+	}
+	// The bytes were consumed from the underlying reader, so they are returned
+	if string(buf[:n]) != "abcd" {
+		t.Errorf("Read returned %q, want the 4 bytes read", buf[:n])
+	}
+}
+
+func TestThrottledReaderUnlimited(t *testing.T) {
+	body := strings.Repeat("x", 1<<16)
+	r := NewThrottledReader(context.Background(), strings.NewReader(body), NewTokenBucket(), "k", Unlimited)
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != body {
+		t.Fatalf("read %d bytes, %v, want the whole body", len(got), err)
+	}
+}
+
+func TestThrottledReaderZeroBurst(t *testing.T) {
+	limit := Limit{Rate: 10, Period: time.Second}
+	r := NewThrottledReader(context.Background(), strings.NewReader("abc"), NewTokenBucket(), "k", limit)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(r)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrExceedsBurst) {
+			t.Errorf("error = %v, want ErrExceedsBurst", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reading with a zero burst never returned")
+	}
+}
+
+func TestThrottledReaderStrategyError(t *testing.T) {
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 10}
+	r := NewThrottledReader(context.Background(), strings.NewReader("abc"), failingN{}, "k", limit)
+	if _, err := io.ReadAll(r); !errors.Is(err, errBackend) {
+		t.Errorf("error = %v, want the backend error", err)
+	}
+}
@@ -1,49 +1,257 @@
 package limiter
 
 import (
+	"container/list"
 	"context"
+	"math"
 	"sync"
 	"time"
 )
 
 // TokenBucket implements the Strategy interface using the token bucket algorithm.
+//
+// Result.Remaining is the number of additional single-token requests that would be
+// allowed right now, i.e. the floor of the tokens left in the bucket. It is reported
+// the same way whether the request was allowed or denied.
 type TokenBucket struct {
-	mu      sync.Mutex
-	buckets map[string]*bucket
+	mu            sync.Mutex
+	buckets       map[string]*bucket
+	initialTokens float64 // Negative for a full bucket
+	clock         Clock
+	janitor       janitor
+	maxKeys       int
+	lru           *list.List // Keys, most recently used first; nil without WithLRU
+	trackDenials  bool
 }
 
 type bucket struct {
 	tokens     float64
 	lastUpdate time.Time
+	lastDenied time.Time     // Only set with WithDenialTracking
+	elem       *list.Element // Position in the LRU list
 }
 
 // NewTokenBucket creates a new instance of TokenBucket strategy.
-// Note: In a real production system, you would want a mechanism to clean up old keys.
-func NewTokenBucket() *TokenBucket {
-	return &TokenBucket{
-		buckets: make(map[string]*bucket),
+// It accepts WithInitialTokens, WithCleanup, WithLRU, WithDenialTracking and WithClock. Without WithCleanup or
+// WithLRU, the bucket of every key ever seen is kept until it is reset.
+func NewTokenBucket(opts ...Option) *TokenBucket {
+	o := applyOptions(opts)
+	tb := &TokenBucket{
+		buckets:       make(map[string]*bucket),
+		initialTokens: o.initialTokens,
+		clock:         o.clock,
+		janitor:       janitor{idle: o.cleanupIdle},
+		maxKeys:       o.maxKeys,
+		trackDenials:  o.trackDenials,
 	}
+	if o.maxKeys > 0 {
+		tb.lru = list.New()
+	}
+	return tb
 }
 
 // Allow checks if the request is allowed based on the token bucket algorithm.
 func (tb *TokenBucket) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return tb.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN checks if a request consuming n tokens is allowed.
+// It returns ErrExceedsBurst without touching the bucket if n can never fit in it,
+// and ErrInvalidCost if n isn't positive.
+func (tb *TokenBucket) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	if n <= 0 {
+		return nil, ErrInvalidCost
+	}
+	return tb.allow(key, limit, float64(n))
+}
+
+// AllowCost checks if a request consuming a fractional number of tokens is allowed,
+// for modelling requests cheaper than one token (e.g. 0.1). Non-positive costs are
+// rejected with ErrInvalidCost.
+func (tb *TokenBucket) AllowCost(ctx context.Context, key string, limit Limit, cost float64) (*Result, error) {
+	if cost <= 0 {
+		return nil, ErrInvalidCost
+	}
+	return tb.allow(key, limit, cost)
+}
+
+func (tb *TokenBucket) allow(key string, limit Limit, cost float64) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("token_bucket"), nil
+	}
+	if exceedsBurst(cost, limit) {
+		return nil, ErrExceedsBurst
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.clock.Now()
+	return tb.take(key, limit, cost, now), nil
+}
+
+// take takes cost tokens from the bucket of key, recording a denial if they are tracked.
+// Must be called with the lock held.
+func (tb *TokenBucket) take(key string, limit Limit, cost float64, now time.Time) *Result {
+	b := tb.get(key, limit, now)
+	res := b.take(limit, cost, now)
+	if !res.Allowed && tb.trackDenials {
+		b.lastDenied = now
+	}
+	return res
+}
+
+// AllowMulti checks each key independently under a single lock, see the package-level AllowMulti.
+func (tb *TokenBucket) AllowMulti(ctx context.Context, reqs []KeyLimit) ([]*Result, error) {
+	results := make([]*Result, len(reqs))
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.clock.Now()
+	for i, req := range reqs {
+		if req.Limit.IsUnlimited() {
+			results[i] = unlimitedResult("token_bucket")
+			continue
+		}
+		results[i] = tb.take(req.Key, req.Limit, 1, now)
+	}
+	return results, nil
+}
+
+// Peek reports whether a request for key would be allowed, without taking a token.
+func (tb *TokenBucket) Peek(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.IsUnlimited() {
+		return unlimitedResult("token_bucket"), nil
+	}
+
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	now := time.Now()
+	now := tb.clock.Now()
+	peek := *tb.create(limit, now)
+	if b, exists := tb.buckets[key]; exists {
+		peek = *b
+	}
+	return peekResult(peek.take(limit, 1, now)), nil
+}
+
+// Quota reports how many tokens key holds and when its bucket will be full again.
+func (tb *TokenBucket) Quota(ctx context.Context, key string, limit Limit) (*Quota, error) {
+	now := tb.clock.Now()
+	if limit.IsUnlimited() {
+		return unlimitedQuota(now), nil
+	}
+
+	if limit.Rate == 0 {
+		return newQuota(0, 0, now), nil
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	peek := *tb.create(limit, now)
+	if b, exists := tb.buckets[key]; exists {
+		peek = *b
+	}
+	tokensPerSec := peek.refill(limit, now)
+	fill := secondsToDuration((float64(limit.Burst) - peek.tokens) / tokensPerSec)
+	q := newQuota(limit.Burst, wholeUnits(peek.tokens), now.Add(fill))
+	q.LastDenied = peek.lastDenied
+	return q, nil
+}
+
+// get returns the bucket for key, creating it if needed. Must be called with the lock held.
+func (tb *TokenBucket) get(key string, limit Limit, now time.Time) *bucket {
+	tb.sweep(now)
+
 	b, exists := tb.buckets[key]
 	if !exists {
-		b = &bucket{
-			tokens:     float64(limit.Burst),
-			lastUpdate: now,
+		b = tb.create(limit, now)
+		tb.store(key, b)
+	} else if tb.lru != nil {
+		tb.lru.MoveToFront(b.elem)
+	}
+	return b
+}
+
+// store sets the bucket of key, evicting the least recently used key if WithLRU's cap is
+// exceeded. Must be called with the lock held.
+func (tb *TokenBucket) store(key string, b *bucket) {
+	tb.remove(key)
+	tb.buckets[key] = b
+	if tb.lru == nil {
+		return
+	}
+	b.elem = tb.lru.PushFront(key)
+	if tb.lru.Len() > tb.maxKeys {
+		tb.remove(tb.lru.Back().Value.(string))
+	}
+}
+
+// remove drops the bucket of key and reports whether it had one. Must be called with the lock held.
+func (tb *TokenBucket) remove(key string) bool {
+	b, exists := tb.buckets[key]
+	if !exists {
+		return false
+	}
+	if tb.lru != nil {
+		tb.lru.Remove(b.elem)
+	}
+	delete(tb.buckets, key)
+	return true
+}
+
+// sweep drops the buckets left untouched for the WithCleanup duration, if a sweep is due.
+// Must be called with the lock held.
+func (tb *TokenBucket) sweep(now time.Time) {
+	if !tb.janitor.due(now) {
+		return
+	}
+	for key, b := range tb.buckets {
+		if now.Sub(b.lastUpdate) >= tb.janitor.idle {
+			tb.remove(key)
 		}
-		tb.buckets[key] = b
 	}
+}
+
+// create returns the bucket of a key seen for the first time: full, or holding the initial tokens.
+func (tb *TokenBucket) create(limit Limit, now time.Time) *bucket {
+	b := newBucket(limit, now)
+	if tb.initialTokens >= 0 && tb.initialTokens < b.tokens {
+		b.tokens = tb.initialTokens
+	}
+	return b
+}
 
+func newBucket(limit Limit, now time.Time) *bucket {
+	return &bucket{
+		tokens:     float64(limit.Burst),
+		lastUpdate: now,
+	}
+}
+
+// refill adds the tokens earned since the last update and returns the refill rate.
+func (b *bucket) refill(limit Limit, now time.Time) float64 {
 	// Calculate tokens to add
 	// Rate is requests per Period.
 	tokensPerSec := float64(limit.Rate) / limit.Period.Seconds()
 	elapsed := now.Sub(b.lastUpdate).Seconds()
+	if elapsed < 0 {
+		// The clock stepped backwards. Add nothing and keep lastUpdate, so the
+		// time until the clock catches up isn't credited twice.
+		return tokensPerSec
+	}
+	if tokensPerSec <= 0 {
+		// A zero Rate never refills
+		b.lastUpdate = now
+		return tokensPerSec
+	}
+	// Never add more than what fills the bucket, so long idle times or clock jumps
+	// can't overflow the product or lose precision
+	if fill := math.Max(0, float64(limit.Burst)-b.tokens) / tokensPerSec; elapsed > fill {
+		elapsed = fill
+	}
 
 	b.tokens += elapsed * tokensPerSec
 	if b.tokens > float64(limit.Burst) {
@@ -51,20 +259,126 @@ func (tb *TokenBucket) Allow(ctx context.Context, key string, limit Limit) (*Res
 	}
 	b.lastUpdate = now
 
-	result := &Result{}
+	return tokensPerSec
+}
+
+// tokenEpsilon is the shortfall below which a bucket is considered to hold the tokens asked for.
+const tokenEpsilon = 1e-9
 
-	if b.tokens >= 1.0 {
-		b.tokens -= 1.0
+// take refills the bucket up to now and consumes cost tokens if they are available.
+func (b *bucket) take(limit Limit, cost float64, now time.Time) *Result {
+	tokensPerSec := b.refill(limit, now)
+
+	result := newResult("token_bucket")
+
+	if exceedsBurst(cost, limit) {
+		// Only Peek and the calls deciding several keys get here, the others fail with
+		// ErrExceedsBurst. Waiting never helps, so there is no ResetAfter.
+		result.Reason = ReasonBurstExceeded
+		result.Remaining = wholeUnits(b.tokens)
+	} else if b.tokens >= cost-tokenEpsilon {
+		// Allow for the rounding error of many fractional costs, so ten requests of 0.1 fit in one token
+		b.tokens = math.Max(0, b.tokens-cost)
 		result.Allowed = true
-		result.Remaining = int(b.tokens)
+		result.Remaining = wholeUnits(b.tokens)
 		result.ResetAfter = 0
 	} else {
 		result.Allowed = false
-		result.Remaining = 0
-		// Time to wait for enough tokens for 1 request
-		waitSec := (1.0 - b.tokens) / tokensPerSec
-		result.ResetAfter = time.Duration(waitSec * float64(time.Second))
+		result.Reason = ReasonRateExceeded
+		// Smaller requests may still fit even though this one didn't
+		result.Remaining = wholeUnits(b.tokens)
+		// Time to wait for enough tokens for the request
+		waitSec := (cost - b.tokens) / tokensPerSec
+		result.ResetAfter = computeResetAfter(time.Duration(waitSec * float64(time.Second)))
 	}
 
-	return result, nil
+	return result
+}
+
+// Seed sets the bucket for key to hold tokens as of time at.
+// Tokens above the burst are kept until the next request caps them.
+func (tb *TokenBucket) Seed(ctx context.Context, key string, tokens float64, at time.Time) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.store(key, &bucket{
+		tokens:     tokens,
+		lastUpdate: at,
+	})
+	return nil
+}
+
+// reserve refills the bucket up to now and consumes cost tokens even if that puts the
+// bucket into debt. It returns how long the caller must wait for the debt to be repaid.
+func (b *bucket) reserve(limit Limit, cost float64, now time.Time) time.Duration {
+	tokensPerSec := b.refill(limit, now)
+
+	b.tokens -= cost
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / tokensPerSec * float64(time.Second))
+}
+
+// Refund returns n tokens to the bucket for key, capped at the burst size.
+func (tb *TokenBucket) Refund(ctx context.Context, key string, limit Limit, n int) error {
+	if limit.IsUnlimited() {
+		return nil
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	b, exists := tb.buckets[key]
+	if !exists {
+		return nil
+	}
+
+	b.tokens += float64(n)
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	return nil
+}
+
+// ActiveKeys returns the keys that hold less than one token under limit.
+func (tb *TokenBucket) ActiveKeys(ctx context.Context, limit Limit) ([]string, error) {
+	if limit.IsUnlimited() {
+		return nil, nil
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.clock.Now()
+	var keys []string
+	for key, b := range tb.buckets {
+		// Refill a copy so listing doesn't move the bucket's clock
+		peek := *b
+		peek.refill(limit, now)
+		if peek.tokens < 1 {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Reset clears the state of key, restoring its full budget.
+func (tb *TokenBucket) Reset(ctx context.Context, key string) (bool, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.remove(key), nil
+}
+
+// Flush clears the state of every key, restoring their full budgets.
+func (tb *TokenBucket) Flush(ctx context.Context) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.buckets = make(map[string]*bucket)
+	if tb.lru != nil {
+		tb.lru.Init()
+	}
+	return nil
 }
@@ -0,0 +1,337 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowNOverBurst(t *testing.T) {
+	ctx := context.Background()
+	tb := NewTokenBucket()
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 5}
+
+	res, err := tb.AllowN(ctx, "k", limit, 6)
+	if !errors.Is(err, ErrExceedsBurst) {
+		t.Fatalf("AllowN(6) error = %v, want ErrExceedsBurst", err)
+	}
+	if res != nil {
+		t.Fatalf("AllowN(6) result = %+v, want nil", res)
+	}
+
+	// The rejected request must not have touched the bucket
+	res, err = tb.AllowN(ctx, "k", limit, 5)
+	if err != nil || !res.Allowed {
+		t.Fatalf("AllowN(5) = %+v, %v, want allowed", res, err)
+	}
+}
+
+func TestTokenBucketAllowCostOverBurst(t *testing.T) {
+	tb := NewTokenBucket()
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 1}
+
+	if _, err := tb.AllowCost(context.Background(), "k", limit, 1.5); !errors.Is(err, ErrExceedsBurst) {
+		t.Fatalf("AllowCost(1.5) error = %v, want ErrExceedsBurst", err)
+	}
+}
+
+func TestTokenBucketZeroBurst(t *testing.T) {
+	ctx := context.Background()
+	tb := NewTokenBucket()
+	limit := Limit{Rate: 10, Period: time.Second}
+
+	// Nothing ever fits, so single requests fail like larger ones instead of being told to retry
+	if _, err := tb.Allow(ctx, "k", limit); !errors.Is(err, ErrExceedsBurst) {
+		t.Errorf("Allow() error = %v, want ErrExceedsBurst", err)
+	}
+	if _, err := tb.AllowCost(ctx, "k", limit, 0.5); !errors.Is(err, ErrExceedsBurst) {
+		t.Errorf("AllowCost(0.5) error = %v, want ErrExceedsBurst", err)
+	}
+
+	// The calls reporting per key deny with no time to wait
+	results, err := tb.AllowMulti(ctx, []KeyLimit{{Key: "k", Limit: limit}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peek := must(tb.Peek(ctx, "k", limit))
+	for name, res := range map[string]*Result{"AllowMulti": results[0], "Peek": peek} {
+		if res.Allowed || res.Reason != ReasonBurstExceeded || res.ResetAfter != 0 {
+			t.Errorf("%s = %+v, want denied as burst exceeded without a ResetAfter", name, res)
+		}
+	}
+}
+
+func TestBucketsZeroRate(t *testing.T) {
+	ctx := context.Background()
+	// The burst is never refilled, so nothing is ever admitted
+	limit := Limit{Rate: 0, Period: time.Second, Burst: 5}
+
+	for name, s := range map[string]Strategy{
+		"token_bucket": NewTokenBucket(),
+		"leaky_bucket": NewLeakyBucket(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.Allow(ctx, "k", limit); !errors.Is(err, ErrExceedsBurst) {
+				t.Errorf("Allow() error = %v, want ErrExceedsBurst", err)
+			}
+			peek := must(s.(Peekable).Peek(ctx, "k", limit))
+			if peek.Allowed || peek.Reason != ReasonBurstExceeded || peek.ResetAfter != 0 {
+				t.Errorf("Peek = %+v, want denied as burst exceeded without a ResetAfter", peek)
+			}
+			q, err := s.(QuotaReporter).Quota(ctx, "k", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Limit != 0 || q.Remaining != 0 || q.ResetAt.IsZero() {
+				t.Errorf("Quota = %+v, want nothing to admit", q)
+			}
+		})
+	}
+}
+
+func TestTokenBucketRemainingAcrossRefill(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	tb := NewTokenBucket(WithClock(clock))
+	// One token every 100ms
+	limit := Limit{Rate: 10, Period: time.Second, Burst: 3}
+
+	for _, step := range []struct {
+		advance       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{0, true, 2},
+		{0, true, 1},
+		{0, true, 0},
+		{0, false, 0},
+		// Half a token: still denied, and not rounded up to one
+		{50 * time.Millisecond, false, 0},
+		// One token: allowed, leaving nothing
+		{50 * time.Millisecond, true, 0},
+		// 2.5 tokens: floored before and after the request
+		{250 * time.Millisecond, true, 1},
+		{0, true, 0},
+		{0, false, 0},
+		// Refilled past the burst: capped
+		{time.Minute, true, 2},
+	} {
+		clock.Advance(step.advance)
+		res, err := tb.Allow(ctx, "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != step.wantAllowed || res.Remaining != step.wantRemaining {
+			t.Fatalf("after %s: allowed %v with %d remaining, want %v with %d",
+				step.advance, res.Allowed, res.Remaining, step.wantAllowed, step.wantRemaining)
+		}
+	}
+}
+
+func TestTokenBucketSeed(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	tb := NewTokenBucket(WithClock(clock))
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 5}
+
+	if err := tb.Seed(ctx, "low", 1, clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := exhaust(t, tb, "low", limit); n != 1 {
+		t.Errorf("seeded with 1 token, admitted %d", n)
+	}
+
+	// Seeding above the burst is accepted and capped on the next refill
+	if err := tb.Seed(ctx, "high", 50, clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := exhaust(t, tb, "high", limit); n != 5 {
+		t.Errorf("seeded with 50 tokens, admitted %d, want the burst of 5", n)
+	}
+
+	// Tokens refill from the seeding time
+	if err := tb.Seed(ctx, "past", 0, clock.Now().Add(-2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n := exhaust(t, tb, "past", limit); n != 2 {
+		t.Errorf("seeded empty 2s ago, admitted %d, want 2", n)
+	}
+}
+
+func TestTokenBucketAllowCostAccumulates(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	tb := NewTokenBucket(WithClock(clock))
+	// One token per second
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 2}
+
+	admitted := 0
+	for i := 0; i < 100; i++ {
+		res, err := tb.AllowCost(ctx, "k", limit, 0.1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			// 0.1 short of a whole request costs a tenth of a second
+			if res.ResetAfter <= 0 || res.ResetAfter > 100*time.Millisecond {
+				t.Errorf("ResetAfter = %s, want at most 100ms", res.ResetAfter)
+			}
+			break
+		}
+		admitted++
+	}
+	if admitted != 20 {
+		t.Fatalf("admitted %d requests of 0.1 from 2 tokens, want 20", admitted)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if n := exhaustCost(t, tb, limit, 0.1); n != 5 {
+		t.Fatalf("admitted %d after half a token refilled, want 5", n)
+	}
+}
+
+func TestTokenBucketAllowCostRejectsNonPositive(t *testing.T) {
+	tb := NewTokenBucket()
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 1}
+	for _, cost := range []float64{0, -0.5} {
+		if _, err := tb.AllowCost(context.Background(), "k", limit, cost); !errors.Is(err, ErrInvalidCost) {
+			t.Errorf("AllowCost(%v) error = %v, want ErrInvalidCost", cost, err)
+		}
+	}
+}
+
+// exhaustCost sends requests of cost for "k" until one is denied, and returns how many were allowed.
+func exhaustCost(t *testing.T, tb *TokenBucket, limit Limit, cost float64) int {
+	t.Helper()
+	for n := 0; n < 1000; n++ {
+		res, err := tb.AllowCost(context.Background(), "k", limit, cost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			return n
+		}
+	}
+	t.Fatal("never denied")
+	return 0
+}
+
+func TestTokenBucketLongIdleRefillsToBurst(t *testing.T) {
+	clock := newFakeClock()
+	tb := NewTokenBucket(WithClock(clock))
+	// A rate high enough that days of refill would overflow any sensible token count
+	limit := Limit{Rate: math.MaxInt32, Period: time.Nanosecond, Burst: 10}
+
+	exhaust(t, tb, "k", limit)
+	clock.Advance(30 * 24 * time.Hour)
+
+	res := must(tb.Allow(context.Background(), "k", limit))
+	if !res.Allowed || res.Remaining != limit.Burst-1 {
+		t.Fatalf("request after a month idle = %+v, want allowed with %d remaining", res, limit.Burst-1)
+	}
+	q, err := tb.Quota(context.Background(), "k", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Remaining != limit.Burst-1 {
+		t.Errorf("Quota.Remaining = %d, want %d", q.Remaining, limit.Burst-1)
+	}
+}
+
+func TestTokenBucketInitialTokensWarmup(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	tb := NewTokenBucket(WithInitialTokens(2), WithClock(clock))
+	// One token per second, up to 5
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 5}
+
+	if n := exhaust(t, tb, "k", limit); n != 2 {
+		t.Fatalf("new key admitted %d, want the 2 initial tokens", n)
+	}
+	for _, step := range []struct {
+		wait time.Duration
+		want int
+	}{
+		{time.Second, 1},
+		{3 * time.Second, 3},
+		// Filling never goes past the burst
+		{time.Hour, 5},
+	} {
+		clock.Advance(step.wait)
+		if n := exhaust(t, tb, "k", limit); n != step.want {
+			t.Errorf("after %s: admitted %d, want %d", step.wait, n, step.want)
+		}
+	}
+
+	// Initial tokens are capped at the burst, and a reset key starts low again
+	tb = NewTokenBucket(WithInitialTokens(100), WithClock(clock))
+	if n := exhaust(t, tb, "k", limit); n != 5 {
+		t.Errorf("initial tokens above the burst admitted %d, want 5", n)
+	}
+	tb = NewTokenBucket(WithInitialTokens(0), WithClock(clock))
+	if res := must(tb.Allow(ctx, "k", limit)); res.Allowed || res.ResetAfter != time.Second {
+		t.Errorf("first request with no initial tokens = %+v, want denied for a second", res)
+	}
+}
+
+func TestTokenBucketDenialTracking(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Period: time.Second, Burst: 1}
+
+	for name, newStrategy := range map[string]func(opts ...Option) QuotaReporter{
+		"token_bucket":         func(opts ...Option) QuotaReporter { return NewTokenBucket(opts...) },
+		"sharded_token_bucket": func(opts ...Option) QuotaReporter { return NewShardedTokenBucket(4, opts...) },
+	} {
+		clock := newFakeClock()
+		qr := newStrategy(WithDenialTracking(), WithClock(clock))
+		s := qr.(StrategyN)
+		lastDenied := func() time.Time {
+			q, err := qr.Quota(ctx, "k", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return q.LastDenied
+		}
+
+		must(s.Allow(ctx, "k", limit))
+		if got := lastDenied(); !got.IsZero() {
+			t.Errorf("%s: LastDenied after an allowed request = %s, want zero", name, got)
+		}
+
+		clock.Advance(100 * time.Millisecond)
+		denied := clock.Now()
+		if res := must(s.Allow(ctx, "k", limit)); res.Allowed {
+			t.Fatalf("%s: second request allowed", name)
+		}
+		if got := lastDenied(); !got.Equal(denied) {
+			t.Errorf("%s: LastDenied = %s, want the denial at %s", name, got, denied)
+		}
+
+		// Allowed requests and requests that can never fit leave it alone
+		clock.Advance(time.Second)
+		if res := must(s.Allow(ctx, "k", limit)); !res.Allowed {
+			t.Fatalf("%s: request after refilling denied", name)
+		}
+		if _, err := s.AllowN(ctx, "k", limit, 2); !errors.Is(err, ErrExceedsBurst) {
+			t.Fatalf("%s: oversized request error = %v, want ErrExceedsBurst", name, err)
+		}
+		if got := lastDenied(); !got.Equal(denied) {
+			t.Errorf("%s: LastDenied after an allowed request = %s, want still %s", name, got, denied)
+		}
+
+		clock.Advance(100 * time.Millisecond)
+		must(s.Allow(ctx, "k", limit))
+		if got := lastDenied(); !got.Equal(clock.Now()) {
+			t.Errorf("%s: LastDenied = %s, want the latest denial at %s", name, got, clock.Now())
+		}
+	}
+
+	// Off by default
+	tb := NewTokenBucket(WithClock(newFakeClock()))
+	exhaust(t, tb, "k", limit)
+	if q, _ := tb.Quota(ctx, "k", limit); !q.LastDenied.IsZero() {
+		t.Errorf("LastDenied without WithDenialTracking = %s, want zero", q.LastDenied)
+	}
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// AdaptiveConfig tunes the control loop of an AdaptiveLimiter.
+type AdaptiveConfig struct {
+	// TargetLatency is the handler latency above which the limit shrinks.
+	TargetLatency time.Duration
+	// Increase is added to the multiplier after every response within TargetLatency.
+	// Default: 0.01, so recovering from the minimum takes about a hundred good responses.
+	Increase float64
+	// Decrease multiplies the multiplier after every slow or failed (5xx) response.
+	// Default: 0.9.
+	Decrease float64
+	// MinMultiplier is the floor of the multiplier, so the limit never shuts traffic off
+	// entirely and latency can still be measured. Default: 0.1.
+	MinMultiplier float64
+}
+
+// AdaptiveLimiter shrinks limits while a slow downstream is struggling, using additive
+// increase, multiplicative decrease (AIMD) as in TCP congestion control.
+//
+// Its Middleware measures how long the wrapped handler takes. Every response slower than
+// TargetLatency, or failing with a 5xx status, multiplies the limit multiplier by Decrease;
+// every other response adds Increase, up to 1. Its LimitFunc scales the rate and burst of a
+// base limit by the multiplier. Put the Middleware inside the rate limiter, so only admitted
+// requests are measured:
+//
+//	adaptive := middleware.NewAdaptiveLimiter(middleware.AdaptiveConfig{TargetLatency: 200 * time.Millisecond})
+//	limit := middleware.New(middleware.Config{
+//		Limiter:   strategy,
+//		LimitFunc: adaptive.LimitFunc(baseLimitFunc),
+//	})
+//	handler := limit(adaptive.Middleware()(app))
+//
+// A single multiplier is shared by every key, since the downstream is shared too.
+type AdaptiveLimiter struct {
+	cfg AdaptiveConfig
+
+	mu         sync.Mutex
+	multiplier float64
+}
+
+// NewAdaptiveLimiter creates a new AdaptiveLimiter starting at the full limit.
+func NewAdaptiveLimiter(cfg AdaptiveConfig) *AdaptiveLimiter {
+	if cfg.Increase <= 0 {
+		cfg.Increase = 0.01
+	}
+	if cfg.Decrease <= 0 || cfg.Decrease >= 1 {
+		cfg.Decrease = 0.9
+	}
+	if cfg.MinMultiplier <= 0 {
+		cfg.MinMultiplier = 0.1
+	}
+	return &AdaptiveLimiter{
+		cfg:        cfg,
+		multiplier: 1,
+	}
+}
+
+// Observe feeds one response into the control loop.
+func (a *AdaptiveLimiter) Observe(latency time.Duration, failed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if failed || latency > a.cfg.TargetLatency {
+		a.multiplier = max(a.cfg.MinMultiplier, a.multiplier*a.cfg.Decrease)
+	} else {
+		a.multiplier = min(1, a.multiplier+a.cfg.Increase)
+	}
+}
+
+// Multiplier returns the fraction of the base limit currently granted, between MinMultiplier and 1.
+func (a *AdaptiveLimiter) Multiplier() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.multiplier
+}
+
+// LimitFunc returns a LimitFunc scaling the limits of base by the current multiplier,
+// never going below one request. Unlimited limits are passed through.
+func (a *AdaptiveLimiter) LimitFunc(base func(r *http.Request) limiter.Limit) func(r *http.Request) limiter.Limit {
+	return func(r *http.Request) limiter.Limit {
+		return scaleLimit(base(r), a.Multiplier())
+	}
+}
+
+// scaleLimit scales the rate and burst of limit by m, never going below one request.
+// Unlimited limits are returned unchanged.
+func scaleLimit(limit limiter.Limit, m float64) limiter.Limit {
+	if limit.IsUnlimited() {
+		return limit
+	}
+	limit.Rate = max(1, int(float64(limit.Rate)*m))
+	limit.Burst = max(1, int(float64(limit.Burst)*m))
+	return limit
+}
+
+// Middleware returns a middleware timing the next handler and feeding its latency and
+// status into the control loop.
+func (a *AdaptiveLimiter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(sw, r)
+			a.Observe(time.Since(start), sw.status >= http.StatusInternalServerError)
+		})
+	}
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. for Flush).
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+func TestAdaptiveLimiterControlLoop(t *testing.T) {
+	a := NewAdaptiveLimiter(AdaptiveConfig{TargetLatency: 100 * time.Millisecond, Increase: 0.1, Decrease: 0.5, MinMultiplier: 0.2})
+	approx := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+
+	// Latency spikes halve the limit down to the floor
+	for _, want := range []float64{0.5, 0.25, 0.2, 0.2} {
+		a.Observe(300*time.Millisecond, false)
+		if got := a.Multiplier(); !approx(got, want) {
+			t.Fatalf("multiplier after a slow response = %g, want %g", got, want)
+		}
+	}
+
+	// Fast responses win it back linearly, up to the full limit
+	for i := 0; i < 7; i++ {
+		a.Observe(10*time.Millisecond, false)
+	}
+	if got := a.Multiplier(); !approx(got, 0.9) {
+		t.Fatalf("multiplier after 7 fast responses = %g, want 0.9", got)
+	}
+	a.Observe(10*time.Millisecond, false)
+	a.Observe(10*time.Millisecond, false)
+	if got := a.Multiplier(); got != 1 {
+		t.Fatalf("multiplier = %g, want capped at 1", got)
+	}
+
+	// Errors count as spikes even when fast
+	a.Observe(time.Millisecond, true)
+	if got := a.Multiplier(); !approx(got, 0.5) {
+		t.Errorf("multiplier after a failure = %g, want 0.5", got)
+	}
+}
+
+func TestAdaptiveLimiterDefaults(t *testing.T) {
+	a := NewAdaptiveLimiter(AdaptiveConfig{TargetLatency: time.Second, Decrease: 1.5})
+	a.Observe(2*time.Second, false)
+	if got := a.Multiplier(); math.Abs(got-0.9) > 1e-9 {
+		t.Errorf("multiplier with an invalid Decrease = %g, want the default 0.9", got)
+	}
+	for i := 0; i < 100; i++ {
+		a.Observe(2*time.Second, false)
+	}
+	if got := a.Multiplier(); got != 0.1 {
+		t.Errorf("multiplier floor = %g, want the default 0.1", got)
+	}
+}
+
+func TestAdaptiveLimiterShrinksLimitOnSlowDownstream(t *testing.T) {
+	a := NewAdaptiveLimiter(AdaptiveConfig{TargetLatency: 20 * time.Millisecond, Increase: 0.25, Decrease: 0.5})
+	base := limiter.Limit{Rate: 100, Period: time.Minute, Burst: 100}
+
+	// The downstream's latency and status, changed by the test to simulate trouble
+	delay, status := time.Duration(0), http.StatusOK
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	})
+	h := New(Config{
+		Limiter:   limiter.NewTokenBucket(),
+		LimitFunc: a.LimitFunc(func(r *http.Request) limiter.Limit { return base }),
+	})(a.Middleware()(app))
+
+	// request serves one request and returns the limit advertised to it
+	request := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Header().Get("X-RateLimit-Limit")
+	}
+
+	if got := request(); got != "100" {
+		t.Fatalf("limit while healthy = %s, want 100", got)
+	}
+
+	delay = 50 * time.Millisecond
+	request()
+	request()
+	delay = 0
+	if got := request(); got != "25" {
+		t.Fatalf("limit after two slow responses = %s, want 25", got)
+	}
+
+	// That fast response raised the multiplier to 0.5, a failure halves it again
+	status = http.StatusBadGateway
+	request()
+	status = http.StatusOK
+	if got := request(); got != "25" {
+		t.Fatalf("limit after a failed response = %s, want 25", got)
+	}
+
+	// The fast responses since then recover the limit
+	for i := 0; i < 2; i++ {
+		request()
+	}
+	if got := request(); got != "100" {
+		t.Errorf("limit after recovering = %s, want 100", got)
+	}
+}
+
+func TestScaleLimit(t *testing.T) {
+	if got := scaleLimit(limiter.Unlimited, 0.1); got != limiter.Unlimited {
+		t.Errorf("scaled Unlimited = %+v, want it unchanged", got)
+	}
+	got := scaleLimit(limiter.Limit{Rate: 5, Period: time.Second, Burst: 3}, 0.1)
+	if got.Rate != 1 || got.Burst != 1 || got.Period != time.Second {
+		t.Errorf("scaled limit = %+v, want one request per second", got)
+	}
+}
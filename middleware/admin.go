@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// ResetHandler returns a handler admins can mount to clear a key's limit on demand.
+// keyFunc extracts the key to reset from the admin request (e.g. a query parameter),
+// and authorize must approve the caller before anything is reset.
+//
+// The endpoint lets anyone it authorizes lift rate limits, so authorize should check
+// real credentials (an admin token, mTLS identity, ...) and the handler should only be
+// mounted on an internal listener or path.
+//
+// It accepts POST and DELETE and responds with 204 on success, 404 if the key had no
+// state, 403 if unauthorized and 400 if no key was given.
+func ResetHandler(s limiter.Resettable, keyFunc KeyFunc, authorize func(r *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if authorize == nil || !authorize(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		key := keyFunc(r)
+		if key == "" {
+			http.Error(w, "Missing Key", http.StatusBadRequest)
+			return
+		}
+
+		existed, err := s.Reset(r.Context(), key)
+		if err != nil {
+			http.Error(w, "Rate Limit Internal Error", http.StatusInternalServerError)
+			return
+		}
+		if !existed {
+			http.Error(w, "Key Not Found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
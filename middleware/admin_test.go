@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+func TestResetHandler(t *testing.T) {
+	ctx := context.Background()
+	tb := limiter.NewTokenBucket()
+	limit := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	h := ResetHandler(tb,
+		func(r *http.Request) string { return r.URL.Query().Get("key") },
+		func(r *http.Request) bool { return r.Header.Get("X-Admin-Token") == "s3cret" },
+	)
+	reset := func(method, target, token string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("X-Admin-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tb.Allow(ctx, "client", limit)
+	if res, _ := tb.Allow(ctx, "client", limit); res.Allowed {
+		t.Fatal("client not limited before the reset")
+	}
+
+	if code := reset(http.MethodPost, "/reset?key=client", ""); code != http.StatusForbidden {
+		t.Fatalf("without a token: status %d, want 403", code)
+	}
+	if code := reset(http.MethodPost, "/reset?key=client", "wrong"); code != http.StatusForbidden {
+		t.Fatalf("with a wrong token: status %d, want 403", code)
+	}
+	if res, _ := tb.Allow(ctx, "client", limit); res.Allowed {
+		t.Fatal("unauthorized call reset the key")
+	}
+
+	if code := reset(http.MethodGet, "/reset?key=client", "s3cret"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET: status %d, want 405", code)
+	}
+	if code := reset(http.MethodPost, "/reset", "s3cret"); code != http.StatusBadRequest {
+		t.Fatalf("without a key: status %d, want 400", code)
+	}
+	if code := reset(http.MethodPost, "/reset?key=client", "s3cret"); code != http.StatusNoContent {
+		t.Fatalf("authorized: status %d, want 204", code)
+	}
+	if res, _ := tb.Allow(ctx, "client", limit); !res.Allowed {
+		t.Fatal("client still limited after the reset")
+	}
+	if code := reset(http.MethodDelete, "/reset?key=unknown", "s3cret"); code != http.StatusNotFound {
+		t.Fatalf("unknown key: status %d, want 404", code)
+	}
+}
+
+func TestResetHandlerNilAuthorizeDenies(t *testing.T) {
+	h := ResetHandler(limiter.NewTokenBucket(), func(r *http.Request) string { return "k" }, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// bandwidthKeySuffix keeps byte budgets apart from request budgets on a shared Strategy.
+const bandwidthKeySuffix = ":bw"
+
+// countingWriter counts the bytes of the response body.
+type countingWriter struct {
+	http.ResponseWriter
+	written int
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	cw.written += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. for Flush).
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// chargeBandwidth charges n bytes to key once the response has been written.
+// If the bucket can't cover them it is drained instead, so the client is denied
+// until it refills. The response itself has already been sent and is never truncated.
+func chargeBandwidth(ctx context.Context, s limiter.StrategyN, key string, limit limiter.Limit, n int) {
+	if n <= 0 {
+		return
+	}
+	// The bucket can never hold more than its burst
+	if n > limit.Burst {
+		n = limit.Burst
+	}
+
+	res, err := s.AllowN(ctx, key, limit, n)
+	if err != nil {
+		return
+	}
+	defer limiter.ReleaseResult(res)
+
+	if !res.Allowed && res.Remaining > 0 {
+		if drained, err := s.AllowN(ctx, key, limit, res.Remaining); err == nil {
+			limiter.ReleaseResult(drained)
+		}
+	}
+}
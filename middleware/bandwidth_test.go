@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+func TestBandwidthLimitChargesResponseBytes(t *testing.T) {
+	tb := limiter.NewTokenBucket()
+	bw := limiter.Limit{Rate: 100, Period: time.Minute, Burst: 100}
+	body := strings.Repeat("x", 60)
+	h := New(Config{
+		Limiter:        tb,
+		KeyFunc:        func(r *http.Request) string { return "client" },
+		LimitFunc:      func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 100, Period: time.Minute, Burst: 100} },
+		BandwidthLimit: bw,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != want {
+			t.Fatalf("request %d: status %d, want %d", i, rec.Code, want)
+		}
+		// The response over budget is still sent in full
+		if want == http.StatusOK && rec.Body.String() != body {
+			t.Fatalf("request %d: body truncated to %d bytes", i, rec.Body.Len())
+		}
+	}
+}
+
+func TestBandwidthLimitSkipsDeniedRequests(t *testing.T) {
+	ctx := context.Background()
+	tb := limiter.NewTokenBucket()
+	bw := limiter.Limit{Rate: 1000, Period: time.Minute, Burst: 1000}
+	h := New(Config{
+		Limiter:        tb,
+		KeyFunc:        func(r *http.Request) string { return "client" },
+		LimitFunc:      func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1} },
+		BandwidthLimit: bw,
+	})(okHandler)
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != want {
+			t.Fatalf("request %d: status %d, want %d", i, rec.Code, want)
+		}
+	}
+
+	// okHandler writes no body, and the denials must not have been charged either
+	q, err := tb.Quota(ctx, "client"+bandwidthKeySuffix, bw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Used != 0 {
+		t.Fatalf("%d bytes charged, want 0", q.Used)
+	}
+}
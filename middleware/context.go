@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// limitContextKey is the context key under which SetContextLimit stores a limit.
+type limitContextKey struct{}
+
+// SetContextLimit returns a copy of r carrying limit for WithContextLimit to pick up.
+// Call it from an authentication middleware that runs before the rate limiter, once the
+// client's tier is known:
+//
+//	func auth(next http.Handler) http.Handler {
+//		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//			limit := freeLimit
+//			if isPaid(r) {
+//				limit = paidLimit
+//			}
+//			next.ServeHTTP(w, middleware.SetContextLimit(r, limit))
+//		})
+//	}
+//
+//	handler := auth(middleware.New(middleware.Config{
+//		Limiter:   strategy,
+//		LimitFunc: middleware.WithContextLimit(freeLimit),
+//	})(app))
+func SetContextLimit(r *http.Request, limit limiter.Limit) *http.Request {
+	return r.WithContext(ContextWithLimit(r.Context(), limit))
+}
+
+// ContextWithLimit returns a copy of ctx carrying limit, see SetContextLimit.
+func ContextWithLimit(ctx context.Context, limit limiter.Limit) context.Context {
+	return context.WithValue(ctx, limitContextKey{}, limit)
+}
+
+// LimitFromContext returns the limit stored in ctx by SetContextLimit, if any.
+func LimitFromContext(ctx context.Context) (limiter.Limit, bool) {
+	limit, ok := ctx.Value(limitContextKey{}).(limiter.Limit)
+	return limit, ok
+}
+
+// WithContextLimit returns a LimitFunc that applies the limit stored in the request context
+// by SetContextLimit, or fallback when none was stored (e.g. unauthenticated requests).
+func WithContextLimit(fallback limiter.Limit) func(r *http.Request) limiter.Limit {
+	return func(r *http.Request) limiter.Limit {
+		if limit, ok := LimitFromContext(r.Context()); ok {
+			return limit
+		}
+		return fallback
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+func TestWithContextLimitFromAuth(t *testing.T) {
+	free := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	paid := limiter.Limit{Rate: 3, Period: time.Minute, Burst: 3}
+
+	// The tier is only known once the auth middleware ran
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "Bearer paid" {
+				r = SetContextLimit(r, paid)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := auth(New(Config{
+		Limiter:   limiter.NewTokenBucket(),
+		KeyFunc:   func(r *http.Request) string { return r.Header.Get("Authorization") },
+		LimitFunc: WithContextLimit(free),
+	})(okHandler))
+
+	for _, tc := range []struct {
+		token string
+		limit limiter.Limit
+	}{
+		{"Bearer paid", paid},
+		// Unauthenticated requests get the fallback
+		{"", free},
+	} {
+		for i := 0; i <= tc.limit.Rate; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", tc.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			want := http.StatusOK
+			if i == tc.limit.Rate {
+				want = http.StatusTooManyRequests
+			}
+			if rec.Code != want {
+				t.Errorf("%q request %d: status %d, want %d", tc.token, i, rec.Code, want)
+			}
+			if got := rec.Header().Get("X-RateLimit-Limit"); got != headerInt(tc.limit.Rate) {
+				t.Errorf("%q request %d: X-RateLimit-Limit = %q, want %d", tc.token, i, got, tc.limit.Rate)
+			}
+		}
+	}
+}
+
+func TestLimitFromContext(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := LimitFromContext(req.Context()); ok {
+		t.Fatal("limit found in a bare context")
+	}
+	want := limiter.Limit{Rate: 5, Period: time.Second, Burst: 5}
+	got, ok := LimitFromContext(SetContextLimit(req, want).Context())
+	if !ok || got != want {
+		t.Errorf("LimitFromContext = %+v, %t, want %+v", got, ok, want)
+	}
+}
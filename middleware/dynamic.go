@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// LimitTable maps a selector value (e.g. an endpoint or a user tier) to its limit.
+type LimitTable map[string]limiter.Limit
+
+// DynamicLimits holds a limit table that can be replaced while requests are in flight,
+// e.g. from a goroutine watching a config file or a remote source.
+type DynamicLimits struct {
+	table    atomic.Value // LimitTable
+	selector func(r *http.Request) string
+	fallback limiter.Limit
+}
+
+// NewDynamicLimits creates a new DynamicLimits. selector picks the table entry for a request,
+// and fallback is used when the table has no entry for it.
+func NewDynamicLimits(selector func(r *http.Request) string, fallback limiter.Limit, table LimitTable) *DynamicLimits {
+	d := &DynamicLimits{
+		selector: selector,
+		fallback: fallback,
+	}
+	d.Update(table)
+	return d
+}
+
+// Update atomically replaces the limit table. It is safe to call concurrently with requests.
+// The table is copied, so the caller may keep modifying its own map.
+func (d *DynamicLimits) Update(table LimitTable) {
+	copied := make(LimitTable, len(table))
+	for k, v := range table {
+		copied[k] = v
+	}
+	d.table.Store(copied)
+}
+
+// LimitFunc returns a LimitFunc that reads the current table on every request.
+func (d *DynamicLimits) LimitFunc() func(r *http.Request) limiter.Limit {
+	return func(r *http.Request) limiter.Limit {
+		table := d.table.Load().(LimitTable)
+		if limit, ok := table[d.selector(r)]; ok {
+			return limit
+		}
+		return d.fallback
+	}
+}
+
+// StrictUnknownPaths returns a LimitFunc that applies lenient to requests under one of the
+// known path prefixes and strict to everything else, so scanners probing random paths run
+// out of budget quickly without per-route configuration. A prefix matches whole path
+// segments: "/api" matches "/api" and "/api/users" but not "/apix".
+func StrictUnknownPaths(known []string, lenient, strict limiter.Limit) func(r *http.Request) limiter.Limit {
+	prefixes := append([]string(nil), known...)
+	return func(r *http.Request) limiter.Limit {
+		for _, prefix := range prefixes {
+			if hasPathPrefix(r.URL.Path, prefix) {
+				return lenient
+			}
+		}
+		return strict
+	}
+}
+
+// hasPathPrefix reports whether path is prefix or lies below it.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// RegionLimitFunc returns a LimitFunc picking the limit by the region of the client, e.g.
+// a country code, as resolved from its IP by resolve (typically a GeoIP database lookup,
+// which the package leaves to the caller). Regions without an entry in limits, empty
+// regions and unparsable addresses get def.
+//
+// resolve is given the IP of r.RemoteAddr, without the port. Behind proxies that is the
+// proxy's address, so have a middleware earlier in the chain set RemoteAddr to the real
+// client IP first.
+func RegionLimitFunc(resolve func(ip string) string, limits LimitTable, def limiter.Limit) func(r *http.Request) limiter.Limit {
+	return func(r *http.Request) limiter.Limit {
+		ip, ok := parseIP(r.RemoteAddr)
+		if !ok {
+			return def
+		}
+		region := resolve(ip.String())
+		if limit, ok := limits[region]; ok && region != "" {
+			return limit
+		}
+		return def
+	}
+}
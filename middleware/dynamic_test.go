@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+func TestDynamicLimitsUpdateConcurrentWithRequests(t *testing.T) {
+	low := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	high := limiter.Limit{Rate: 100, Period: time.Minute, Burst: 100}
+	fallback := limiter.Limit{Rate: 5, Period: time.Minute, Burst: 5}
+
+	d := NewDynamicLimits(func(r *http.Request) string { return r.URL.Path }, fallback, LimitTable{"/search": low})
+	limitFunc := d.LimitFunc()
+	h := New(Config{Limiter: limiter.NewTokenBucket(), LimitFunc: limitFunc})(okHandler)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				d.Update(LimitTable{"/search": high})
+			} else {
+				d.Update(LimitTable{"/search": low})
+			}
+		}
+	}()
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				req := httptest.NewRequest(http.MethodGet, "/search", nil)
+				if got := limitFunc(req); got != low && got != high {
+					t.Errorf("LimitFunc = %+v, want one of the tables' limits", got)
+					return
+				}
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	d.Update(LimitTable{"/search": high})
+	if got := limitFunc(httptest.NewRequest(http.MethodGet, "/search", nil)); got != high {
+		t.Errorf("after the last update: %+v, want %+v", got, high)
+	}
+	if got := limitFunc(httptest.NewRequest(http.MethodGet, "/other", nil)); got != fallback {
+		t.Errorf("unknown path: %+v, want the fallback", got)
+	}
+}
+
+func TestDynamicLimitsCopiesTable(t *testing.T) {
+	low := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	table := LimitTable{"/search": low}
+	d := NewDynamicLimits(func(r *http.Request) string { return r.URL.Path }, limiter.Limit{}, table)
+
+	table["/search"] = limiter.Limit{Rate: 100, Period: time.Minute, Burst: 100}
+	if got := d.LimitFunc()(httptest.NewRequest(http.MethodGet, "/search", nil)); got != low {
+		t.Errorf("LimitFunc = %+v after the caller modified its map, want %+v", got, low)
+	}
+}
+
+func TestStrictUnknownPaths(t *testing.T) {
+	lenient := limiter.Limit{Rate: 100, Period: time.Minute, Burst: 100}
+	strict := limiter.Limit{Rate: 2, Period: time.Minute, Burst: 2}
+	known := []string{"/api", "/static/"}
+	limitFunc := StrictUnknownPaths(known, lenient, strict)
+
+	for path, want := range map[string]limiter.Limit{
+		"/api":            lenient,
+		"/api/users":      lenient,
+		"/static/app.js":  lenient,
+		"/apix":           strict,
+		"/wp-login.php":   strict,
+		"/.env":           strict,
+		"/static":         strict,
+		"/admin/api/keys": strict,
+	} {
+		if got := limitFunc(httptest.NewRequest(http.MethodGet, path, nil)); got != want {
+			t.Errorf("%s: limit %+v, want %+v", path, got, want)
+		}
+	}
+
+	// The known prefixes are copied
+	known[0] = "/other"
+	if got := limitFunc(httptest.NewRequest(http.MethodGet, "/api", nil)); got != lenient {
+		t.Errorf("/api after changing the caller's slice: limit %+v, want lenient", got)
+	}
+}
+
+func TestRegionLimitFunc(t *testing.T) {
+	strict := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	relaxed := limiter.Limit{Rate: 100, Period: time.Minute, Burst: 100}
+	def := limiter.Limit{Rate: 10, Period: time.Minute, Burst: 10}
+
+	var resolved []string
+	// A stub GeoIP lookup
+	resolve := func(ip string) string {
+		resolved = append(resolved, ip)
+		switch ip {
+		case "203.0.113.7":
+			return "XX"
+		case "198.51.100.1", "2001:db8::1":
+			return "YY"
+		case "192.0.2.1":
+			return "ZZ"
+		}
+		return ""
+	}
+	limitFunc := RegionLimitFunc(resolve, LimitTable{"XX": strict, "YY": relaxed, "": strict}, def)
+
+	for _, tc := range []struct {
+		remoteAddr string
+		want       limiter.Limit
+		wantIP     string
+	}{
+		{"203.0.113.7:4242", strict, "203.0.113.7"},
+		{"198.51.100.1:80", relaxed, "198.51.100.1"},
+		{"[2001:db8::1]:443", relaxed, "2001:db8::1"},
+		// IPv4-mapped IPv6 addresses resolve as IPv4
+		{"[::ffff:203.0.113.7]:80", strict, "203.0.113.7"},
+		// A region without an entry
+		{"192.0.2.1:80", def, "192.0.2.1"},
+		// An unresolved region gets the default, even with an entry for ""
+		{"10.0.0.1:80", def, "10.0.0.1"},
+		// Unparsable addresses never reach the resolver
+		{"not-an-ip", def, ""},
+		{"", def, ""},
+	} {
+		resolved = nil
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if got := limitFunc(req); got != tc.want {
+			t.Errorf("%q: limit %+v, want %+v", tc.remoteAddr, got, tc.want)
+		}
+		var want []string
+		if tc.wantIP != "" {
+			want = []string{tc.wantIP}
+		}
+		if !slices.Equal(resolved, want) {
+			t.Errorf("%q: resolver given %q, want %q", tc.remoteAddr, resolved, want)
+		}
+	}
+}
+
+func TestRegionLimitFuncInMiddleware(t *testing.T) {
+	cfg := Config{
+		Limiter: limiter.NewTokenBucket(),
+		LimitFunc: RegionLimitFunc(func(ip string) string { return "XX" },
+			LimitTable{"XX": {Rate: 1, Period: time.Minute, Burst: 1}},
+			limiter.Limit{Rate: 10, Period: time.Minute, Burst: 10}),
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	serve(cfg, req)
+	if rec := serve(cfg, req); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second request from a strict region: status %d, want 429", rec.Code)
+	}
+}
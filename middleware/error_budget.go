@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// ErrorBudgetConfig sets the SLO an ErrorBudgetLimiter defends.
+type ErrorBudgetConfig struct {
+	// SLOTarget is the fraction of responses that must succeed, e.g. 0.999. The error budget
+	// is the remainder: 0.1% of responses may fail. Default: 0.99.
+	SLOTarget float64
+	// Window is the rolling window the error rate is measured over. Default: one minute.
+	Window time.Duration
+	// MinRequests is how many responses the window must hold before the limit is reduced,
+	// so a single failure on a quiet service doesn't throttle it. Default: 20.
+	MinRequests int
+	// MinMultiplier is the floor of the multiplier, so some traffic always gets through to
+	// show whether the downstream has recovered. Default: 0.1.
+	MinMultiplier float64
+	// Clock is the time source of the rolling window. Default: the system clock.
+	Clock limiter.Clock
+}
+
+// ErrorBudgetLimiter shrinks limits while a downstream burns its error budget faster than
+// its SLO allows, in the style of SRE burn-rate alerts.
+//
+// Its Middleware records whether each response failed (5xx status). The burn rate is the
+// error rate over the rolling window divided by the error budget: 1 means the budget is
+// being spent exactly as fast as the SLO allows, 10 means ten times faster. While the burn
+// rate is above 1, the LimitFunc scales the rate and burst of a base limit by 1/burn rate
+// (but not below MinMultiplier), so admitted traffic drops as errors rise.
+//
+// This closes a feedback loop: fewer requests relieve the downstream, the error rate falls
+// and the limit recovers as failed responses age out of the window. Put the Middleware
+// inside the rate limiter, so only admitted requests are measured:
+//
+//	budget := middleware.NewErrorBudgetLimiter(middleware.ErrorBudgetConfig{SLOTarget: 0.999})
+//	limit := middleware.New(middleware.Config{
+//		Limiter:   strategy,
+//		LimitFunc: budget.LimitFunc(baseLimitFunc),
+//	})
+//	handler := limit(budget.Middleware()(app))
+//
+// A single error rate is shared by every key, since the downstream is shared too.
+type ErrorBudgetLimiter struct {
+	cfg ErrorBudgetConfig
+	now func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	curr        budgetCounts
+	prev        budgetCounts
+}
+
+type budgetCounts struct {
+	total  int
+	failed int
+}
+
+// NewErrorBudgetLimiter creates a new ErrorBudgetLimiter starting at the full limit.
+func NewErrorBudgetLimiter(cfg ErrorBudgetConfig) *ErrorBudgetLimiter {
+	if cfg.SLOTarget <= 0 || cfg.SLOTarget >= 1 {
+		cfg.SLOTarget = 0.99
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.MinMultiplier <= 0 || cfg.MinMultiplier > 1 {
+		cfg.MinMultiplier = 0.1
+	}
+	now := time.Now
+	if cfg.Clock != nil {
+		now = cfg.Clock.Now
+	}
+	return &ErrorBudgetLimiter{
+		cfg:         cfg,
+		now:         now,
+		windowStart: now(),
+	}
+}
+
+// Observe records one response.
+func (e *ErrorBudgetLimiter) Observe(failed bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.advance(e.now())
+	e.curr.total++
+	if failed {
+		e.curr.failed++
+	}
+}
+
+// BurnRate returns how many times faster than the SLO allows the error budget is being
+// spent over the rolling window. It is zero until the window holds MinRequests responses.
+func (e *ErrorBudgetLimiter) BurnRate() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	e.advance(now)
+
+	// Like SlidingWindow, weight the previous window by how much of it still overlaps
+	weight := 1 - float64(now.Sub(e.windowStart))/float64(e.cfg.Window)
+	total := float64(e.curr.total) + float64(e.prev.total)*weight
+	failed := float64(e.curr.failed) + float64(e.prev.failed)*weight
+	if total < float64(e.cfg.MinRequests) {
+		return 0
+	}
+	// Round away float noise from 1-SLOTarget, so spending the budget exactly as fast as the
+	// SLO allows gives a burn rate of 1 rather than a hair above it
+	burn := failed / total / (1 - e.cfg.SLOTarget)
+	return math.Round(burn*1e9) / 1e9
+}
+
+// Multiplier returns the fraction of the base limit currently granted, between MinMultiplier and 1.
+func (e *ErrorBudgetLimiter) Multiplier() float64 {
+	burn := e.BurnRate()
+	if burn <= 1 {
+		return 1
+	}
+	return max(e.cfg.MinMultiplier, 1/burn)
+}
+
+// advance moves the window forward to now. Must be called with the lock held.
+func (e *ErrorBudgetLimiter) advance(now time.Time) {
+	elapsed := now.Sub(e.windowStart)
+	if elapsed < e.cfg.Window {
+		return
+	}
+	if elapsed < 2*e.cfg.Window {
+		e.prev = e.curr
+	} else {
+		e.prev = budgetCounts{}
+	}
+	e.curr = budgetCounts{}
+	e.windowStart = e.windowStart.Add(elapsed.Truncate(e.cfg.Window))
+}
+
+// LimitFunc returns a LimitFunc scaling the limits of base by the current multiplier,
+// never going below one request. Unlimited limits are passed through.
+func (e *ErrorBudgetLimiter) LimitFunc(base func(r *http.Request) limiter.Limit) func(r *http.Request) limiter.Limit {
+	return func(r *http.Request) limiter.Limit {
+		return scaleLimit(base(r), e.Multiplier())
+	}
+}
+
+// Middleware returns a middleware recording whether each response of the next handler failed.
+func (e *ErrorBudgetLimiter) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			e.Observe(sw.status >= http.StatusInternalServerError)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// fakeClock is a limiter.Clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// observe records ok successful and failed failed responses.
+func observe(e *ErrorBudgetLimiter, ok, failed int) {
+	for i := 0; i < ok; i++ {
+		e.Observe(false)
+	}
+	for i := 0; i < failed; i++ {
+		e.Observe(true)
+	}
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestErrorBudgetExhaustion(t *testing.T) {
+	clock := newFakeClock()
+	// A 10% error budget over a minute
+	e := NewErrorBudgetLimiter(ErrorBudgetConfig{SLOTarget: 0.9, MinRequests: 10, Clock: clock})
+
+	// Errors on a quiet service don't count yet
+	observe(e, 0, 9)
+	if burn := e.BurnRate(); burn != 0 {
+		t.Fatalf("burn rate below MinRequests = %v, want 0", burn)
+	}
+
+	// Within budget: 1 failure in 10 spends it exactly as fast as the SLO allows
+	e = NewErrorBudgetLimiter(ErrorBudgetConfig{SLOTarget: 0.9, MinRequests: 10, Clock: clock})
+	observe(e, 9, 1)
+	if burn := e.BurnRate(); !approx(burn, 1) {
+		t.Fatalf("burn rate at 10%% errors = %v, want 1", burn)
+	}
+	if m := e.Multiplier(); m != 1 {
+		t.Fatalf("multiplier within budget = %v, want 1", m)
+	}
+
+	// 5 failures in 20 burn the budget 2.5 times too fast
+	observe(e, 6, 4)
+	if burn := e.BurnRate(); !approx(burn, 2.5) {
+		t.Fatalf("burn rate at 25%% errors = %v, want 2.5", burn)
+	}
+	if m := e.Multiplier(); !approx(m, 0.4) {
+		t.Errorf("multiplier = %v, want 1/2.5", m)
+	}
+	base := func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 100, Period: time.Minute, Burst: 50} }
+	if got := e.LimitFunc(base)(nil); got.Rate != 40 || got.Burst != 20 || got.Period != time.Minute {
+		t.Errorf("scaled limit = %+v, want 40 per minute with a burst of 20", got)
+	}
+
+	// With a 1% budget, a fully failing downstream burns it 100 times too fast, but the
+	// limit bottoms out at MinMultiplier
+	e = NewErrorBudgetLimiter(ErrorBudgetConfig{SLOTarget: 0.99, MinRequests: 10, Clock: clock})
+	observe(e, 0, 10)
+	if burn := e.BurnRate(); !approx(burn, 100) {
+		t.Fatalf("burn rate with every response failing = %v, want 100", burn)
+	}
+	if m := e.Multiplier(); m != 0.1 {
+		t.Errorf("multiplier with every response failing = %v, want the 0.1 floor", m)
+	}
+	if got := e.LimitFunc(base)(nil); got.Rate != 10 || got.Burst != 5 {
+		t.Errorf("floored limit = %+v, want 10 per minute with a burst of 5", got)
+	}
+}
+
+func TestErrorBudgetRecovers(t *testing.T) {
+	clock := newFakeClock()
+	e := NewErrorBudgetLimiter(ErrorBudgetConfig{SLOTarget: 0.9, MinRequests: 10, MinMultiplier: 0.25, Clock: clock})
+
+	observe(e, 10, 10)
+	if burn := e.BurnRate(); !approx(burn, 5) {
+		t.Fatalf("burn rate = %v, want 5", burn)
+	}
+	if m := e.Multiplier(); m != 0.25 {
+		t.Fatalf("multiplier = %v, want the 0.25 floor", m)
+	}
+
+	// Half way through the next window, half the old failures still count, diluted by successes
+	clock.Advance(time.Minute + 30*time.Second)
+	observe(e, 10, 0)
+	// (10*0.5 failed) / (20*0.5 + 10 total) = 25% errors
+	if burn := e.BurnRate(); !approx(burn, 2.5) {
+		t.Fatalf("burn rate half a window later = %v, want 2.5", burn)
+	}
+	if m := e.Multiplier(); !approx(m, 0.4) {
+		t.Errorf("multiplier half a window later = %v, want 0.4", m)
+	}
+
+	// Once the failures have aged out, the full limit is back
+	clock.Advance(time.Minute)
+	observe(e, 10, 0)
+	if m := e.Multiplier(); m != 1 {
+		t.Errorf("multiplier after the failures aged out = %v, want 1", m)
+	}
+
+	// A long quiet spell forgets everything
+	observe(e, 0, 20)
+	clock.Advance(time.Hour)
+	if burn := e.BurnRate(); burn != 0 {
+		t.Errorf("burn rate after an hour without responses = %v, want 0", burn)
+	}
+}
+
+func TestErrorBudgetFeedbackLoop(t *testing.T) {
+	clock := newFakeClock()
+	budget := NewErrorBudgetLimiter(ErrorBudgetConfig{SLOTarget: 0.9, MinRequests: 10, Clock: clock})
+	failing := true
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	h := New(Config{
+		Limiter: limiter.NewTokenBucket(limiter.WithClock(clock)),
+		KeyFunc: GlobalKeyFunc(),
+		LimitFunc: budget.LimitFunc(func(r *http.Request) limiter.Limit {
+			return limiter.Limit{Rate: 100, Period: time.Minute, Burst: 20}
+		}),
+	})(budget.Middleware()(app))
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	// The failing downstream spends the budget, and the limit drops to the floor of 10 per
+	// minute with a burst of 2, less than the 10 tokens left in the bucket
+	for i := 0; i < 10; i++ {
+		if code := serve(); code != http.StatusServiceUnavailable {
+			t.Fatalf("request %d: status %d, want the downstream's 503", i, code)
+		}
+	}
+	admitted := 0
+	for i := 0; i < 10; i++ {
+		if serve() != http.StatusTooManyRequests {
+			admitted++
+		}
+	}
+	if admitted != 2 {
+		t.Fatalf("admitted %d requests while the budget burns, want the throttled burst of 2", admitted)
+	}
+
+	// The downstream recovers and the failures age out: the full limit is back
+	failing = false
+	clock.Advance(2 * time.Minute)
+	admitted = 0
+	for i := 0; i < 20; i++ {
+		if serve() == http.StatusOK {
+			admitted++
+		}
+	}
+	if admitted != 20 {
+		t.Errorf("admitted %d requests after recovering, want the full burst of 20", admitted)
+	}
+}
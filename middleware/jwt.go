@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// ClaimsParser extracts the verified claims of the request's JWT. It should return an error
+// for missing, malformed or unverified tokens. The package doesn't parse JWTs itself, so
+// plug in the library you already use, e.g. one validating the Authorization header.
+type ClaimsParser func(r *http.Request) (map[string]interface{}, error)
+
+// JWTKeyFunc returns a KeyFunc that keys requests by a JWT claim, as "<claim>:<value>",
+// e.g. "sub:1234". Requests whose token fails to parse, or lacks the claim, fall back to
+// the client address. Make sure parse verifies the signature: otherwise clients can pick
+// any key, and with it a fresh budget, by forging tokens.
+func JWTKeyFunc(claim string, parse ClaimsParser) KeyFunc {
+	return func(r *http.Request) string {
+		value, ok := claimValue(r, claim, parse)
+		if !ok {
+			return DefaultKeyFunc(r)
+		}
+		return claim + ":" + value
+	}
+}
+
+// JWTLimitFunc returns a LimitFunc picking the limit from a JWT claim, e.g. a "plan" claim
+// holding "free" or "pro". Requests whose token fails to parse, lacks the claim, or whose
+// claim value has no entry in limits get fallback.
+func JWTLimitFunc(claim string, parse ClaimsParser, limits LimitTable, fallback limiter.Limit) func(r *http.Request) limiter.Limit {
+	return func(r *http.Request) limiter.Limit {
+		value, ok := claimValue(r, claim, parse)
+		if !ok {
+			return fallback
+		}
+		if limit, ok := limits[value]; ok {
+			return limit
+		}
+		return fallback
+	}
+}
+
+// claimValue returns the claim of the request's token as a string, if present.
+func claimValue(r *http.Request, claim string, parse ClaimsParser) (string, bool) {
+	claims, err := parse(r)
+	if err != nil {
+		return "", false
+	}
+	v, ok := claims[claim]
+	if !ok || v == nil {
+		return "", false
+	}
+	s := fmt.Sprint(v)
+	return s, s != ""
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// stubClaims stands for a JWT library, mapping bearer tokens to their verified claims.
+func stubClaims(tokens map[string]map[string]interface{}) ClaimsParser {
+	return func(r *http.Request) (map[string]interface{}, error) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return nil, errors.New("no token")
+		}
+		claims, ok := tokens[token]
+		if !ok {
+			return nil, errors.New("invalid token")
+		}
+		return claims, nil
+	}
+}
+
+func withToken(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestJWTKeyFunc(t *testing.T) {
+	parse := stubClaims(map[string]map[string]interface{}{
+		"alice":   {"sub": "alice", "plan": "pro"},
+		"numeric": {"sub": float64(1234)}, // JSON numbers decode to float64
+		"nosub":   {"plan": "free"},
+	})
+	keyFunc := JWTKeyFunc("sub", parse)
+
+	for token, want := range map[string]string{
+		"alice":   "sub:alice",
+		"numeric": "sub:1234",
+		"nosub":   "10.0.0.1:1234",
+		"forged":  "10.0.0.1:1234",
+		"":        "10.0.0.1:1234",
+	} {
+		if got := keyFunc(withToken(token)); got != want {
+			t.Errorf("token %q: key %q, want %q", token, got, want)
+		}
+	}
+}
+
+func TestJWTLimitFunc(t *testing.T) {
+	free := limiter.Limit{Rate: 10, Period: time.Minute, Burst: 10}
+	pro := limiter.Limit{Rate: 1000, Period: time.Minute, Burst: 1000}
+	fallback := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	parse := stubClaims(map[string]map[string]interface{}{
+		"alice":   {"plan": "pro"},
+		"bob":     {"plan": "free"},
+		"carol":   {"plan": "enterprise"},
+		"dave":    {"sub": "dave"},
+		"nilplan": {"plan": nil},
+	})
+	limitFunc := JWTLimitFunc("plan", parse, LimitTable{"free": free, "pro": pro}, fallback)
+
+	for token, want := range map[string]limiter.Limit{
+		"alice":   pro,
+		"bob":     free,
+		"carol":   fallback, // No entry for the plan
+		"dave":    fallback, // No plan claim
+		"nilplan": fallback,
+		"forged":  fallback,
+		"":        fallback,
+	} {
+		if got := limitFunc(withToken(token)); got != want {
+			t.Errorf("token %q: limit %+v, want %+v", token, got, want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KeyFunc computes the rate limit key from the request.
+type KeyFunc func(r *http.Request) string
+
+// DefaultKeyFunc is the KeyFunc used when Config.KeyFunc is nil. It keys requests by
+// r.RemoteAddr, the address of the immediate peer, which is a proxy's address when the
+// service runs behind one (see ClientIPKeyFunc). Wrap it to build on the default.
+func DefaultKeyFunc(r *http.Request) string {
+	return r.RemoteAddr // Simple default, usually you want X-Forwarded-For or similar
+}
+
+// GlobalKeyFunc returns a KeyFunc that maps every request to the same key,
+// so all clients share a single budget.
+func GlobalKeyFunc() KeyFunc {
+	return func(r *http.Request) string {
+		return "global"
+	}
+}
+
+// APIKeyFunc returns a KeyFunc that keys requests by the API key found in header.
+// The key is replaced by its hex SHA-256 hash so secrets never reach the limiter's
+// storage or logs. The hash is one-way and collision resistant, which is all keying needs.
+// Requests without the header fall back to the client address.
+func APIKeyFunc(header string) KeyFunc {
+	return func(r *http.Request) string {
+		apiKey := r.Header.Get(header)
+		if apiKey == "" {
+			return DefaultKeyFunc(r)
+		}
+
+		sum := sha256.Sum256([]byte(apiKey))
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// CookieKeyFunc returns a KeyFunc that keys requests by the value of the cookie named
+// cookieName, typically a session ID, as "cookie:<hex>". Like APIKeyFunc, the value is
+// replaced by its hex SHA-256 hash, since session IDs are credentials too. Values are
+// URL-decoded first, so encoded and plain spellings of a value share a key. When the
+// request carries several cookies with that name (e.g. set for different paths), the first
+// non-empty one wins, which browsers send for the most specific path. Requests without the
+// cookie use fallback, or the client address if fallback is nil.
+func CookieKeyFunc(cookieName string, fallback KeyFunc) KeyFunc {
+	if fallback == nil {
+		fallback = DefaultKeyFunc
+	}
+	return func(r *http.Request) string {
+		for _, c := range r.Cookies() {
+			if c.Name != cookieName || c.Value == "" {
+				continue
+			}
+			value := c.Value
+			if decoded, err := url.QueryUnescape(value); err == nil {
+				value = decoded
+			}
+			sum := sha256.Sum256([]byte(value))
+			return "cookie:" + hex.EncodeToString(sum[:])
+		}
+		return fallback(r)
+	}
+}
+
+// MTLSKeyFunc returns a KeyFunc that keys requests by the common name of the verified TLS
+// client certificate, as "cn:<name>", for limiting service-to-service traffic over mTLS.
+// The identity holds for every stream multiplexed on an HTTP/2 or HTTP/3 connection.
+// Requests without a client certificate, or whose certificate has no common name, use
+// fallback, or the client address if fallback is nil.
+//
+// Only certificates the server verified are trusted, so configure the TLS server with
+// ClientAuth set to tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert.
+func MTLSKeyFunc(fallback KeyFunc) KeyFunc {
+	if fallback == nil {
+		fallback = DefaultKeyFunc
+	}
+	return func(r *http.Request) string {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			if cn := r.TLS.VerifiedChains[0][0].Subject.CommonName; cn != "" {
+				return "cn:" + cn
+			}
+		}
+		return fallback(r)
+	}
+}
+
+// BodyHashKeyFunc returns a KeyFunc that appends the SHA-256 of the request body to the key
+// computed by base, as "<key>:body:<hex>", so identical submissions from a client share a
+// budget separate from its other requests (e.g. limit retries of the same payment to one
+// per minute). Requests without a body use base's key unchanged.
+//
+// The body has to be buffered in memory to be hashed, then is handed to the next handler
+// unchanged. To bound that cost, only bodies of up to maxBytes are hashed: larger ones are
+// streamed through unbuffered, apart from the first maxBytes+1 bytes, and keyed as
+// "<key>:body:oversized". Read errors are treated the same way.
+func BodyHashKeyFunc(base KeyFunc, maxBytes int64) KeyFunc {
+	if base == nil {
+		base = DefaultKeyFunc
+	}
+	return func(r *http.Request) string {
+		key := base(r)
+		if r.Body == nil || r.Body == http.NoBody {
+			return key
+		}
+
+		buf, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+		// Put back what was read in front of the rest of the body
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buf), r.Body), Closer: r.Body}
+		if err != nil || int64(len(buf)) > maxBytes {
+			return key + ":body:oversized"
+		}
+
+		sum := sha256.Sum256(buf)
+		return key + ":body:" + hex.EncodeToString(sum[:])
+	}
+}
+
+// readCloser joins a reader with the closer of the body it replays.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// CombineKeys returns a KeyFunc joining the keys of funcs with sep, in order, e.g.
+// CombineKeys("|", DefaultKeyFunc, APIKeyFunc("X-API-Key")) to limit each API key per client
+// address. Nil funcs are skipped. An empty key keeps its place, so "a|" and "|a" stay
+// distinct, but when every key is empty the result is empty too, for Config.EmptyKeyMode to
+// handle. Pick a sep that can't occur in the keys, or different combinations may collide.
+func CombineKeys(sep string, funcs ...KeyFunc) KeyFunc {
+	var nonNil []KeyFunc
+	for _, f := range funcs {
+		if f != nil {
+			nonNil = append(nonNil, f)
+		}
+	}
+	return func(r *http.Request) string {
+		parts := make([]string, len(nonNil))
+		empty := true
+		for i, f := range nonNil {
+			parts[i] = f(r)
+			empty = empty && parts[i] == ""
+		}
+		if empty {
+			return ""
+		}
+		return strings.Join(parts, sep)
+	}
+}
+
+// PathParamKeyFunc returns a KeyFunc that keys requests by the path parameters named params
+// plus the client address, e.g. PathParamKeyFunc("/orders/{id}", "id") keys a request to
+// "/orders/42" as "<addr>:id=42", limiting each client per order. The pattern is matched
+// segment by segment: "{name}" matches any single non-empty segment and other segments must
+// match exactly; a trailing slash is ignored. Colons in values are escaped, so distinct
+// values never share a key. Paths that don't match the pattern are keyed by the client
+// address alone, sharing one budget per client.
+//
+// It panics if a name in params doesn't appear in pattern, as that is a programming error.
+func PathParamKeyFunc(pattern string, params ...string) KeyFunc {
+	segments := splitPath(pattern)
+	index := make(map[string]int)
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			index[seg[1:len(seg)-1]] = i
+		}
+	}
+	positions := make([]int, len(params))
+	for i, name := range params {
+		pos, ok := index[name]
+		if !ok {
+			panic(fmt.Sprintf("middleware: path parameter %q not in pattern %q", name, pattern))
+		}
+		positions[i] = pos
+	}
+
+	return func(r *http.Request) string {
+		key := DefaultKeyFunc(r)
+
+		path := splitPath(r.URL.Path)
+		if len(path) != len(segments) {
+			return key
+		}
+		for i, seg := range segments {
+			isParam := strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+			if (isParam && path[i] == "") || (!isParam && path[i] != seg) {
+				return key
+			}
+		}
+
+		var b strings.Builder
+		b.WriteString(key)
+		for i, name := range params {
+			b.WriteString(":" + name + "=" + escapeKeyPart(path[positions[i]]))
+		}
+		return b.String()
+	}
+}
+
+// splitPath splits a URL path into its segments, ignoring the leading and trailing slashes.
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+func TestAPIKeyFunc(t *testing.T) {
+	keyFunc := APIKeyFunc("X-API-Key")
+	withKey := func(apiKey string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		return req
+	}
+
+	a, b := keyFunc(withKey("secret-1")), keyFunc(withKey("secret-1"))
+	if a != b {
+		t.Fatalf("same API key hashed to %q and %q", a, b)
+	}
+	if len(a) != 64 || strings.Contains(a, "secret") {
+		t.Fatalf("key %q is not a hex SHA-256 hash of the API key", a)
+	}
+	if c := keyFunc(withKey("secret-2")); c == a {
+		t.Fatal("different API keys share a key")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if got := keyFunc(req); got != "10.0.0.1:1234" {
+		t.Fatalf("request without the header keyed by %q, want the client address", got)
+	}
+}
+
+func TestCookieKeyFunc(t *testing.T) {
+	keyFunc := CookieKeyFunc("session", nil)
+	withCookies := func(header string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if header != "" {
+			req.Header.Set("Cookie", header)
+		}
+		return req
+	}
+	hashed := func(value string) string {
+		sum := sha256.Sum256([]byte(value))
+		return "cookie:" + hex.EncodeToString(sum[:])
+	}
+
+	for _, tc := range []struct {
+		name, cookies, want string
+	}{
+		{"present", "session=abc123", hashed("abc123")},
+		{"among others", "theme=dark; session=abc123; lang=en", hashed("abc123")},
+		// Encoded and plain spellings of a value share a key
+		{"url-encoded", "session=abc%3D", hashed("abc=")},
+		{"plain", "session=abc=", hashed("abc=")},
+		{"not url-encoded", "session=100%", hashed("100%")},
+		{"first of several", "session=path-specific; session=site-wide", hashed("path-specific")},
+		{"empty ones skipped", "session=; session=site-wide", hashed("site-wide")},
+		{"absent", "theme=dark", "10.0.0.1:1234"},
+		{"only empty", "session=", "10.0.0.1:1234"},
+		{"no cookies", "", "10.0.0.1:1234"},
+	} {
+		if got := keyFunc(withCookies(tc.cookies)); got != tc.want {
+			t.Errorf("%s: key %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	fallback := CookieKeyFunc("session", func(r *http.Request) string { return "anonymous" })
+	if got := fallback(withCookies("theme=dark")); got != "anonymous" {
+		t.Errorf("key with a fallback = %q, want anonymous", got)
+	}
+}
+
+func TestMTLSKeyFunc(t *testing.T) {
+	cert := func(cn string) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	}
+	withTLS := func(state *tls.ConnectionState) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.TLS = state
+		return req
+	}
+
+	keyFunc := MTLSKeyFunc(nil)
+	for _, tc := range []struct {
+		name  string
+		state *tls.ConnectionState
+		want  string
+	}{
+		{"verified", &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert("billing")},
+			VerifiedChains:   [][]*x509.Certificate{{cert("billing"), cert("internal-ca")}},
+		}, "cn:billing"},
+		// A certificate the server didn't verify is not trusted
+		{"unverified", &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert("billing")},
+		}, "10.0.0.1:1234"},
+		{"no common name", &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert("")}},
+		}, "10.0.0.1:1234"},
+		{"plain HTTP", nil, "10.0.0.1:1234"},
+	} {
+		if got := keyFunc(withTLS(tc.state)); got != tc.want {
+			t.Errorf("%s: key %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	fallback := MTLSKeyFunc(func(r *http.Request) string { return "anonymous" })
+	if got := fallback(withTLS(nil)); got != "anonymous" {
+		t.Errorf("key with a fallback = %q, want anonymous", got)
+	}
+}
+
+func TestBodyHashKeyFunc(t *testing.T) {
+	keyFunc := BodyHashKeyFunc(nil, 16)
+	post := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		return req
+	}
+
+	a, b := keyFunc(post(`{"amount":5}`)), keyFunc(post(`{"amount":5}`))
+	if a != b {
+		t.Fatalf("identical bodies keyed %q and %q", a, b)
+	}
+	if !strings.HasPrefix(a, "10.0.0.1:1234:body:") {
+		t.Fatalf("key %q doesn't extend the base key", a)
+	}
+	if c := keyFunc(post(`{"amount":6}`)); c == a {
+		t.Fatal("different bodies share a key")
+	}
+
+	get := httptest.NewRequest(http.MethodGet, "/", nil)
+	get.RemoteAddr = "10.0.0.1:1234"
+	if got := keyFunc(get); got != "10.0.0.1:1234" {
+		t.Errorf("key without a body = %q, want the base key", got)
+	}
+
+	// Bodies over the cap aren't hashed but reach the handler whole
+	big := strings.Repeat("x", 100)
+	req := post(big)
+	if got := keyFunc(req); got != "10.0.0.1:1234:body:oversized" {
+		t.Errorf("key of an oversized body = %q", got)
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != big {
+		t.Errorf("handler read %d bytes of the oversized body, want all %d", len(body), len(big))
+	}
+
+	// So do hashed ones
+	req = post("hello")
+	keyFunc(req)
+	if body, _ := io.ReadAll(req.Body); string(body) != "hello" {
+		t.Errorf("handler read %q, want the original body", body)
+	}
+}
+
+func TestBodyHashKeyFuncLimitsIdenticalSubmissions(t *testing.T) {
+	h := New(Config{
+		Limiter:   limiter.NewTokenBucket(),
+		KeyFunc:   BodyHashKeyFunc(nil, 1024),
+		LimitFunc: func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1} },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	submit := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := submit("order-1"); rec.Code != http.StatusOK || rec.Body.String() != "order-1" {
+		t.Fatalf("first submission: status %d, body %q", rec.Code, rec.Body.String())
+	}
+	if rec := submit("order-1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("retried submission: status %d, want 429", rec.Code)
+	}
+	if rec := submit("order-2"); rec.Code != http.StatusOK {
+		t.Errorf("different submission: status %d, want 200", rec.Code)
+	}
+}
+
+func TestCombineKeys(t *testing.T) {
+	header := func(name string) KeyFunc {
+		return func(r *http.Request) string { return r.Header.Get(name) }
+	}
+	req := func(a, b string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r.Header.Set("A", a)
+		r.Header.Set("B", b)
+		return r
+	}
+	method := func(r *http.Request) string { return r.Method }
+	path := func(r *http.Request) string { return r.URL.Path }
+
+	for _, tc := range []struct {
+		name string
+		key  KeyFunc
+		req  *http.Request
+		want string
+	}{
+		{"in order", CombineKeys("|", method, path, header("A")), req("x", ""), "GET|/orders|x"},
+		{"order matters", CombineKeys("|", header("A"), path, method), req("x", ""), "x|/orders|GET"},
+		{"multi-character separator", CombineKeys("::", method, path), req("", ""), "GET::/orders"},
+		{"empty separator", CombineKeys("", method, path), req("", ""), "GET/orders"},
+		{"nil funcs skipped", CombineKeys("|", nil, method, nil, path, nil), req("", ""), "GET|/orders"},
+		{"single func", CombineKeys("|", method), req("", ""), "GET"},
+		{"no funcs", CombineKeys("|"), req("", ""), ""},
+		{"only nil funcs", CombineKeys("|", nil, nil), req("", ""), ""},
+		// Empty keys keep their place
+		{"empty first", CombineKeys("|", header("A"), header("B")), req("", "y"), "|y"},
+		{"empty last", CombineKeys("|", header("A"), header("B")), req("y", ""), "y|"},
+		// But all empty is empty, for EmptyKeyMode
+		{"all empty", CombineKeys("|", header("A"), header("B")), req("", ""), ""},
+	} {
+		if got := tc.key(tc.req); got != tc.want {
+			t.Errorf("%s: key %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCombineKeysAllEmptyHitsEmptyKeyMode(t *testing.T) {
+	header := func(name string) KeyFunc {
+		return func(r *http.Request) string { return r.Header.Get(name) }
+	}
+	cfg := Config{
+		Limiter:      limiter.NewTokenBucket(),
+		KeyFunc:      CombineKeys("|", header("X-Tenant"), header("X-User")),
+		EmptyKeyMode: EmptyKeyDeny,
+	}
+
+	if rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusForbidden {
+		t.Errorf("request without any key part: status %d, want 403", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User", "alice")
+	if rec := serve(cfg, req); rec.Code != http.StatusOK {
+		t.Errorf("request with one key part: status %d, want 200", rec.Code)
+	}
+}
+
+func TestPathParamKeyFunc(t *testing.T) {
+	orders := PathParamKeyFunc("/orders/{id}", "id")
+	items := PathParamKeyFunc("/orders/{order}/items/{item}", "item", "order")
+	request := func(path, addr string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		req.RemoteAddr = addr
+		return req
+	}
+
+	for _, tc := range []struct {
+		name    string
+		keyFunc KeyFunc
+		path    string
+		want    string
+	}{
+		{"matched", orders, "/orders/42", "10.0.0.1:1234:id=42"},
+		{"trailing slash", orders, "/orders/42/", "10.0.0.1:1234:id=42"},
+		{"params in the given order", items, "/orders/42/items/7", "10.0.0.1:1234:item=7:order=42"},
+		{"colon in a value", orders, "/orders/a:b", "10.0.0.1:1234:id=a%3Ab"},
+		// Unmatched paths fall back to the client address
+		{"other literal", orders, "/invoices/42", "10.0.0.1:1234"},
+		{"too short", orders, "/orders", "10.0.0.1:1234"},
+		{"too long", orders, "/orders/42/items", "10.0.0.1:1234"},
+		{"empty parameter", orders, "/orders//", "10.0.0.1:1234"},
+		{"root", orders, "/", "10.0.0.1:1234"},
+	} {
+		if got := tc.keyFunc(request(tc.path, "10.0.0.1:1234")); got != tc.want {
+			t.Errorf("%s: %s keyed as %q, want %q", tc.name, tc.path, got, tc.want)
+		}
+	}
+
+	// Distinct values and clients never share a key, even when values look like separators
+	keys := map[string]bool{}
+	for _, path := range []string{"/orders/3/items/1:order=2", "/orders/2:order=3/items/1"} {
+		keys[items(request(path, "10.0.0.1:1234"))] = true
+	}
+	keys[orders(request("/orders/42", "10.0.0.2:1234"))] = true
+	keys[orders(request("/orders/42", "10.0.0.1:1234"))] = true
+	if len(keys) != 4 {
+		t.Errorf("keys %v collide", keys)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("PathParamKeyFunc with a parameter missing from the pattern didn't panic")
+		}
+	}()
+	PathParamKeyFunc("/orders/{id}", "order")
+}
+
+func TestPathParamKeyFuncLimitsPerResource(t *testing.T) {
+	h := New(Config{
+		Limiter:   limiter.NewTokenBucket(),
+		KeyFunc:   PathParamKeyFunc("/orders/{id}", "id"),
+		LimitFunc: func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1} },
+	})(okHandler)
+	status := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if status("/orders/1") != http.StatusOK || status("/orders/2") != http.StatusOK {
+		t.Fatal("first request to each order denied")
+	}
+	if code := status("/orders/1"); code != http.StatusTooManyRequests {
+		t.Errorf("second request to order 1: status %d, want 429", code)
+	}
+	// Unmatched paths share the client's own budget
+	if status("/health") != http.StatusOK {
+		t.Fatal("first unmatched request denied")
+	}
+	if code := status("/metrics"); code != http.StatusTooManyRequests {
+		t.Errorf("second unmatched request: status %d, want 429 from the shared client budget", code)
+	}
+}
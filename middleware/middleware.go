@@ -1,8 +1,12 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alibaba/rate-limiter-go/limiter"
@@ -13,68 +17,441 @@ type Config struct {
 	Limiter limiter.Strategy
 	// KeyFunc computes the rate limit key from the request.
 	// Common examples: IP address, User ID (from context), API Key.
-	KeyFunc func(r *http.Request) string
+	KeyFunc KeyFunc
 	// LimitFunc returns the limit configuration for the request.
-	// This allows dynamic limits per user/endpoint.
+	// This allows dynamic limits per user/endpoint. Return limiter.Unlimited
+	// to let a request through without charging any budget (e.g. admin traffic).
 	LimitFunc func(r *http.Request) limiter.Limit
+	// Overrides gives specific keys, as returned by KeyFunc, their own limit, e.g. one API
+	// key 1000/min while LimitFunc gives everyone else 100/min. Keys without an entry get
+	// LimitFunc's limit. The map is copied by New, so later changes to it have no effect;
+	// to change overrides at runtime, use a DynamicLimits selecting on the key as LimitFunc.
+	// Ignored when KeysFunc is set.
+	Overrides LimitTable
+	// KeysFunc limits the request on several dimensions at once (e.g. IP, user and endpoint),
+	// each with its own key and limit. The request is allowed only if every key allows it,
+	// and no key is charged otherwise. When set, LimitFunc is ignored, and KeyFunc is only
+	// called to key BandwidthLimit.
+	// The rate limit headers describe the most restrictive key: the denying one,
+	// or the one with the fewest requests remaining.
+	KeysFunc func(r *http.Request) []KeyedLimit
 	// ErrorHandler handles internal errors from the limiter (e.g. Redis down).
-	// Default: Log and continue (Fail Open) or returns 500? Often Fail Open is safer.
+	// When set, it overrides FailureMode and writes the response itself.
 	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+	// FailureMode decides what happens to a request when the limiter fails and there is no
+	// ErrorHandler: FailWithError (default) answers 500, FailOpen lets it through to the next
+	// handler unlimited (PeekMethod requests get a bare 200), and FailClosed answers 503
+	// Service Unavailable.
+	FailureMode FailureMode
 	// RateLimitHandler handles requests allowed/denied logic customization.
-	// If nil, default 429 response is used when denied.
+	// If nil, default 429 response is used when denied, with a body formatted per DenialBody.
+	// The result is recycled once the request completes, so it must not be retained.
 	RateLimitHandler func(w http.ResponseWriter, r *http.Request, res *limiter.Result)
+	// OnDecision is called once after every limiter check, allowed, denied or failed, with
+	// the key and limit that decided it (for KeysFunc, the key reported in the headers).
+	// The key is the effective one, after NamespaceFunc and any prefix added by the limiter
+	// (see limiter.AllowWithKey).
+	// res is nil when err is set. It runs before the middleware sets any header or writes the
+	// response, so headers it adds are sent, except X-RateLimit-* and Retry-After which the
+	// middleware overwrites. Like in RateLimitHandler, res must not be retained.
+	OnDecision func(r *http.Request, key string, limit limiter.Limit, res *limiter.Result, err error)
+	// MethodFilter reports whether requests with the given HTTP method are limited.
+	// Other methods go straight to the next handler. Default: all methods are limited.
+	MethodFilter func(method string) bool
+	// PeekMethod lets clients check their quota without consuming it, typically "HEAD".
+	// Requests with this method are answered by the middleware itself with 200 OK and the
+	// X-RateLimit headers (plus Retry-After when the next request would be denied), using
+	// Peek instead of Allow; the next handler is not called. It requires Limiter to implement
+	// limiter.Peekable and KeysFunc to be nil, otherwise such requests are limited as usual.
+	PeekMethod string
+	// SkipOnHeader bypasses limiting when a request header matches the configured value
+	// (compared case-insensitively), e.g. {"X-Cache": "HIT"} to avoid charging the origin
+	// for cache hits. Clients can send any header they like, so only use headers set by
+	// trusted infrastructure (a CDN or proxy that strips or overwrites client values).
+	SkipOnHeader map[string]string
+	// NamespaceFunc isolates tenants that share keys (e.g. client IPs behind a gateway).
+	// A non-empty result is prepended to every key as "namespace:key", including the keys
+	// from KeysFunc. Default: no namespace.
+	NamespaceFunc func(r *http.Request) string
+	// EmptyKeyMode decides what happens when KeyFunc returns an empty string, e.g. because
+	// the client couldn't be identified. The default, EmptyKeyTreatAsGlobal, makes all such
+	// requests share one budget, which is rarely what you want for a key that should never
+	// be empty: one misbehaving source then throttles every unidentified client.
+	EmptyKeyMode EmptyKeyMode
+	// DenialBody selects the body of the default 429 response.
+	// Default: BodyNegotiate (JSON if the client accepts it, plain text otherwise).
+	DenialBody BodyFormat
+	// RemainingPercentHeader additionally sends X-RateLimit-Remaining-Pct, the remaining
+	// requests as a whole percentage (0-100) of X-RateLimit-Limit, for dashboards.
+	// It is omitted when the limit is zero or unlimited.
+	RemainingPercentHeader bool
+	// RetryAfterFormat selects how Retry-After is rendered: FormatSeconds (default) or
+	// FormatHTTPDate. The JSON bodies' retry_after is always in seconds.
+	RetryAfterFormat RetryAfterFormat
+	// MaxRetryAfter caps the wait advertised in Retry-After and the JSON bodies, for slow
+	// limits (e.g. 1/day) whose hints some clients reject as too large. Only the hint is
+	// capped: clients retrying sooner are simply denied again. Zero means no cap.
+	MaxRetryAfter time.Duration
+	// RetryAfterFunc overrides the wait advertised to denied requests in Retry-After and the
+	// JSON bodies, e.g. to advise a longer backoff on a flaky endpoint than its limit alone
+	// would. It receives the denied result; returning res.ResetAfter keeps the default.
+	// MaxRetryAfter still caps the returned wait. Default: res.ResetAfter.
+	RetryAfterFunc func(r *http.Request, res *limiter.Result) time.Duration
+	// ErrorRetryAfter is the Retry-After sent with the 500 and 503 responses of FailWithError
+	// and FailClosed when the limiter fails (e.g. Redis down), so clients back off during an
+	// outage instead of retrying at once. It is also the least wait advertised when a strategy
+	// fails closed, denying with limiter.ReasonBackendError. Responses written by ErrorHandler
+	// are left alone. Default: one second; a negative value sends no Retry-After.
+	ErrorRetryAfter time.Duration
+	// SoftLimitThreshold (0-1) is the fraction of the limit a client may consume before
+	// being warned. Once reached, allowed requests get an "X-RateLimit-Warning: true" header
+	// and OnSoftLimit is called, so clients can slow down before they are denied.
+	// Zero disables the warning.
+	SoftLimitThreshold float64
+	// OnSoftLimit is called for allowed requests that crossed SoftLimitThreshold.
+	OnSoftLimit func(r *http.Request, res *limiter.Result)
+	// BandwidthLimit caps the response bytes per period for each key of KeyFunc, even when
+	// KeysFunc is set (Rate bytes per Period, with Burst as the bucket size). The charge is
+	// post-hoc: a response is never truncated, it is counted after being written, and once
+	// the budget is spent later requests are denied until it refills. Only responses of the wrapped handler are charged, requests
+	// denied by the middleware cost no bandwidth. Requires Limiter to implement
+	// limiter.StrategyN. A zero Rate disables bandwidth limiting.
+	BandwidthLimit limiter.Limit
+	// Grace lets the first Grace over-limit requests of each period through anyway, with an
+	// "X-RateLimit-Warning: grace" header, before requests are denied. Grace requests are
+	// counted on the same Limiter under a separate key, which refills Grace requests per
+	// period of the limit. This effectively raises the burst, and the sustained rate, by
+	// Grace per period; it only gives clients that overshoot a warning before a hard 429.
+	// Denials from KeysFunc limits don't get grace. Zero disables it.
+	Grace int
 }
 
+// EmptyKeyMode selects how requests with an empty key are handled, see Config.EmptyKeyMode.
+type EmptyKeyMode int
+
+const (
+	// EmptyKeyTreatAsGlobal limits all requests with an empty key together under the "" key.
+	EmptyKeyTreatAsGlobal EmptyKeyMode = iota
+	// EmptyKeyAllow lets requests with an empty key through without limiting them.
+	EmptyKeyAllow
+	// EmptyKeyDeny rejects requests with an empty key with 403 Forbidden.
+	EmptyKeyDeny
+)
+
+// FailureMode selects how requests are handled when the limiter fails, see Config.FailureMode.
+type FailureMode int
+
+const (
+	// FailWithError answers 500 Internal Server Error, surfacing the outage.
+	FailWithError FailureMode = iota
+	// FailOpen lets the request through unlimited, favouring availability over protection.
+	FailOpen
+	// FailClosed answers 503 Service Unavailable, protecting the backend at the cost of availability.
+	FailClosed
+)
+
+// KeyedLimit is a single dimension of a multi-dimensional limit, see Config.KeysFunc.
+type KeyedLimit = limiter.KeyLimit
+
 // New creates a new HTTP middleware handler
+//
+// Responses carry X-RateLimit-Limit (the rate) and X-RateLimit-Remaining, except for
+// Unlimited limits, which have neither. Both values are capped at math.MaxInt32, so clients
+// parsing them as 32-bit integers never overflow.
 func New(cfg Config) func(http.Handler) http.Handler {
 	if cfg.KeyFunc == nil {
-		cfg.KeyFunc = func(r *http.Request) string {
-			return r.RemoteAddr // Simple default, usually you want X-Forwarded-For or similar
-		}
+		cfg.KeyFunc = DefaultKeyFunc
 	}
 	if cfg.LimitFunc == nil {
-		// Default strict limit
-		cfg.LimitFunc = func(r *http.Request) limiter.Limit {
-			return limiter.Limit{
-				Rate:   10,
-				Period: time.Minute,
-				Burst:  10,
-			}
+		cfg.LimitFunc = DefaultLimitFunc
+	}
+	if cfg.ErrorRetryAfter == 0 {
+		cfg.ErrorRetryAfter = time.Second
+	}
+	overrides := make(LimitTable, len(cfg.Overrides))
+	for key, limit := range cfg.Overrides {
+		overrides[key] = limit
+	}
+	limitFor := func(r *http.Request, key string) limiter.Limit {
+		if limit, ok := overrides[key]; ok {
+			return limit
 		}
+		return cfg.LimitFunc(r)
+	}
+	retryAfterFor := func(r *http.Request, res *limiter.Result) time.Duration {
+		if cfg.RetryAfterFunc != nil {
+			return cfg.RetryAfterFunc(r, res)
+		}
+		return res.ResetAfter
+	}
+
+	peeker, _ := cfg.Limiter.(limiter.Peekable)
+	if cfg.PeekMethod == "" || cfg.KeysFunc != nil {
+		peeker = nil
+	}
+
+	bandwidth, _ := cfg.Limiter.(limiter.StrategyN)
+	if cfg.BandwidthLimit.Rate == 0 {
+		bandwidth = nil
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := cfg.KeyFunc(r)
-			limit := cfg.LimitFunc(r)
+			// Replaced below for this request only, to see whether it reached the handler
+			next := next
+			if cfg.MethodFilter != nil && !cfg.MethodFilter(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for header, value := range cfg.SkipOnHeader {
+				if strings.EqualFold(r.Header.Get(header), value) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			// KeysFunc replaces KeyFunc, which may be expensive (e.g. BodyHashKeyFunc), so
+			// it only runs when it keys something
+			var key string
+			if cfg.KeysFunc == nil || bandwidth != nil {
+				key = cfg.KeyFunc(r)
+			}
+			if key == "" && cfg.KeysFunc == nil {
+				switch cfg.EmptyKeyMode {
+				case EmptyKeyAllow:
+					next.ServeHTTP(w, r)
+					return
+				case EmptyKeyDeny:
+					writeDenied(w, r, cfg.DenialBody, denial{
+						status:  http.StatusForbidden,
+						message: "Forbidden",
+						detail:  "The client could not be identified for rate limiting.",
+					})
+					return
+				}
+			}
+			baseKey := key
+			ns := ""
+			if cfg.NamespaceFunc != nil {
+				if ns = cfg.NamespaceFunc(r); ns != "" {
+					ns += ":"
+					key = ns + key
+				}
+			}
+
+			peeking := peeker != nil && r.Method == cfg.PeekMethod
+
+			if bandwidth != nil && !peeking {
+				bwKey := key + bandwidthKeySuffix
+				res, err := bandwidth.AllowN(r.Context(), bwKey, cfg.BandwidthLimit, 1)
+				if err == nil && !res.Allowed {
+					retryAfter := retryAfterSeconds(retryAfterFor(r, res), cfg.MaxRetryAfter)
+					limiter.ReleaseResult(res)
+					setRetryAfter(w, cfg.RetryAfterFormat, retryAfter)
+					writeDenied(w, r, cfg.DenialBody, denial{
+						status:     http.StatusTooManyRequests,
+						message:    "Bandwidth Limit Exceeded",
+						detail:     fmt.Sprintf("Bandwidth limit of %s bytes exceeded.", cfg.BandwidthLimit),
+						retryAfter: &retryAfter,
+						reason:     limiter.ReasonRateExceeded,
+					})
+					return
+				}
+				limiter.ReleaseResult(res)
 
-			res, err := cfg.Limiter.Allow(r.Context(), key, limit)
+				// Only responses of the handler are charged, not the denials written below
+				cw := &countingWriter{ResponseWriter: w}
+				served := false
+				handler := next
+				next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					served = true
+					handler.ServeHTTP(w, r)
+				})
+				defer func() {
+					if served && cw.written > 0 {
+						// The first byte was charged up front
+						chargeBandwidth(r.Context(), bandwidth, bwKey, cfg.BandwidthLimit, cw.written-1)
+						return
+					}
+					// Give back the byte charged up front, nothing was sent
+					if refunder, ok := bandwidth.(limiter.Refunder); ok {
+						_ = refunder.Refund(r.Context(), bwKey, cfg.BandwidthLimit, 1)
+					}
+				}()
+				w = cw
+			}
+
+			var (
+				res   *limiter.Result
+				limit limiter.Limit
+				err   error
+			)
+			// decidedKey is the key reported to OnDecision. key stays the request's own, since
+			// the grace check goes through the limiter again and would add its prefix twice.
+			decidedKey := key
+			if cfg.KeysFunc != nil {
+				keys := cfg.KeysFunc(r)
+				if len(keys) == 0 {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if ns != "" {
+					namespaced := make([]KeyedLimit, len(keys))
+					for i, k := range keys {
+						namespaced[i] = KeyedLimit{Key: ns + k.Key, Limit: k.Limit}
+					}
+					keys = namespaced
+				}
+
+				var i int
+				res, i, err = limiter.AllowAll(r.Context(), cfg.Limiter, keys)
+				if i < 0 {
+					i = 0
+				}
+				decidedKey, limit = keys[i].Key, keys[i].Limit
+			} else if peeking {
+				limit = limitFor(r, baseKey)
+				res, err = peeker.Peek(r.Context(), key, limit)
+			} else {
+				limit = limitFor(r, baseKey)
+				res, decidedKey, err = limiter.AllowWithKey(r.Context(), cfg.Limiter, key, limit)
+			}
+			if cfg.OnDecision != nil {
+				cfg.OnDecision(r, decidedKey, limit, res, err)
+			}
+
+			if errors.Is(err, limiter.ErrExceedsBurst) {
+				// The request can never fit in the bucket, so don't suggest a retry.
+				writeDenied(w, r, cfg.DenialBody, burstDenial(limit))
+				return
+			}
 			if err != nil {
 				if cfg.ErrorHandler != nil {
 					cfg.ErrorHandler(w, r, err)
 					return
 				}
-				// Default behavior for error: Log and Fail Open (Allow) to avoid blocking traffic on DB failure,
-				// OR Fail Closed. Here we'll return 500 for safety of awareness.
+				if cfg.FailureMode == FailOpen {
+					if peeking {
+						// There is no quota to report, and peeks never reach the next handler
+						w.WriteHeader(http.StatusOK)
+						return
+					}
+					next.ServeHTTP(w, r)
+					return
+				}
+				if cfg.ErrorRetryAfter > 0 {
+					setRetryAfter(w, cfg.RetryAfterFormat, retryAfterSeconds(cfg.ErrorRetryAfter, cfg.MaxRetryAfter))
+				}
+				if cfg.FailureMode == FailClosed {
+					http.Error(w, "Rate Limit Unavailable", http.StatusServiceUnavailable)
+					return
+				}
 				http.Error(w, "Rate Limit Internal Error", http.StatusInternalServerError)
 				return
 			}
+			defer limiter.ReleaseResult(res)
+
+			if !limit.IsUnlimited() {
+				w.Header().Set("X-RateLimit-Limit", headerInt(limit.Rate))
+				w.Header().Set("X-RateLimit-Remaining", headerInt(res.Remaining))
+			}
+			if cfg.RemainingPercentHeader && limit.Rate > 0 {
+				// Remaining can exceed Rate when the burst is larger
+				pct := 100
+				if res.Remaining < limit.Rate {
+					pct = res.Remaining * 100 / limit.Rate
+				}
+				w.Header().Set("X-RateLimit-Remaining-Pct", strconv.Itoa(pct))
+			}
 
-			// Set generic headers if desired (X-RateLimit-Limit, etc)
-			// (Optional: standard headers)
+			if peeking {
+				if !res.Allowed && res.Reason != limiter.ReasonBurstExceeded {
+					setRetryAfter(w, cfg.RetryAfterFormat, retryAfterSeconds(retryAfterFor(r, res), cfg.MaxRetryAfter))
+				}
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			if !res.Allowed && cfg.Grace > 0 && cfg.KeysFunc == nil && res.Reason == limiter.ReasonRateExceeded &&
+				allowGrace(r.Context(), cfg.Limiter, key, limit, cfg.Grace) {
+				w.Header().Set("X-RateLimit-Warning", "grace")
+				next.ServeHTTP(w, r)
+				return
+			}
 
 			if !res.Allowed {
 				if cfg.RateLimitHandler != nil {
 					cfg.RateLimitHandler(w, r, res)
 					return
 				}
+				if res.Reason == limiter.ReasonBurstExceeded {
+					writeDenied(w, r, cfg.DenialBody, burstDenial(limit))
+					return
+				}
 
-				w.Header().Set("Retry-After", strconv.Itoa(int(res.ResetAfter.Seconds())))
-				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				wait := retryAfterFor(r, res)
+				if res.Reason == limiter.ReasonBackendError {
+					wait = max(wait, cfg.ErrorRetryAfter)
+				}
+				retryAfter := retryAfterSeconds(wait, cfg.MaxRetryAfter)
+				setRetryAfter(w, cfg.RetryAfterFormat, retryAfter)
+				writeDenied(w, r, cfg.DenialBody, denial{
+					status:     http.StatusTooManyRequests,
+					message:    "Too Many Requests",
+					detail:     fmt.Sprintf("Rate limit of %s exceeded, %d requests remaining.", limit, res.Remaining),
+					retryAfter: &retryAfter,
+					reason:     res.Reason,
+				})
 				return
 			}
 
+			if cfg.SoftLimitThreshold > 0 && limit.Rate > 0 {
+				used := limit.Rate - res.Remaining
+				if float64(used) >= cfg.SoftLimitThreshold*float64(limit.Rate) {
+					w.Header().Set("X-RateLimit-Warning", "true")
+					if cfg.OnSoftLimit != nil {
+						cfg.OnSoftLimit(r, res)
+					}
+				}
+			}
+
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// graceKeySuffix keeps grace allowances apart from request budgets on a shared Strategy.
+const graceKeySuffix = ":grace"
+
+// allowGrace reports whether a denied request may use one of the grace requests of key.
+// An error counts as no grace left.
+func allowGrace(ctx context.Context, s limiter.Strategy, key string, limit limiter.Limit, grace int) bool {
+	res, err := s.Allow(ctx, key+graceKeySuffix, limiter.Limit{Rate: grace, Period: limit.Period, Burst: grace})
+	if err != nil {
+		return false
+	}
+	defer limiter.ReleaseResult(res)
+	return res.Allowed
+}
+
+// DefaultLimitFunc is the LimitFunc used when Config.LimitFunc is nil: a strict
+// 10 requests per minute, with a burst of 10, for every request.
+func DefaultLimitFunc(r *http.Request) limiter.Limit {
+	return limiter.Limit{
+		Rate:   10,
+		Period: time.Minute,
+		Burst:  10,
+	}
+}
+
+// LimitMethods returns a MethodFilter that only limits the given HTTP methods,
+// e.g. LimitMethods("POST", "PUT", "DELETE") to leave reads unlimited.
+func LimitMethods(methods ...string) func(method string) bool {
+	set := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		set[strings.ToUpper(m)] = true
+	}
+	return func(method string) bool {
+		return set[method]
+	}
+}
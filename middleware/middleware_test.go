@@ -0,0 +1,812 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// okHandler answers 200 OK, standing for the application behind the middleware.
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// serve runs req through the middleware configured by cfg in front of okHandler.
+func serve(cfg Config, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	New(cfg)(okHandler).ServeHTTP(rec, req)
+	return rec
+}
+
+// strategyFunc adapts a function to the limiter.Strategy interface, e.g. to stand for a failing backend.
+type strategyFunc func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error)
+
+func (f strategyFunc) Allow(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+	return f(ctx, key, limit)
+}
+
+func TestKeysFuncRequiresEveryKey(t *testing.T) {
+	tb := limiter.NewTokenBucket()
+	perIP := limiter.Limit{Rate: 10, Period: time.Minute, Burst: 10}
+	perUser := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	h := New(Config{
+		Limiter: tb,
+		KeysFunc: func(r *http.Request) []KeyedLimit {
+			return []KeyedLimit{
+				{Key: "ip:" + r.RemoteAddr, Limit: perIP},
+				{Key: "user:" + r.Header.Get("X-User"), Limit: perUser},
+			}
+		},
+	})(okHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User", "alice")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", rec.Code)
+	}
+	// The headers describe the most restrictive key, the user's
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "1" {
+		t.Errorf("X-RateLimit-Limit = %q, want the user limit 1", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rec.Code)
+	}
+
+	// The denied request must not have charged the IP key
+	q, err := tb.Quota(context.Background(), "ip:"+req.RemoteAddr, perIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Used != 1 {
+		t.Errorf("IP key used %d tokens, want 1", q.Used)
+	}
+}
+
+func TestKeysFuncEmptyPassesThrough(t *testing.T) {
+	rec := serve(Config{
+		Limiter:  limiter.NewTokenBucket(),
+		KeysFunc: func(r *http.Request) []KeyedLimit { return nil },
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+}
+
+func TestKeysFuncSkipsKeyFunc(t *testing.T) {
+	calls := 0
+	cfg := Config{
+		Limiter: limiter.NewTokenBucket(),
+		KeyFunc: func(r *http.Request) string {
+			calls++
+			return "k"
+		},
+		KeysFunc: func(r *http.Request) []KeyedLimit {
+			return []KeyedLimit{{Key: "ip", Limit: limiter.Limit{Rate: 10, Period: time.Second, Burst: 10}}}
+		},
+	}
+
+	if rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	if calls != 0 {
+		t.Errorf("KeyFunc called %d times alongside KeysFunc, want none", calls)
+	}
+
+	// It still keys the bandwidth limit
+	cfg.BandwidthLimit = limiter.Limit{Rate: 1 << 20, Period: time.Second, Burst: 1 << 20}
+	serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+	if calls != 1 {
+		t.Errorf("KeyFunc called %d times with a BandwidthLimit, want once", calls)
+	}
+}
+
+func TestUnlimitedLimitFunc(t *testing.T) {
+	cfg := Config{
+		Limiter:   limiter.NewTokenBucket(),
+		LimitFunc: func(r *http.Request) limiter.Limit { return limiter.Unlimited },
+	}
+	for i := 0; i < 50; i++ {
+		if rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, rec.Code)
+		}
+	}
+}
+
+func TestGlobalKeyFuncSharesOneBudget(t *testing.T) {
+	cfg := Config{
+		Limiter:   limiter.NewTokenBucket(),
+		KeyFunc:   GlobalKeyFunc(),
+		LimitFunc: func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1} },
+	}
+	first := httptest.NewRequest(http.MethodGet, "/", nil)
+	first.RemoteAddr = "10.0.0.1:1234"
+	second := httptest.NewRequest(http.MethodGet, "/", nil)
+	second.RemoteAddr = "10.0.0.2:1234"
+
+	if rec := serve(cfg, first); rec.Code != http.StatusOK {
+		t.Fatalf("first client: status %d, want 200", rec.Code)
+	}
+	if rec := serve(cfg, second); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second client: status %d, want 429", rec.Code)
+	}
+}
+
+func TestSoftLimitThreshold(t *testing.T) {
+	var warned int
+	h := New(Config{
+		Limiter:            limiter.NewTokenBucket(),
+		LimitFunc:          func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 10, Period: time.Minute, Burst: 10} },
+		SoftLimitThreshold: 0.8,
+		OnSoftLimit:        func(r *http.Request, res *limiter.Result) { warned++ },
+	})(okHandler)
+
+	for i := 1; i <= 11; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		// The 8th request is the first to have used 80% of the limit
+		want := ""
+		if i >= 8 && i <= 10 {
+			want = "true"
+		}
+		if got := rec.Header().Get("X-RateLimit-Warning"); got != want {
+			t.Errorf("request %d: X-RateLimit-Warning = %q, want %q", i, got, want)
+		}
+		if i == 11 && rec.Code != http.StatusTooManyRequests {
+			t.Errorf("request 11: status %d, want 429", rec.Code)
+		}
+	}
+	if warned != 3 {
+		t.Errorf("OnSoftLimit called %d times, want 3", warned)
+	}
+}
+
+func TestMethodFilter(t *testing.T) {
+	cfg := denyAll()
+	cfg.MethodFilter = LimitMethods("post", "PUT", "DELETE")
+
+	for method, want := range map[string]int{
+		http.MethodGet:    http.StatusOK,
+		http.MethodHead:   http.StatusOK,
+		http.MethodPost:   http.StatusTooManyRequests,
+		http.MethodPut:    http.StatusTooManyRequests,
+		http.MethodDelete: http.StatusTooManyRequests,
+	} {
+		if rec := serve(cfg, httptest.NewRequest(method, "/", nil)); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", method, rec.Code, want)
+		}
+	}
+
+	// Without a filter every method is limited
+	if rec := serve(denyAll(), httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("GET without a filter: status %d, want 429", rec.Code)
+	}
+}
+
+func TestEmptyKeyMode(t *testing.T) {
+	limit := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	for _, tc := range []struct {
+		mode EmptyKeyMode
+		want []int
+	}{
+		// All unidentified clients share one budget
+		{EmptyKeyTreatAsGlobal, []int{http.StatusOK, http.StatusTooManyRequests}},
+		{EmptyKeyAllow, []int{http.StatusOK, http.StatusOK}},
+		{EmptyKeyDeny, []int{http.StatusForbidden, http.StatusForbidden}},
+	} {
+		cfg := Config{
+			Limiter:      limiter.NewTokenBucket(),
+			KeyFunc:      func(r *http.Request) string { return "" },
+			LimitFunc:    func(r *http.Request) limiter.Limit { return limit },
+			EmptyKeyMode: tc.mode,
+		}
+		for i, want := range tc.want {
+			if rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != want {
+				t.Errorf("mode %d, request %d: status %d, want %d", tc.mode, i, rec.Code, want)
+			}
+		}
+	}
+}
+
+func TestSkipOnHeader(t *testing.T) {
+	cfg := denyAll()
+	cfg.SkipOnHeader = map[string]string{"X-Cache": "HIT"}
+
+	for value, want := range map[string]int{
+		"HIT":  http.StatusOK,
+		"hit":  http.StatusOK,
+		"MISS": http.StatusTooManyRequests,
+		"":     http.StatusTooManyRequests,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if value != "" {
+			req.Header.Set("X-Cache", value)
+		}
+		if rec := serve(cfg, req); rec.Code != want {
+			t.Errorf("X-Cache %q: status %d, want %d", value, rec.Code, want)
+		}
+	}
+}
+
+func TestNamespaceFuncIsolatesTenants(t *testing.T) {
+	var keys []string
+	cfg := Config{
+		Limiter:   limiter.NewTokenBucket(),
+		LimitFunc: func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1} },
+		KeyFunc:   func(r *http.Request) string { return "203.0.113.7" },
+		NamespaceFunc: func(r *http.Request) string {
+			return r.Header.Get("X-Tenant")
+		},
+		OnDecision: func(r *http.Request, key string, limit limiter.Limit, res *limiter.Result, err error) {
+			keys = append(keys, key)
+		},
+	}
+	h := New(cfg)(okHandler)
+
+	request := func(tenant string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Each tenant has its own budget for the shared IP
+	for _, tenant := range []string{"a", "b", ""} {
+		if code := request(tenant); code != http.StatusOK {
+			t.Errorf("tenant %q: first request status %d, want 200", tenant, code)
+		}
+	}
+	if code := request("a"); code != http.StatusTooManyRequests {
+		t.Errorf("tenant a: second request status %d, want 429", code)
+	}
+
+	want := []string{"a:203.0.113.7", "b:203.0.113.7", "203.0.113.7", "a:203.0.113.7"}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("key %d = %q, want %q", i, keys[i], want[i])
+		}
+	}
+}
+
+func TestOnDecisionFiresForEveryOutcome(t *testing.T) {
+	errBackend := errors.New("backend down")
+
+	type decision struct {
+		allowed bool
+		err     error
+	}
+	var (
+		decisions []decision
+		current   http.ResponseWriter // The writer of the request being decided
+	)
+	cfg := Config{
+		Limiter: limiter.NewTokenBucket(),
+		LimitFunc: func(r *http.Request) limiter.Limit {
+			return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+		},
+		OnDecision: func(r *http.Request, key string, limit limiter.Limit, res *limiter.Result, err error) {
+			d := decision{err: err}
+			if res != nil {
+				d.allowed = res.Allowed
+			}
+			decisions = append(decisions, d)
+			// It runs before the response is written: its headers are sent, except the
+			// rate limit ones the middleware owns
+			current.Header().Set("X-Decision", "seen")
+			current.Header().Set("X-RateLimit-Limit", "bogus")
+		},
+	}
+	do := func(cfg Config) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		current = rec
+		New(cfg)(okHandler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	allowed := do(cfg)
+	denied := do(cfg)
+	cfg.Limiter = strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+		return nil, errBackend
+	})
+	failed := do(cfg)
+
+	want := []decision{{allowed: true}, {allowed: false}, {err: errBackend}}
+	if len(decisions) != len(want) {
+		t.Fatalf("OnDecision called %d times, want %d", len(decisions), len(want))
+	}
+	for i, d := range decisions {
+		if d.allowed != want[i].allowed || !errors.Is(d.err, want[i].err) {
+			t.Errorf("decision %d = %+v, want %+v", i, d, want[i])
+		}
+	}
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{"allowed": allowed, "denied": denied, "failed": failed} {
+		if got := rec.Header().Get("X-Decision"); got != "seen" {
+			t.Errorf("%s: X-Decision = %q, want the header set by OnDecision", name, got)
+		}
+	}
+	if got := denied.Header().Get("X-RateLimit-Limit"); got != "1" {
+		t.Errorf("X-RateLimit-Limit = %q, want the middleware's value", got)
+	}
+}
+
+func TestRemainingPercentHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		limit limiter.Limit
+		want  string
+	}{
+		{"full", limiter.Limit{Rate: 4, Period: time.Minute, Burst: 5}, "100"},
+		{"half", limiter.Limit{Rate: 4, Period: time.Minute, Burst: 3}, "50"},
+		{"rounded down", limiter.Limit{Rate: 3, Period: time.Minute, Burst: 2}, "33"},
+		{"empty", limiter.Limit{Rate: 4, Period: time.Minute, Burst: 1}, "0"},
+		{"zero limit", limiter.Limit{Rate: 0, Period: time.Minute, Burst: 1}, ""},
+		{"unlimited", limiter.Unlimited, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(Config{
+				Limiter:                limiter.NewTokenBucket(),
+				LimitFunc:              func(r *http.Request) limiter.Limit { return tc.limit },
+				RemainingPercentHeader: true,
+			}, httptest.NewRequest(http.MethodGet, "/", nil))
+			if got := rec.Header().Get("X-RateLimit-Remaining-Pct"); got != tc.want {
+				t.Errorf("X-RateLimit-Remaining-Pct = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// Off by default
+	rec := serve(Config{Limiter: limiter.NewTokenBucket()}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("X-RateLimit-Remaining-Pct"); got != "" {
+		t.Errorf("X-RateLimit-Remaining-Pct = %q without the option, want none", got)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	const goroutines, perGoroutine = 32, 20
+	limit := limiter.Limit{Rate: 50, Period: time.Hour, Burst: 50}
+
+	for name, s := range map[string]limiter.Strategy{
+		"token_bucket":   limiter.NewTokenBucket(),
+		"sliding_window": limiter.NewSlidingWindow(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := New(Config{
+				Limiter:                s,
+				KeyFunc:                GlobalKeyFunc(),
+				LimitFunc:              func(r *http.Request) limiter.Limit { return limit },
+				SoftLimitThreshold:     0.5,
+				RemainingPercentHeader: true,
+			})(okHandler)
+
+			var ok atomic.Int64
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < perGoroutine; i++ {
+						rec := httptest.NewRecorder()
+						h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+						switch rec.Code {
+						case http.StatusOK:
+							ok.Add(1)
+						case http.StatusTooManyRequests:
+						default:
+							t.Errorf("status %d", rec.Code)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			// An hour-long period barely refills during the test
+			if got := ok.Load(); got < int64(limit.Rate) || got > int64(limit.Rate)+1 {
+				t.Errorf("%d requests served, want %d", got, limit.Rate)
+			}
+		})
+	}
+}
+
+func TestPeekMethodDoesNotConsume(t *testing.T) {
+	var served int
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	})
+	h := New(Config{
+		Limiter:    limiter.NewTokenBucket(),
+		LimitFunc:  func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 2, Period: time.Minute, Burst: 2} },
+		PeekMethod: http.MethodHead,
+	})(app)
+	do := func(method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+		return rec
+	}
+
+	for i := 0; i < 5; i++ {
+		rec := do(http.MethodHead)
+		if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "2" {
+			t.Fatalf("HEAD %d: status %d, remaining %q, want 200 with 2 remaining", i, rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+	if served != 0 {
+		t.Fatalf("quota checks reached the handler %d times, want never", served)
+	}
+
+	for i, want := range []string{"1", "0"} {
+		if rec := do(http.MethodGet); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != want {
+			t.Fatalf("GET %d: status %d, remaining %q, want 200 with %s remaining", i, rec.Code, rec.Header().Get("X-RateLimit-Remaining"), want)
+		}
+	}
+
+	// Out of quota, the check still answers 200 but says when to come back
+	rec := do(http.MethodHead)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "0" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("HEAD when exhausted: status %d, headers %v, want 200 with 0 remaining and a Retry-After", rec.Code, rec.Header())
+	}
+	if rec := do(http.MethodGet); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("GET when exhausted: status %d, want 429", rec.Code)
+	}
+
+	// Without Peekable, HEAD is limited like any other method
+	cfg := denyAll()
+	cfg.Limiter = limiter.NewMinInterval()
+	cfg.PeekMethod = http.MethodHead
+	serve(cfg, httptest.NewRequest(http.MethodHead, "/", nil))
+	if rec := serve(cfg, httptest.NewRequest(http.MethodHead, "/", nil)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("HEAD without Peekable: status %d, want 429", rec.Code)
+	}
+}
+
+func TestExportedDefaultsMatchNilConfig(t *testing.T) {
+	type decision struct {
+		key   string
+		limit limiter.Limit
+	}
+	record := func(cfg Config) []decision {
+		var got []decision
+		cfg.Limiter = limiter.NewTokenBucket()
+		cfg.OnDecision = func(r *http.Request, key string, limit limiter.Limit, res *limiter.Result, err error) {
+			got = append(got, decision{key, limit})
+		}
+		for _, addr := range []string{"10.0.0.1:1234", "10.0.0.2:1234"} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = addr
+			serve(cfg, req)
+		}
+		return got
+	}
+
+	implicit := record(Config{})
+	explicit := record(Config{KeyFunc: DefaultKeyFunc, LimitFunc: DefaultLimitFunc})
+	if len(implicit) != 2 || len(explicit) != 2 {
+		t.Fatalf("%d and %d decisions, want 2 each", len(implicit), len(explicit))
+	}
+	for i := range implicit {
+		if implicit[i] != explicit[i] {
+			t.Errorf("request %d: nil config decided %+v, exported defaults %+v", i, implicit[i], explicit[i])
+		}
+	}
+	if implicit[0].key != "10.0.0.1:1234" {
+		t.Errorf("default key = %q, want the remote address", implicit[0].key)
+	}
+	if want := (limiter.Limit{Rate: 10, Period: time.Minute, Burst: 10}); implicit[0].limit != want {
+		t.Errorf("default limit = %+v, want %+v", implicit[0].limit, want)
+	}
+}
+
+func TestWrappingDefaultKeyFunc(t *testing.T) {
+	var keys []string
+	cfg := Config{
+		Limiter: limiter.NewTokenBucket(),
+		KeyFunc: func(r *http.Request) string {
+			return r.URL.Path + "|" + DefaultKeyFunc(r)
+		},
+		OnDecision: func(r *http.Request, key string, limit limiter.Limit, res *limiter.Result, err error) {
+			keys = append(keys, key)
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	serve(cfg, req)
+
+	if len(keys) != 1 || keys[0] != "/orders|10.0.0.1:1234" {
+		t.Errorf("keys = %q, want the path prefixed to the default key", keys)
+	}
+}
+
+func TestGraceRequestsPassWithWarning(t *testing.T) {
+	cfg := Config{
+		Limiter: limiter.NewTokenBucket(),
+		KeyFunc: func(r *http.Request) string { return r.Header.Get("X-Client") },
+		LimitFunc: func(r *http.Request) limiter.Limit {
+			return limiter.Limit{Rate: 2, Period: time.Hour, Burst: 2}
+		},
+		Grace: 2,
+	}
+	request := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Client", client)
+		return serve(cfg, req)
+	}
+
+	for i, want := range []struct {
+		code    int
+		warning string
+	}{
+		{http.StatusOK, ""},
+		{http.StatusOK, ""},
+		// Over the limit, within grace
+		{http.StatusOK, "grace"},
+		{http.StatusOK, "grace"},
+		// Grace used up
+		{http.StatusTooManyRequests, ""},
+		{http.StatusTooManyRequests, ""},
+	} {
+		rec := request("alice")
+		if rec.Code != want.code || rec.Header().Get("X-RateLimit-Warning") != want.warning {
+			t.Errorf("request %d: status %d, warning %q, want %d and %q", i, rec.Code,
+				rec.Header().Get("X-RateLimit-Warning"), want.code, want.warning)
+		}
+	}
+
+	// Grace is counted per key
+	request("bob")
+	request("bob")
+	if rec := request("bob"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Warning") != "grace" {
+		t.Errorf("another client's first over-limit request: status %d, warning %q, want a grace request",
+			rec.Code, rec.Header().Get("X-RateLimit-Warning"))
+	}
+}
+
+// prefixingStrategy is a KeyReporter storing every key under a prefix, recording the keys it stores.
+type prefixingStrategy struct {
+	inner  limiter.Strategy
+	prefix string
+	stored []string
+}
+
+func (p *prefixingStrategy) Allow(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+	res, _, err := p.AllowWithKey(ctx, key, limit)
+	return res, err
+}
+
+func (p *prefixingStrategy) AllowWithKey(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, string, error) {
+	key = p.prefix + key
+	p.stored = append(p.stored, key)
+	res, err := p.inner.Allow(ctx, key, limit)
+	return res, key, err
+}
+
+func TestGraceWithPrefixingKeyReporter(t *testing.T) {
+	s := &prefixingStrategy{inner: limiter.NewTokenBucket(), prefix: "tenant:"}
+	var decided []string
+	cfg := Config{
+		Limiter: s,
+		KeyFunc: func(r *http.Request) string { return "alice" },
+		LimitFunc: func(r *http.Request) limiter.Limit {
+			return limiter.Limit{Rate: 1, Period: time.Hour, Burst: 1}
+		},
+		Grace: 1,
+		OnDecision: func(r *http.Request, key string, limit limiter.Limit, res *limiter.Result, err error) {
+			decided = append(decided, key)
+		},
+	}
+
+	serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Header().Get("X-RateLimit-Warning") != "grace" {
+		t.Fatalf("over-limit request: status %d, want a grace request", rec.Code)
+	}
+
+	// The grace check is prefixed once, by the strategy, and OnDecision sees the effective key
+	if want := []string{"tenant:alice", "tenant:alice", "tenant:alice:grace"}; !slices.Equal(s.stored, want) {
+		t.Errorf("stored keys = %q, want %q", s.stored, want)
+	}
+	if want := []string{"tenant:alice", "tenant:alice"}; !slices.Equal(decided, want) {
+		t.Errorf("OnDecision keys = %q, want %q", decided, want)
+	}
+}
+
+func TestGraceDisabledByDefault(t *testing.T) {
+	cfg := Config{
+		Limiter: limiter.NewTokenBucket(),
+		LimitFunc: func(r *http.Request) limiter.Limit {
+			return limiter.Limit{Rate: 1, Period: time.Hour, Burst: 1}
+		},
+	}
+	serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over-limit request without Grace: status %d, want 429", rec.Code)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	limitFuncCalls := 0
+	overrides := LimitTable{"vip": {Rate: 3, Period: time.Minute, Burst: 3}}
+	cfg := Config{
+		Limiter: limiter.NewTokenBucket(),
+		KeyFunc: func(r *http.Request) string { return r.Header.Get("X-API-Key") },
+		LimitFunc: func(r *http.Request) limiter.Limit {
+			limitFuncCalls++
+			return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+		},
+		NamespaceFunc: func(r *http.Request) string { return "tenant" },
+		Overrides:     overrides,
+	}
+	h := New(cfg)(okHandler)
+	admitted := func(apiKey string, n int) int {
+		ok := 0
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-API-Key", apiKey)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code == http.StatusOK {
+				ok++
+			}
+		}
+		return ok
+	}
+
+	// Hit: matched on the key from KeyFunc, before the namespace is added
+	if n := admitted("vip", 5); n != 3 {
+		t.Errorf("overridden key admitted %d, want its own limit of 3", n)
+	}
+	if limitFuncCalls != 0 {
+		t.Errorf("LimitFunc called %d times for an overridden key, want 0", limitFuncCalls)
+	}
+	// Miss
+	if n := admitted("regular", 5); n != 1 {
+		t.Errorf("other key admitted %d, want LimitFunc's limit of 1", n)
+	}
+	if limitFuncCalls != 5 {
+		t.Errorf("LimitFunc called %d times for a key without override, want 5", limitFuncCalls)
+	}
+
+	// New copied the map, so changing it later doesn't race with requests or take effect
+	overrides["regular"] = limiter.Unlimited
+	delete(overrides, "vip")
+	if n := admitted("regular", 1); n != 0 || limitFuncCalls != 6 {
+		t.Errorf("override added after New applied")
+	}
+	if admitted("vip", 1); limitFuncCalls != 6 {
+		t.Errorf("override removed after New dropped")
+	}
+}
+
+// errLimiterDown stands for a backend outage, e.g. Redis being unreachable.
+var errLimiterDown = errors.New("redis: connection refused")
+
+// failingStrategy is a limiter whose every check fails with errLimiterDown.
+type failingStrategy struct{}
+
+func (failingStrategy) Allow(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+	return nil, errLimiterDown
+}
+
+func (failingStrategy) Peek(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+	return nil, errLimiterDown
+}
+
+func TestFailureModes(t *testing.T) {
+	paths := map[string]struct {
+		cfg    Config
+		method string
+	}{
+		"single key": {Config{}, http.MethodGet},
+		"several keys": {Config{KeysFunc: func(r *http.Request) []KeyedLimit {
+			return []KeyedLimit{
+				{Key: "ip", Limit: limiter.Limit{Rate: 10, Period: time.Minute, Burst: 10}},
+				{Key: "user", Limit: limiter.Limit{Rate: 5, Period: time.Minute, Burst: 5}},
+			}
+		}}, http.MethodGet},
+		"peek": {Config{PeekMethod: http.MethodHead}, http.MethodHead},
+	}
+	for _, tc := range []struct {
+		mode       FailureMode
+		wantStatus int
+		// Whether requests other than peeks reach the application
+		wantNext   bool
+		retryAfter string
+	}{
+		{FailWithError, http.StatusInternalServerError, false, "1"},
+		{FailOpen, http.StatusOK, true, ""},
+		{FailClosed, http.StatusServiceUnavailable, false, "1"},
+	} {
+		for name, path := range paths {
+			var nextCalled bool
+			cfg := path.cfg
+			cfg.Limiter = failingStrategy{}
+			cfg.FailureMode = tc.mode
+			h := New(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(path.method, "/", nil))
+			if rec.Code != tc.wantStatus {
+				t.Errorf("mode %d, %s: status %d, want %d", tc.mode, name, rec.Code, tc.wantStatus)
+			}
+			if want := tc.wantNext && path.method != cfg.PeekMethod; nextCalled != want {
+				t.Errorf("mode %d, %s: next handler called = %v, want %v", tc.mode, name, nextCalled, want)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tc.retryAfter {
+				t.Errorf("mode %d, %s: Retry-After = %q, want %q", tc.mode, name, got, tc.retryAfter)
+			}
+			// Nothing is known about the quota
+			if got := rec.Header().Get("X-RateLimit-Remaining"); got != "" {
+				t.Errorf("mode %d, %s: X-RateLimit-Remaining = %q, want none", tc.mode, name, got)
+			}
+		}
+	}
+}
+
+func TestErrorHandlerOverridesFailureMode(t *testing.T) {
+	for _, mode := range []FailureMode{FailWithError, FailOpen, FailClosed} {
+		var handled error
+		h := New(Config{
+			Limiter:     failingStrategy{},
+			FailureMode: mode,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				handled = err
+				w.WriteHeader(http.StatusTeapot)
+			},
+		})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("mode %d: next handler called despite the ErrorHandler", mode)
+		}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusTeapot {
+			t.Errorf("mode %d: status %d, want the ErrorHandler's 418", mode, rec.Code)
+		}
+		if !errors.Is(handled, errLimiterDown) {
+			t.Errorf("mode %d: ErrorHandler got %v, want the limiter's error", mode, handled)
+		}
+		if got := rec.Header().Get("Retry-After"); got != "" {
+			t.Errorf("mode %d: Retry-After = %q, want the ErrorHandler's response left alone", mode, got)
+		}
+	}
+}
+
+func TestFailureModeOnlyAppliesToFailures(t *testing.T) {
+	// Requests that can never fit and plain denials aren't outages, even failing open
+	for name, strategy := range map[string]limiter.Strategy{
+		"exceeds burst": strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+			return nil, limiter.ErrExceedsBurst
+		}),
+		"denied": strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+			return &limiter.Result{Reason: limiter.ReasonRateExceeded, ResetAfter: time.Second}, nil
+		}),
+	} {
+		rec := serve(Config{Limiter: strategy, FailureMode: FailOpen}, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: status %d with FailOpen, want 429", name, rec.Code)
+		}
+	}
+}
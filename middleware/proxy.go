@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxyConfig describes the proxies (CDN, load balancer, ...) in front of the app,
+// so the real client IP can be recovered from X-Forwarded-For.
+//
+// Each proxy appends the address it received the request from, so the header is walked
+// right to left, skipping trusted proxies, and the first untrusted address is the client.
+// Entries to the left of it were written by the client and are ignored, which defeats
+// spoofed prefixes. A request from an untrusted peer is keyed by the peer itself.
+type TrustedProxyConfig struct {
+	// TrustedCIDRs lists the networks whose addresses are trusted proxies (e.g. "10.0.0.0/8").
+	TrustedCIDRs []string
+	// TrustedHops is the number of proxies in the chain that are trusted regardless of
+	// their address, counting the direct peer (e.g. 2 for CDN -> LB -> app).
+	TrustedHops int
+}
+
+// ClientIPKeyFunc returns a KeyFunc that keys requests by client IP according to cfg.
+// It returns an error if a trusted CIDR is malformed.
+func ClientIPKeyFunc(cfg TrustedProxyConfig) (KeyFunc, error) {
+	prefixes := make([]netip.Prefix, 0, len(cfg.TrustedCIDRs))
+	for _, cidr := range cfg.TrustedCIDRs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("middleware: invalid trusted CIDR %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	trusted := func(ip netip.Addr) bool {
+		for _, p := range prefixes {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		peer, ok := parseIP(r.RemoteAddr)
+		if !ok {
+			return r.RemoteAddr
+		}
+
+		// The chain as seen by the last hop: forwarded addresses followed by the direct peer
+		var chain []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			chain = append(chain, strings.Split(header, ",")...)
+		}
+
+		client := peer
+		for hop := 0; ; hop++ {
+			if hop >= cfg.TrustedHops && !trusted(client) {
+				return client.String()
+			}
+			if len(chain) == 0 {
+				// Everything is trusted, the leftmost address is the best we have
+				return client.String()
+			}
+
+			next, ok := parseIP(strings.TrimSpace(chain[len(chain)-1]))
+			if !ok {
+				// A trusted proxy wouldn't write garbage, stop at the last valid address
+				return client.String()
+			}
+			client = next
+			chain = chain[:len(chain)-1]
+		}
+	}, nil
+}
+
+// parseIP parses an address with or without a port.
+func parseIP(addr string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPKeyFunc(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    TrustedProxyConfig
+		peer   string
+		xff    []string
+		wantIP string
+	}{
+		{
+			name:   "untrusted peer ignores the header",
+			cfg:    TrustedProxyConfig{TrustedCIDRs: []string{"10.0.0.0/8"}},
+			peer:   "203.0.113.9:4000",
+			xff:    []string{"1.1.1.1"},
+			wantIP: "203.0.113.9",
+		},
+		{
+			name:   "CDN then load balancer",
+			cfg:    TrustedProxyConfig{TrustedCIDRs: []string{"10.0.0.0/8", "198.51.100.0/24"}},
+			peer:   "10.0.0.2:4000",
+			xff:    []string{"203.0.113.7, 198.51.100.4"},
+			wantIP: "203.0.113.7",
+		},
+		{
+			name:   "spoofed prefix is skipped",
+			cfg:    TrustedProxyConfig{TrustedCIDRs: []string{"10.0.0.0/8"}},
+			peer:   "10.0.0.2:4000",
+			xff:    []string{"6.6.6.6, 203.0.113.7"},
+			wantIP: "203.0.113.7",
+		},
+		{
+			name:   "entries across several headers",
+			cfg:    TrustedProxyConfig{TrustedCIDRs: []string{"10.0.0.0/8"}},
+			peer:   "10.0.0.2:4000",
+			xff:    []string{"6.6.6.6", "203.0.113.7, 10.1.1.1"},
+			wantIP: "203.0.113.7",
+		},
+		{
+			name:   "trusted hops",
+			cfg:    TrustedProxyConfig{TrustedHops: 2},
+			peer:   "192.0.2.1:4000",
+			xff:    []string{"6.6.6.6, 203.0.113.7, 192.0.2.50"},
+			wantIP: "203.0.113.7",
+		},
+		{
+			name:   "everything trusted",
+			cfg:    TrustedProxyConfig{TrustedCIDRs: []string{"0.0.0.0/0"}},
+			peer:   "10.0.0.2:4000",
+			xff:    []string{"203.0.113.7, 10.1.1.1"},
+			wantIP: "203.0.113.7",
+		},
+		{
+			name:   "garbage stops the walk",
+			cfg:    TrustedProxyConfig{TrustedCIDRs: []string{"10.0.0.0/8"}},
+			peer:   "10.0.0.2:4000",
+			xff:    []string{"203.0.113.7, not-an-ip"},
+			wantIP: "10.0.0.2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keyFunc, err := ClientIPKeyFunc(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.peer
+			for _, v := range tc.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := keyFunc(req); got != tc.wantIP {
+				t.Errorf("key %q, want %q", got, tc.wantIP)
+			}
+		})
+	}
+}
+
+func TestClientIPKeyFuncInvalidCIDR(t *testing.T) {
+	if _, err := ClientIPKeyFunc(TrustedProxyConfig{TrustedCIDRs: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatal("invalid CIDR accepted")
+	}
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+	"github.com/redis/go-redis/v9"
+)
+
+// LimitStore is the part of a Redis client RedisLimitSource needs. *redis.Client implements it.
+type LimitStore interface {
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+	MGet(ctx context.Context, keys ...string) *redis.SliceCmd
+}
+
+// RedisLimitSource serves a limit table stored in Redis, so a fleet of gateways shares one
+// limit configuration. Each limit is a string key matching keyPattern, whose value is in the
+// limiter.ParseLimit format: with the pattern "ratelimit:limits:*", the key
+// "ratelimit:limits:/search" holding "100/1m" limits the "/search" selector value.
+//
+// The table is cached locally and reloaded in the background once per TTL, so a limit set in
+// Redis reaches every gateway within the TTL; keys deleted from Redis disappear from the table
+// the same way. Requests never wait on Redis. If Redis is unavailable, the last table loaded
+// keeps being served, and an entry that no longer parses keeps its last valid limit. Until
+// the first load completes the table is empty. Call Close to stop the reloading.
+type RedisLimitSource struct {
+	client  LimitStore
+	pattern string
+	ttl     time.Duration
+
+	table atomic.Value // LimitTable
+
+	mu      sync.Mutex
+	lastErr error
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewRedisLimitSource creates a new RedisLimitSource loading the keys matching keyPattern,
+// a SCAN pattern with a single "*" standing for the selector value, every ttl (one minute if
+// not positive). The first load starts right away, use Refresh to wait for it.
+func NewRedisLimitSource(client LimitStore, keyPattern string, ttl time.Duration) *RedisLimitSource {
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	s := &RedisLimitSource{
+		client:  client,
+		pattern: keyPattern,
+		ttl:     ttl,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.table.Store(LimitTable{})
+	go s.refreshLoop()
+	return s
+}
+
+// Table returns the current limit table. It must not be modified.
+func (s *RedisLimitSource) Table() LimitTable {
+	return s.table.Load().(LimitTable)
+}
+
+// LimitFunc returns a LimitFunc looking up the value picked by selector in the current table,
+// and using fallback when it has no entry for it.
+func (s *RedisLimitSource) LimitFunc(selector func(r *http.Request) string, fallback limiter.Limit) func(r *http.Request) limiter.Limit {
+	return func(r *http.Request) limiter.Limit {
+		if limit, ok := s.Table()[selector(r)]; ok {
+			return limit
+		}
+		return fallback
+	}
+}
+
+// Err returns the error of the last load, or nil if it succeeded, e.g. for a health check.
+// Entries that failed to parse are reported too, even though the rest of the table loaded.
+func (s *RedisLimitSource) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastErr
+}
+
+// Refresh reloads the table from Redis now. On error the current table is kept.
+func (s *RedisLimitSource) Refresh(ctx context.Context) error {
+	prefix, suffix, _ := strings.Cut(s.pattern, "*")
+	current := s.Table()
+
+	var keys []string
+	var cursor uint64
+	for {
+		page, next, err := s.client.Scan(ctx, cursor, s.pattern, 100).Result()
+		if err != nil {
+			return s.setErr(err)
+		}
+		keys = append(keys, page...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	table := make(LimitTable, len(keys))
+	var parseErrs []error
+	for start := 0; start < len(keys); start += 100 {
+		batch := keys[start:min(start+100, len(keys))]
+		vals, err := s.client.MGet(ctx, batch...).Result()
+		if err != nil {
+			return s.setErr(err)
+		}
+		for i, v := range vals {
+			// Keys deleted since the scan come back nil
+			str, ok := v.(string)
+			if !ok {
+				continue
+			}
+			selector := strings.TrimSuffix(strings.TrimPrefix(batch[i], prefix), suffix)
+			limit, err := limiter.ParseLimit(str)
+			if err != nil {
+				parseErrs = append(parseErrs, fmt.Errorf("%s: %w", batch[i], err))
+				if last, ok := current[selector]; ok {
+					table[selector] = last
+				}
+				continue
+			}
+			table[selector] = limit
+		}
+	}
+
+	s.table.Store(table)
+	return s.setErr(errors.Join(parseErrs...))
+}
+
+// setErr records err as the outcome of the last load and returns it.
+func (s *RedisLimitSource) setErr(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastErr = err
+	return err
+}
+
+// Close stops reloading the table. The last table loaded keeps being served.
+func (s *RedisLimitSource) Close() {
+	close(s.quit)
+	<-s.done
+}
+
+func (s *RedisLimitSource) refreshLoop() {
+	defer close(s.done)
+
+	s.refreshOnce()
+
+	ticker := time.NewTicker(s.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.refreshOnce()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// refreshOnce reloads the table, giving up when the next reload is due so a hung Redis
+// doesn't stall the loop. Errors are kept for Err, the next reload retries anyway.
+func (s *RedisLimitSource) refreshOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.ttl)
+	defer cancel()
+
+	_ = s.Refresh(ctx)
+}
@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+	"github.com/redis/go-redis/v9"
+)
+
+// stubLimitStore is an in-memory LimitStore. It supports SCAN patterns with a single "*".
+type stubLimitStore struct {
+	mu   sync.Mutex
+	data map[string]string
+	err  error // Returned by every call while set
+	// Deleted between a SCAN and the MGET that follows it
+	vanishing []string
+}
+
+func newStubLimitStore(data map[string]string) *stubLimitStore {
+	return &stubLimitStore{data: data}
+}
+
+func (s *stubLimitStore) set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+}
+
+func (s *stubLimitStore) del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+}
+
+func (s *stubLimitStore) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// vanish makes keys disappear at the end of the next SCAN, before they are read.
+func (s *stubLimitStore) vanish(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vanishing = keys
+}
+
+// Scan returns count keys at a time, the cursor being the index of the next page.
+func (s *stubLimitStore) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return redis.NewScanCmdResult(nil, 0, s.err)
+	}
+
+	prefix, suffix, _ := strings.Cut(match, "*")
+	var keys []string
+	for key := range s.data {
+		if strings.HasPrefix(key, prefix) && strings.HasSuffix(key, suffix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	end := min(int(cursor)+int(count), len(keys))
+	page := keys[cursor:end]
+	next := uint64(end)
+	if end == len(keys) {
+		next = 0
+		for _, key := range s.vanishing {
+			delete(s.data, key)
+		}
+		s.vanishing = nil
+	}
+	return redis.NewScanCmdResult(page, next, nil)
+}
+
+func (s *stubLimitStore) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return redis.NewSliceResult(nil, s.err)
+	}
+
+	vals := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if v, ok := s.data[key]; ok {
+			vals[i] = v
+		}
+	}
+	return redis.NewSliceResult(vals, nil)
+}
+
+func mustParseLimit(t *testing.T, s string) limiter.Limit {
+	t.Helper()
+	limit, err := limiter.ParseLimit(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limit
+}
+
+func TestRedisLimitSourceLoadsTable(t *testing.T) {
+	store := newStubLimitStore(map[string]string{
+		"ratelimit:limits:/search": "100/1m",
+		"ratelimit:limits:/upload": "5/10s;burst=10",
+		"other:/search":            "1/1h",
+	})
+	src := NewRedisLimitSource(store, "ratelimit:limits:*", time.Hour)
+	defer src.Close()
+	if err := src.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := LimitTable{
+		"/search": mustParseLimit(t, "100/1m"),
+		"/upload": mustParseLimit(t, "5/10s;burst=10"),
+	}
+	if got := src.Table(); len(got) != len(want) || got["/search"] != want["/search"] || got["/upload"] != want["/upload"] {
+		t.Fatalf("table = %v, want %v", got, want)
+	}
+
+	fallback := limiter.Limit{Rate: 1, Period: time.Second, Burst: 1}
+	limitFunc := src.LimitFunc(func(r *http.Request) string { return r.URL.Path }, fallback)
+	for path, want := range map[string]limiter.Limit{
+		"/search": want["/search"],
+		"/upload": want["/upload"],
+		"/other":  fallback,
+	} {
+		if got := limitFunc(httptest.NewRequest(http.MethodGet, path, nil)); got != want {
+			t.Errorf("%s: limit %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestRedisLimitSourcePages(t *testing.T) {
+	data := make(map[string]string)
+	for i := 0; i < 250; i++ {
+		data[fmt.Sprintf("limits:%03d", i)] = fmt.Sprintf("%d/1m", i+1)
+	}
+	src := NewRedisLimitSource(newStubLimitStore(data), "limits:*", time.Hour)
+	defer src.Close()
+	if err := src.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	table := src.Table()
+	if len(table) != 250 {
+		t.Fatalf("loaded %d limits over several pages, want 250", len(table))
+	}
+	if table["249"].Rate != 250 {
+		t.Errorf("last limit = %v, want 250/1m", table["249"])
+	}
+}
+
+func TestRedisLimitSourcePropagatesChanges(t *testing.T) {
+	ctx := context.Background()
+	store := newStubLimitStore(map[string]string{
+		"limits:a": "10/1m",
+		"limits:b": "20/1m",
+	})
+	src := NewRedisLimitSource(store, "limits:*", time.Hour)
+	defer src.Close()
+	if err := src.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store.set("limits:a", "15/1m")
+	store.set("limits:c", "30/1m")
+	store.del("limits:b")
+	// Nothing changes until the next load
+	if got := src.Table()["a"].Rate; got != 10 {
+		t.Errorf("limit of a before reloading = %d, want the cached 10", got)
+	}
+	if err := src.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	table := src.Table()
+	if table["a"].Rate != 15 || table["c"].Rate != 30 {
+		t.Errorf("table after reloading = %v, want a at 15 and c at 30", table)
+	}
+	if _, ok := table["b"]; ok {
+		t.Error("b still in the table after being deleted from Redis")
+	}
+}
+
+func TestRedisLimitSourceReloadsInBackground(t *testing.T) {
+	store := newStubLimitStore(map[string]string{"limits:a": "10/1m"})
+	src := NewRedisLimitSource(store, "limits:*", 10*time.Millisecond)
+	closeSrc := sync.OnceFunc(src.Close)
+	defer closeSrc()
+
+	waitFor := func(rate int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for src.Table()["a"].Rate != rate {
+			if time.Now().After(deadline) {
+				t.Fatalf("limit of a = %v, want a rate of %d within the TTL", src.Table()["a"], rate)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// The first load starts right away, then changes arrive within the TTL
+	waitFor(10)
+	store.set("limits:a", "15/1m")
+	waitFor(15)
+
+	// Once closed, the last table stays
+	closeSrc()
+	store.set("limits:a", "20/1m")
+	time.Sleep(30 * time.Millisecond)
+	if got := src.Table()["a"].Rate; got != 15 {
+		t.Errorf("limit of a after Close = %d, want the last loaded 15", got)
+	}
+}
+
+func TestRedisLimitSourceServesLastTableWhenUnavailable(t *testing.T) {
+	ctx := context.Background()
+	store := newStubLimitStore(map[string]string{"limits:a": "10/1m"})
+	src := NewRedisLimitSource(store, "limits:*", time.Hour)
+	defer src.Close()
+	if err := src.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	down := errors.New("redis: connection refused")
+	store.fail(down)
+	store.set("limits:a", "99/1m")
+	if err := src.Refresh(ctx); !errors.Is(err, down) {
+		t.Fatalf("Refresh error = %v, want the Redis error", err)
+	}
+	if err := src.Err(); !errors.Is(err, down) {
+		t.Errorf("Err = %v, want the Redis error", err)
+	}
+	if got := src.Table()["a"].Rate; got != 10 {
+		t.Errorf("limit of a while Redis is down = %d, want the last known 10", got)
+	}
+
+	store.fail(nil)
+	if err := src.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Err(); err != nil {
+		t.Errorf("Err after recovering = %v, want nil", err)
+	}
+	if got := src.Table()["a"].Rate; got != 99 {
+		t.Errorf("limit of a after recovering = %d, want 99", got)
+	}
+}
+
+func TestRedisLimitSourceBadEntries(t *testing.T) {
+	ctx := context.Background()
+	store := newStubLimitStore(map[string]string{
+		"limits:a": "10/1m",
+		"limits:b": "20/1m",
+	})
+	src := NewRedisLimitSource(store, "limits:*", time.Hour)
+	defer src.Close()
+	if err := src.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A broken entry keeps its last valid limit, a new broken one is left out, and the
+	// rest of the table still loads
+	store.set("limits:a", "ten per minute")
+	store.set("limits:b", "25/1m")
+	store.set("limits:c", "/1m")
+	err := src.Refresh(ctx)
+	if err == nil || !strings.Contains(err.Error(), "limits:a") || !strings.Contains(err.Error(), "limits:c") {
+		t.Fatalf("Refresh error = %v, want both broken entries named", err)
+	}
+	if src.Err() == nil {
+		t.Error("Err = nil, want the parse errors reported")
+	}
+	table := src.Table()
+	if table["a"].Rate != 10 || table["b"].Rate != 25 {
+		t.Errorf("table = %v, want a kept at 10 and b updated to 25", table)
+	}
+	if _, ok := table["c"]; ok {
+		t.Error("unparsable new entry c in the table")
+	}
+
+	// Keys deleted between the SCAN and the MGET are skipped
+	store.vanish("limits:b")
+	store.set("limits:a", "10/1m")
+	store.del("limits:c")
+	if err := src.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := src.Table()["b"]; ok {
+		t.Error("key deleted during the load still in the table")
+	}
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// BodyFormat selects the body of the default denial response.
+type BodyFormat int
+
+const (
+	// BodyNegotiate sends JSON when the Accept header lists application/json, plain text otherwise.
+	BodyNegotiate BodyFormat = iota
+	// BodyText always sends plain text.
+	BodyText
+	// BodyJSON always sends a JSON object with "error" and "retry_after".
+	BodyJSON
+	// BodyProblemJSON sends an RFC 7807 application/problem+json document with
+	// a "retry_after" extension member.
+	BodyProblemJSON
+)
+
+// denial describes a denial response independently of its body format.
+type denial struct {
+	status     int
+	message    string
+	detail     string
+	retryAfter *int // Omitted when nil
+	reason     limiter.Reason
+}
+
+// burstDenial is the denial of a request that can never fit in the burst of limit, or is
+// under a zero Rate. Waiting never helps, so it has no Retry-After.
+func burstDenial(limit limiter.Limit) denial {
+	detail := fmt.Sprintf("Request can never fit in the burst of %d.", limit.Burst)
+	if limit.Rate == 0 {
+		detail = fmt.Sprintf("Rate limit of %s admits no requests.", limit)
+	}
+	return denial{
+		status:  http.StatusTooManyRequests,
+		message: "Request Exceeds Rate Limit",
+		detail:  detail,
+		reason:  limiter.ReasonBurstExceeded,
+	}
+}
+
+// denialBody is the JSON body of the default denial response.
+type denialBody struct {
+	Error      string `json:"error"`
+	Reason     string `json:"reason,omitempty"`
+	RetryAfter *int   `json:"retry_after,omitempty"`
+}
+
+// problemBody is an RFC 7807 problem document.
+type problemBody struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	RetryAfter *int   `json:"retry_after,omitempty"`
+}
+
+// writeDenied writes the default denial response in the given format.
+func writeDenied(w http.ResponseWriter, r *http.Request, format BodyFormat, d denial) {
+	if format == BodyNegotiate {
+		format = BodyText
+		if acceptsJSON(r) {
+			format = BodyJSON
+		}
+	}
+
+	var reason string
+	if d.reason != limiter.ReasonOK {
+		reason = d.reason.String()
+	}
+
+	switch format {
+	case BodyJSON:
+		writeJSON(w, "application/json", d.status, denialBody{Error: d.message, Reason: reason, RetryAfter: d.retryAfter})
+	case BodyProblemJSON:
+		writeJSON(w, "application/problem+json", d.status, problemBody{
+			Type:       "about:blank",
+			Title:      d.message,
+			Status:     d.status,
+			Detail:     d.detail,
+			Reason:     reason,
+			RetryAfter: d.retryAfter,
+		})
+	default:
+		http.Error(w, d.message, d.status)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// RetryAfterFormat selects how the Retry-After header is rendered.
+type RetryAfterFormat int
+
+const (
+	// FormatSeconds sends the number of seconds to wait, e.g. "Retry-After: 30".
+	FormatSeconds RetryAfterFormat = iota
+	// FormatHTTPDate sends the time to retry at as an HTTP-date in GMT,
+	// e.g. "Retry-After: Wed, 21 Oct 2015 07:28:00 GMT".
+	FormatHTTPDate
+)
+
+// setRetryAfter sets the Retry-After header for a wait of the given whole seconds.
+func setRetryAfter(w http.ResponseWriter, format RetryAfterFormat, seconds int) {
+	value := strconv.Itoa(seconds)
+	if format == FormatHTTPDate {
+		value = time.Now().Add(time.Duration(seconds) * time.Second).UTC().Format(http.TimeFormat)
+	}
+	w.Header().Set("Retry-After", value)
+}
+
+// retryAfterSeconds renders a wait as whole seconds for Retry-After, rounding up
+// so clients never retry before the limit has actually reset. A positive maxWait
+// caps the wait, see Config.MaxRetryAfter.
+func retryAfterSeconds(d, maxWait time.Duration) int {
+	if maxWait > 0 && d > maxWait {
+		d = maxWait
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// headerInt renders n for the X-RateLimit headers, capped at math.MaxInt32.
+func headerInt(n int) string {
+	return strconv.Itoa(min(n, math.MaxInt32))
+}
+
+// acceptsJSON reports whether the Accept header lists application/json.
+func acceptsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), "application/json") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,439 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// denyAll returns a Config whose limiter denies every request as rate exceeded: buckets
+// start empty and take a minute to earn a token.
+func denyAll() Config {
+	return Config{
+		Limiter:   limiter.NewTokenBucket(limiter.WithInitialTokens(0)),
+		LimitFunc: func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1} },
+	}
+}
+
+func TestDenialBodyNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		accept   string
+		wantType string
+	}{
+		{"application/json", "application/json"},
+		{"text/html, application/json;q=0.9", "application/json"},
+		{"text/plain", "text/plain; charset=utf-8"},
+		{"", "text/plain; charset=utf-8"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		rec := serve(denyAll(), req)
+
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Accept %q: status %d, want 429", tc.accept, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tc.wantType {
+			t.Errorf("Accept %q: Content-Type %q, want %q", tc.accept, got, tc.wantType)
+		}
+		if tc.wantType != "application/json" {
+			continue
+		}
+		var body denialBody
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("Accept %q: %v", tc.accept, err)
+		}
+		if body.Error == "" || body.Reason != "rate_exceeded" || body.RetryAfter == nil || *body.RetryAfter != 60 {
+			t.Errorf("Accept %q: body %+v, want an error, reason and retry_after of 60", tc.accept, body)
+		}
+	}
+}
+
+func TestDenialBodyFixedFormats(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+
+	cfg := denyAll()
+	cfg.DenialBody = BodyText
+	if got := serve(cfg, req).Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("BodyText: Content-Type %q", got)
+	}
+
+	cfg.DenialBody = BodyProblemJSON
+	rec := serve(cfg, req)
+	if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("BodyProblemJSON: Content-Type %q", got)
+	}
+	var body problemBody
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != http.StatusTooManyRequests || body.Type != "about:blank" {
+		t.Errorf("problem body %+v", body)
+	}
+}
+
+func TestRateLimitHandlerOverridesDenial(t *testing.T) {
+	cfg := denyAll()
+	cfg.RateLimitHandler = func(w http.ResponseWriter, r *http.Request, res *limiter.Result) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("busy"))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+
+	rec := serve(cfg, req)
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "busy" {
+		t.Fatalf("got %d %q, want the handler's response", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProblemJSONDocument(t *testing.T) {
+	cfg := denyAll()
+	cfg.DenialBody = BodyProblemJSON
+	rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Fatalf("Content-Type %q, want application/problem+json", got)
+	}
+	var doc map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"type":        "about:blank",
+		"title":       "Too Many Requests",
+		"status":      float64(429),
+		"detail":      "Rate limit of 1/1m exceeded, 0 requests remaining.",
+		"reason":      "rate_exceeded",
+		"retry_after": float64(60),
+	}
+	if len(doc) != len(want) {
+		t.Errorf("document %v, want %v", doc, want)
+	}
+	for k, v := range want {
+		if doc[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, doc[k], v)
+		}
+	}
+}
+
+func TestDenialReasonInBody(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		result *limiter.Result
+		err    error
+		want   string
+	}{
+		{"rate exceeded", &limiter.Result{Reason: limiter.ReasonRateExceeded}, nil, "rate_exceeded"},
+		{"backend failing closed", &limiter.Result{Reason: limiter.ReasonBackendError}, nil, "backend_error"},
+		{"overloaded", &limiter.Result{Reason: limiter.ReasonOverloaded}, nil, "overloaded"},
+		{"burst exceeded", nil, limiter.ErrExceedsBurst, "burst_exceeded"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{
+				Limiter: strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+					return tc.result, tc.err
+				}),
+				DenialBody: BodyJSON,
+			}
+			rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("status %d, want 429", rec.Code)
+			}
+			var body denialBody
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Reason != tc.want {
+				t.Errorf("reason = %q, want %q", body.Reason, tc.want)
+			}
+		})
+	}
+}
+
+func TestZeroBurstHasNoRetryAfter(t *testing.T) {
+	for name, limit := range map[string]limiter.Limit{
+		"zero burst": {Rate: 10, Period: time.Second},
+		"zero rate":  {Rate: 0, Period: time.Second, Burst: 10},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				Limiter:    limiter.NewTokenBucket(),
+				LimitFunc:  func(r *http.Request) limiter.Limit { return limit },
+				DenialBody: BodyJSON,
+				PeekMethod: http.MethodHead,
+			}
+
+			rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+			var body denialBody
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusTooManyRequests || body.Reason != "burst_exceeded" || body.RetryAfter != nil {
+				t.Errorf("got %d with %+v, want 429 as burst exceeded without a retry_after", rec.Code, body)
+			}
+			if got := rec.Header().Get("Retry-After"); got != "" {
+				t.Errorf("Retry-After = %q, want none since waiting never helps", got)
+			}
+
+			// A peek is denied by a result rather than an error, and gets no Retry-After either
+			rec = serve(cfg, httptest.NewRequest(http.MethodHead, "/", nil))
+			if got := rec.Header().Get("Retry-After"); rec.Code != http.StatusOK || got != "" {
+				t.Errorf("peek got %d with Retry-After %q, want 200 without one", rec.Code, got)
+			}
+		})
+	}
+}
+
+func TestRetryAfterFormat(t *testing.T) {
+	rec := serve(denyAll(), httptest.NewRequest(http.MethodGet, "/", nil))
+	seconds, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		t.Fatalf("default Retry-After = %q, want delta-seconds", rec.Header().Get("Retry-After"))
+	}
+
+	// The date is rendered in GMT whatever the local time zone
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.FixedZone("UTC+9", 9*60*60)
+
+	cfg := denyAll()
+	cfg.RetryAfterFormat = FormatHTTPDate
+	before := time.Now()
+	rec = serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+	value := rec.Header().Get("Retry-After")
+	if !strings.HasSuffix(value, " GMT") {
+		t.Fatalf("Retry-After = %q, want an HTTP-date in GMT", value)
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		t.Fatalf("Retry-After = %q: %v", value, err)
+	}
+	want := before.Add(time.Duration(seconds) * time.Second).Truncate(time.Second)
+	if d := at.Sub(want); d < -time.Second || d > time.Second {
+		t.Errorf("Retry-After = %s, want about %s", at, want.UTC())
+	}
+}
+
+func TestMaxRetryAfterCapsOnlyTheHint(t *testing.T) {
+	tb := limiter.NewTokenBucket()
+	daily := limiter.Limit{Rate: 1, Period: 24 * time.Hour, Burst: 1}
+	cfg := Config{
+		Limiter:       tb,
+		KeyFunc:       func(r *http.Request) string { return "client" },
+		LimitFunc:     func(r *http.Request) limiter.Limit { return daily },
+		MaxRetryAfter: time.Minute,
+	}
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "application/json")
+		return serve(cfg, req)
+	}
+
+	request()
+	rec := request()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want the 60s cap rather than a day", got)
+	}
+	var body denialBody
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RetryAfter == nil || *body.RetryAfter != 60 {
+		t.Errorf("body retry_after = %v, want 60", body.RetryAfter)
+	}
+
+	// The limiter still waits out the full day
+	res, err := tb.Peek(context.Background(), "client", daily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ResetAfter < 23*time.Hour {
+		t.Errorf("limiter ResetAfter = %s, want about a day", res.ResetAfter)
+	}
+
+	// Retrying after the advertised wait is simply denied again
+	if rec := request(); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("retry: status %d, Retry-After %q, want another capped 429", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Waits under the cap are advertised as they are
+	cfg.MaxRetryAfter = 48 * time.Hour
+	got := request().Header().Get("Retry-After")
+	if seconds, err := strconv.Atoi(got); err != nil || seconds < 86000 || seconds > 86400 {
+		t.Errorf("Retry-After under the cap = %q, want the full wait of about 86400", got)
+	}
+}
+
+func TestRateLimitHeadersForUnlimitedAndHugeLimits(t *testing.T) {
+	unlimited := serve(Config{
+		Limiter:   limiter.NewTokenBucket(),
+		LimitFunc: func(r *http.Request) limiter.Limit { return limiter.Unlimited },
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, name := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining"} {
+		if _, ok := unlimited.Header()[name]; ok {
+			t.Errorf("unlimited tier: %s = %q, want it omitted", name, unlimited.Header().Get(name))
+		}
+	}
+
+	huge := serve(Config{
+		Limiter: limiter.NewTokenBucket(),
+		LimitFunc: func(r *http.Request) limiter.Limit {
+			return limiter.Limit{Rate: math.MaxInt, Period: time.Second, Burst: math.MaxInt}
+		},
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	want := strconv.Itoa(math.MaxInt32)
+	for _, name := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining"} {
+		if got := huge.Header().Get(name); got != want {
+			t.Errorf("huge limit: %s = %q, want it capped at %s", name, got, want)
+		}
+	}
+
+	// Ordinary values are rendered as they are
+	small := serve(Config{
+		Limiter:   limiter.NewTokenBucket(),
+		LimitFunc: func(r *http.Request) limiter.Limit { return limiter.Limit{Rate: 5, Period: time.Second, Burst: 5} },
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if small.Header().Get("X-RateLimit-Limit") != "5" || small.Header().Get("X-RateLimit-Remaining") != "4" {
+		t.Errorf("headers %q and %q, want 5 and 4",
+			small.Header().Get("X-RateLimit-Limit"), small.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestErrorRetryAfter(t *testing.T) {
+	failing := strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+		return nil, errors.New("redis: connection refused")
+	})
+	for _, tc := range []struct {
+		name       string
+		cfg        Config
+		wantStatus int
+		want       string
+	}{
+		{"default", Config{}, http.StatusInternalServerError, "1"},
+		{"fail closed", Config{FailureMode: FailClosed}, http.StatusServiceUnavailable, "1"},
+		{"configured", Config{ErrorRetryAfter: 5 * time.Second}, http.StatusInternalServerError, "5"},
+		{"capped", Config{ErrorRetryAfter: time.Minute, MaxRetryAfter: 10 * time.Second}, http.StatusInternalServerError, "10"},
+		{"disabled", Config{ErrorRetryAfter: -1}, http.StatusInternalServerError, ""},
+		{"fail open", Config{FailureMode: FailOpen}, http.StatusOK, ""},
+		{"error handler", Config{ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusBadGateway)
+		}}, http.StatusBadGateway, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Limiter = failing
+			rec := serve(tc.cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tc.want {
+				t.Errorf("Retry-After = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestErrorRetryAfterOnBackendDenials(t *testing.T) {
+	// A strategy failing closed, e.g. an open circuit breaker, denies with no wait of its own
+	cfg := Config{Limiter: strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+		return &limiter.Result{Reason: limiter.ReasonBackendError}, nil
+	})}
+	rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want the 1s ErrorRetryAfter", got)
+	}
+
+	// A longer wait of the strategy wins
+	cfg.Limiter = strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+		return &limiter.Result{Reason: limiter.ReasonBackendError, ResetAfter: 30 * time.Second}, nil
+	})
+	if got := serve(cfg, httptest.NewRequest(http.MethodGet, "/", nil)).Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want the strategy's 30s", got)
+	}
+
+	// Plain rate limit denials keep their own wait
+	rec = serve(Config{
+		Limiter: strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+			return &limiter.Result{Reason: limiter.ReasonRateExceeded, ResetAfter: 3 * time.Second}, nil
+		}),
+		ErrorRetryAfter: time.Minute,
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q on a rate limit denial, want the strategy's 3s", got)
+	}
+}
+
+func TestRetryAfterFunc(t *testing.T) {
+	var calls int
+	cfg := Config{
+		Limiter: strategyFunc(func(ctx context.Context, key string, limit limiter.Limit) (*limiter.Result, error) {
+			return &limiter.Result{Reason: limiter.ReasonRateExceeded, ResetAfter: 2 * time.Second}, nil
+		}),
+		// Clients of the flaky endpoint are advised to back off longer
+		RetryAfterFunc: func(r *http.Request, res *limiter.Result) time.Duration {
+			calls++
+			if r.URL.Path == "/flaky" {
+				return 30 * time.Second
+			}
+			return res.ResetAfter
+		},
+	}
+	request := func(path string) (string, *int) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := serve(cfg, req)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s: status %d, want 429", path, rec.Code)
+		}
+		var body denialBody
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Header().Get("Retry-After"), body.RetryAfter
+	}
+
+	if header, body := request("/flaky"); header != "30" || body == nil || *body != 30 {
+		t.Errorf("/flaky: Retry-After %q, retry_after %v, want the 30s override", header, body)
+	}
+	if header, body := request("/stable"); header != "2" || body == nil || *body != 2 {
+		t.Errorf("/stable: Retry-After %q, retry_after %v, want the limiter's 2s", header, body)
+	}
+	if calls != 2 {
+		t.Errorf("RetryAfterFunc called %d times, want once per denial", calls)
+	}
+
+	// MaxRetryAfter still caps the override
+	cfg.MaxRetryAfter = 10 * time.Second
+	if header, _ := request("/flaky"); header != "10" {
+		t.Errorf("capped override: Retry-After %q, want 10", header)
+	}
+
+	// Allowed requests never consult it
+	calls = 0
+	cfg.Limiter = limiter.NewTokenBucket()
+	if rec := serve(cfg, httptest.NewRequest(http.MethodGet, "/flaky", nil)); rec.Code != http.StatusOK || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("allowed request: status %d, Retry-After %q, want 200 without a hint", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if calls != 0 {
+		t.Errorf("RetryAfterFunc called %d times for an allowed request, want 0", calls)
+	}
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+// Key suffixes used to keep connection and message budgets apart when a single
+// Strategy backs both. A client identified as "1.2.3.4" is limited on
+// "1.2.3.4:conn" for new connections and "1.2.3.4:msg" for messages.
+const (
+	ConnKeySuffix    = ":conn"
+	MessageKeySuffix = ":msg"
+)
+
+// ConnectionLimiter creates a middleware that only limits connection handshakes:
+// WebSocket upgrades and Server-Sent Events requests. Other requests pass through untouched.
+// Keys computed by cfg.KeyFunc get ConnKeySuffix appended.
+func ConnectionLimiter(cfg Config) func(http.Handler) http.Handler {
+	keyFunc := cfg.KeyFunc
+	if keyFunc == nil {
+		keyFunc = DefaultKeyFunc
+	}
+	cfg.KeyFunc = func(r *http.Request) string {
+		return keyFunc(r) + ConnKeySuffix
+	}
+	limited := New(cfg)
+
+	return func(next http.Handler) http.Handler {
+		handshake := limited(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isHandshake(r) {
+				handshake.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isHandshake(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// MessageLimiter limits inbound messages on a long-lived connection.
+// Call Allow for every message and close the connection when it returns false.
+type MessageLimiter struct {
+	limiter limiter.Strategy
+	key     string
+	limit   limiter.Limit
+}
+
+// NewMessageLimiter creates a new MessageLimiter charging key (with MessageKeySuffix appended) on s.
+// The same Strategy can be shared with ConnectionLimiter since the key suffixes differ.
+func NewMessageLimiter(s limiter.Strategy, key string, limit limiter.Limit) *MessageLimiter {
+	return &MessageLimiter{
+		limiter: s,
+		key:     key + MessageKeySuffix,
+		limit:   limit,
+	}
+}
+
+// Allow reports whether one more message may be processed.
+func (m *MessageLimiter) Allow(ctx context.Context) (bool, error) {
+	res, err := m.limiter.Allow(ctx, m.key, m.limit)
+	if err != nil {
+		return false, err
+	}
+	return res.Allowed, nil
+}
+
+// ConnLimiter limits inbound messages on one connection against two budgets at once: one
+// for the connection alone and one shared by every connection of the same user, so a user
+// can't multiply their allowance by opening more connections. Each message type (e.g. "chat"
+// or "move") has its own pair of budgets; pass "" to limit all messages together.
+//
+// Construct one per connection and call Close when it ends, typically with defer right after
+// the upgrade. Close resets the connection's budgets on Strategies implementing
+// limiter.Resettable, so per-connection keys don't pile up; other Strategies must expire
+// them on their own. The user's budgets are kept, since other connections may share them.
+//
+// The user is limited on "<user>:msg" and the connection on "<user>:conn:<connID>:msg", each
+// followed by ":<messageType>" if one is given. Colons and percent signs in user, connID and
+// messageType are percent-encoded, so no user, connection or message type can land on another's key.
+type ConnLimiter struct {
+	limiter   limiter.Strategy
+	connKey   string
+	userKey   string
+	connLimit limiter.Limit
+	userLimit limiter.Limit
+
+	mu    sync.Mutex
+	types map[string]struct{} // Message types seen, to reset on Close. Never shrinks before Close.
+}
+
+// NewConnLimiter creates a new ConnLimiter for connection connID of user, charging each
+// message to the connection under connLimit and to the user under userLimit on s.
+// connID only needs to be unique among the user's connections.
+func NewConnLimiter(s limiter.Strategy, user, connID string, connLimit, userLimit limiter.Limit) *ConnLimiter {
+	return &ConnLimiter{
+		limiter:   s,
+		connKey:   escapeKeyPart(user) + ConnKeySuffix + ":" + escapeKeyPart(connID) + MessageKeySuffix,
+		userKey:   escapeKeyPart(user) + MessageKeySuffix,
+		connLimit: connLimit,
+		userLimit: userLimit,
+		types:     make(map[string]struct{}),
+	}
+}
+
+// Allow reports whether one more message of messageType may be processed. The message is
+// charged only if both the connection and the user budgets allow it.
+//
+// messageType must come from a fixed set chosen by the server, such as the kinds of messages
+// the protocol defines, never from client input: every type seen is remembered until Close,
+// and each one is a separate pair of budgets a client could otherwise multiply at will.
+func (c *ConnLimiter) Allow(ctx context.Context, messageType string) (bool, error) {
+	c.mu.Lock()
+	c.types[messageType] = struct{}{}
+	c.mu.Unlock()
+
+	res, _, err := limiter.AllowAll(ctx, c.limiter, []limiter.KeyLimit{
+		{Key: typedKey(c.connKey, messageType), Limit: c.connLimit},
+		{Key: typedKey(c.userKey, messageType), Limit: c.userLimit},
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.Allowed, nil
+}
+
+// Close resets the connection's budgets if the Strategy supports it. The first error is returned.
+func (c *ConnLimiter) Close(ctx context.Context) error {
+	r, ok := c.limiter.(limiter.Resettable)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for messageType := range c.types {
+		if _, err := r.Reset(ctx, typedKey(c.connKey, messageType)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.types = make(map[string]struct{})
+	return firstErr
+}
+
+func typedKey(key, messageType string) string {
+	if messageType == "" {
+		return key
+	}
+	return key + ":" + escapeKeyPart(messageType)
+}
+
+// keyPartEscaper percent-encodes the ":" separating the parts of composite keys, such as those
+// of ConnLimiter and PathParamKeyFunc. Escaping "%" as well keeps the encoding reversible, so
+// distinct parts always give distinct keys.
+var keyPartEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+func escapeKeyPart(s string) string {
+	return keyPartEscaper.Replace(s)
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alibaba/rate-limiter-go/limiter"
+)
+
+func TestConnectionLimiterOnlyLimitsHandshakes(t *testing.T) {
+	h := ConnectionLimiter(Config{
+		Limiter: limiter.NewTokenBucket(),
+		LimitFunc: func(r *http.Request) limiter.Limit {
+			return limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+		},
+	})(okHandler)
+
+	upgrade := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	upgrade.Header.Set("Upgrade", "websocket")
+	events := httptest.NewRequest(http.MethodGet, "/events", nil)
+	events.Header.Set("Accept", "text/event-stream")
+	plain := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	for i, tc := range []struct {
+		req  *http.Request
+		want int
+	}{
+		{upgrade, http.StatusOK},
+		{upgrade, http.StatusTooManyRequests},
+		// SSE shares the connection budget of the client
+		{events, http.StatusTooManyRequests},
+		{plain, http.StatusOK},
+		{plain, http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, tc.req)
+		if rec.Code != tc.want {
+			t.Errorf("request %d to %s: status %d, want %d", i, tc.req.URL.Path, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestMessageLimiterSharesStrategyWithConnections(t *testing.T) {
+	ctx := context.Background()
+	tb := limiter.NewTokenBucket()
+	limit := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+
+	h := ConnectionLimiter(Config{
+		Limiter:   tb,
+		KeyFunc:   func(r *http.Request) string { return "client" },
+		LimitFunc: func(r *http.Request) limiter.Limit { return limit },
+	})(okHandler)
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("handshake: status %d, want 200", rec.Code)
+	}
+
+	// The connection used up its own budget, not the message one
+	m := NewMessageLimiter(tb, "client", limit)
+	if ok, err := m.Allow(ctx); err != nil || !ok {
+		t.Fatalf("first message = %v, %v, want allowed", ok, err)
+	}
+	if ok, err := m.Allow(ctx); err != nil || ok {
+		t.Fatalf("second message = %v, %v, want denied", ok, err)
+	}
+}
+
+func TestConnLimiterConnectionAndUserBudgets(t *testing.T) {
+	ctx := context.Background()
+	tb := limiter.NewTokenBucket()
+	connLimit := limiter.Limit{Rate: 2, Period: time.Minute, Burst: 2}
+	userLimit := limiter.Limit{Rate: 3, Period: time.Minute, Burst: 3}
+
+	allow := func(c *ConnLimiter, messageType string) bool {
+		t.Helper()
+		ok, err := c.Allow(ctx, messageType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	first := NewConnLimiter(tb, "alice", "c1", connLimit, userLimit)
+	second := NewConnLimiter(tb, "alice", "c2", connLimit, userLimit)
+	if !allow(first, "chat") || !allow(first, "chat") {
+		t.Fatal("messages within the connection budget denied")
+	}
+	if allow(first, "chat") {
+		t.Fatal("third message on a connection allowed, want the connection budget to bind")
+	}
+	// The denied message wasn't charged to the user, who has one message left
+	if !allow(second, "chat") {
+		t.Fatal("message on a second connection denied")
+	}
+	if allow(second, "chat") {
+		t.Fatal("fourth message of the user allowed, want the user budget to bind")
+	}
+
+	// Message types have budgets of their own
+	if !allow(first, "move") {
+		t.Error("first move denied by the chat budgets")
+	}
+
+	// Another user is unaffected
+	if !allow(NewConnLimiter(tb, "bob", "c1", connLimit, userLimit), "chat") {
+		t.Error("message of another user denied")
+	}
+}
+
+func TestConnLimiterCloseResetsOnlyTheConnection(t *testing.T) {
+	ctx := context.Background()
+	tb := limiter.NewTokenBucket()
+	connLimit := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	userLimit := limiter.Limit{Rate: 2, Period: time.Minute, Burst: 2}
+
+	c := NewConnLimiter(tb, "alice", "c1", connLimit, userLimit)
+	if ok, _ := c.Allow(ctx, "chat"); !ok {
+		t.Fatal("first message denied")
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reconnect reusing the connection ID starts with a fresh connection budget,
+	// but the user's budget still counts the first message
+	c = NewConnLimiter(tb, "alice", "c1", connLimit, userLimit)
+	if ok, _ := c.Allow(ctx, "chat"); !ok {
+		t.Fatal("message after Close denied by the old connection budget")
+	}
+	other := NewConnLimiter(tb, "alice", "c2", connLimit, userLimit)
+	if ok, _ := other.Allow(ctx, "chat"); ok {
+		t.Fatal("third message of the user allowed, want Close to keep the user budget")
+	}
+}
+
+func TestConnLimiterKeysDontCollide(t *testing.T) {
+	ctx := context.Background()
+	one := limiter.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	roomy := limiter.Limit{Rate: 100, Period: time.Minute, Burst: 100}
+
+	for name, pair := range map[string][2]struct{ user, connID, messageType string }{
+		// Without escaping, the connection key "alice:c1:msg" would be the user key of "alice:c1"
+		"connection vs user":     {{"alice", "c1", ""}, {"alice:c1", "c9", ""}},
+		"connection vs typed":    {{"alice", "msg", "chat"}, {"alice", "c1", "msg:chat"}},
+		"connection id vs type":  {{"alice", "c1", "x"}, {"alice", "c1:msg:x", ""}},
+		"percent-encoded user":   {{"a:b", "c1", ""}, {"a%3Ab", "c1", ""}},
+		"conn marker in user id": {{"alice:conn:c1", "c2", ""}, {"alice", "c1", ""}},
+	} {
+		tb := limiter.NewTokenBucket()
+		// The first of each pair spends its whole connection budget and the second its user
+		// budget, so a message of the second is denied if a key is shared
+		first := NewConnLimiter(tb, pair[0].user, pair[0].connID, one, roomy)
+		if ok, _ := first.Allow(ctx, pair[0].messageType); !ok {
+			t.Fatalf("%s: first message denied", name)
+		}
+		second := NewConnLimiter(tb, pair[1].user, pair[1].connID, roomy, one)
+		if ok, _ := second.Allow(ctx, pair[1].messageType); !ok {
+			t.Errorf("%s: message denied, keys collide", name)
+		}
+
+		// And the other way round
+		tb = limiter.NewTokenBucket()
+		first = NewConnLimiter(tb, pair[0].user, pair[0].connID, roomy, one)
+		first.Allow(ctx, pair[0].messageType)
+		second = NewConnLimiter(tb, pair[1].user, pair[1].connID, one, roomy)
+		if ok, _ := second.Allow(ctx, pair[1].messageType); !ok {
+			t.Errorf("%s, reversed: message denied, keys collide", name)
+		}
+	}
+}